@@ -0,0 +1,127 @@
+package iap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+const (
+	AmazonUrlProduction = "https://appstore-sdk.amazon.com/version/1.0/verifyReceiptId"
+	AmazonUrlSandbox    = "https://appstore-sdk.amazon.com/sandbox/version/1.0/verifyReceiptId"
+)
+
+var (
+	ErrNon200Amazon               = errors.New("non 200 response from amazon")
+	ErrAmazonInvalidReceipt       = errors.New("amazon receipt id is invalid")
+	ErrAmazonReceiptNoLongerValid = errors.New("amazon receipt is no longer valid")
+	ErrAmazonInvalidSecret        = errors.New("amazon developer secret is invalid")
+	ErrAmazonInvalidUser          = errors.New("amazon user id is invalid")
+)
+
+const (
+	AmazonProductTypeConsumable   = "CONSUMABLE"
+	AmazonProductTypeEntitled     = "ENTITLED"
+	AmazonProductTypeSubscription = "SUBSCRIPTION"
+)
+
+// ReceiptAmazonResponse Receipt Verification Service response. Dates are UNIX milliseconds.
+type ReceiptAmazonResponse struct {
+	ReceiptID        string `json:"receiptId"`
+	ProductType      string `json:"productType"` // possible values: CONSUMABLE, ENTITLED, SUBSCRIPTION
+	ProductID        string `json:"productId"`
+	ParentProductID  string `json:"parentProductId"`
+	PurchaseDate     int64  `json:"purchaseDate"`
+	CancelDate       *int64 `json:"cancelDate"` // Only present for canceled or refunded purchases.
+	CancelReason     *int   `json:"cancelReason"`
+	RenewalDate      int64  `json:"renewalDate"` // Subscriptions only.
+	AutoRenewing     bool   `json:"autoRenewing"`
+	FreeTrialEndDate *int64 `json:"freeTrialEndDate"`
+	Quantity         int    `json:"quantity"`
+	Term             string `json:"term"`
+	TermSku          string `json:"termSku"`
+	TestTransaction  bool   `json:"testTransaction"`
+	BetaProduct      bool   `json:"betaProduct"`
+}
+
+// ValidateReceiptAmazon validate a receipt id with the Amazon Receipt Verification Service.
+// userID is the Amazon user id returned by the Appstore SDK, not the game user.
+// return response struct and raw data.
+func ValidateReceiptAmazon(ctx context.Context, httpc *http.Client, developerSecret, userID, receiptID string) (*ReceiptAmazonResponse, []byte, error) {
+	return requestValidateAmazon(ctx, httpc, AmazonUrlProduction, developerSecret, userID, receiptID)
+}
+
+// ValidateReceiptAmazonSandbox ValidateReceiptAmazon against the RVS sandbox, for App Tester purchases.
+func ValidateReceiptAmazonSandbox(ctx context.Context, httpc *http.Client, developerSecret, userID, receiptID string) (*ReceiptAmazonResponse, []byte, error) {
+	return requestValidateAmazon(ctx, httpc, AmazonUrlSandbox, developerSecret, userID, receiptID)
+}
+
+func requestValidateAmazon(ctx context.Context, httpc *http.Client, baseUrl, developerSecret, userID, receiptID string) (*ReceiptAmazonResponse, []byte, error) {
+	if len(developerSecret) < 1 {
+		return nil, nil, errors.New("'developerSecret' is empty")
+	}
+
+	if len(userID) < 1 {
+		return nil, nil, errors.New("'userID' is empty")
+	}
+
+	if len(receiptID) < 1 {
+		return nil, nil, errors.New("'receiptID' is empty")
+	}
+
+	u := fmt.Sprintf("%s/developer/%s/user/%s/receiptId/%s", baseUrl, url.PathEscape(developerSecret), url.PathEscape(userID), url.PathEscape(receiptID))
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, nil, redactAmazonSecret(err, developerSecret)
+	}
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return nil, nil, redactAmazonSecret(err, developerSecret)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+		buf, err := ioutil.ReadAll(resp.Body)
+		if err != nil {
+			return nil, nil, err
+		}
+
+		var out ReceiptAmazonResponse
+		if err := json.Unmarshal(buf, &out); err != nil {
+			return nil, nil, err
+		}
+		return &out, buf, nil
+	case 400:
+		return nil, nil, ErrAmazonInvalidReceipt
+	case 410:
+		return nil, nil, ErrAmazonReceiptNoLongerValid
+	case 496:
+		return nil, nil, ErrAmazonInvalidSecret
+	case 497:
+		return nil, nil, ErrAmazonInvalidUser
+	default:
+		return nil, nil, ErrNon200Amazon
+	}
+}
+
+// redactAmazonSecret the developer secret is part of the RVS URL, keep it out of the *url.Error of a failed request.
+func redactAmazonSecret(err error, developerSecret string) error {
+	var ue *url.Error
+	if errors.As(err, &ue) {
+		ue.URL = strings.Replace(ue.URL, url.PathEscape(developerSecret), "REDACTED", -1)
+	}
+	return err
+}
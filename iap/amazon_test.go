@@ -0,0 +1,57 @@
+package iap
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestAmazonErrorRedactsSecret(t *testing.T) {
+	srv := httptest.NewServer(http.NotFoundHandler())
+	srv.Close()
+
+	_, _, err := requestValidateAmazon(context.Background(), srv.Client(), srv.URL, "developer-secret", "amzn-user", "receipt-1")
+	if err == nil {
+		t.Fatal("request to a closed server succeeded")
+	}
+	if strings.Contains(err.Error(), "developer-secret") {
+		t.Fatalf("error %q contains the developer secret", err)
+	}
+}
+
+func TestValidateReceiptAmazon(t *testing.T) {
+	tests := []struct {
+		name   string
+		secret string
+		err    error
+	}{
+		{name: "valid receipt", secret: "developer-secret"},
+		{name: "invalid secret", secret: "other-secret", err: ErrAmazonInvalidSecret},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				if r.URL.Path != "/version/1.0/verifyReceiptId/developer/developer-secret/user/amzn-user/receiptId/receipt-1" {
+					w.WriteHeader(496)
+					return
+				}
+				_, _ = w.Write([]byte(`{"receiptId":"receipt-1","productType":"CONSUMABLE","productId":"coins","purchaseDate":1690000000000,"quantity":1}`))
+			}))
+			defer srv.Close()
+
+			resp, raw, err := ValidateReceiptAmazon(context.Background(), redirectClient(srv), tt.secret, "amzn-user", "receipt-1")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				return
+			}
+			if resp.ReceiptID != "receipt-1" || resp.ProductType != AmazonProductTypeConsumable || resp.ProductID != "coins" || resp.PurchaseDate != 1690000000000 || len(raw) < 1 {
+				t.Fatalf("response %+v, want the coins receipt", resp)
+			}
+		})
+	}
+}
@@ -5,8 +5,13 @@ import (
 	"context"
 	"encoding/json"
 	"errors"
+	"fmt"
+	"io"
 	"io/ioutil"
+	"math/rand"
 	"net/http"
+	"strconv"
+	"time"
 )
 
 const (
@@ -19,20 +24,80 @@ const (
 	AppleReceiptIsSandbox = 21007
 )
 
+// verifyReceipt status codes.
+const (
+	AppleStatusBadRequest            = 21000 // The request to the App Store was not made using POST or its JSON was unreadable.
+	AppleStatusMalformedReceipt      = 21002 // The receipt-data property was malformed or the service had a temporary issue.
+	AppleStatusNotAuthenticated      = 21003 // The receipt could not be authenticated.
+	AppleStatusSharedSecretMismatch  = 21004 // The shared secret does not match the one on file for the account.
+	AppleStatusServerUnavailable     = 21005 // The receipt server was temporarily unable to provide the receipt.
+	AppleStatusSubscriptionExpired   = 21006 // Valid receipt but the subscription has expired, iOS 6 style receipts only.
+	AppleStatusProductionInSandbox   = 21008 // The receipt is from production but was sent to the sandbox.
+	AppleStatusInternalDataAccess    = 21009 // Internal data access error, try again later.
+	AppleStatusAccountNotFound       = 21010 // The user account cannot be found or has been deleted.
+	AppleStatusInternalErrorRangeMin = 21100 // 21100-21199 internal data access errors.
+	AppleStatusInternalErrorRangeMax = 21199
+)
+
 var (
-	ErrNon200Apple = errors.New("non 200 response from apple")
+	ErrNon200Apple                = errors.New("non 200 response from apple")
+	ErrSandboxReceiptInProduction = errors.New("apple sandbox receipt rejected in production only mode")
 )
 
+// httpErrorBodyLimit how much of a non 200 response body AppleHTTPError and GoogleHTTPError keep.
+const httpErrorBodyLimit = 4 << 10
+
+// AppleHTTPError non 200 response from verifyReceipt, errors.Is(err, ErrNon200Apple) holds for it.
+// A 200 response with a non zero status is not an AppleHTTPError, see ValidateReceiptAppleResponse.Status.
+type AppleHTTPError struct {
+	StatusCode int
+	// Body first 4KB of the response body.
+	Body []byte
+	// RetryAfter parsed Retry-After header, zero when absent. Apple sends it with 503s during incidents.
+	RetryAfter time.Duration
+}
+
+func (e *AppleHTTPError) Error() string {
+	return fmt.Sprintf("%v: %d", ErrNon200Apple, e.StatusCode)
+}
+
+func (e *AppleHTTPError) Is(target error) bool {
+	return target == ErrNon200Apple
+}
+
 const (
 	AppleSandboxEnv    = "Sandbox"
 	AppleProductionEnv = "Production"
 )
 
+const (
+	AppleOwnershipPurchased    = "PURCHASED"
+	AppleOwnershipFamilyShared = "FAMILY_SHARED"
+)
+
+const (
+	AppleOfferTypeFreeTrial    = "FREE_TRIAL"
+	AppleOfferTypeIntroductory = "INTRODUCTORY"
+	AppleOfferTypePromotional  = "PROMOTIONAL"
+	AppleOfferTypeOfferCode    = "OFFER_CODE"
+)
+
 type ValidateReceiptAppleResponse struct {
 	IsRetryable bool             `json:"is-retryable"` // If true, must be retried later.
 	Status      int              `json:"status"`
 	Receipt     *ResponseReceipt `json:"receipt"`
 	Environment string           `json:"environment"` // possible values: 'Sandbox', 'Production'.
+	// Only returned for app receipts that contain auto-renewable subscriptions, one entry per subscription.
+	PendingRenewalInfo []PendingRenewalInfo `json:"pending_renewal_info"`
+	// Only returned for receipts that contain auto-renewable subscriptions, every transaction of them,
+	// the authoritative subscription state. LatestReceipt is the latest base64 encoded receipt.
+	LatestReceiptInfo []*InApp `json:"latest_receipt_info"`
+	LatestReceipt     string   `json:"latest_receipt"`
+	// SubscriptionInfoUnavailable is set when a subscription receipt was validated without the shared secret,
+	// Apple still returns the in_app entries but renewal info is missing so subscription fields can't be trusted.
+	SubscriptionInfoUnavailable bool `json:"-"`
+	// UsedSandboxFallback is set when production answered 21007 and the receipt was validated with the sandbox.
+	UsedSandboxFallback bool `json:"-"`
 }
 
 type ResponseReceipt struct {
@@ -41,56 +106,275 @@ type ResponseReceipt struct {
 }
 
 type InApp struct {
-	OriginalTransactionID string               `json:"original_transaction_id"`
-	TransactionId         string               `json:"transaction_id"` // Different than OriginalTransactionId if the user Auto-renews subscription or restores a purchase.
-	ProductID             string               `json:"product_id"`
-	ExpiresDateMs         string               `json:"expires_date_ms"` // Only returned for Subscription expiration or renewal date.
-	PurchaseDateMs        string               `json:"purchase_date_ms"`
-	CancellationDateMs    string               `json:"cancellation_date_ms"` // canceled a transaction This field is only present for refunded transactions
-	CancellationReason    string               `json:"cancellation_reason"`  // reason for a refunded transaction Possible values: 1, 0
-	PendingRenewalInfo    []PendingRenewalInfo `json:"pending_renewal_info"` // Only returned for app receipts that contain auto-renewable subscriptions.
+	OriginalTransactionID  string               `json:"original_transaction_id"`
+	TransactionId          string               `json:"transaction_id"` // Different than OriginalTransactionId if the user Auto-renews subscription or restores a purchase.
+	ProductID              string               `json:"product_id"`
+	ExpiresDateMs          string               `json:"expires_date_ms"`           // Only returned for Subscription expiration or renewal date.
+	PurchaseDateMs         string               `json:"purchase_date_ms"`          // For renewals the start of the current period.
+	OriginalPurchaseDateMs string               `json:"original_purchase_date_ms"` // For subscriptions the first purchase, for restores the original purchase.
+	CancellationDateMs     string               `json:"cancellation_date_ms"`      // canceled a transaction This field is only present for refunded transactions
+	CancellationReason     string               `json:"cancellation_reason"`       // reason for a refunded transaction Possible values: 1, 0
+	PendingRenewalInfo     []PendingRenewalInfo `json:"pending_renewal_info"`      // Only returned for app receipts that contain auto-renewable subscriptions.
+	InAppOwnershipType     string               `json:"in_app_ownership_type"`     // Possible values: PURCHASED, FAMILY_SHARED
+	TrialPeriod            string               `json:"is_trial_period"`           // Possible values: true, false
+	InIntroOfferPeriod     string               `json:"is_in_intro_offer_period"`  // Possible values: true, false
+	PromotionalOfferID     string               `json:"promotional_offer_id"`      // Only present when redeemed with a promotional offer.
+	OfferCodeRefName       string               `json:"offer_code_ref_name"`       // Only present when redeemed with an offer code.
+	RawQuantity            string               `json:"quantity"`                  // Units bought in the transaction, e.g. 5 coin packs.
+	AppAccountToken        string               `json:"app_account_token"`         // UUID the app attached to the purchase, StoreKit 2 only.
+	// IsTrialPeriod the transaction is a free trial period.
+	IsTrialPeriod bool `json:"-"`
+	// OfferType of the period, one of the AppleOfferType consts, empty for a full price period.
+	OfferType string `json:"-"`
+	// Quantity parsed RawQuantity, 1 when absent or malformed.
+	Quantity int `json:"-"`
+}
+
+// setComputed fills the json:"-" fields from the raw ones after decoding.
+func (i *InApp) setComputed() {
+	i.Quantity = 1
+	if q, err := strconv.Atoi(i.RawQuantity); err == nil && q > 0 {
+		i.Quantity = q
+	}
+
+	i.IsTrialPeriod = i.TrialPeriod == "true"
+	switch {
+	case i.IsTrialPeriod:
+		i.OfferType = AppleOfferTypeFreeTrial
+	case len(i.PromotionalOfferID) > 0:
+		i.OfferType = AppleOfferTypePromotional
+	case len(i.OfferCodeRefName) > 0:
+		i.OfferType = AppleOfferTypeOfferCode
+	case i.InIntroOfferPeriod == "true":
+		i.OfferType = AppleOfferTypeIntroductory
+	default:
+		i.OfferType = ""
+	}
 }
 
 type PendingRenewalInfo struct {
-	AutoRenewStatus string `json:"auto_renew_status"` // Possible values: 1, 0
+	AutoRenewStatus          string `json:"auto_renew_status"`     // Possible values: 1, 0
+	AutoRenewProductID       string `json:"auto_renew_product_id"` // Product the subscription renews into, differs from ProductID after a downgrade.
+	ProductID                string `json:"product_id"`
+	OriginalTransactionID    string `json:"original_transaction_id"`
+	IsInBillingRetryPeriod   string `json:"is_in_billing_retry_period"`   // Possible values: 1, 0
+	GracePeriodExpiresDateMs string `json:"grace_period_expires_date_ms"` // Only present while the subscription is in the billing grace period.
+	ExpirationIntent         string `json:"expiration_intent"`            // Only present for expired subscriptions, 1 customer canceled, 2 billing error, 3 price increase declined, 4 product unavailable, 5 unknown.
+	PromotionalOfferID       string `json:"promotional_offer_id"`         // Promotional offer applied to the next renewal.
+	OfferCodeRefName         string `json:"offer_code_ref_name"`          // Offer code applied to the next renewal.
+}
+
+// SubscriptionTransactions returns LatestReceiptInfo when present, the receipt in_app entries otherwise.
+func (r *ValidateReceiptAppleResponse) SubscriptionTransactions() []*InApp {
+	if len(r.LatestReceiptInfo) > 0 {
+		return r.LatestReceiptInfo
+	}
+	if r.Receipt == nil {
+		return nil
+	}
+	return r.Receipt.InApp
+}
+
+// RenewalInfo returns the pending renewal info for the subscription of inApp, nil when there is none.
+func (r *ValidateReceiptAppleResponse) RenewalInfo(inApp *InApp) *PendingRenewalInfo {
+	if len(inApp.PendingRenewalInfo) > 0 {
+		return &inApp.PendingRenewalInfo[0]
+	}
+
+	for i := range r.PendingRenewalInfo {
+		if r.PendingRenewalInfo[i].OriginalTransactionID == inApp.OriginalTransactionID {
+			return &r.PendingRenewalInfo[i]
+		}
+	}
+	return nil
+}
+
+// AppleOptions for the *WithOptions validation functions, the zero value behaves like the plain functions.
+type AppleOptions struct {
+	// Retry responses with is-retryable set, default no retry.
+	Retry AppleRetryPolicy
+	// ExcludeOldTransactions sent as exclude-old-transactions, nil keeps the function default:
+	// ValidateReceiptApple excludes them, ValidateSubscriptionReceiptApple returns every renewal.
+	ExcludeOldTransactions *bool
+	// ProductionUrl and SandboxUrl optional, default AppleUrlProduction and AppleUrlSandbox,
+	// e.g. to point the validation at a mock server.
+	ProductionUrl string
+	SandboxUrl    string
+	// ProductionOnly a 21007 from production fails with ErrSandboxReceiptInProduction instead of falling back to the sandbox.
+	ProductionOnly bool
+	// SandboxOnly validate with the sandbox only, a single request (without retries), e.g. for QA builds.
+	SandboxOnly bool
+}
+
+func (o AppleOptions) productionUrl() string {
+	if len(o.ProductionUrl) > 0 {
+		return o.ProductionUrl
+	}
+	return AppleUrlProduction
+}
+
+func (o AppleOptions) sandboxUrl() string {
+	if len(o.SandboxUrl) > 0 {
+		return o.SandboxUrl
+	}
+	return AppleUrlSandbox
+}
+
+// AppleRetryPolicy retries a verifyReceipt call while Apple answers with is-retryable set (e.g. 21005),
+// waiting Backoff before the first retry and doubling it after each one, jittered by up to half of it.
+// A retry that would wait past the context deadline is not attempted.
+type AppleRetryPolicy struct {
+	// MaxAttempts including the first call, 1 or less means no retry.
+	MaxAttempts int
+	Backoff     time.Duration
+	// RetryUnavailable also retry HTTP 503 responses, waiting the Retry-After header when Apple sends one,
+	// up to a minute.
+	RetryUnavailable bool
+}
+
+// maxAppleRetryAfter longest Retry-After honored, a larger one waits this long.
+const maxAppleRetryAfter = 60 * time.Second
+
+// delay before the next attempt, retryAfter capped at maxAppleRetryAfter or else the jittered backoff.
+func (p AppleRetryPolicy) delay(backoff, retryAfter time.Duration) time.Duration {
+	if retryAfter > maxAppleRetryAfter {
+		return maxAppleRetryAfter
+	}
+	if retryAfter <= 0 && backoff > 0 {
+		return backoff/2 + time.Duration(rand.Int63n(int64(backoff/2)+1))
+	}
+	return retryAfter
+}
+
+// wait before the next attempt, false when it doesn't fit in the context deadline.
+func (p AppleRetryPolicy) wait(ctx context.Context, backoff, retryAfter time.Duration) bool {
+	d := p.delay(backoff, retryAfter)
+	if deadline, ok := ctx.Deadline(); ok && time.Until(deadline) < d {
+		return false
+	}
+
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+		return false
+	case <-t.C:
+		return true
+	}
 }
 
 // ValidateReceiptApple this function will check against both the production and sandbox Apple URLs follow by Apple suggestion.
+// old transactions are excluded, only the latest purchase is returned.
 // return response struct and raw data. Do what ever you want.
+// raw is always the body of the environment that validated the receipt, the sandbox one after a 21007 fallback.
 func ValidateReceiptApple(ctx context.Context, httpc *http.Client, receipt, password string) (*ValidateReceiptAppleResponse, []byte, error) {
-	resp, raw, err := requestValidateWithUrl(ctx, httpc, AppleUrlProduction, receipt, password, false)
+	return validateWithSandboxFallback(ctx, httpc, receipt, password, true, AppleOptions{})
+}
+
+// ValidateReceiptAppleWithOptions ValidateReceiptApple with options.
+func ValidateReceiptAppleWithOptions(ctx context.Context, httpc *http.Client, receipt, password string, opts AppleOptions) (*ValidateReceiptAppleResponse, []byte, error) {
+	return validateWithSandboxFallback(ctx, httpc, receipt, password, true, opts)
+}
+
+// ValidateSubscriptionReceiptApple this function for purchase subscription will check against both the production and sandbox Apple URLs follow by Apple suggestion.
+// password is the app shared secret, when empty the receipt is still validated but SubscriptionInfoUnavailable is set.
+// old transactions are included so the response carries the full renewal history.
+// return response struct and raw data. Do what ever you want.
+// raw is always the body of the environment that validated the receipt, the sandbox one after a 21007 fallback.
+func ValidateSubscriptionReceiptApple(ctx context.Context, httpc *http.Client, receipt, password string) (*ValidateReceiptAppleResponse, []byte, error) {
+	return ValidateSubscriptionReceiptAppleWithOptions(ctx, httpc, receipt, password, AppleOptions{})
+}
+
+// ValidateSubscriptionReceiptAppleWithOptions ValidateSubscriptionReceiptApple with options.
+func ValidateSubscriptionReceiptAppleWithOptions(ctx context.Context, httpc *http.Client, receipt, password string, opts AppleOptions) (*ValidateReceiptAppleResponse, []byte, error) {
+	resp, raw, err := validateWithSandboxFallback(ctx, httpc, receipt, password, false, opts)
 	if err != nil {
 		return nil, nil, err
 	}
 
-	switch resp.Status {
-	case AppleReceiptIsSandbox:
-		// Receipt should be checked with the Apple sandbox.
-		return requestValidateWithUrl(ctx, httpc, AppleUrlSandbox, receipt, password, false)
-	}
-
+	resp.SubscriptionInfoUnavailable = len(password) < 1
 	return resp, raw, nil
 }
 
-// ValidateSubscriptionReceiptApple this function for purchase subscription will check against both the production and sandbox Apple URLs follow by Apple suggestion.
-// required password
-// return response struct and raw data. Do what ever you want.
-func ValidateSubscriptionReceiptApple(ctx context.Context, httpc *http.Client, receipt, password string) (*ValidateReceiptAppleResponse, []byte, error) {
-	resp, raw, err := requestValidateWithUrl(ctx, httpc, AppleUrlProduction, receipt, password, true)
+func validateWithSandboxFallback(ctx context.Context, httpc *http.Client, receipt, password string, excludeOldTransactions bool, opts AppleOptions) (*ValidateReceiptAppleResponse, []byte, error) {
+	if opts.ExcludeOldTransactions != nil {
+		excludeOldTransactions = *opts.ExcludeOldTransactions
+	}
+
+	if opts.ProductionOnly && opts.SandboxOnly {
+		return nil, nil, errors.New("'ProductionOnly' and 'SandboxOnly' are exclusive")
+	}
+
+	if opts.SandboxOnly {
+		return requestValidateWithRetry(ctx, httpc, opts.sandboxUrl(), receipt, password, excludeOldTransactions, AppleRetryPolicy{})
+	}
+
+	resp, raw, err := requestValidateWithRetry(ctx, httpc, opts.productionUrl(), receipt, password, excludeOldTransactions, opts.Retry)
 	if err != nil {
 		return nil, nil, err
 	}
 
 	switch resp.Status {
 	case AppleReceiptIsSandbox:
-		// Receipt should be checked with the Apple sandbox.
-		return requestValidateWithUrl(ctx, httpc, AppleUrlSandbox, receipt, password, true)
+		if opts.ProductionOnly {
+			return nil, nil, ErrSandboxReceiptInProduction
+		}
+		// Receipt should be checked with the Apple sandbox, the production body must not leak to the caller.
+		sandboxResp, sandboxRaw, err := requestValidateWithRetry(ctx, httpc, opts.sandboxUrl(), receipt, password, excludeOldTransactions, opts.Retry)
+		if err != nil {
+			return nil, nil, err
+		}
+		sandboxResp.UsedSandboxFallback = true
+		return sandboxResp, sandboxRaw, nil
 	}
 
 	return resp, raw, nil
 }
 
-func requestValidateWithUrl(ctx context.Context, httpc *http.Client, url, receipt, password string, isSubscription bool) (*ValidateReceiptAppleResponse, []byte, error) {
+// requestValidateWithRetry returns the last response, or error, when the attempts run out or the context is done.
+func requestValidateWithRetry(ctx context.Context, httpc *http.Client, url, receipt, password string, excludeOldTransactions bool, retry AppleRetryPolicy) (*ValidateReceiptAppleResponse, []byte, error) {
+	backoff := retry.Backoff
+	for attempt := 1; ; attempt++ {
+		resp, raw, err := requestValidateWithUrl(ctx, httpc, url, receipt, password, excludeOldTransactions)
+		if attempt >= retry.MaxAttempts {
+			return resp, raw, err
+		}
+
+		var retryAfter time.Duration
+		if err != nil {
+			var httpErr *AppleHTTPError
+			if !retry.RetryUnavailable || !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusServiceUnavailable {
+				return nil, nil, err
+			}
+			retryAfter = httpErr.RetryAfter
+		} else if !resp.IsRetryable {
+			return resp, raw, nil
+		}
+
+		if !retry.wait(ctx, backoff, retryAfter) {
+			return resp, raw, err
+		}
+		backoff *= 2
+	}
+}
+
+// parseRetryAfter reads a Retry-After header, delay seconds or an HTTP date.
+func parseRetryAfter(header string) time.Duration {
+	if len(header) < 1 {
+		return 0
+	}
+	if secs, err := strconv.Atoi(header); err == nil && secs > 0 {
+		return time.Duration(secs) * time.Second
+	}
+	if at, err := http.ParseTime(header); err == nil {
+		if d := time.Until(at); d > 0 {
+			return d
+		}
+	}
+	return 0
+}
+
+func requestValidateWithUrl(ctx context.Context, httpc *http.Client, url, receipt, password string, excludeOldTransactions bool) (*ValidateReceiptAppleResponse, []byte, error) {
 	if len(url) < 1 {
 		return nil, nil, errors.New("'url' is empty")
 	}
@@ -99,14 +383,9 @@ func requestValidateWithUrl(ctx context.Context, httpc *http.Client, url, receip
 		return nil, nil, errors.New("'receipt' is empty")
 	}
 
-	// optional use only subscription validation
-	if len(password) < 1 && isSubscription {
-		return nil, nil, errors.New("'password' is empty")
-	}
-
 	payload := map[string]interface{}{
 		"receipt-data":             receipt,
-		"exclude-old-transactions": true,
+		"exclude-old-transactions": excludeOldTransactions,
 		"password":                 password,
 	}
 
@@ -115,11 +394,16 @@ func requestValidateWithUrl(ctx context.Context, httpc *http.Client, url, receip
 		return nil, nil, err
 	}
 
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
 	req, err := http.NewRequestWithContext(ctx, "POST", url, &w)
 	if err != nil {
 		return nil, nil, err
 	}
 	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	setHTTPHeaders(ctx, req)
 
 	resp, err := httpc.Do(req)
 	if err != nil {
@@ -138,8 +422,17 @@ func requestValidateWithUrl(ctx context.Context, httpc *http.Client, url, receip
 		if err := json.Unmarshal(buf, &out); err != nil {
 			return nil, nil, err
 		}
+		for _, inApp := range out.LatestReceiptInfo {
+			inApp.setComputed()
+		}
+		if out.Receipt != nil {
+			for _, inApp := range out.Receipt.InApp {
+				inApp.setComputed()
+			}
+		}
 		return &out, buf, nil
 	default:
-		return nil, nil, ErrNon200Apple
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, httpErrorBodyLimit))
+		return nil, nil, &AppleHTTPError{StatusCode: resp.StatusCode, Body: body, RetryAfter: parseRetryAfter(resp.Header.Get("Retry-After"))}
 	}
 }
@@ -0,0 +1,282 @@
+package iap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"math/big"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/internal/flight"
+)
+
+const (
+	AppleRootCAG3Url = "https://www.apple.com/certificateauthority/AppleRootCA-G3.cer"
+)
+
+var (
+	ErrAppleJWSInvalid       = errors.New("apple JWS is invalid")
+	ErrAppleRootCertsMissing = errors.New("apple root certificates unavailable")
+)
+
+var (
+	// Marker extensions Apple puts on the certificates of the StoreKit signing chain.
+	oidAppleLeaf         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+	oidAppleIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+)
+
+// AppleRootCertCache fetches Apple's published root CA certificates and keeps them for RefreshInterval.
+// When a refresh fails the previously fetched certificates keep being used.
+type AppleRootCertCache struct {
+	// URLs of DER encoded root certificates, default AppleRootCAG3Url.
+	URLs []string
+	// RefreshInterval default 24 hours.
+	RefreshInterval time.Duration
+	// HTTPClient default http.DefaultClient.
+	HTTPClient *http.Client
+
+	mu        sync.Mutex
+	pool      *x509.CertPool
+	fetchedAt time.Time
+	pinned    bool
+	// flight concurrent callers of a stale cache share one fetch, made without holding mu.
+	flight flight.Group
+}
+
+// DefaultAppleRootCerts is used by VerifyAppleJWS when ctx carries no ContextWithAppleRootCerts cache.
+var DefaultAppleRootCerts = &AppleRootCertCache{}
+
+type appleRootCertsKey struct{}
+
+// ContextWithAppleRootCerts returns a ctx VerifyAppleJWS, and the decoding of the signed transactions and
+// notifications built on it, verify against roots instead of DefaultAppleRootCerts.
+func ContextWithAppleRootCerts(ctx context.Context, roots *AppleRootCertCache) context.Context {
+	return context.WithValue(ctx, appleRootCertsKey{}, roots)
+}
+
+func appleRootCertsFromContext(ctx context.Context) *AppleRootCertCache {
+	if roots, ok := ctx.Value(appleRootCertsKey{}).(*AppleRootCertCache); ok && roots != nil {
+		return roots
+	}
+	return DefaultAppleRootCerts
+}
+
+// SetCertificates pins the root certificates (DER encoded) and stops fetching them, for air-gapped environments.
+func (c *AppleRootCertCache) SetCertificates(certs ...[]byte) error {
+	if len(certs) < 1 {
+		return errors.New("'certs' is empty")
+	}
+
+	pool := x509.NewCertPool()
+	for _, der := range certs {
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return err
+		}
+		pool.AddCert(cert)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.pool = pool
+	c.fetchedAt = time.Now()
+	c.pinned = true
+	return nil
+}
+
+// Pool returns the cached root certificates, fetching them when the cache is empty or stale.
+func (c *AppleRootCertCache) Pool(ctx context.Context) (*x509.CertPool, error) {
+	if pool, fresh := c.cached(); fresh {
+		return pool, nil
+	}
+
+	v, err, _ := c.flight.Do(ctx, "", func() (interface{}, error) {
+		pool, err := c.fetch(ctx)
+		if err != nil {
+			return nil, err
+		}
+
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		if c.pinned {
+			// SetCertificates was called during the fetch.
+			return c.pool, nil
+		}
+		c.pool = pool
+		c.fetchedAt = time.Now()
+		return pool, nil
+	})
+	if err != nil {
+		if stale, _ := c.cached(); stale != nil {
+			// keep serving the stale certificates, Apple rotates roots rarely.
+			return stale, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrAppleRootCertsMissing, err)
+	}
+	pool, ok := v.(*x509.CertPool)
+	if !ok {
+		return nil, ErrAppleRootCertsMissing
+	}
+	return pool, nil
+}
+
+// cached the current pool, nil when none was fetched, and whether it is pinned or within RefreshInterval.
+func (c *AppleRootCertCache) cached() (*x509.CertPool, bool) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	interval := c.RefreshInterval
+	if interval <= 0 {
+		interval = 24 * time.Hour
+	}
+	return c.pool, c.pool != nil && (c.pinned || time.Since(c.fetchedAt) < interval)
+}
+
+func (c *AppleRootCertCache) fetch(ctx context.Context) (*x509.CertPool, error) {
+	urls := c.URLs
+	if len(urls) < 1 {
+		urls = []string{AppleRootCAG3Url}
+	}
+	httpc := c.HTTPClient
+	if httpc == nil {
+		httpc = http.DefaultClient
+	}
+
+	pool := x509.NewCertPool()
+	for _, u := range urls {
+		req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+		if err != nil {
+			return nil, err
+		}
+
+		resp, err := httpc.Do(req)
+		if err != nil {
+			return nil, err
+		}
+		der, err := ioutil.ReadAll(resp.Body)
+		resp.Body.Close()
+		if err != nil {
+			return nil, err
+		}
+		if resp.StatusCode != 200 {
+			return nil, fmt.Errorf("non 200 response fetching %s", u)
+		}
+
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, err
+		}
+		pool.AddCert(cert)
+	}
+	return pool, nil
+}
+
+type jwsHeader struct {
+	Alg string   `json:"alg"`
+	X5c []string `json:"x5c"`
+}
+
+// VerifyAppleJWS verifies a JWS signed by the App Store (StoreKit 2 transactions, server notifications)
+// against the ContextWithAppleRootCerts cache of ctx, or DefaultAppleRootCerts, and returns the decoded payload.
+func VerifyAppleJWS(ctx context.Context, signed string) ([]byte, error) {
+	return verifyAppleJWS(ctx, appleRootCertsFromContext(ctx), signed)
+}
+
+func verifyAppleJWS(ctx context.Context, roots *AppleRootCertCache, signed string) ([]byte, error) {
+	if len(signed) < 1 {
+		return nil, errors.New("'signed' is empty")
+	}
+
+	parts := strings.Split(signed, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("%w: malformed", ErrAppleJWSInvalid)
+	}
+
+	rawHeader, err := base64.RawURLEncoding.DecodeString(parts[0])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleJWSInvalid, err)
+	}
+	var header jwsHeader
+	if err := json.Unmarshal(rawHeader, &header); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleJWSInvalid, err)
+	}
+	if header.Alg != "ES256" {
+		return nil, fmt.Errorf("%w: unexpected alg %q", ErrAppleJWSInvalid, header.Alg)
+	}
+	if len(header.X5c) < 2 {
+		return nil, fmt.Errorf("%w: certificate chain missing", ErrAppleJWSInvalid)
+	}
+
+	certs := make([]*x509.Certificate, 0, len(header.X5c))
+	for _, c := range header.X5c {
+		der, err := base64.StdEncoding.DecodeString(c)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAppleJWSInvalid, err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %v", ErrAppleJWSInvalid, err)
+		}
+		certs = append(certs, cert)
+	}
+
+	if !hasExtension(certs[0], oidAppleLeaf) || !hasExtension(certs[1], oidAppleIntermediate) {
+		return nil, fmt.Errorf("%w: not an App Store certificate chain", ErrAppleJWSInvalid)
+	}
+
+	pool, err := roots.Pool(ctx)
+	if err != nil {
+		return nil, err
+	}
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	if _, err := certs[0].Verify(x509.VerifyOptions{
+		Roots:         pool,
+		Intermediates: intermediates,
+		KeyUsages:     []x509.ExtKeyUsage{x509.ExtKeyUsageAny},
+	}); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleJWSInvalid, err)
+	}
+
+	key, ok := certs[0].PublicKey.(*ecdsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: leaf key is not ECDSA", ErrAppleJWSInvalid)
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil || len(sig) != 64 {
+		return nil, fmt.Errorf("%w: malformed signature", ErrAppleJWSInvalid)
+	}
+	digest := sha256.Sum256([]byte(parts[0] + "." + parts[1]))
+	r := new(big.Int).SetBytes(sig[:32])
+	s := new(big.Int).SetBytes(sig[32:])
+	if !ecdsa.Verify(key, digest[:], r, s) {
+		return nil, fmt.Errorf("%w: bad signature", ErrAppleJWSInvalid)
+	}
+
+	payload, err := base64.RawURLEncoding.DecodeString(parts[1])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleJWSInvalid, err)
+	}
+	return payload, nil
+}
+
+func hasExtension(cert *x509.Certificate, oid asn1.ObjectIdentifier) bool {
+	for _, ext := range cert.Extensions {
+		if ext.Id.Equal(oid) {
+			return true
+		}
+	}
+	return false
+}
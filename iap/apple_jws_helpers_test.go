@@ -0,0 +1,37 @@
+package iap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/pem"
+	"testing"
+
+	"github.com/panuwattoa/in-app-purchase/iap/iaptest"
+)
+
+// trustAppleSigner ctx verifying the Apple JWS against the root of s.
+func trustAppleSigner(t *testing.T, s *iaptest.AppleSigner) context.Context {
+	t.Helper()
+	roots := &AppleRootCertCache{}
+	if err := roots.SetCertificates(s.Root.Raw); err != nil {
+		t.Fatal(err)
+	}
+	return ContextWithAppleRootCerts(context.Background(), roots)
+}
+
+// newAppStoreConnectKey PEM PKCS8 ECDSA key like an App Store Connect .p8.
+func newAppStoreConnectKey(t *testing.T) string {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := x509.MarshalPKCS8PrivateKey(key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: der}))
+}
@@ -0,0 +1,108 @@
+package iap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap/iaptest"
+)
+
+func TestAppleRootCertCacheFetchUnlocked(t *testing.T) {
+	der := newSelfSignedCert(t, newRSAKey(t))
+	release := make(chan struct{})
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		<-release
+		_, _ = w.Write(der)
+	}))
+	defer srv.Close()
+	c := &AppleRootCertCache{URLs: []string{srv.URL}, HTTPClient: srv.Client()}
+
+	const n = 4
+	var wg sync.WaitGroup
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			_, err := c.Pool(context.Background())
+			errs <- err
+		}()
+	}
+	for atomic.LoadInt32(&requests) < 1 {
+		time.Sleep(time.Millisecond)
+	}
+
+	// the fetch in flight must not hold the cache lock.
+	pinned := make(chan error, 1)
+	go func() { pinned <- c.SetCertificates(der) }()
+	select {
+	case err := <-pinned:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(time.Second):
+		t.Fatal("SetCertificates blocked by the fetch in flight")
+	}
+
+	close(release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+	if requests != 1 {
+		t.Fatalf("%d fetches, want 1 shared fetch", requests)
+	}
+}
+
+func TestAppleRootCertCacheRefresh(t *testing.T) {
+	signer := iaptest.NewAppleSigner(t)
+	var requests, failing int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		if atomic.LoadInt32(&failing) > 0 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write(signer.Root.Raw)
+	}))
+	defer srv.Close()
+	c := &AppleRootCertCache{URLs: []string{srv.URL}, HTTPClient: srv.Client(), RefreshInterval: time.Hour}
+	ctx := ContextWithAppleRootCerts(context.Background(), c)
+	signed := signer.Sign(t, map[string]interface{}{"transactionId": "1000"})
+
+	// expire simulates RefreshInterval passing since the last fetch.
+	expire := func() {
+		c.mu.Lock()
+		c.fetchedAt = time.Now().Add(-2 * c.RefreshInterval)
+		c.mu.Unlock()
+	}
+	verify := func(step string, fetches int32) {
+		t.Helper()
+		if _, err := VerifyAppleJWS(ctx, signed); err != nil {
+			t.Fatalf("%s: %v", step, err)
+		}
+		if n := atomic.LoadInt32(&requests); n != fetches {
+			t.Fatalf("%s: %d fetches, want %d", step, n, fetches)
+		}
+	}
+
+	verify("first fetch", 1)
+	verify("cached", 1)
+	expire()
+	verify("refreshed", 2)
+
+	// a failed refresh keeps the stale certificates.
+	expire()
+	atomic.StoreInt32(&failing, 1)
+	verify("failed refresh", 3)
+}
@@ -0,0 +1,114 @@
+package iap
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrAppleNotificationInvalid  = errors.New("apple notification is invalid")
+	ErrAppleNotificationPassword = errors.New("apple notification shared secret mismatch")
+)
+
+// AppleNotificationV1 legacy (version 1) App Store server notification.
+type AppleNotificationV1 struct {
+	NotificationType   string               `json:"notification_type"` // e.g. DID_RENEW, CANCEL, REFUND, DID_CHANGE_RENEWAL_STATUS
+	Password           string               `json:"password"`          // The app shared secret, checked by ParseAppleNotificationV1.
+	Environment        string               `json:"environment"`       // possible values: 'Sandbox', 'PROD'.
+	AutoRenewStatus    string               `json:"auto_renew_status"` // Possible values: true, false
+	AutoRenewProductID string               `json:"auto_renew_product_id"`
+	BundleID           string               `json:"bid"`
+	BundleVersion      string               `json:"bvrs"`
+	UnifiedReceipt     *AppleUnifiedReceipt `json:"unified_receipt"`
+}
+
+type AppleUnifiedReceipt struct {
+	Status             int                  `json:"status"`
+	Environment        string               `json:"environment"`
+	LatestReceipt      string               `json:"latest_receipt"`
+	LatestReceiptInfo  []*InApp             `json:"latest_receipt_info"`
+	PendingRenewalInfo []PendingRenewalInfo `json:"pending_renewal_info"`
+}
+
+// ParseAppleNotificationV1 parses a legacy server notification body and checks its password is the app shared secret.
+func ParseAppleNotificationV1(body []byte, password string) (*AppleNotificationV1, error) {
+	if len(body) < 1 {
+		return nil, errors.New("'body' is empty")
+	}
+
+	if len(password) < 1 {
+		return nil, errors.New("'password' is empty")
+	}
+
+	var n AppleNotificationV1
+	if err := json.Unmarshal(body, &n); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleNotificationInvalid, err)
+	}
+
+	if subtle.ConstantTimeCompare([]byte(n.Password), []byte(password)) != 1 {
+		return nil, ErrAppleNotificationPassword
+	}
+
+	if len(n.NotificationType) < 1 || n.UnifiedReceipt == nil {
+		return nil, fmt.Errorf("%w: notification_type or unified_receipt missing", ErrAppleNotificationInvalid)
+	}
+	for _, inApp := range n.UnifiedReceipt.LatestReceiptInfo {
+		inApp.setComputed()
+	}
+	return &n, nil
+}
+
+// AppleNotificationV2 decoded App Store Server Notification V2, the signed transaction and renewal info are verified too.
+type AppleNotificationV2 struct {
+	NotificationType string                  `json:"notificationType"` // e.g. DID_RENEW, EXPIRED, REFUND, DID_CHANGE_RENEWAL_STATUS
+	Subtype          string                  `json:"subtype"`          // e.g. AUTO_RENEW_DISABLED, VOLUNTARY, BILLING_RETRY
+	NotificationUUID string                  `json:"notificationUUID"`
+	Version          string                  `json:"version"`
+	SignedDate       int64                   `json:"signedDate"`
+	Data             AppleNotificationV2Data `json:"data"`
+	// Transaction decoded Data.SignedTransactionInfo, nil when absent (e.g. TEST notifications).
+	Transaction *AppleTransaction `json:"-"`
+	// RenewalInfo decoded Data.SignedRenewalInfo, subscriptions only.
+	RenewalInfo *AppleRenewalInfo `json:"-"`
+}
+
+type AppleNotificationV2Data struct {
+	AppAppleID            int64  `json:"appAppleId"`
+	BundleID              string `json:"bundleId"`
+	BundleVersion         string `json:"bundleVersion"`
+	Environment           string `json:"environment"` // possible values: 'Sandbox', 'Production'.
+	SignedTransactionInfo string `json:"signedTransactionInfo"`
+	SignedRenewalInfo     string `json:"signedRenewalInfo"`
+	Status                int    `json:"status"` // Subscription status, 1 active, 2 expired, 3 billing retry, 4 grace period, 5 revoked.
+}
+
+// ParseAppleServerNotificationV2 verifies the signedPayload of a version 2 notification and decodes it.
+func ParseAppleServerNotificationV2(ctx context.Context, signedPayload string) (*AppleNotificationV2, error) {
+	payload, err := VerifyAppleJWS(ctx, signedPayload)
+	if err != nil {
+		return nil, err
+	}
+
+	var n AppleNotificationV2
+	if err := json.Unmarshal(payload, &n); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleNotificationInvalid, err)
+	}
+	if len(n.NotificationType) < 1 {
+		return nil, fmt.Errorf("%w: notificationType missing", ErrAppleNotificationInvalid)
+	}
+
+	if len(n.Data.SignedTransactionInfo) > 0 {
+		if n.Transaction, err = DecodeAppleTransaction(ctx, n.Data.SignedTransactionInfo); err != nil {
+			return nil, err
+		}
+	}
+	if len(n.Data.SignedRenewalInfo) > 0 {
+		if n.RenewalInfo, err = DecodeAppleRenewalInfo(ctx, n.Data.SignedRenewalInfo); err != nil {
+			return nil, err
+		}
+	}
+	return &n, nil
+}
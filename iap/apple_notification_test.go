@@ -0,0 +1,58 @@
+package iap
+
+import (
+	"context"
+	"errors"
+	"testing"
+
+	"github.com/panuwattoa/in-app-purchase/iap/iaptest"
+)
+
+func TestParseAppleServerNotificationV2(t *testing.T) {
+	signer := iaptest.NewAppleSigner(t)
+	ctx := trustAppleSigner(t, signer)
+
+	signedPayload := signer.Sign(t, map[string]interface{}{
+		"notificationType": "DID_RENEW",
+		"notificationUUID": "uuid",
+		"signedDate":       1700000000000,
+		"data": map[string]interface{}{
+			"bundleId":              "com.example.app",
+			"environment":           "Sandbox",
+			"signedTransactionInfo": signer.Sign(t, map[string]interface{}{"transactionId": "2", "originalTransactionId": "1", "productId": "monthly"}),
+			"signedRenewalInfo":     signer.Sign(t, map[string]interface{}{"originalTransactionId": "1", "autoRenewStatus": 1}),
+		},
+	})
+
+	n, err := ParseAppleServerNotificationV2(ctx, signedPayload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.NotificationType != "DID_RENEW" || n.Data.BundleID != "com.example.app" {
+		t.Fatalf("unexpected notification %+v", n)
+	}
+	if n.Transaction == nil || n.Transaction.TransactionID != "2" || n.RenewalInfo == nil || n.RenewalInfo.AutoRenewStatus != 1 {
+		t.Fatalf("unexpected transaction %+v renewal info %+v", n.Transaction, n.RenewalInfo)
+	}
+}
+
+func TestParseAppleServerNotificationV2Untrusted(t *testing.T) {
+	ctx := trustAppleSigner(t, iaptest.NewAppleSigner(t))
+	other := iaptest.NewAppleSigner(t)
+
+	_, err := ParseAppleServerNotificationV2(ctx, other.Sign(t, map[string]interface{}{"notificationType": "REFUND"}))
+	if !errors.Is(err, ErrAppleJWSInvalid) {
+		t.Fatalf("expected ErrAppleJWSInvalid, got %v", err)
+	}
+}
+
+func TestParseAppleServerNotificationV2Canceled(t *testing.T) {
+	signer := iaptest.NewAppleSigner(t)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+	ctx = ContextWithAppleRootCerts(ctx, &AppleRootCertCache{})
+	if _, err := ParseAppleServerNotificationV2(ctx, signer.Sign(t, map[string]interface{}{"notificationType": "TEST"})); err == nil {
+		t.Fatal("expected the canceled ctx to stop the root certificate fetch")
+	}
+}
@@ -0,0 +1,134 @@
+package iap
+
+import (
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"fmt"
+)
+
+var (
+	ErrAppleReceiptMalformed = errors.New("apple receipt is malformed")
+)
+
+// ASN.1 field types of the app receipt, see
+// https://developer.apple.com/library/archive/releasenotes/General/ValidateAppStoreReceipt/Chapters/ReceiptFields.html
+const (
+	appleReceiptAttrBundleID           = 2
+	appleReceiptAttrApplicationVersion = 3
+	appleReceiptAttrInApp              = 17
+	appleInAppAttrTransactionID        = 1703
+)
+
+// AppleLocalReceipt fields read from the app receipt itself without calling Apple.
+// The PKCS#7 signature is not verified, only use it to route the receipt (e.g. pick the shared secret),
+// never to grant anything.
+type AppleLocalReceipt struct {
+	BundleID           string
+	ApplicationVersion string
+	// InAppTransactionIDs transaction_id of every in-app purchase entry.
+	InAppTransactionIDs []string
+	// Attributes every attribute of the receipt by ASN.1 type, the values still DER encoded in receipt order,
+	// for fields not modeled here. Types that repeat (17 in-app purchase) have a value per attribute.
+	Attributes map[int][][]byte
+}
+
+type pkcs7ContentInfo struct {
+	ContentType asn1.ObjectIdentifier
+	Content     asn1.RawValue `asn1:"explicit,optional,tag:0"`
+}
+
+type pkcs7SignedData struct {
+	Version          int
+	DigestAlgorithms asn1.RawValue
+	ContentInfo      pkcs7ContentInfo
+	Certificates     asn1.RawValue `asn1:"optional,tag:0"`
+	CRLs             asn1.RawValue `asn1:"optional,tag:1"`
+	SignerInfos      asn1.RawValue
+}
+
+type appleReceiptAttribute struct {
+	Type    int
+	Version int
+	Value   []byte
+}
+
+// ParseAppleReceiptLocal decodes the base64 receipt the app sends and reads its attributes.
+func ParseAppleReceiptLocal(receipt string) (*AppleLocalReceipt, error) {
+	if len(receipt) < 1 {
+		return nil, errors.New("'receipt' is empty")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(receipt)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleReceiptMalformed, err)
+	}
+
+	var ci pkcs7ContentInfo
+	if _, err := asn1.Unmarshal(der, &ci); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleReceiptMalformed, err)
+	}
+	var sd pkcs7SignedData
+	if _, err := asn1.Unmarshal(ci.Content.Bytes, &sd); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleReceiptMalformed, err)
+	}
+	var payload []byte
+	if _, err := asn1.Unmarshal(sd.ContentInfo.Content.Bytes, &payload); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleReceiptMalformed, err)
+	}
+
+	var attrs []appleReceiptAttribute
+	if _, err := asn1.UnmarshalWithParams(payload, &attrs, "set"); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrAppleReceiptMalformed, err)
+	}
+
+	out := &AppleLocalReceipt{Attributes: make(map[int][][]byte, len(attrs))}
+	for _, attr := range attrs {
+		switch attr.Type {
+		case appleReceiptAttrBundleID, appleReceiptAttrApplicationVersion:
+			// a second bundle_id could route the receipt to another app.
+			if len(out.Attributes[attr.Type]) > 0 {
+				return nil, fmt.Errorf("%w: attribute %d repeated", ErrAppleReceiptMalformed, attr.Type)
+			}
+		}
+		out.Attributes[attr.Type] = append(out.Attributes[attr.Type], attr.Value)
+
+		switch attr.Type {
+		case appleReceiptAttrBundleID:
+			if _, err := asn1.Unmarshal(attr.Value, &out.BundleID); err != nil {
+				return nil, fmt.Errorf("%w: bundle_id: %v", ErrAppleReceiptMalformed, err)
+			}
+		case appleReceiptAttrApplicationVersion:
+			if _, err := asn1.Unmarshal(attr.Value, &out.ApplicationVersion); err != nil {
+				return nil, fmt.Errorf("%w: application_version: %v", ErrAppleReceiptMalformed, err)
+			}
+		case appleReceiptAttrInApp:
+			id, err := appleInAppTransactionID(attr.Value)
+			if err != nil {
+				return nil, fmt.Errorf("%w: in_app: %v", ErrAppleReceiptMalformed, err)
+			}
+			out.InAppTransactionIDs = append(out.InAppTransactionIDs, id)
+		}
+	}
+	return out, nil
+}
+
+// appleInAppTransactionID reads the transaction_id of an in-app purchase entry, itself a set of attributes.
+func appleInAppTransactionID(value []byte) (string, error) {
+	var attrs []appleReceiptAttribute
+	if _, err := asn1.UnmarshalWithParams(value, &attrs, "set"); err != nil {
+		return "", err
+	}
+
+	for _, attr := range attrs {
+		if attr.Type != appleInAppAttrTransactionID {
+			continue
+		}
+		var id string
+		if _, err := asn1.Unmarshal(attr.Value, &id); err != nil {
+			return "", err
+		}
+		return id, nil
+	}
+	return "", errors.New("transaction_id missing")
+}
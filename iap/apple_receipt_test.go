@@ -0,0 +1,87 @@
+package iap
+
+import (
+	"encoding/asn1"
+	"encoding/base64"
+	"errors"
+	"testing"
+)
+
+// appleLocalReceipt a PKCS#7 receipt wrapping attrs, unsigned as ParseAppleReceiptLocal doesn't verify it.
+func appleLocalReceipt(t *testing.T, attrs ...appleReceiptAttribute) string {
+	t.Helper()
+	marshal := func(v interface{}, params string) []byte {
+		der, err := asn1.MarshalWithParams(v, params)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return der
+	}
+	explicit := func(der []byte) asn1.RawValue {
+		return asn1.RawValue{Class: asn1.ClassContextSpecific, Tag: 0, IsCompound: true, Bytes: der}
+	}
+	type contentInfo struct {
+		ContentType asn1.ObjectIdentifier
+		Content     asn1.RawValue
+	}
+	type signedData struct {
+		Version          int
+		DigestAlgorithms asn1.RawValue
+		ContentInfo      contentInfo
+		SignerInfos      asn1.RawValue
+	}
+
+	data := asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 1}
+	emptySet := asn1.RawValue{Class: asn1.ClassUniversal, Tag: asn1.TagSet, IsCompound: true}
+	sd := marshal(signedData{
+		Version:          1,
+		DigestAlgorithms: emptySet,
+		ContentInfo:      contentInfo{ContentType: data, Content: explicit(marshal(marshal(attrs, "set"), ""))},
+		SignerInfos:      emptySet,
+	}, "")
+	return base64.StdEncoding.EncodeToString(marshal(contentInfo{ContentType: asn1.ObjectIdentifier{1, 2, 840, 113549, 1, 7, 2}, Content: explicit(sd)}, ""))
+}
+
+func appleReceiptString(t *testing.T, typ int, value string) appleReceiptAttribute {
+	t.Helper()
+	der, err := asn1.MarshalWithParams(value, "utf8")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return appleReceiptAttribute{Type: typ, Version: 1, Value: der}
+}
+
+func appleReceiptInApp(t *testing.T, transactionID string) appleReceiptAttribute {
+	t.Helper()
+	der, err := asn1.MarshalWithParams([]appleReceiptAttribute{appleReceiptString(t, appleInAppAttrTransactionID, transactionID)}, "set")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return appleReceiptAttribute{Type: appleReceiptAttrInApp, Version: 1, Value: der}
+}
+
+func TestParseAppleReceiptLocalRepeatedAttributes(t *testing.T) {
+	receipt := appleLocalReceipt(t,
+		appleReceiptString(t, appleReceiptAttrBundleID, "com.example.app"),
+		appleReceiptInApp(t, "1000"),
+		appleReceiptInApp(t, "1001"),
+	)
+	lr, err := ParseAppleReceiptLocal(receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if lr.BundleID != "com.example.app" || len(lr.InAppTransactionIDs) != 2 {
+		t.Fatalf("unexpected receipt %+v", lr)
+	}
+	if len(lr.Attributes[appleReceiptAttrInApp]) != 2 {
+		t.Fatalf("%d in-app attributes, want both", len(lr.Attributes[appleReceiptAttrInApp]))
+	}
+
+	repeated := appleLocalReceipt(t,
+		appleReceiptString(t, appleReceiptAttrBundleID, "com.example.app"),
+		appleReceiptString(t, appleReceiptAttrBundleID, "com.example.other"),
+	)
+	if _, err := ParseAppleReceiptLocal(repeated); !errors.Is(err, ErrAppleReceiptMalformed) {
+		t.Fatalf("error %v, want ErrAppleReceiptMalformed for a repeated bundle_id", err)
+	}
+}
@@ -0,0 +1,298 @@
+package iap
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+const (
+	AppleServerAPIUrlProduction = "https://api.storekit.itunes.apple.com"
+	AppleServerAPIUrlSandbox    = "https://api.storekit-sandbox.itunes.apple.com"
+)
+
+var (
+	ErrAppleBundleMismatch = errors.New("apple bundle id mismatch")
+)
+
+// appleServerAPITokenTTL Apple rejects tokens valid for more than an hour.
+const appleServerAPITokenTTL = 5 * time.Minute
+
+// ValidateTransactionApple validate a StoreKit 2 signed transaction with the App Store Server API.
+// The signed transaction from the client is only trusted to pick the transaction ID, bundle and environment,
+// the returned transaction is the one Apple's server signed.
+// Callers serving a known app must check its BundleID.
+// issuerID, keyID and privateKey (PEM .p8) are the App Store Connect in-app purchase key.
+// return the transaction and raw data.
+func ValidateTransactionApple(ctx context.Context, httpc *http.Client, signedTransaction, issuerID, keyID, privateKey string) (*AppleTransaction, []byte, error) {
+	client, err := DecodeAppleTransaction(ctx, signedTransaction)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	token, err := appleServerAPIToken(issuerID, keyID, client.BundleID, privateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	raw, err := requestAppleServerAPI(ctx, httpc, client.Environment, "/inApps/v1/transactions/"+client.TransactionID, token)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var info struct {
+		SignedTransactionInfo string `json:"signedTransactionInfo"`
+	}
+	if err := json.Unmarshal(raw, &info); err != nil {
+		return nil, nil, err
+	}
+
+	out, err := DecodeAppleTransaction(ctx, info.SignedTransactionInfo)
+	if err != nil {
+		return nil, nil, err
+	}
+	if out.BundleID != client.BundleID {
+		return nil, nil, fmt.Errorf("%w: %s, expected %s", ErrAppleBundleMismatch, out.BundleID, client.BundleID)
+	}
+	return out, raw, nil
+}
+
+func requestAppleServerAPI(ctx context.Context, httpc *http.Client, environment, path, token string) ([]byte, error) {
+	base := AppleServerAPIUrlProduction
+	if environment == AppleSandboxEnv {
+		base = AppleServerAPIUrlSandbox
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", base+path, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Authorization", "Bearer "+token)
+	req.Header.Set("Accept", "application/json")
+	setHTTPHeaders(ctx, req)
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, httpErrorBodyLimit))
+		return nil, &AppleHTTPError{StatusCode: resp.StatusCode, Body: body}
+	}
+	return ioutil.ReadAll(resp.Body)
+}
+
+// appleServerAPIToken signs the ES256 bearer token the App Store Server API expects.
+func appleServerAPIToken(issuerID, keyID, bundleID, privateKey string) (string, error) {
+	if len(issuerID) < 1 {
+		return "", errors.New("'issuerID' is empty")
+	}
+
+	if len(keyID) < 1 {
+		return "", errors.New("'keyID' is empty")
+	}
+
+	block, _ := pem.Decode([]byte(privateKey))
+	if block == nil {
+		return "", errors.New("'privateKey' is not PEM encoded")
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	if err != nil {
+		return "", err
+	}
+	key, ok := parsed.(*ecdsa.PrivateKey)
+	if !ok {
+		return "", errors.New("'privateKey' is not an ECDSA key")
+	}
+
+	now := time.Now()
+	header, err := json.Marshal(map[string]string{"alg": "ES256", "kid": keyID, "typ": "JWT"})
+	if err != nil {
+		return "", err
+	}
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss": issuerID,
+		"iat": now.Unix(),
+		"exp": now.Add(appleServerAPITokenTTL).Unix(),
+		"aud": "appstoreconnect-v1",
+		"bid": bundleID,
+	})
+	if err != nil {
+		return "", err
+	}
+
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(claims)
+	digest := sha256.Sum256([]byte(signing))
+	r, s, err := ecdsa.Sign(rand.Reader, key, digest[:])
+	if err != nil {
+		return "", fmt.Errorf("signing App Store Server API token: %w", err)
+	}
+
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	s.FillBytes(sig[32:])
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// AppStoreServerCreds App Store Connect in-app purchase key and app for the App Store Server API.
+type AppStoreServerCreds struct {
+	IssuerID string
+	KeyID    string
+	// PrivateKey PEM .p8 of the key.
+	PrivateKey string
+	BundleID   string
+	// Environment AppleProductionEnv or AppleSandboxEnv, when empty production is tried first then the sandbox.
+	Environment string
+}
+
+// Subscription statuses of the Get All Subscription Statuses endpoint.
+const (
+	AppleSubscriptionStatusActive       = 1
+	AppleSubscriptionStatusExpired      = 2
+	AppleSubscriptionStatusBillingRetry = 3
+	AppleSubscriptionStatusBillingGrace = 4
+	AppleSubscriptionStatusRevoked      = 5
+)
+
+// App Store Server API errorCode of a 404 for a transaction unknown to the environment.
+const (
+	appleErrorOriginalTransactionIdNotFound = 4040005
+	appleErrorTransactionIdNotFound         = 4040010
+)
+
+// AppleSubscriptionStatus latest state of one subscription of the customer, with its decoded signed transaction and renewal info.
+type AppleSubscriptionStatus struct {
+	SubscriptionGroupIdentifier string
+	OriginalTransactionID       string
+	Status                      int // one of the AppleSubscriptionStatus consts.
+	// AutoRenew and ExpiresDate (UNIX milliseconds) read from RenewalInfo and Transaction.
+	AutoRenew   bool
+	ExpiresDate int64
+	Transaction *AppleTransaction
+	RenewalInfo *AppleRenewalInfo
+	// DecodeErr the signed transaction or renewal info failed to decode or verify, the one that failed is nil.
+	DecodeErr error
+}
+
+type appleSubscriptionStatusesResponse struct {
+	Environment string `json:"environment"`
+	BundleID    string `json:"bundleId"`
+	Data        []struct {
+		SubscriptionGroupIdentifier string `json:"subscriptionGroupIdentifier"`
+		LastTransactions            []struct {
+			OriginalTransactionID string `json:"originalTransactionId"`
+			Status                int    `json:"status"`
+			SignedTransactionInfo string `json:"signedTransactionInfo"`
+			SignedRenewalInfo     string `json:"signedRenewalInfo"`
+		} `json:"lastTransactions"`
+	} `json:"data"`
+}
+
+// GetSubscriptionStatusesApple the statuses of every subscription of the customer owning originalTransactionID,
+// one entry per subscription group, with the App Store Server API Get All Subscription Statuses endpoint.
+// Statuses of an app other than creds.BundleID fail with ErrAppleBundleMismatch, an entry whose signed data
+// doesn't decode is returned with DecodeErr set.
+// return the statuses and raw data.
+func GetSubscriptionStatusesApple(ctx context.Context, httpc *http.Client, originalTransactionID string, creds AppStoreServerCreds) ([]*AppleSubscriptionStatus, []byte, error) {
+	if len(originalTransactionID) < 1 {
+		return nil, nil, errors.New("'originalTransactionID' is empty")
+	}
+
+	token, err := appleServerAPIToken(creds.IssuerID, creds.KeyID, creds.BundleID, creds.PrivateKey)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	path := "/inApps/v1/subscriptions/" + originalTransactionID
+	env := creds.Environment
+	if len(env) < 1 {
+		env = AppleProductionEnv
+	}
+	raw, err := requestAppleServerAPI(ctx, httpc, env, path, token)
+	if len(creds.Environment) < 1 && isAppleServerAPINotFound(err) {
+		// the transaction belongs to the sandbox.
+		raw, err = requestAppleServerAPI(ctx, httpc, AppleSandboxEnv, path, token)
+	}
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var resp appleSubscriptionStatusesResponse
+	if err := json.Unmarshal(raw, &resp); err != nil {
+		return nil, nil, err
+	}
+	if resp.BundleID != creds.BundleID {
+		return nil, nil, fmt.Errorf("%w: %s, expected %s", ErrAppleBundleMismatch, resp.BundleID, creds.BundleID)
+	}
+
+	log := LoggerFromContext(ctx)
+	var out []*AppleSubscriptionStatus
+	for _, group := range resp.Data {
+		for _, last := range group.LastTransactions {
+			status := &AppleSubscriptionStatus{
+				SubscriptionGroupIdentifier: group.SubscriptionGroupIdentifier,
+				OriginalTransactionID:       last.OriginalTransactionID,
+				Status:                      last.Status,
+			}
+			// an entry that doesn't decode is returned with DecodeErr, the other entries are still usable.
+			if len(last.SignedTransactionInfo) > 0 {
+				tx, err := DecodeAppleTransaction(ctx, last.SignedTransactionInfo)
+				switch {
+				case err != nil:
+					status.DecodeErr = err
+				case tx.BundleID != creds.BundleID:
+					return nil, nil, fmt.Errorf("%w: %s, expected %s", ErrAppleBundleMismatch, tx.BundleID, creds.BundleID)
+				default:
+					status.Transaction = tx
+					status.ExpiresDate = tx.ExpiresDate
+				}
+			}
+			if len(last.SignedRenewalInfo) > 0 {
+				if renewal, err := DecodeAppleRenewalInfo(ctx, last.SignedRenewalInfo); err != nil {
+					status.DecodeErr = err
+				} else {
+					status.RenewalInfo = renewal
+					status.AutoRenew = renewal.AutoRenewStatus == 1
+				}
+			}
+			if status.DecodeErr != nil {
+				log.Error("apple subscription status undecodable", "original_transaction_id", last.OriginalTransactionID, "error", status.DecodeErr)
+			}
+			out = append(out, status)
+		}
+	}
+	return out, raw, nil
+}
+
+// isAppleServerAPINotFound a 404 for an unknown transaction ID, e.g. asking production about a sandbox transaction.
+func isAppleServerAPINotFound(err error) bool {
+	var httpErr *AppleHTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != 404 {
+		return false
+	}
+
+	var body struct {
+		ErrorCode int `json:"errorCode"`
+	}
+	if json.Unmarshal(httpErr.Body, &body) != nil {
+		return true
+	}
+	return body.ErrorCode == appleErrorOriginalTransactionIdNotFound || body.ErrorCode == appleErrorTransactionIdNotFound
+}
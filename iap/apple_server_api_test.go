@@ -0,0 +1,111 @@
+package iap
+
+import (
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/panuwattoa/in-app-purchase/iap/iaptest"
+)
+
+func TestValidateTransactionApple(t *testing.T) {
+	signer := iaptest.NewAppleSigner(t)
+	ctx := trustAppleSigner(t, signer)
+	privateKey := newAppStoreConnectKey(t)
+
+	tests := []struct {
+		name         string
+		serverBundle string
+		wantErr      error
+	}{
+		{"same bundle", "com.example.app", nil},
+		{"other bundle", "com.example.other", ErrAppleBundleMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var path, auth string
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				path, auth = r.URL.Path, r.Header.Get("Authorization")
+				json.NewEncoder(w).Encode(map[string]string{
+					"signedTransactionInfo": signer.Sign(t, map[string]interface{}{"transactionId": "42", "bundleId": tt.serverBundle, "productId": "gems"}),
+				})
+			}))
+			defer srv.Close()
+
+			client := signer.Sign(t, map[string]interface{}{"transactionId": "42", "bundleId": "com.example.app", "environment": AppleSandboxEnv})
+			tx, _, err := ValidateTransactionApple(ctx, redirectClient(srv), client, "issuer", "kid", privateKey)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if path != "/inApps/v1/transactions/42" || !strings.HasPrefix(auth, "Bearer ") {
+				t.Fatalf("unexpected request %s %s", path, auth)
+			}
+			if tt.wantErr == nil && tx.ProductID != "gems" {
+				t.Fatalf("unexpected transaction %+v", tx)
+			}
+		})
+	}
+}
+
+func TestGetSubscriptionStatusesApple(t *testing.T) {
+	signer := iaptest.NewAppleSigner(t)
+	ctx := trustAppleSigner(t, signer)
+	creds := AppStoreServerCreds{IssuerID: "issuer", KeyID: "kid", PrivateKey: newAppStoreConnectKey(t), BundleID: "com.example.app", Environment: AppleProductionEnv}
+
+	statuses := func(bundleID, txBundleID string) map[string]interface{} {
+		return map[string]interface{}{
+			"environment": AppleProductionEnv,
+			"bundleId":    bundleID,
+			"data": []map[string]interface{}{{
+				"subscriptionGroupIdentifier": "group",
+				"lastTransactions": []map[string]interface{}{
+					{
+						"originalTransactionId": "1000",
+						"status":                AppleSubscriptionStatusActive,
+						"signedTransactionInfo": signer.Sign(t, map[string]interface{}{"transactionId": "1001", "bundleId": txBundleID, "expiresDate": 1700000000000}),
+						"signedRenewalInfo":     signer.Sign(t, map[string]interface{}{"autoRenewStatus": 1}),
+					},
+					{"originalTransactionId": "2000", "status": AppleSubscriptionStatusExpired, "signedTransactionInfo": "not.a.jws"},
+				},
+			}},
+		}
+	}
+
+	tests := []struct {
+		name    string
+		body    map[string]interface{}
+		wantErr error
+	}{
+		{"same bundle", statuses("com.example.app", "com.example.app"), nil},
+		{"other bundle", statuses("com.example.other", "com.example.app"), ErrAppleBundleMismatch},
+		{"transaction of other bundle", statuses("com.example.app", "com.example.other"), ErrAppleBundleMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				json.NewEncoder(w).Encode(tt.body)
+			}))
+			defer srv.Close()
+
+			out, _, err := GetSubscriptionStatusesApple(ctx, redirectClient(srv), "1000", creds)
+			if !errors.Is(err, tt.wantErr) {
+				t.Fatalf("expected %v, got %v", tt.wantErr, err)
+			}
+			if tt.wantErr != nil {
+				return
+			}
+			if len(out) != 2 {
+				t.Fatalf("expected 2 statuses, got %d", len(out))
+			}
+			if out[0].DecodeErr != nil || !out[0].AutoRenew || out[0].ExpiresDate != 1700000000000 {
+				t.Fatalf("unexpected status %+v", out[0])
+			}
+			if out[1].DecodeErr == nil || out[1].Transaction != nil || out[1].Status != AppleSubscriptionStatusExpired {
+				t.Fatalf("unexpected undecodable status %+v", out[1])
+			}
+		})
+	}
+}
@@ -0,0 +1,316 @@
+package iap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestAppleRetryAfter(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			w.Header().Set("Retry-After", "1")
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_, _ = w.Write([]byte(`{"status":0,"environment":"Production"}`))
+	}))
+	defer srv.Close()
+
+	opts := AppleOptions{
+		ProductionUrl: srv.URL,
+		Retry:         AppleRetryPolicy{MaxAttempts: 2, RetryUnavailable: true},
+	}
+	start := time.Now()
+	resp, _, err := ValidateReceiptAppleWithOptions(context.Background(), srv.Client(), "receipt", "", opts)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 0 || requests != 2 {
+		t.Fatalf("status %d after %d requests, want 0 after 2", resp.Status, requests)
+	}
+	if elapsed := time.Since(start); elapsed < time.Second {
+		t.Fatalf("retried after %v, want the 1s Retry-After", elapsed)
+	}
+}
+
+func TestAppleRetryAfterCapped(t *testing.T) {
+	p := AppleRetryPolicy{}
+	if d := p.delay(0, time.Hour); d != maxAppleRetryAfter {
+		t.Fatalf("delay %v for an hour Retry-After, want %v", d, maxAppleRetryAfter)
+	}
+	if d := p.delay(0, 2*time.Second); d != 2*time.Second {
+		t.Fatalf("delay %v for a 2s Retry-After, want 2s", d)
+	}
+	if d := p.delay(time.Second, 0); d < time.Second/2 || d > time.Second {
+		t.Fatalf("delay %v for a 1s backoff, want between 500ms and 1s", d)
+	}
+}
+
+func TestAppleSandboxOnlySingleRequest(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(`{"status":21005,"is-retryable":true,"environment":"Sandbox"}`))
+	}))
+	defer srv.Close()
+
+	opts := AppleOptions{
+		SandboxOnly: true,
+		SandboxUrl:  srv.URL,
+		Retry:       AppleRetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond},
+	}
+	if _, _, err := ValidateReceiptAppleWithOptions(context.Background(), srv.Client(), "receipt", "", opts); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Fatalf("%d requests, want 1", requests)
+	}
+}
+
+func TestAppleSandboxFallbackRaw(t *testing.T) {
+	const sandboxBody = `{"status":0,"environment":"Sandbox"}`
+	mux := http.NewServeMux()
+	mux.HandleFunc("/production", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":21007}`))
+	})
+	mux.HandleFunc("/sandbox", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(sandboxBody))
+	})
+	srv := httptest.NewServer(mux)
+	defer srv.Close()
+
+	opts := AppleOptions{ProductionUrl: srv.URL + "/production", SandboxUrl: srv.URL + "/sandbox"}
+	for name, validate := range map[string]func() (*ValidateReceiptAppleResponse, []byte, error){
+		"receipt": func() (*ValidateReceiptAppleResponse, []byte, error) {
+			return ValidateReceiptAppleWithOptions(context.Background(), srv.Client(), "receipt", "", opts)
+		},
+		"subscription": func() (*ValidateReceiptAppleResponse, []byte, error) {
+			return ValidateSubscriptionReceiptAppleWithOptions(context.Background(), srv.Client(), "receipt", "secret", opts)
+		},
+	} {
+		resp, raw, err := validate()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !resp.UsedSandboxFallback || string(raw) != sandboxBody {
+			t.Fatalf("%s: raw %s, want the sandbox body", name, raw)
+		}
+	}
+}
+
+func TestAppleProductionOnly(t *testing.T) {
+	tests := []struct {
+		name           string
+		productionOnly bool
+		err            error
+		sandbox        int32
+	}{
+		{name: "production only", productionOnly: true, err: ErrSandboxReceiptInProduction},
+		{name: "sandbox fallback", sandbox: 1},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var sandbox int32
+			mux := http.NewServeMux()
+			mux.HandleFunc("/production", func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"status":21007}`))
+			})
+			mux.HandleFunc("/sandbox", func(w http.ResponseWriter, r *http.Request) {
+				atomic.AddInt32(&sandbox, 1)
+				_, _ = w.Write([]byte(`{"status":0,"environment":"Sandbox"}`))
+			})
+			srv := httptest.NewServer(mux)
+			defer srv.Close()
+
+			opts := AppleOptions{ProductionUrl: srv.URL + "/production", SandboxUrl: srv.URL + "/sandbox", ProductionOnly: tt.productionOnly}
+			resp, _, err := validateWithSandboxFallback(context.Background(), srv.Client(), "receipt", "", true, opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if sandbox != tt.sandbox {
+				t.Fatalf("%d sandbox requests, want %d", sandbox, tt.sandbox)
+			}
+			if tt.err == nil && (!resp.UsedSandboxFallback || resp.Environment != AppleSandboxEnv) {
+				t.Fatalf("response %+v, want the sandbox fallback", resp)
+			}
+		})
+	}
+}
+
+func TestAppleExcludeOldTransactions(t *testing.T) {
+	exclude, include := true, false
+	validateReceipt := func(ctx context.Context, httpc *http.Client, opts AppleOptions) (*ValidateReceiptAppleResponse, []byte, error) {
+		return ValidateReceiptAppleWithOptions(ctx, httpc, "receipt", "", opts)
+	}
+	validateSubscription := func(ctx context.Context, httpc *http.Client, opts AppleOptions) (*ValidateReceiptAppleResponse, []byte, error) {
+		return ValidateSubscriptionReceiptAppleWithOptions(ctx, httpc, "receipt", "secret", opts)
+	}
+	tests := []struct {
+		name     string
+		validate func(ctx context.Context, httpc *http.Client, opts AppleOptions) (*ValidateReceiptAppleResponse, []byte, error)
+		option   *bool
+		want     bool
+	}{
+		{name: "purchase default", validate: validateReceipt, want: true},
+		{name: "purchase included", validate: validateReceipt, option: &include, want: false},
+		{name: "subscription default", validate: validateSubscription, want: false},
+		{name: "subscription excluded", validate: validateSubscription, option: &exclude, want: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var payload map[string]interface{}
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewDecoder(r.Body).Decode(&payload)
+				_, _ = w.Write([]byte(`{"status":0,"environment":"Production","receipt":{"in_app":[
+					{"product_id":"monthly","transaction_id":"1","original_transaction_id":"1"},
+					{"product_id":"monthly","transaction_id":"2","original_transaction_id":"1"},
+					{"product_id":"monthly","transaction_id":"3","original_transaction_id":"1"}]}}`))
+			}))
+			defer srv.Close()
+
+			resp, _, err := tt.validate(context.Background(), srv.Client(), AppleOptions{ProductionUrl: srv.URL, ExcludeOldTransactions: tt.option})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if got, ok := payload["exclude-old-transactions"].(bool); !ok || got != tt.want {
+				t.Fatalf("exclude-old-transactions %v, want %v", payload["exclude-old-transactions"], tt.want)
+			}
+			if len(resp.Receipt.InApp) != 3 {
+				t.Fatalf("%d in_app entries, want every renewal", len(resp.Receipt.InApp))
+			}
+			for i, inApp := range resp.Receipt.InApp {
+				if want := strconv.Itoa(i + 1); inApp.TransactionId != want {
+					t.Fatalf("in_app %d transaction %q, want %q", i, inApp.TransactionId, want)
+				}
+			}
+		})
+	}
+}
+
+func TestAppleRetryRetryable(t *testing.T) {
+	tests := []struct {
+		name     string
+		bodies   []string
+		want     string
+		requests int32
+	}{
+		{
+			name:     "retryable twice then valid",
+			bodies:   []string{`{"status":21005,"is-retryable":true}`, `{"status":21005,"is-retryable":true}`, `{"status":0,"environment":"Production"}`},
+			want:     `{"status":0,"environment":"Production"}`,
+			requests: 3,
+		},
+		{
+			name:     "not retryable",
+			bodies:   []string{`{"status":21010}`, `{"status":0,"environment":"Production"}`},
+			want:     `{"status":21010}`,
+			requests: 1,
+		},
+		{
+			name:     "attempts exhausted",
+			bodies:   []string{`{"status":21005,"is-retryable":true}`, `{"status":21005,"is-retryable":true}`, `{"status":21009,"is-retryable":true}`, `{"status":0}`},
+			want:     `{"status":21009,"is-retryable":true}`,
+			requests: 3,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			var requests int32
+			srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&requests, 1)
+				_, _ = w.Write([]byte(tt.bodies[n-1]))
+			}))
+			defer srv.Close()
+
+			opts := AppleOptions{ProductionUrl: srv.URL, Retry: AppleRetryPolicy{MaxAttempts: 3, Backoff: time.Millisecond}}
+			_, raw, err := ValidateReceiptAppleWithOptions(context.Background(), srv.Client(), "receipt", "", opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if string(raw) != tt.want || requests != tt.requests {
+				t.Fatalf("body %s after %d requests, want %s after %d", raw, requests, tt.want, tt.requests)
+			}
+		})
+	}
+}
+
+func TestAppleRetryDeadline(t *testing.T) {
+	var requests int32
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		_, _ = w.Write([]byte(`{"status":21005,"is-retryable":true}`))
+	}))
+	defer srv.Close()
+
+	// the backoff outlasts the deadline, the last body is returned without waiting for it.
+	ctx, cancel := context.WithTimeout(context.Background(), 100*time.Millisecond)
+	defer cancel()
+	opts := AppleOptions{ProductionUrl: srv.URL, Retry: AppleRetryPolicy{MaxAttempts: 3, Backoff: time.Minute}}
+	start := time.Now()
+	resp, _, err := ValidateReceiptAppleWithOptions(ctx, srv.Client(), "receipt", "", opts)
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Fatalf("retry took %v past the deadline", elapsed)
+	}
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.Status != 21005 || requests != 1 {
+		t.Fatalf("status %d after %d requests, want the 21005", resp.Status, requests)
+	}
+}
+
+func TestAppleLatestReceiptInfo(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte(`{"status":0,"environment":"Production","latest_receipt":"bGF0ZXN0","receipt":{"in_app":[
+			{"product_id":"monthly","transaction_id":"1000","original_transaction_id":"1000","expires_date_ms":"1600000000000"}]},
+			"latest_receipt_info":[
+			{"product_id":"monthly","transaction_id":"1001","original_transaction_id":"1000","expires_date_ms":"1700000000000"},
+			{"product_id":"monthly","transaction_id":"1000","original_transaction_id":"1000","expires_date_ms":"1600000000000"}]}`))
+	}))
+	defer srv.Close()
+
+	resp, _, err := ValidateSubscriptionReceiptAppleWithOptions(context.Background(), srv.Client(), "receipt", "secret", AppleOptions{ProductionUrl: srv.URL})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.LatestReceipt != "bGF0ZXN0" || len(resp.LatestReceiptInfo) != 2 || len(resp.Receipt.InApp) != 1 {
+		t.Fatalf("response %+v, want both sections", resp)
+	}
+	if transactions := resp.SubscriptionTransactions(); len(transactions) != 2 || transactions[0].TransactionId != "1001" {
+		t.Fatalf("subscription transactions %+v, want the latest_receipt_info ones", transactions)
+	}
+
+	// in_app without latest_receipt_info.
+	resp.LatestReceiptInfo = nil
+	if transactions := resp.SubscriptionTransactions(); len(transactions) != 1 || transactions[0].TransactionId != "1000" {
+		t.Fatalf("subscription transactions %+v, want the in_app ones", transactions)
+	}
+}
+
+func TestAppleHTTPError(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+		_, _ = w.Write([]byte("internal error"))
+	}))
+	defer srv.Close()
+
+	_, _, err := ValidateReceiptAppleWithOptions(context.Background(), srv.Client(), "receipt", "", AppleOptions{ProductionUrl: srv.URL})
+	var httpErr *AppleHTTPError
+	if !errors.As(err, &httpErr) {
+		t.Fatalf("error %v, want an AppleHTTPError", err)
+	}
+	if httpErr.StatusCode != http.StatusInternalServerError || string(httpErr.Body) != "internal error" {
+		t.Fatalf("status %d body %q, want the 500 response", httpErr.StatusCode, httpErr.Body)
+	}
+	if !errors.Is(err, ErrNon200Apple) {
+		t.Fatalf("error %v, want ErrNon200Apple", err)
+	}
+}
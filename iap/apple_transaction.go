@@ -0,0 +1,89 @@
+package iap
+
+import (
+	"context"
+	"encoding/json"
+)
+
+const (
+	AppleTransactionTypeAutoRenewable = "Auto-Renewable Subscription"
+	AppleTransactionTypeNonConsumable = "Non-Consumable"
+	AppleTransactionTypeConsumable    = "Consumable"
+	AppleTransactionTypeNonRenewing   = "Non-Renewing Subscription"
+)
+
+// AppleTransaction is the decoded payload of a StoreKit 2 / App Store Server API signed transaction (JWSTransaction).
+// Dates are UNIX milliseconds.
+type AppleTransaction struct {
+	TransactionID               string `json:"transactionId"`
+	OriginalTransactionID       string `json:"originalTransactionId"`
+	WebOrderLineItemID          string `json:"webOrderLineItemId"`
+	BundleID                    string `json:"bundleId"`
+	ProductID                   string `json:"productId"`
+	SubscriptionGroupIdentifier string `json:"subscriptionGroupIdentifier"`
+	PurchaseDate                int64  `json:"purchaseDate"`
+	OriginalPurchaseDate        int64  `json:"originalPurchaseDate"`
+	ExpiresDate                 int64  `json:"expiresDate"` // Only returned for subscriptions.
+	Quantity                    int    `json:"quantity"`
+	Type                        string `json:"type"` // Possible values: Auto-Renewable Subscription, Non-Consumable, Consumable, Non-Renewing Subscription
+	AppAccountToken             string `json:"appAccountToken"`
+	InAppOwnershipType          string `json:"inAppOwnershipType"` // Possible values: PURCHASED, FAMILY_SHARED
+	SignedDate                  int64  `json:"signedDate"`
+	RevocationReason            *int   `json:"revocationReason"` // Only present for refunded transactions, possible values: 0, 1
+	RevocationDate              int64  `json:"revocationDate"`
+	IsUpgraded                  bool   `json:"isUpgraded"`
+	OfferType                   int    `json:"offerType"`
+	OfferIdentifier             string `json:"offerIdentifier"`
+	Environment                 string `json:"environment"`       // possible values: 'Sandbox', 'Production'.
+	Storefront                  string `json:"storefront"`        // ISO 3166-1 alpha-3 country code e.g. USA
+	StorefrontID                string `json:"storefrontId"`      // Apple defined storefront identifier
+	TransactionReason           string `json:"transactionReason"` // Possible values: PURCHASE, RENEWAL
+	Currency                    string `json:"currency"`
+	Price                       int64  `json:"price"` // milli units of Currency
+}
+
+// DecodeAppleTransaction verifies a signed transaction against Apple's root certificates and decodes it.
+func DecodeAppleTransaction(ctx context.Context, signedTransaction string) (*AppleTransaction, error) {
+	payload, err := VerifyAppleJWS(ctx, signedTransaction)
+	if err != nil {
+		return nil, err
+	}
+
+	var out AppleTransaction
+	if err := json.Unmarshal(payload, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// AppleRenewalInfo is the decoded payload of a signed renewal info (JWSRenewalInfo). Dates are UNIX milliseconds.
+type AppleRenewalInfo struct {
+	OriginalTransactionID       string `json:"originalTransactionId"`
+	ProductID                   string `json:"productId"`
+	AutoRenewProductID          string `json:"autoRenewProductId"`
+	AutoRenewStatus             int    `json:"autoRenewStatus"`  // Possible values: 1, 0
+	ExpirationIntent            int    `json:"expirationIntent"` // Only present for expired subscriptions.
+	IsInBillingRetryPeriod      bool   `json:"isInBillingRetryPeriod"`
+	GracePeriodExpiresDate      int64  `json:"gracePeriodExpiresDate"`
+	OfferType                   int    `json:"offerType"`
+	OfferIdentifier             string `json:"offerIdentifier"`
+	PriceIncreaseStatus         *int   `json:"priceIncreaseStatus"`
+	RecentSubscriptionStartDate int64  `json:"recentSubscriptionStartDate"`
+	RenewalDate                 int64  `json:"renewalDate"`
+	SignedDate                  int64  `json:"signedDate"`
+	Environment                 string `json:"environment"` // possible values: 'Sandbox', 'Production'.
+}
+
+// DecodeAppleRenewalInfo verifies a signed renewal info against Apple's root certificates and decodes it.
+func DecodeAppleRenewalInfo(ctx context.Context, signedRenewalInfo string) (*AppleRenewalInfo, error) {
+	payload, err := VerifyAppleJWS(ctx, signedRenewalInfo)
+	if err != nil {
+		return nil, err
+	}
+
+	var out AppleRenewalInfo
+	if err := json.Unmarshal(payload, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
@@ -1,16 +1,24 @@
 package iap
 
 import (
+	"bytes"
 	"context"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/hex"
 	"encoding/json"
 	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
-	"log"
+	"net"
 	"net/http"
 	"net/url"
+	"strings"
+	"sync"
 	"time"
 
+	"golang.org/x/oauth2"
 	"golang.org/x/oauth2/google"
 	goJWT "golang.org/x/oauth2/jwt"
 )
@@ -22,6 +30,19 @@ type ReceiptGoogle struct {
 	PurchaseState int    `json:"purchaseState"`
 	PurchaseTime  int64  `json:"purchaseTime"`
 	PurchaseToken string `json:"purchaseToken"`
+	// SkuDetails decoded skuDetails of the wrapped receipt, nil when the client didn't include it.
+	SkuDetails *SkuDetailsGoogle `json:"-"`
+}
+
+// SkuDetailsGoogle the product listing as shown to the user at purchase time, prices are not validated by Google.
+type SkuDetailsGoogle struct {
+	ProductID         string `json:"productId"`
+	Type              string `json:"type"` // Possible values: inapp, subs
+	Price             string `json:"price"`
+	PriceAmountMicros int64  `json:"price_amount_micros"`
+	PriceCurrencyCode string `json:"price_currency_code"`
+	Title             string `json:"title"`
+	Description       string `json:"description"`
 }
 
 type ReceiptGoogleResponse struct {
@@ -34,6 +55,14 @@ type ReceiptGoogleResponse struct {
 	PurchaseTimeMillis   string `json:"purchaseTimeMillis"`
 	PurchaseType         int    `json:"purchaseType"`
 	RegionCode           string `json:"regionCode"`
+	// IsTestPurchase is set when the response carries purchaseType 0, a license tester purchase. PurchaseType is
+	// also 0 for a purchase made through the standard billing flow, which has no purchaseType.
+	IsTestPurchase bool `json:"-"`
+	// Only present if the app set them at purchase time with BillingFlowParams.
+	ObfuscatedExternalAccountId string `json:"obfuscatedExternalAccountId"`
+	ObfuscatedExternalProfileId string `json:"obfuscatedExternalProfileId"`
+	// AlreadyConsumed consumptionState 1, the purchase was consumed and must not be granted again.
+	AlreadyConsumed bool `json:"-"`
 }
 
 type ReceiptSubscriptionGoogleResponse struct {
@@ -53,76 +82,267 @@ type ReceiptSubscriptionGoogleResponse struct {
 	//1 Subscription was canceled by the system, for example because of a billing problem
 	//2 Subscription was replaced with a new subscription
 	//3 Subscription was canceled by the developer
-	UserCancellationTimeMillis int `json:"userCancellationTimeMillis"`
+	UserCancellationTimeMillis int64 `json:"userCancellationTimeMillis,string,omitempty"`
 	// Only present if cancelReason is 0.
 	PaymentState int `json:"paymentState"`
 	//0 Payment pending
 	//1 Payment received
 	//2 Free trial
 	//3 Pending deferred upgrade/downgrade
+	// ISO 3166-1 alpha-2 billing country/region code of the user at purchase time.
+	CountryCode string `json:"countryCode"`
+	// IsCanceled is set when the response carries a cancelReason, CancelReason 0 is only meaningful then.
+	IsCanceled bool `json:"-"`
+	// IsTestPurchase is set when the response carries purchaseType 0, see ReceiptGoogleResponse.IsTestPurchase.
+	IsTestPurchase bool `json:"-"`
+	// Only present if the app set them at purchase time with BillingFlowParams.
+	ObfuscatedExternalAccountId string `json:"obfuscatedExternalAccountId"`
+	ObfuscatedExternalProfileId string `json:"obfuscatedExternalProfileId"`
+}
+
+// GoogleDeveloperNotification Real-time developer notification, exactly one of the notification fields is set.
+type GoogleDeveloperNotification struct {
+	Version                    string                            `json:"version"`
+	PackageName                string                            `json:"packageName"`
+	EventTimeMillis            int64                             `json:"eventTimeMillis,string"`
+	SubscriptionNotification   *GoogleSubscriptionNotification   `json:"subscriptionNotification,omitempty"`
+	OneTimeProductNotification *GoogleOneTimeProductNotification `json:"oneTimeProductNotification,omitempty"`
+	VoidedPurchaseNotification *GoogleVoidedPurchaseNotification `json:"voidedPurchaseNotification,omitempty"`
+	TestNotification           *GoogleTestNotification           `json:"testNotification,omitempty"`
+}
+
+type GoogleSubscriptionNotification struct {
+	Version string `json:"version"`
+	// 1 RECOVERED, 2 RENEWED, 3 CANCELED, 4 PURCHASED, 5 ON_HOLD, 6 IN_GRACE_PERIOD, 7 RESTARTED,
+	// 8 PRICE_CHANGE_CONFIRMED, 9 DEFERRED, 10 PAUSED, 11 PAUSE_SCHEDULE_CHANGED, 12 REVOKED, 13 EXPIRED
+	NotificationType int    `json:"notificationType"`
+	PurchaseToken    string `json:"purchaseToken"`
+	SubscriptionId   string `json:"subscriptionId"`
+}
+
+type GoogleOneTimeProductNotification struct {
+	Version string `json:"version"`
+	// 1 ONE_TIME_PRODUCT_PURCHASED, 2 ONE_TIME_PRODUCT_CANCELED
+	NotificationType int    `json:"notificationType"`
+	PurchaseToken    string `json:"purchaseToken"`
+	Sku              string `json:"sku"`
+}
+
+type GoogleVoidedPurchaseNotification struct {
+	PurchaseToken string `json:"purchaseToken"`
+	OrderId       string `json:"orderId"`
+	ProductType   int    `json:"productType"` // 1 subscription, 2 one-time
+	RefundType    int    `json:"refundType"`  // 1 full refund, 2 quantity based partial refund
+}
+
+type GoogleTestNotification struct {
+	Version string `json:"version"`
+}
+
+var (
+	ErrNon200ServiceGoogle   = errors.New("non 200 response from Google service")
+	ErrGoogleAuthUnavailable = errors.New("Google token endpoint unavailable and no valid cached token")
+	ErrTokenMintTimeout      = errors.New("Google access token request timed out")
+	ErrAPITimeout            = errors.New("Google API request timed out")
+)
+
+// GoogleHTTPError non 200 response from the Android Publisher API, errors.Is(err, ErrNon200ServiceGoogle) holds for it.
+type GoogleHTTPError struct {
+	StatusCode int
+	// Body first 4KB of the response body, Google returns a JSON error object.
+	Body []byte
+}
+
+func (e *GoogleHTTPError) Error() string {
+	return fmt.Sprintf("%v: %d", ErrNon200ServiceGoogle, e.StatusCode)
+}
+
+func (e *GoogleHTTPError) Is(target error) bool {
+	return target == ErrNon200ServiceGoogle
+}
+
+func newGoogleHTTPError(resp *http.Response) error {
+	body, _ := ioutil.ReadAll(io.LimitReader(resp.Body, httpErrorBodyLimit))
+	return &GoogleHTTPError{StatusCode: resp.StatusCode, Body: body}
+}
+
+// googleTokenMintBudget share of the remaining context deadline a token mint may use,
+// so a slow token endpoint always leaves time for the API call.
+const googleTokenMintBudget = 0.5
+
+// GoogleTokenRefreshSkew default GoogleOptions.TokenRefreshSkew, a cached access token is refreshed this long
+// before it expires. If the token endpoint is down then, the cached token keeps being used until it expires.
+const GoogleTokenRefreshSkew = 5 * time.Minute
+
+// GoogleAPIUrl default GoogleOptions.BaseUrl, the base URL of the Android Publisher API.
+const GoogleAPIUrl = "https://androidpublisher.googleapis.com"
+
+// googleAPIUrl joins path (androidpublisher/v3/...) to baseUrl, GoogleAPIUrl when empty.
+func googleAPIUrl(baseUrl, path, rawQuery string) (string, error) {
+	if len(baseUrl) < 1 {
+		baseUrl = GoogleAPIUrl
+	}
+
+	u, err := url.Parse(baseUrl)
+	if err != nil {
+		return "", err
+	}
+	u.Path = strings.TrimSuffix(u.Path, "/") + "/" + path
+	u.RawQuery = rawQuery
+	return u.String(), nil
+}
+
+// googleCredentials JWT config and last token of one service account.
+type googleCredentials struct {
+	conf *goJWT.Config
+
+	mu    sync.Mutex
+	token *oauth2.Token
+}
+
+// googleTokenSource an oauth2.TokenSource returning the cached token of creds until it is within
+// refreshSkew of its expiry, only then a new one is minted with ctx.
+type googleTokenSource struct {
+	ctx         context.Context
+	creds       *googleCredentials
+	refreshSkew time.Duration
+}
+
+func (s googleTokenSource) Token() (*oauth2.Token, error) {
+	s.creds.mu.Lock()
+	defer s.creds.mu.Unlock()
+
+	now := time.Now()
+	if cached := s.creds.token; cached != nil && now.Add(s.refreshSkew).Before(cached.Expiry) {
+		return cached, nil
+	}
+
+	// the oauth2 errors don't wrap the transport error, it is recorded to tell an outage from a bad key.
+	var roundTripErr error
+	ctx := context.WithValue(s.ctx, oauth2.HTTPClient, recordRoundTripErr(s.ctx, &roundTripErr))
+	token, err := s.creds.conf.TokenSource(ctx).Token()
+	if err != nil {
+		if !isGoogleAuthOutage(err, roundTripErr) {
+			return nil, err
+		}
+		if cached := s.creds.token; cached != nil && now.Before(cached.Expiry) {
+			return cached, nil
+		}
+		return nil, fmt.Errorf("%w: %v", ErrGoogleAuthUnavailable, err)
+	}
+
+	s.creds.token = token
+	return token, nil
 }
 
 var (
-	ErrNon200ServiceGoogle = errors.New("non 200 response from Google service")
+	tokenMu sync.Mutex
+	// googleCredentialsCache keyed by googleCredentialsKey, a server may validate for several service accounts.
+	googleCredentialsCache = map[string]*googleCredentials{}
 )
 
-var conf *goJWT.Config
+func googleCredentialsKey(clientEmail string, privateKey string) string {
+	sum := sha256.Sum256([]byte(clientEmail + "\x00" + privateKey))
+	return hex.EncodeToString(sum[:])
+}
 
 // ValidateReceiptGoogle validate an IAP receipt with the Android Publisher API and the Google credentials.
 func ValidateReceiptGoogle(ctx context.Context, httpc *http.Client, clientEmail string, privateKey string, receipt string) (*ReceiptGoogleResponse, *ReceiptGoogle, []byte, error) {
+	return ValidateReceiptGoogleWithOptions(ctx, httpc, clientEmail, privateKey, receipt, GoogleOptions{})
+}
+
+// ValidateReceiptGoogleWithOptions ValidateReceiptGoogle with options.
+func ValidateReceiptGoogleWithOptions(ctx context.Context, httpc *http.Client, clientEmail string, privateKey string, receipt string, opts GoogleOptions) (*ReceiptGoogleResponse, *ReceiptGoogle, []byte, error) {
 	if len(receipt) < 1 {
 		return nil, nil, nil, errors.New("'receipt' is empty")
 	}
 
-	token, err := getGoolgeAccessToken(ctx, clientEmail, privateKey)
+	if len(opts.PublicKey) > 0 {
+		if err := VerifyGoogleSignature(receipt, opts.PublicKey); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var out *ReceiptGoogleResponse
+	var gr *ReceiptGoogle
+	var raw []byte
+	err := withGoogleAccessToken(ctx, httpc, clientEmail, privateKey, opts, func(token string) (err error) {
+		out, gr, raw, err = requestValidateReceiptGoogle(ctx, httpc, opts.BaseUrl, token, receipt)
+		return err
+	})
 	if err != nil {
 		return nil, nil, nil, err
 	}
-
-	return requestValidateReceiptGoogle(ctx, httpc, token, receipt)
+	return out, gr, raw, nil
 }
 
 // ValidateSubscriptionReceiptGoogle validate an IAP receipt with subscription type
 func ValidateSubscriptionReceiptGoogle(ctx context.Context, httpc *http.Client, clientEmail string, privateKey string, receipt string) (*ReceiptSubscriptionGoogleResponse, *ReceiptGoogle, []byte, error) {
+	return ValidateSubscriptionReceiptGoogleWithOptions(ctx, httpc, clientEmail, privateKey, receipt, GoogleOptions{})
+}
+
+// ValidateSubscriptionReceiptGoogleWithOptions ValidateSubscriptionReceiptGoogle with options.
+func ValidateSubscriptionReceiptGoogleWithOptions(ctx context.Context, httpc *http.Client, clientEmail string, privateKey string, receipt string, opts GoogleOptions) (*ReceiptSubscriptionGoogleResponse, *ReceiptGoogle, []byte, error) {
 	if len(receipt) < 1 {
 		return nil, nil, nil, errors.New("'receipt' is empty")
 	}
 
-	token, err := getGoolgeAccessToken(ctx, clientEmail, privateKey)
+	if len(opts.PublicKey) > 0 {
+		if err := VerifyGoogleSignature(receipt, opts.PublicKey); err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	var out *ReceiptSubscriptionGoogleResponse
+	var gr *ReceiptGoogle
+	var raw []byte
+	err := withGoogleAccessToken(ctx, httpc, clientEmail, privateKey, opts, func(token string) (err error) {
+		out, gr, raw, err = requestValidateSubscriptionReceiptGoogle(ctx, httpc, opts.BaseUrl, token, receipt)
+		return err
+	})
 	if err != nil {
 		return nil, nil, nil, err
 	}
-
-	return requestValidateSubscriptionReceiptGoogle(ctx, httpc, token, receipt)
+	return out, gr, raw, nil
 }
 
-func requestValidateReceiptGoogle(ctx context.Context, httpc *http.Client, token string, receipt string) (*ReceiptGoogleResponse, *ReceiptGoogle, []byte, error) {
+func requestValidateReceiptGoogle(ctx context.Context, httpc *http.Client, baseUrl, token string, receipt string) (*ReceiptGoogleResponse, *ReceiptGoogle, []byte, error) {
 
-	gr, err := decodeReceipt(receipt)
+	gr, err := decodeReceipt(LoggerFromContext(ctx), receipt)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	u := &url.URL{
-		Host:     "androidpublisher.googleapis.com",
-		Path:     fmt.Sprintf("androidpublisher/v3/applications/%s/purchases/products/%s/tokens/%s", gr.PackageName, gr.ProductID, gr.PurchaseToken),
-		RawQuery: fmt.Sprintf("access_token=%s", token),
-		Scheme:   "https",
+	u, err := googleAPIUrl(baseUrl, fmt.Sprintf("androidpublisher/v3/applications/%s/purchases/products/%s/tokens/%s", gr.PackageName, gr.ProductID, gr.PurchaseToken), fmt.Sprintf("access_token=%s", token))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		if isTimeout(ctx, err) {
+			return nil, nil, nil, fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
+		return nil, nil, nil, err
 	}
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	setHTTPHeaders(ctx, req)
 
 	resp, err := httpc.Do(req)
 	if err != nil {
+		if isTimeout(ctx, err) {
+			return nil, nil, nil, fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
 		return nil, nil, nil, err
 	}
 
 	defer resp.Body.Close()
-	log.Printf("resp.StatusCode %v", resp.StatusCode)
+	LoggerFromContext(ctx).Debug("google response", "status_code", resp.StatusCode)
 
 	switch resp.StatusCode {
 
@@ -136,14 +356,22 @@ func requestValidateReceiptGoogle(ctx context.Context, httpc *http.Client, token
 		if err := json.Unmarshal(buf, &out); err != nil {
 			return nil, nil, nil, err
 		}
+		out.AlreadyConsumed = out.ConsumptionState == 1
+
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(buf, &fields); err != nil {
+			return nil, nil, nil, err
+		}
+		_, hasPurchaseType := fields["purchaseType"]
+		out.IsTestPurchase = hasPurchaseType && out.PurchaseType == 0
 
 		return out, gr, buf, nil
 	default:
-		return nil, nil, nil, ErrNon200ServiceGoogle
+		return nil, nil, nil, newGoogleHTTPError(resp)
 	}
 }
 
-func requestValidateSubscriptionReceiptGoogle(ctx context.Context, httpc *http.Client, token string, receipt string) (*ReceiptSubscriptionGoogleResponse, *ReceiptGoogle, []byte, error) {
+func requestValidateSubscriptionReceiptGoogle(ctx context.Context, httpc *http.Client, baseUrl, token string, receipt string) (*ReceiptSubscriptionGoogleResponse, *ReceiptGoogle, []byte, error) {
 	if len(token) < 1 {
 		return nil, nil, nil, errors.New("'token' is empty")
 	}
@@ -152,31 +380,41 @@ func requestValidateSubscriptionReceiptGoogle(ctx context.Context, httpc *http.C
 		return nil, nil, nil, errors.New("'receipt' is empty")
 	}
 
-	gr, err := decodeReceipt(receipt)
+	gr, err := decodeReceipt(LoggerFromContext(ctx), receipt)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 
-	u := &url.URL{
-		Host:     "androidpublisher.googleapis.com",
-		Path:     fmt.Sprintf("androidpublisher/v3/applications/%s/purchases/subscriptions/%s/tokens/%s", gr.PackageName, gr.ProductID, gr.PurchaseToken),
-		RawQuery: fmt.Sprintf("access_token=%s", token),
-		Scheme:   "https",
+	u, err := googleAPIUrl(baseUrl, fmt.Sprintf("androidpublisher/v3/applications/%s/purchases/subscriptions/%s/tokens/%s", gr.PackageName, gr.ProductID, gr.PurchaseToken), fmt.Sprintf("access_token=%s", token))
+	if err != nil {
+		return nil, nil, nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		if isTimeout(ctx, err) {
+			return nil, nil, nil, fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
+		return nil, nil, nil, err
 	}
-	req, err := http.NewRequestWithContext(ctx, "GET", u.String(), nil)
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
 	if err != nil {
 		return nil, nil, nil, err
 	}
 	req.Header.Set("Content-Type", "application/json")
 	req.Header.Set("Accept", "application/json")
+	setHTTPHeaders(ctx, req)
 
 	resp, err := httpc.Do(req)
 	if err != nil {
+		if isTimeout(ctx, err) {
+			return nil, nil, nil, fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
 		return nil, nil, nil, err
 	}
 
 	defer resp.Body.Close()
-	log.Printf("resp.StatusCode %v", resp.StatusCode)
+	LoggerFromContext(ctx).Debug("google response", "status_code", resp.StatusCode)
 
 	switch resp.StatusCode {
 
@@ -191,15 +429,120 @@ func requestValidateSubscriptionReceiptGoogle(ctx context.Context, httpc *http.C
 			return nil, nil, nil, err
 		}
 
+		var fields map[string]json.RawMessage
+		if err := json.Unmarshal(buf, &fields); err != nil {
+			return nil, nil, nil, err
+		}
+		_, out.IsCanceled = fields["cancelReason"]
+		_, hasPurchaseType := fields["purchaseType"]
+		out.IsTestPurchase = hasPurchaseType && out.PurchaseType == 0
+
 		return out, gr, buf, nil
 	default:
-		return nil, nil, nil, ErrNon200ServiceGoogle
+		return nil, nil, nil, newGoogleHTTPError(resp)
+	}
+}
+
+// googleAccessToken gets the access token within googleTokenMintBudget of the ctx deadline.
+func googleAccessToken(ctx context.Context, httpc *http.Client, clientEmail string, privateKey string, opts GoogleOptions) (string, error) {
+	if err := ctx.Err(); err != nil {
+		if isTimeout(ctx, err) {
+			return "", fmt.Errorf("%w: %v", ErrTokenMintTimeout, err)
+		}
+		return "", err
+	}
+
+	tokenCtx := ctx
+	client := httpc
+	if deadline, ok := ctx.Deadline(); ok {
+		budget := time.Duration(float64(time.Until(deadline)) * googleTokenMintBudget)
+		var cancel context.CancelFunc
+		tokenCtx, cancel = context.WithTimeout(ctx, budget)
+		defer cancel()
+		// the oauth2 token request doesn't follow the context deadline, only the client timeout,
+		// a shorter timeout of httpc is kept.
+		c := *httpc
+		if c.Timeout <= 0 || budget < c.Timeout {
+			c.Timeout = budget
+		}
+		client = &c
+	}
+
+	token, err := getGoolgeAccessToken(context.WithValue(tokenCtx, oauth2.HTTPClient, client), clientEmail, privateKey, opts.tokenRefreshSkew())
+	if err != nil {
+		if isTimeout(tokenCtx, err) {
+			return "", fmt.Errorf("%w: %v", ErrTokenMintTimeout, err)
+		}
+		return "", err
+	}
+	return token, nil
+}
+
+// withGoogleAccessToken calls fn with the access token of the service account. When Google rejects it, e.g. it
+// was revoked or expired mid-flight, the cached token is dropped and fn is retried once with a fresh one.
+func withGoogleAccessToken(ctx context.Context, httpc *http.Client, clientEmail string, privateKey string, opts GoogleOptions, fn func(token string) error) error {
+	token, err := googleAccessToken(ctx, httpc, clientEmail, privateKey, opts)
+	if err != nil {
+		return err
+	}
+
+	err = fn(token)
+	if !isGoogleTokenRejected(err) {
+		return err
+	}
+
+	LoggerFromContext(ctx).Debug("google access token rejected, refreshing", "error", err)
+	invalidateGoogleAccessToken(clientEmail, privateKey, token)
+	if token, err = googleAccessToken(ctx, httpc, clientEmail, privateKey, opts); err != nil {
+		return err
+	}
+	return fn(token)
+}
+
+// isGoogleTokenRejected a 401, or a 403 blaming the credentials rather than the permissions of the account.
+func isGoogleTokenRejected(err error) bool {
+	var httpErr *GoogleHTTPError
+	if !errors.As(err, &httpErr) {
+		return false
+	}
+
+	switch httpErr.StatusCode {
+	case 401:
+		return true
+	case 403:
+		return bytes.Contains(httpErr.Body, []byte("authError")) || bytes.Contains(httpErr.Body, []byte("Invalid Credentials"))
+	default:
+		return false
+	}
+}
+
+// invalidateGoogleAccessToken drops the cached token of the service account if it is still token.
+func invalidateGoogleAccessToken(clientEmail string, privateKey string, token string) {
+	tokenMu.Lock()
+	creds, ok := googleCredentialsCache[googleCredentialsKey(clientEmail, privateKey)]
+	tokenMu.Unlock()
+	if !ok {
+		return
+	}
+
+	creds.mu.Lock()
+	defer creds.mu.Unlock()
+	if creds.token != nil && creds.token.AccessToken == token {
+		creds.token = nil
 	}
 }
 
-// getGoolgeAccessToken returns a TokenSource which repeatedly returns the
-// same token as long as it's valid,
-func getGoolgeAccessToken(ctx context.Context, clientEmail string, privateKey string) (string, error) {
+func isTimeout(ctx context.Context, err error) bool {
+	if errors.Is(ctx.Err(), context.DeadlineExceeded) {
+		return true
+	}
+
+	var ne net.Error
+	return errors.As(err, &ne) && ne.Timeout()
+}
+
+// getGoolgeAccessToken returns the access token of the service account, cached per account by googleTokenSource.
+func getGoolgeAccessToken(ctx context.Context, clientEmail string, privateKey string, refreshSkew time.Duration) (string, error) {
 	if len(clientEmail) < 1 {
 		return "", errors.New("'clientEmail' is empty")
 	}
@@ -208,9 +551,12 @@ func getGoolgeAccessToken(ctx context.Context, clientEmail string, privateKey st
 		return "", errors.New("'privateKey' is empty")
 	}
 	const authUrl = "https://accounts.google.com/o/oauth2/token"
-	if conf == nil {
-		now := time.Now()
-		conf = &goJWT.Config{
+	key := googleCredentialsKey(clientEmail, privateKey)
+	tokenMu.Lock()
+	creds, ok := googleCredentialsCache[key]
+	if !ok {
+		creds = &googleCredentials{}
+		creds.conf = &goJWT.Config{
 			Email: clientEmail,
 			// The contents of your RSA private key or your PEM file
 			// that contains a private key.
@@ -227,12 +573,104 @@ func getGoolgeAccessToken(ctx context.Context, clientEmail string, privateKey st
 			},
 			TokenURL: google.JWTTokenURL,
 			Audience: authUrl,
-			Expires:  time.Duration(now.Add(1 * time.Hour).Unix()),
+			// lifetime of the signed assertion, and so of the access token.
+			Expires: time.Hour,
 		}
+		googleCredentialsCache[key] = creds
 	}
+	tokenMu.Unlock()
 
-	token, err := conf.TokenSource(ctx).Token()
-	return token.AccessToken, err
+	token, err := googleTokenSource{ctx: ctx, creds: creds, refreshSkew: refreshSkew}.Token()
+	if err != nil {
+		return "", err
+	}
+	return token.AccessToken, nil
+}
+
+// isGoogleAuthOutage token endpoint unreachable, timing out or failing on its side (5xx). A 4xx means the
+// credentials are wrong, a key that doesn't parse or a malformed token response aren't outages either.
+func isGoogleAuthOutage(err, roundTripErr error) bool {
+	if roundTripErr != nil {
+		return true
+	}
+
+	var re *oauth2.RetrieveError
+	if errors.As(err, &re) {
+		return re.Response != nil && re.Response.StatusCode >= 500
+	}
+	return false
+}
+
+// recordRoundTripErr a copy of the oauth2 client of ctx whose transport errors are recorded to err.
+func recordRoundTripErr(ctx context.Context, err *error) *http.Client {
+	c := http.Client{}
+	if client, ok := ctx.Value(oauth2.HTTPClient).(*http.Client); ok && client != nil {
+		c = *client
+	}
+	transport := c.Transport
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	c.Transport = roundTripRecorder{base: transport, err: err}
+	return &c
+}
+
+type roundTripRecorder struct {
+	base http.RoundTripper
+	err  *error
+}
+
+func (r roundTripRecorder) RoundTrip(req *http.Request) (*http.Response, error) {
+	resp, err := r.base.RoundTrip(req)
+	if err != nil {
+		*r.err = err
+	}
+	return resp, err
+}
+
+// ParseGoogleRTDN decodes a real-time developer notification from a Pub/Sub push request body,
+// a bare Pub/Sub message ({"data": ...}) is accepted too.
+func ParseGoogleRTDN(pubsubMessage []byte) (*GoogleDeveloperNotification, error) {
+	if len(pubsubMessage) < 1 {
+		return nil, errors.New("'pubsubMessage' is empty")
+	}
+
+	var envelope struct {
+		Message *struct {
+			Data string `json:"data"`
+		} `json:"message"`
+		Data string `json:"data"`
+	}
+	if err := json.Unmarshal(pubsubMessage, &envelope); err != nil {
+		return nil, err
+	}
+
+	data := envelope.Data
+	if envelope.Message != nil {
+		data = envelope.Message.Data
+	}
+	if len(data) < 1 {
+		return nil, errors.New("'data' field not found, message is malformed")
+	}
+
+	buf, err := base64.StdEncoding.DecodeString(data)
+	if err != nil {
+		return nil, err
+	}
+
+	var out GoogleDeveloperNotification
+	if err := json.Unmarshal(buf, &out); err != nil {
+		return nil, err
+	}
+	return &out, nil
+}
+
+// DecodeReceiptGoogle decodes the client receipt without validating it, e.g. to pick credentials by package name.
+func DecodeReceiptGoogle(receipt string) (*ReceiptGoogle, error) {
+	if len(receipt) < 1 {
+		return nil, errors.New("'receipt' is empty")
+	}
+	return decodeReceipt(nopLogger{}, receipt)
 }
 
 // The standard google receipt structure:
@@ -242,20 +680,28 @@ func getGoolgeAccessToken(ctx context.Context, clientEmail string, privateKey st
 //       \\\"type\\\":\\\"inapp\\\",\\\"price\\\":\\\"\\u0e3f29.00\\\",\\\"price_amount_micros\\\":29000000,
 //       \\\"price_currency_code\\\":\\\"THB\\\",\\\"title\\\":\\\"xxx\\\",\\\"description\\\":\\\"xxxxx\\\",
 //       \\\"skuDetailsToken\\\":\\\"AEuhp4IhWdExxxxxxxxxxx\\\"}\"}"
-func decodeReceipt(receipt string) (*ReceiptGoogle, error) {
-	var wrapper map[string]interface{}
-	if err := json.Unmarshal([]byte(receipt), &wrapper); err != nil {
+func decodeReceipt(log Logger, receipt string) (*ReceiptGoogle, error) {
+	unwrapped, err := unwrapGoogleReceipt(receipt)
+	if err != nil {
 		return nil, err
 	}
 
-	unwrapped, ok := wrapper["json"].(string)
-	if !ok {
-		return nil, errors.New("'json' field not found, receipt is malformed")
-	}
-
 	var gr ReceiptGoogle
-	if err := json.Unmarshal([]byte(unwrapped), &gr); err != nil {
+	if err := json.Unmarshal([]byte(unwrapped.payload), &gr); err != nil {
 		return nil, err
 	}
+	if len(gr.PackageName) < 1 || len(gr.ProductID) < 1 || len(gr.PurchaseToken) < 1 {
+		return nil, errors.New("'packageName', 'productId' or 'purchaseToken' not found, receipt is malformed")
+	}
+
+	if len(unwrapped.skuDetails) > 0 {
+		// not signed and only informative, a malformed one is ignored like a non string one.
+		var details SkuDetailsGoogle
+		if err := json.Unmarshal([]byte(unwrapped.skuDetails), &details); err != nil {
+			log.Debug("google receipt skuDetails ignored", "error", err)
+		} else {
+			gr.SkuDetails = &details
+		}
+	}
 	return &gr, nil
 }
@@ -0,0 +1,140 @@
+package iap
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+var (
+	ErrGooglePurchaseAlreadyConsumed = errors.New("google purchase already consumed")
+)
+
+// AcknowledgeProductGoogle acknowledges an in-app product purchase, Google refunds purchases not acknowledged within 3 days.
+func AcknowledgeProductGoogle(ctx context.Context, httpc *http.Client, clientEmail, privateKey, packageName, productID, purchaseToken string) error {
+	return AcknowledgeProductGoogleWithOptions(ctx, httpc, clientEmail, privateKey, packageName, productID, purchaseToken, GoogleOptions{})
+}
+
+// AcknowledgeProductGoogleWithOptions AcknowledgeProductGoogle with options.
+func AcknowledgeProductGoogleWithOptions(ctx context.Context, httpc *http.Client, clientEmail, privateKey, packageName, productID, purchaseToken string, opts GoogleOptions) error {
+	if len(productID) < 1 {
+		return errors.New("'productID' is empty")
+	}
+	return postPurchaseActionGoogle(ctx, httpc, clientEmail, privateKey, packageName, "products", productID, purchaseToken, "acknowledge", opts)
+}
+
+// AcknowledgeSubscriptionGoogle acknowledges a subscription purchase.
+func AcknowledgeSubscriptionGoogle(ctx context.Context, httpc *http.Client, clientEmail, privateKey, packageName, subscriptionID, purchaseToken string) error {
+	return AcknowledgeSubscriptionGoogleWithOptions(ctx, httpc, clientEmail, privateKey, packageName, subscriptionID, purchaseToken, GoogleOptions{})
+}
+
+// AcknowledgeSubscriptionGoogleWithOptions AcknowledgeSubscriptionGoogle with options.
+func AcknowledgeSubscriptionGoogleWithOptions(ctx context.Context, httpc *http.Client, clientEmail, privateKey, packageName, subscriptionID, purchaseToken string, opts GoogleOptions) error {
+	if len(subscriptionID) < 1 {
+		return errors.New("'subscriptionID' is empty")
+	}
+	return postPurchaseActionGoogle(ctx, httpc, clientEmail, privateKey, packageName, "subscriptions", subscriptionID, purchaseToken, "acknowledge", opts)
+}
+
+// ConsumeProductGoogle consumes an in-app product purchase so the user can buy it again, for consumables
+// the app doesn't consume itself. A purchase consumed before fails with ErrGooglePurchaseAlreadyConsumed.
+func ConsumeProductGoogle(ctx context.Context, httpc *http.Client, clientEmail, privateKey, packageName, productID, purchaseToken string) error {
+	return ConsumeProductGoogleWithOptions(ctx, httpc, clientEmail, privateKey, packageName, productID, purchaseToken, GoogleOptions{})
+}
+
+// ConsumeProductGoogleWithOptions ConsumeProductGoogle with options.
+func ConsumeProductGoogleWithOptions(ctx context.Context, httpc *http.Client, clientEmail, privateKey, packageName, productID, purchaseToken string, opts GoogleOptions) error {
+	if len(productID) < 1 {
+		return errors.New("'productID' is empty")
+	}
+
+	err := postPurchaseActionGoogle(ctx, httpc, clientEmail, privateKey, packageName, "products", productID, purchaseToken, "consume", opts)
+	var httpErr *GoogleHTTPError
+	if errors.As(err, &httpErr) && httpErr.StatusCode == 400 && googleAlreadyConsumed(httpErr.Body) {
+		return fmt.Errorf("%w: %s", ErrGooglePurchaseAlreadyConsumed, httpErr.Body)
+	}
+	return err
+}
+
+// googleAlreadyConsumed the JSON error body of a 400 says the purchase is consumed. productNotOwnedByUser alone
+// is also the answer for a token of another app or user and is not enough.
+func googleAlreadyConsumed(body []byte) bool {
+	var out struct {
+		Error struct {
+			Message string `json:"message"`
+			Errors  []struct {
+				Reason  string `json:"reason"`
+				Message string `json:"message"`
+			} `json:"errors"`
+		} `json:"error"`
+	}
+	if err := json.Unmarshal(body, &out); err != nil {
+		return false
+	}
+
+	consumed := func(s string) bool { return strings.Contains(strings.ToLower(s), "consumed") }
+	if consumed(out.Error.Message) {
+		return true
+	}
+	for _, e := range out.Error.Errors {
+		if consumed(e.Reason) || consumed(e.Message) {
+			return true
+		}
+	}
+	return false
+}
+
+// postPurchaseActionGoogle POST purchases/{kind}/{id}/tokens/{token}:{action}.
+func postPurchaseActionGoogle(ctx context.Context, httpc *http.Client, clientEmail, privateKey, packageName, kind, id, purchaseToken, action string, opts GoogleOptions) error {
+	if len(packageName) < 1 {
+		return errors.New("'packageName' is empty")
+	}
+
+	if len(purchaseToken) < 1 {
+		return errors.New("'purchaseToken' is empty")
+	}
+
+	return withGoogleAccessToken(ctx, httpc, clientEmail, privateKey, opts, func(token string) error {
+		return requestPurchaseActionGoogle(ctx, httpc, opts.BaseUrl, token, packageName, kind, id, purchaseToken, action)
+	})
+}
+
+func requestPurchaseActionGoogle(ctx context.Context, httpc *http.Client, baseUrl, token, packageName, kind, id, purchaseToken, action string) error {
+	u, err := googleAPIUrl(baseUrl, fmt.Sprintf("androidpublisher/v3/applications/%s/purchases/%s/%s/tokens/%s:%s", packageName, kind, id, purchaseToken, action), fmt.Sprintf("access_token=%s", token))
+	if err != nil {
+		return err
+	}
+
+	if err := ctx.Err(); err != nil {
+		if isTimeout(ctx, err) {
+			return fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
+		return err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u, bytes.NewReader([]byte("{}")))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+	setHTTPHeaders(ctx, req)
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		if isTimeout(ctx, err) {
+			return fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 && resp.StatusCode != 204 {
+		return newGoogleHTTPError(resp)
+	}
+	return nil
+}
@@ -0,0 +1,137 @@
+package iap
+
+import (
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrGoogleSignatureInvalid = errors.New("google receipt signature is invalid")
+)
+
+// GoogleOptions optional behaviour of the Google validation.
+type GoogleOptions struct {
+	// PublicKey optional, the base64 encoded RSA license key of the app from the Play Console.
+	// When set the receipt signature must verify before it is sent to Google, see VerifyGoogleSignature.
+	PublicKey string
+	// BaseUrl optional, default GoogleAPIUrl, scheme, host and an optional path prefix of the Android Publisher API,
+	// e.g. to go through an API gateway or reach a mock server.
+	BaseUrl string
+	// TokenRefreshSkew optional, default GoogleTokenRefreshSkew, how long before it expires the cached access
+	// token of the service account is refreshed.
+	TokenRefreshSkew time.Duration
+}
+
+func (o GoogleOptions) tokenRefreshSkew() time.Duration {
+	if o.TokenRefreshSkew > 0 {
+		return o.TokenRefreshSkew
+	}
+	return GoogleTokenRefreshSkew
+}
+
+// VerifyGoogleSignature verifies the SHA1withRSA signature of the receipt json payload against the app license key.
+// Receipts without the json and signature wrapper can't be verified and fail.
+func VerifyGoogleSignature(receipt, base64PublicKey string) error {
+	if len(receipt) < 1 {
+		return errors.New("'receipt' is empty")
+	}
+
+	if len(base64PublicKey) < 1 {
+		return errors.New("'base64PublicKey' is empty")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(base64PublicKey)
+	if err != nil {
+		return err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return err
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("'base64PublicKey' is not an RSA key")
+	}
+
+	unwrapped, err := unwrapGoogleReceipt(receipt)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrGoogleSignatureInvalid, err)
+	}
+	if !unwrapped.wrapped {
+		return fmt.Errorf("%w: 'json' field not found", ErrGoogleSignatureInvalid)
+	}
+	if len(unwrapped.signature) < 1 {
+		return fmt.Errorf("%w: 'signature' field not found", ErrGoogleSignatureInvalid)
+	}
+	sig, err := base64.StdEncoding.DecodeString(unwrapped.signature)
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrGoogleSignatureInvalid, err)
+	}
+
+	digest := sha1.Sum([]byte(unwrapped.payload))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA1, digest[:], sig); err != nil {
+		return fmt.Errorf("%w: %v", ErrGoogleSignatureInvalid, err)
+	}
+	return nil
+}
+
+// googleUnwrappedReceipt a client receipt taken apart by unwrapGoogleReceipt.
+type googleUnwrappedReceipt struct {
+	// payload the purchase JSON, the signed bytes when wrapped.
+	payload    string
+	signature  string
+	skuDetails string
+	// wrapped the receipt has the json and signature wrapper, otherwise payload is the receipt itself.
+	wrapped bool
+}
+
+// unwrapGoogleReceipt unwraps a Unity IAP Payload and the json and signature wrapper of a client receipt.
+// Both the signature verification and the decoding go through it so they read the same payload,
+// a wrapper carrying both json and Payload is ambiguous and rejected.
+func unwrapGoogleReceipt(receipt string) (*googleUnwrappedReceipt, error) {
+	var wrapper map[string]json.RawMessage
+	if err := json.Unmarshal([]byte(receipt), &wrapper); err != nil {
+		return nil, err
+	}
+
+	rawPayload, hasPayload := wrapper["Payload"]
+	rawJSON, hasJSON := wrapper["json"]
+	if hasPayload && hasJSON {
+		return nil, errors.New("receipt has both 'json' and 'Payload' fields")
+	}
+
+	if hasPayload {
+		var payload string
+		if err := json.Unmarshal(rawPayload, &payload); err != nil {
+			return nil, fmt.Errorf("'Payload' field is not a string: %v", err)
+		}
+		return unwrapGoogleReceipt(payload)
+	}
+
+	if !hasJSON {
+		// the inner purchase JSON handed over directly.
+		return &googleUnwrappedReceipt{payload: receipt}, nil
+	}
+
+	out := &googleUnwrappedReceipt{wrapped: true}
+	if err := json.Unmarshal(rawJSON, &out.payload); err != nil {
+		return nil, fmt.Errorf("'json' field is not a string: %v", err)
+	}
+	if rawSignature, ok := wrapper["signature"]; ok {
+		if err := json.Unmarshal(rawSignature, &out.signature); err != nil {
+			return nil, fmt.Errorf("'signature' field is not a string: %v", err)
+		}
+	}
+	if rawSkuDetails, ok := wrapper["skuDetails"]; ok {
+		// not signed, a non string skuDetails is ignored.
+		_ = json.Unmarshal(rawSkuDetails, &out.skuDetails)
+	}
+	return out, nil
+}
@@ -0,0 +1,97 @@
+package iap
+
+import (
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha1"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+)
+
+func googlePurchaseJSON(productID, token string) string {
+	return `{"orderId":"GPA.1234-5678-9012-34567","packageName":"com.example.app","productId":"` + productID +
+		`","purchaseTime":1607721533824,"purchaseState":0,"purchaseToken":"` + token + `"}`
+}
+
+// signGoogleReceipt the standard json and signature wrapper of payload signed by key.
+func signGoogleReceipt(t *testing.T, key *rsa.PrivateKey, payload string) (string, string) {
+	t.Helper()
+	digest := sha1.Sum([]byte(payload))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA1, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return payload, base64.StdEncoding.EncodeToString(sig)
+}
+
+func googleLicenseKey(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+func marshalString(t *testing.T, v interface{}) string {
+	t.Helper()
+	buf, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(buf)
+}
+
+func TestVerifyGoogleSignature(t *testing.T) {
+	key := newRSAKey(t)
+	licenseKey := googleLicenseKey(t, key)
+	payload, signature := signGoogleReceipt(t, key, googlePurchaseJSON("gems_10", "tokA"))
+	receipt := marshalString(t, map[string]string{"json": payload, "signature": signature})
+	unity := marshalString(t, map[string]string{"Store": "GooglePlay", "Payload": receipt})
+
+	for name, r := range map[string]string{"standard": receipt, "unity": unity} {
+		t.Run(name, func(t *testing.T) {
+			if err := VerifyGoogleSignature(r, licenseKey); err != nil {
+				t.Fatal(err)
+			}
+			gr, err := DecodeReceiptGoogle(r)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if gr.ProductID != "gems_10" || gr.PurchaseToken != "tokA" {
+				t.Fatalf("decoded %s %s", gr.ProductID, gr.PurchaseToken)
+			}
+		})
+	}
+}
+
+func TestVerifyGoogleSignatureTampered(t *testing.T) {
+	key := newRSAKey(t)
+	licenseKey := googleLicenseKey(t, key)
+	payload, signature := signGoogleReceipt(t, key, googlePurchaseJSON("gems_10", "tokA"))
+	signed := marshalString(t, map[string]string{"json": payload, "signature": signature})
+	tampered := googlePurchaseJSON("gems_10000", "tokB")
+
+	tests := map[string]string{
+		"json and Payload":  marshalString(t, map[string]string{"json": tampered, "Payload": signed, "signature": signature}),
+		"payload changed":   marshalString(t, map[string]string{"json": tampered, "signature": signature}),
+		"signature missing": marshalString(t, map[string]string{"json": payload}),
+		"unwrapped":         payload,
+	}
+	for name, receipt := range tests {
+		t.Run(name, func(t *testing.T) {
+			if err := VerifyGoogleSignature(receipt, licenseKey); !errors.Is(err, ErrGoogleSignatureInvalid) {
+				t.Fatalf("expected ErrGoogleSignatureInvalid, got %v", err)
+			}
+		})
+	}
+
+	// the ambiguous wrapper doesn't decode either, whatever the field order.
+	if _, err := DecodeReceiptGoogle(tests["json and Payload"]); err == nil {
+		t.Fatal("expected a receipt with both json and Payload to be rejected")
+	}
+}
@@ -0,0 +1,596 @@
+package iap
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testGoogle fake Google OAuth token endpoint and Android Publisher API behind mux.
+type testGoogle struct {
+	mux    *http.ServeMux
+	srv    *httptest.Server
+	client *http.Client
+	// email and key of a service account of its own, access tokens are cached by account.
+	email, key string
+	requests   int32
+}
+
+func newTestGoogle(t *testing.T) *testGoogle {
+	t.Helper()
+	g := &testGoogle{
+		mux:   http.NewServeMux(),
+		email: "test@example.iam.gserviceaccount.com",
+		key:   string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(newRSAKey(t))})),
+	}
+	g.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"Bearer","expires_in":3600}`))
+	})
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&g.requests, 1)
+		g.mux.ServeHTTP(w, r)
+	}))
+	t.Cleanup(srv.Close)
+	g.srv = srv
+	g.client = redirectClient(srv)
+	return g
+}
+
+func TestGoogleDoneContext(t *testing.T) {
+	receipt := googlePurchaseJSON("gems_10", "tokA")
+	expired, cancel := context.WithDeadline(context.Background(), time.Now().Add(-time.Second))
+	defer cancel()
+	canceled, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	tests := []struct {
+		name string
+		ctx  context.Context
+		err  error
+	}{
+		{name: "deadline exceeded", ctx: expired, err: ErrTokenMintTimeout},
+		{name: "canceled", ctx: canceled, err: context.Canceled},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoogle(t)
+			_, _, _, err := ValidateReceiptGoogle(tt.ctx, g.client, g.email, g.key, receipt)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if g.requests > 0 {
+				t.Fatalf("%d requests with a done ctx", g.requests)
+			}
+		})
+	}
+}
+
+func TestDecodeReceiptGoogleSkuDetails(t *testing.T) {
+	receipt := func(skuDetails string) string {
+		return marshalString(t, map[string]string{"json": googlePurchaseJSON("coins", "token-1"), "skuDetails": skuDetails})
+	}
+
+	gr, err := DecodeReceiptGoogle(receipt(`{"productId":"coins","price_amount_micros":29000000,"price_currency_code":"THB"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gr.SkuDetails == nil || gr.SkuDetails.PriceAmountMicros != 29000000 {
+		t.Fatalf("skuDetails %+v, want the decoded listing", gr.SkuDetails)
+	}
+
+	// the purchase is still validated without its listing.
+	gr, err = DecodeReceiptGoogle(receipt(`{"productId":`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if gr.SkuDetails != nil || gr.PurchaseToken != "token-1" {
+		t.Fatalf("receipt %+v, want the purchase without skuDetails", gr)
+	}
+}
+
+func TestConsumeProductGoogleAlreadyConsumed(t *testing.T) {
+	tests := []struct {
+		name string
+		body string
+		err  error
+	}{
+		{
+			name: "consumed",
+			body: `{"error":{"code":400,"message":"The purchase has already been consumed.","errors":[{"reason":"productNotOwnedByUser"}]}}`,
+			err:  ErrGooglePurchaseAlreadyConsumed,
+		},
+		{
+			name: "not owned",
+			body: `{"error":{"code":400,"message":"The product purchase is not owned by the user.","errors":[{"reason":"productNotOwnedByUser"}]}}`,
+			err:  ErrNon200ServiceGoogle,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoogle(t)
+			g.mux.HandleFunc("/androidpublisher/v3/applications/com.example.app/purchases/products/coins/tokens/token-1:consume", func(w http.ResponseWriter, r *http.Request) {
+				w.WriteHeader(http.StatusBadRequest)
+				_, _ = w.Write([]byte(tt.body))
+			})
+
+			err := ConsumeProductGoogle(context.Background(), g.client, g.email, g.key, "com.example.app", "coins", "token-1")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if tt.err != ErrGooglePurchaseAlreadyConsumed && errors.Is(err, ErrGooglePurchaseAlreadyConsumed) {
+				t.Fatal("purchase not owned by the user reported as consumed")
+			}
+		})
+	}
+}
+
+func TestGoogleTokenMintPerAccount(t *testing.T) {
+	slow, fast := newTestGoogle(t), newTestGoogle(t)
+	minting := make(chan struct{})
+	release := make(chan struct{})
+	// a token endpoint of its own, the server looks slow.mux up per request.
+	slow.mux = http.NewServeMux()
+	slow.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		close(minting)
+		<-release
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"slow-token","token_type":"Bearer","expires_in":3600}`))
+	})
+
+	done := make(chan error, 1)
+	go func() {
+		_, err := googleAccessToken(context.Background(), slow.client, slow.email, slow.key, GoogleOptions{})
+		done <- err
+	}()
+	<-minting
+
+	// another service account mints while the first one is stuck at the token endpoint.
+	minted := make(chan error, 1)
+	go func() {
+		_, err := googleAccessToken(context.Background(), fast.client, fast.email, fast.key, GoogleOptions{})
+		minted <- err
+	}()
+	select {
+	case err := <-minted:
+		if err != nil {
+			t.Fatal(err)
+		}
+	case <-time.After(2 * time.Second):
+		t.Fatal("token mint of another account blocked by a slow one")
+	}
+
+	close(release)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestGoogleTokenEndpointDown(t *testing.T) {
+	g := newTestGoogle(t)
+	var down int32
+	g.mux = http.NewServeMux()
+	g.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.LoadInt32(&down) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"Bearer","expires_in":3600}`))
+	})
+	if _, err := googleAccessToken(context.Background(), g.client, g.email, g.key, GoogleOptions{}); err != nil {
+		t.Fatal(err)
+	}
+
+	// every call refreshes, the endpoint is down.
+	atomic.StoreInt32(&down, 1)
+	token, err := googleAccessToken(context.Background(), g.client, g.email, g.key, GoogleOptions{TokenRefreshSkew: 2 * time.Hour})
+	if err != nil || token != "test-token" {
+		t.Fatalf("token %q error %v, want the cached token", token, err)
+	}
+
+	tokenMu.Lock()
+	creds := googleCredentialsCache[googleCredentialsKey(g.email, g.key)]
+	tokenMu.Unlock()
+	creds.mu.Lock()
+	creds.token.Expiry = time.Now().Add(-time.Minute)
+	creds.mu.Unlock()
+	if _, err := googleAccessToken(context.Background(), g.client, g.email, g.key, GoogleOptions{}); !errors.Is(err, ErrGoogleAuthUnavailable) {
+		t.Fatalf("error %v, want ErrGoogleAuthUnavailable", err)
+	}
+}
+
+func TestGoogleTokenRejected(t *testing.T) {
+	const app = "/androidpublisher/v3/applications/com.example.app/purchases"
+	tests := []struct {
+		name string
+		path string
+		call func(g *testGoogle) error
+	}{
+		{name: "subscription v2", path: app + "/subscriptionsv2/tokens/token-1", call: func(g *testGoogle) error {
+			_, _, err := ValidateSubscriptionV2Google(context.Background(), g.client, g.email, g.key, "com.example.app", "token-1")
+			return err
+		}},
+		{name: "acknowledge product", path: app + "/products/coins/tokens/token-1:acknowledge", call: func(g *testGoogle) error {
+			return AcknowledgeProductGoogle(context.Background(), g.client, g.email, g.key, "com.example.app", "coins", "token-1")
+		}},
+		{name: "acknowledge subscription", path: app + "/subscriptions/monthly/tokens/token-1:acknowledge", call: func(g *testGoogle) error {
+			return AcknowledgeSubscriptionGoogle(context.Background(), g.client, g.email, g.key, "com.example.app", "monthly", "token-1")
+		}},
+		{name: "consume", path: app + "/products/coins/tokens/token-1:consume", call: func(g *testGoogle) error {
+			return ConsumeProductGoogle(context.Background(), g.client, g.email, g.key, "com.example.app", "coins", "token-1")
+		}},
+		{name: "voided purchases", path: app + "/voidedpurchases", call: func(g *testGoogle) error {
+			_, err := ListVoidedPurchasesGoogle(context.Background(), g.client, g.email, g.key, "com.example.app", time.Time{}, time.Time{})
+			return err
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoogle(t)
+			var minted int32
+			g.mux = http.NewServeMux()
+			g.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+				n := atomic.AddInt32(&minted, 1)
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = fmt.Fprintf(w, `{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, n)
+			})
+			g.mux.HandleFunc(tt.path, func(w http.ResponseWriter, r *http.Request) {
+				// the first token was revoked after it was cached.
+				if r.URL.Query().Get("access_token") == "token-1" {
+					w.WriteHeader(http.StatusUnauthorized)
+					return
+				}
+				_, _ = w.Write([]byte(`{}`))
+			})
+
+			if err := tt.call(g); err != nil {
+				t.Fatal(err)
+			}
+			if n := atomic.LoadInt32(&minted); n != 2 {
+				t.Fatalf("%d tokens minted, want the rejected one refreshed once", n)
+			}
+		})
+	}
+}
+
+func TestGoogleTokenMintBudget(t *testing.T) {
+	g := newTestGoogle(t)
+	release := make(chan struct{})
+	defer close(release)
+	g.mux = http.NewServeMux()
+	g.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		http.Redirect(w, r, "/slow-token", http.StatusTemporaryRedirect)
+	})
+	g.mux.HandleFunc("/slow-token", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	// the budgeted client is a copy of the caller's, not only its transport.
+	var redirects int32
+	g.client.CheckRedirect = func(req *http.Request, via []*http.Request) error {
+		atomic.AddInt32(&redirects, 1)
+		return nil
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 200*time.Millisecond)
+	defer cancel()
+	start := time.Now()
+	_, err := googleAccessToken(ctx, g.client, g.email, g.key, GoogleOptions{})
+	if !errors.Is(err, ErrTokenMintTimeout) {
+		t.Fatalf("error %v, want ErrTokenMintTimeout", err)
+	}
+	if elapsed := time.Since(start); elapsed > 180*time.Millisecond {
+		t.Fatalf("token mint took %v, want within half the deadline", elapsed)
+	}
+	if redirects != 1 {
+		t.Fatalf("%d redirects checked by the caller's client, want 1", redirects)
+	}
+}
+
+func TestGoogleTokenErrorNotOutage(t *testing.T) {
+	tests := []struct {
+		name    string
+		handler http.HandlerFunc
+		outage  bool
+	}{
+		{name: "malformed token response", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.Header().Set("Content-Type", "application/json")
+			_, _ = w.Write([]byte(`{"access_token":`))
+		}},
+		{name: "credentials rejected", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadRequest)
+		}},
+		{name: "token endpoint 5xx", handler: func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(http.StatusBadGateway)
+		}, outage: true},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoogle(t)
+			if _, err := googleAccessToken(context.Background(), g.client, g.email, g.key, GoogleOptions{}); err != nil {
+				t.Fatal(err)
+			}
+			// still valid but within the refresh skew, only an outage falls back to it.
+			tokenMu.Lock()
+			creds := googleCredentialsCache[googleCredentialsKey(g.email, g.key)]
+			tokenMu.Unlock()
+			creds.mu.Lock()
+			creds.token.Expiry = time.Now().Add(time.Minute)
+			creds.mu.Unlock()
+
+			g.mux = http.NewServeMux()
+			g.mux.HandleFunc("/token", tt.handler)
+			token, err := googleAccessToken(context.Background(), g.client, g.email, g.key, GoogleOptions{})
+			if tt.outage {
+				if err != nil || token != "test-token" {
+					t.Fatalf("token %q error %v, want the cached token", token, err)
+				}
+				return
+			}
+			if err == nil || errors.Is(err, ErrGoogleAuthUnavailable) {
+				t.Fatalf("token %q error %v, want the token endpoint error", token, err)
+			}
+		})
+	}
+
+	t.Run("invalid private key", func(t *testing.T) {
+		g := newTestGoogle(t)
+		_, err := googleAccessToken(context.Background(), g.client, g.email, "not a key", GoogleOptions{})
+		if err == nil || errors.Is(err, ErrGoogleAuthUnavailable) {
+			t.Fatalf("error %v, want the key parse error", err)
+		}
+		// the key is refused before any request.
+		if g.requests > 0 {
+			t.Fatalf("%d requests with an invalid key", g.requests)
+		}
+	})
+
+	t.Run("network error", func(t *testing.T) {
+		g := newTestGoogle(t)
+		client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+			return nil, errors.New("connection refused")
+		})}
+		_, err := googleAccessToken(context.Background(), client, g.email, g.key, GoogleOptions{})
+		if !errors.Is(err, ErrGoogleAuthUnavailable) {
+			t.Fatalf("error %v, want ErrGoogleAuthUnavailable", err)
+		}
+	})
+}
+
+func TestValidateReceiptGooglePerCredentials(t *testing.T) {
+	g := newTestGoogle(t)
+	other := newTestGoogle(t)
+	var mu sync.Mutex
+	var issuers, assertions, tokens []string
+	g.mux = http.NewServeMux()
+	g.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		assertion := r.FormValue("assertion")
+		var claims struct {
+			Iss string `json:"iss"`
+		}
+		if parts := strings.Split(assertion, "."); len(parts) == 3 {
+			payload, _ := base64.RawURLEncoding.DecodeString(parts[1])
+			_ = json.Unmarshal(payload, &claims)
+		}
+		mu.Lock()
+		issuers = append(issuers, claims.Iss)
+		assertions = append(assertions, assertion)
+		mu.Unlock()
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"token-` + claims.Iss + `","token_type":"Bearer","expires_in":3600}`))
+	})
+	g.mux.HandleFunc("/androidpublisher/v3/applications/com.example.app/purchases/products/gems_10/tokens/tokA", func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		tokens = append(tokens, r.URL.Query().Get("access_token"))
+		mu.Unlock()
+		_, _ = w.Write([]byte(`{"purchaseState":0}`))
+	})
+
+	receipt := googlePurchaseJSON("gems_10", "tokA")
+	other.email = "other@example.iam.gserviceaccount.com"
+	for _, account := range []*testGoogle{g, other} {
+		if _, _, _, err := ValidateReceiptGoogle(context.Background(), g.client, account.email, account.key, receipt); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(assertions) != 2 || assertions[0] == assertions[1] {
+		t.Fatalf("%d token requests, want two distinct assertions", len(assertions))
+	}
+	if issuers[0] != g.email || issuers[1] != other.email {
+		t.Fatalf("assertions issued by %v, want %s then %s", issuers, g.email, other.email)
+	}
+	want := []string{"token-" + g.email, "token-" + other.email}
+	if len(tokens) != 2 || tokens[0] != want[0] || tokens[1] != want[1] {
+		t.Fatalf("API calls authorized with %v, want %v", tokens, want)
+	}
+}
+
+func TestGoogleTokenRefresh(t *testing.T) {
+	g := newTestGoogle(t)
+	var minted int32
+	g.mux = http.NewServeMux()
+	g.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		n := atomic.AddInt32(&minted, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(fmt.Sprintf(`{"access_token":"token-%d","token_type":"Bearer","expires_in":3600}`, n)))
+	})
+	token := func(opts GoogleOptions) string {
+		t.Helper()
+		token, err := googleAccessToken(context.Background(), g.client, g.email, g.key, opts)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	// reused while valid.
+	if first, second := token(GoogleOptions{}), token(GoogleOptions{}); first != "token-1" || second != "token-1" || minted != 1 {
+		t.Fatalf("tokens %q and %q with %d mints, want the first one reused", first, second, minted)
+	}
+
+	// within the skew of its expiry.
+	if refreshed := token(GoogleOptions{TokenRefreshSkew: 2 * time.Hour}); refreshed != "token-2" {
+		t.Fatalf("token %q within the refresh skew, want a new one", refreshed)
+	}
+
+	// after expiry.
+	tokenMu.Lock()
+	creds := googleCredentialsCache[googleCredentialsKey(g.email, g.key)]
+	tokenMu.Unlock()
+	creds.mu.Lock()
+	creds.token.Expiry = time.Now().Add(-time.Minute)
+	creds.mu.Unlock()
+	if refreshed := token(GoogleOptions{}); refreshed != "token-3" || minted != 3 {
+		t.Fatalf("token %q with %d mints after expiry, want a new one", refreshed, minted)
+	}
+}
+
+func TestGoogleBaseUrl(t *testing.T) {
+	receipt := googlePurchaseJSON("gems_10", "tokA")
+	tests := []struct {
+		name string
+		path string
+		call func(ctx context.Context, g *testGoogle, client *http.Client, opts GoogleOptions) error
+	}{
+		{
+			name: "product",
+			path: "/gateway/androidpublisher/v3/applications/com.example.app/purchases/products/gems_10/tokens/tokA",
+			call: func(ctx context.Context, g *testGoogle, client *http.Client, opts GoogleOptions) error {
+				_, _, _, err := ValidateReceiptGoogleWithOptions(ctx, client, g.email, g.key, receipt, opts)
+				return err
+			},
+		},
+		{
+			name: "subscription",
+			path: "/gateway/androidpublisher/v3/applications/com.example.app/purchases/subscriptions/gems_10/tokens/tokA",
+			call: func(ctx context.Context, g *testGoogle, client *http.Client, opts GoogleOptions) error {
+				_, _, _, err := ValidateSubscriptionReceiptGoogleWithOptions(ctx, client, g.email, g.key, receipt, opts)
+				return err
+			},
+		},
+		{
+			name: "subscription v2",
+			path: "/gateway/androidpublisher/v3/applications/com.example.app/purchases/subscriptionsv2/tokens/tokA",
+			call: func(ctx context.Context, g *testGoogle, client *http.Client, opts GoogleOptions) error {
+				_, _, err := ValidateSubscriptionV2GoogleWithOptions(ctx, client, g.email, g.key, "com.example.app", "tokA", opts)
+				return err
+			},
+		},
+		{
+			name: "acknowledge",
+			path: "/gateway/androidpublisher/v3/applications/com.example.app/purchases/products/gems_10/tokens/tokA:acknowledge",
+			call: func(ctx context.Context, g *testGoogle, client *http.Client, opts GoogleOptions) error {
+				return AcknowledgeProductGoogleWithOptions(ctx, client, g.email, g.key, "com.example.app", "gems_10", "tokA", opts)
+			},
+		},
+		{
+			name: "voided",
+			path: "/gateway/androidpublisher/v3/applications/com.example.app/purchases/voidedpurchases",
+			call: func(ctx context.Context, g *testGoogle, client *http.Client, opts GoogleOptions) error {
+				_, err := ListVoidedPurchasesGoogleWithOptions(ctx, client, g.email, g.key, "com.example.app", time.Time{}, time.Time{}, opts)
+				return err
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoogle(t)
+			var path string
+			g.mux.HandleFunc("/gateway/", func(w http.ResponseWriter, r *http.Request) {
+				path = r.URL.Path
+				w.Header().Set("Content-Type", "application/json")
+				_, _ = w.Write([]byte(`{}`))
+			})
+			// only the token endpoint is redirected, the API calls go to BaseUrl as is.
+			transport := g.srv.Client().Transport
+			client := &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+				if r.URL.Path == "/token" {
+					return g.client.Transport.RoundTrip(r)
+				}
+				return transport.RoundTrip(r)
+			})}
+
+			if err := tt.call(context.Background(), g, client, GoogleOptions{BaseUrl: g.srv.URL + "/gateway/"}); err != nil {
+				t.Fatal(err)
+			}
+			if path != tt.path {
+				t.Fatalf("path %q, want %q", path, tt.path)
+			}
+		})
+	}
+}
+
+func TestGoogleTokenMintClientTimeout(t *testing.T) {
+	g := newTestGoogle(t)
+	release := make(chan struct{})
+	defer close(release)
+	g.mux = http.NewServeMux()
+	g.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	// the client timeout is shorter than the budget of the deadline.
+	g.client.Timeout = 50 * time.Millisecond
+
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	start := time.Now()
+	if _, err := googleAccessToken(ctx, g.client, g.email, g.key, GoogleOptions{}); err == nil {
+		t.Fatal("token minted, want the client timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Fatalf("token mint took %v, want the 50ms client timeout", elapsed)
+	}
+}
+
+func TestParseGoogleRTDN(t *testing.T) {
+	subscription := base64.StdEncoding.EncodeToString([]byte(`{"version":"1.0","packageName":"com.example.app","eventTimeMillis":"1607721533824",` +
+		`"subscriptionNotification":{"version":"1.0","notificationType":4,"purchaseToken":"token-1","subscriptionId":"monthly"}}`))
+	product := base64.StdEncoding.EncodeToString([]byte(`{"version":"1.0","packageName":"com.example.app","eventTimeMillis":"1607721533824",` +
+		`"oneTimeProductNotification":{"version":"1.0","notificationType":1,"purchaseToken":"token-2","sku":"coins"}}`))
+
+	// Pub/Sub push request body.
+	n, err := ParseGoogleRTDN([]byte(`{"message":{"attributes":{},"data":"` + subscription + `","messageId":"136969346945","publishTime":"2020-12-11T21:18:54.246Z"},` +
+		`"subscription":"projects/example/subscriptions/play-rtdn"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.PackageName != "com.example.app" || n.EventTimeMillis != 1607721533824 || n.SubscriptionNotification == nil ||
+		n.SubscriptionNotification.NotificationType != 4 || n.SubscriptionNotification.PurchaseToken != "token-1" ||
+		n.SubscriptionNotification.SubscriptionId != "monthly" || n.OneTimeProductNotification != nil {
+		t.Fatalf("notification %+v, want the subscription purchase", n)
+	}
+
+	// bare Pub/Sub message.
+	n, err = ParseGoogleRTDN([]byte(`{"data":"` + product + `","messageId":"136969346946"}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if n.OneTimeProductNotification == nil || n.OneTimeProductNotification.PurchaseToken != "token-2" ||
+		n.OneTimeProductNotification.Sku != "coins" || n.SubscriptionNotification != nil {
+		t.Fatalf("notification %+v, want the one-time product purchase", n)
+	}
+
+	for _, body := range []string{``, `{"message":{}}`, `{"message":{"data":"not base64!"}}`} {
+		if _, err := ParseGoogleRTDN([]byte(body)); err == nil {
+			t.Fatalf("body %q parsed, want an error", body)
+		}
+	}
+}
@@ -0,0 +1,194 @@
+package iap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"time"
+)
+
+// subscriptionState values of purchases.subscriptionsv2.
+const (
+	GoogleSubscriptionStatePending                 = "SUBSCRIPTION_STATE_PENDING"
+	GoogleSubscriptionStateActive                  = "SUBSCRIPTION_STATE_ACTIVE"
+	GoogleSubscriptionStatePaused                  = "SUBSCRIPTION_STATE_PAUSED"
+	GoogleSubscriptionStateInGracePeriod           = "SUBSCRIPTION_STATE_IN_GRACE_PERIOD"
+	GoogleSubscriptionStateOnHold                  = "SUBSCRIPTION_STATE_ON_HOLD"
+	GoogleSubscriptionStateCanceled                = "SUBSCRIPTION_STATE_CANCELED"
+	GoogleSubscriptionStateExpired                 = "SUBSCRIPTION_STATE_EXPIRED"
+	GoogleSubscriptionStatePendingPurchaseCanceled = "SUBSCRIPTION_STATE_PENDING_PURCHASE_CANCELED"
+)
+
+// SubscriptionPurchaseV2Google purchases.subscriptionsv2 response.
+type SubscriptionPurchaseV2Google struct {
+	Kind                 string                       `json:"kind"`
+	RegionCode           string                       `json:"regionCode"`
+	LineItems            []SubscriptionLineItemGoogle `json:"lineItems"`
+	StartTime            string                       `json:"startTime"`
+	SubscriptionState    string                       `json:"subscriptionState"`
+	LatestOrderId        string                       `json:"latestOrderId"`
+	LinkedPurchaseToken  string                       `json:"linkedPurchaseToken"`
+	AcknowledgementState string                       `json:"acknowledgementState"`
+	// Only present for license testing purchases.
+	TestPurchase *struct{} `json:"testPurchase,omitempty"`
+	// Only present once the subscription was canceled.
+	CanceledStateContext       *CanceledStateContextGoogle       `json:"canceledStateContext,omitempty"`
+	ExternalAccountIdentifiers *ExternalAccountIdentifiersGoogle `json:"externalAccountIdentifiers,omitempty"`
+	// PriceChangeMode and PriceChangeState of the first line item with a pending price change,
+	// empty when there is none. The user must be prompted while the state is OUTSTANDING.
+	PriceChangeMode  string `json:"-"`
+	PriceChangeState string `json:"-"`
+	// GracePeriodExpiryTime latest line item expiryTime while SubscriptionState is IN_GRACE_PERIOD,
+	// Google extends it to the end of the grace period. Zero otherwise.
+	GracePeriodExpiryTime time.Time `json:"-"`
+}
+
+// HasAccess subscriptionState ACTIVE or IN_GRACE_PERIOD, or CANCELED which keeps access until the expiry.
+func (r *SubscriptionPurchaseV2Google) HasAccess() bool {
+	switch r.SubscriptionState {
+	case GoogleSubscriptionStateActive, GoogleSubscriptionStateInGracePeriod, GoogleSubscriptionStateCanceled:
+		return true
+	}
+	return false
+}
+
+// CanceledStateContextGoogle exactly one of the reasons is set.
+type CanceledStateContextGoogle struct {
+	UserInitiatedCancellation *struct {
+		CancelTime string `json:"cancelTime"`
+	} `json:"userInitiatedCancellation,omitempty"`
+	SystemInitiatedCancellation    *struct{} `json:"systemInitiatedCancellation,omitempty"`
+	DeveloperInitiatedCancellation *struct{} `json:"developerInitiatedCancellation,omitempty"`
+	ReplacementCancellation        *struct{} `json:"replacementCancellation,omitempty"`
+}
+
+type ExternalAccountIdentifiersGoogle struct {
+	ExternalAccountId           string `json:"externalAccountId"`
+	ObfuscatedExternalAccountId string `json:"obfuscatedExternalAccountId"`
+	ObfuscatedExternalProfileId string `json:"obfuscatedExternalProfileId"`
+}
+
+type SubscriptionLineItemGoogle struct {
+	ProductId        string                  `json:"productId"`
+	ExpiryTime       string                  `json:"expiryTime"`
+	AutoRenewingPlan *AutoRenewingPlanGoogle `json:"autoRenewingPlan,omitempty"`
+	OfferDetails     *OfferDetailsGoogle     `json:"offerDetails,omitempty"`
+}
+
+type AutoRenewingPlanGoogle struct {
+	AutoRenewEnabled   bool                      `json:"autoRenewEnabled"`
+	PriceChangeDetails *PriceChangeDetailsGoogle `json:"priceChangeDetails,omitempty"`
+}
+
+type PriceChangeDetailsGoogle struct {
+	NewPrice *MoneyGoogle `json:"newPrice,omitempty"`
+	// PRICE_DECREASE, PRICE_INCREASE or OPT_OUT_PRICE_INCREASE.
+	PriceChangeMode string `json:"priceChangeMode"`
+	// OUTSTANDING, CONFIRMED or APPLIED.
+	PriceChangeState           string `json:"priceChangeState"`
+	ExpectedNewPriceChargeTime string `json:"expectedNewPriceChargeTime"`
+}
+
+type MoneyGoogle struct {
+	CurrencyCode string `json:"currencyCode"`
+	Units        string `json:"units"`
+	Nanos        int64  `json:"nanos"`
+}
+
+type OfferDetailsGoogle struct {
+	BasePlanId string   `json:"basePlanId"`
+	OfferId    string   `json:"offerId"`
+	OfferTags  []string `json:"offerTags"`
+}
+
+// ValidateSubscriptionV2Google validate a subscription purchase token with the purchases.subscriptionsv2 endpoint.
+func ValidateSubscriptionV2Google(ctx context.Context, httpc *http.Client, clientEmail string, privateKey string, packageName string, purchaseToken string) (*SubscriptionPurchaseV2Google, []byte, error) {
+	return ValidateSubscriptionV2GoogleWithOptions(ctx, httpc, clientEmail, privateKey, packageName, purchaseToken, GoogleOptions{})
+}
+
+// ValidateSubscriptionV2GoogleWithOptions ValidateSubscriptionV2Google with options, there's no receipt
+// signature to verify with PublicKey.
+func ValidateSubscriptionV2GoogleWithOptions(ctx context.Context, httpc *http.Client, clientEmail string, privateKey string, packageName string, purchaseToken string, opts GoogleOptions) (*SubscriptionPurchaseV2Google, []byte, error) {
+	if len(packageName) < 1 {
+		return nil, nil, errors.New("'packageName' is empty")
+	}
+
+	if len(purchaseToken) < 1 {
+		return nil, nil, errors.New("'purchaseToken' is empty")
+	}
+
+	var buf []byte
+	err := withGoogleAccessToken(ctx, httpc, clientEmail, privateKey, opts, func(token string) (err error) {
+		buf, err = requestSubscriptionV2Google(ctx, httpc, opts.BaseUrl, token, packageName, purchaseToken)
+		return err
+	})
+	if err != nil {
+		return nil, nil, err
+	}
+
+	out := &SubscriptionPurchaseV2Google{}
+	if err := json.Unmarshal(buf, out); err != nil {
+		return nil, nil, err
+	}
+
+	for _, item := range out.LineItems {
+		if item.AutoRenewingPlan != nil && item.AutoRenewingPlan.PriceChangeDetails != nil {
+			out.PriceChangeMode = item.AutoRenewingPlan.PriceChangeDetails.PriceChangeMode
+			out.PriceChangeState = item.AutoRenewingPlan.PriceChangeDetails.PriceChangeState
+			break
+		}
+	}
+
+	if out.SubscriptionState == GoogleSubscriptionStateInGracePeriod {
+		for _, item := range out.LineItems {
+			expiry, err := time.Parse(time.RFC3339Nano, item.ExpiryTime)
+			if err != nil {
+				return nil, nil, err
+			}
+			if expiry.After(out.GracePeriodExpiryTime) {
+				out.GracePeriodExpiryTime = expiry
+			}
+		}
+	}
+
+	return out, buf, nil
+}
+
+func requestSubscriptionV2Google(ctx context.Context, httpc *http.Client, baseUrl, token, packageName, purchaseToken string) ([]byte, error) {
+	u, err := googleAPIUrl(baseUrl, fmt.Sprintf("androidpublisher/v3/applications/%s/purchases/subscriptionsv2/tokens/%s", packageName, purchaseToken), fmt.Sprintf("access_token=%s", token))
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		if isTimeout(ctx, err) {
+			return nil, fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	setHTTPHeaders(ctx, req)
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		if isTimeout(ctx, err) {
+			return nil, fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newGoogleHTTPError(resp)
+	}
+
+	return ioutil.ReadAll(resp.Body)
+}
@@ -0,0 +1,103 @@
+package iap
+
+import (
+	"context"
+	"net/http"
+	"testing"
+	"time"
+)
+
+const subscriptionV2Path = "/androidpublisher/v3/applications/com.example.app/purchases/subscriptionsv2/tokens/token-1"
+
+func TestValidateSubscriptionV2Google(t *testing.T) {
+	g := newTestGoogle(t)
+	g.mux.HandleFunc(subscriptionV2Path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{
+			"kind": "androidpublisher#subscriptionPurchaseV2",
+			"regionCode": "TH",
+			"lineItems": [{
+				"productId": "monthly",
+				"expiryTime": "2023-08-01T10:00:00.123Z",
+				"autoRenewingPlan": {
+					"autoRenewEnabled": true,
+					"priceChangeDetails": {"newPrice": {"currencyCode": "THB", "units": "129"}, "priceChangeMode": "PRICE_INCREASE", "priceChangeState": "OUTSTANDING"}
+				},
+				"offerDetails": {"basePlanId": "monthly-autorenew", "offerId": "intro", "offerTags": ["launch"]}
+			}],
+			"startTime": "2023-07-01T10:00:00.123Z",
+			"subscriptionState": "SUBSCRIPTION_STATE_ACTIVE",
+			"latestOrderId": "GPA.1234-5678-9012-34567..0",
+			"acknowledgementState": "ACKNOWLEDGEMENT_STATE_ACKNOWLEDGED",
+			"externalAccountIdentifiers": {"obfuscatedExternalAccountId": "account-1"}
+		}`))
+	})
+
+	out, raw, err := ValidateSubscriptionV2Google(context.Background(), g.client, g.email, g.key, "com.example.app", "token-1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(raw) < 1 {
+		t.Fatal("raw body is empty")
+	}
+	if out.SubscriptionState != GoogleSubscriptionStateActive || !out.HasAccess() || out.LatestOrderId != "GPA.1234-5678-9012-34567..0" || out.RegionCode != "TH" {
+		t.Fatalf("subscription %+v, want the active one", out)
+	}
+	if len(out.LineItems) != 1 {
+		t.Fatalf("%d line items, want 1", len(out.LineItems))
+	}
+	item := out.LineItems[0]
+	if item.ProductId != "monthly" || item.ExpiryTime != "2023-08-01T10:00:00.123Z" || item.AutoRenewingPlan == nil || !item.AutoRenewingPlan.AutoRenewEnabled {
+		t.Fatalf("line item %+v, want the auto renewing monthly", item)
+	}
+	if item.OfferDetails == nil || item.OfferDetails.BasePlanId != "monthly-autorenew" || item.OfferDetails.OfferId != "intro" {
+		t.Fatalf("offer details %+v, want the base plan and offer", item.OfferDetails)
+	}
+	if out.PriceChangeMode != "PRICE_INCREASE" || out.PriceChangeState != "OUTSTANDING" {
+		t.Fatalf("price change %q %q, want the outstanding increase", out.PriceChangeMode, out.PriceChangeState)
+	}
+	if out.ExternalAccountIdentifiers == nil || out.ExternalAccountIdentifiers.ObfuscatedExternalAccountId != "account-1" {
+		t.Fatalf("external account identifiers %+v", out.ExternalAccountIdentifiers)
+	}
+	if !out.GracePeriodExpiryTime.IsZero() {
+		t.Fatalf("grace period expiry %v of an active subscription, want zero", out.GracePeriodExpiryTime)
+	}
+}
+
+func TestValidateSubscriptionV2GoogleStates(t *testing.T) {
+	tests := []struct {
+		state  string
+		access bool
+		grace  bool
+	}{
+		{state: GoogleSubscriptionStateActive, access: true},
+		{state: GoogleSubscriptionStateCanceled, access: true},
+		{state: GoogleSubscriptionStateInGracePeriod, access: true, grace: true},
+		{state: GoogleSubscriptionStateOnHold},
+		{state: GoogleSubscriptionStateExpired},
+	}
+	for _, tt := range tests {
+		t.Run(tt.state, func(t *testing.T) {
+			g := newTestGoogle(t)
+			g.mux.HandleFunc(subscriptionV2Path, func(w http.ResponseWriter, r *http.Request) {
+				_, _ = w.Write([]byte(`{"subscriptionState":"` + tt.state + `","lineItems":[` +
+					`{"productId":"monthly","expiryTime":"2023-08-01T10:00:00Z"},{"productId":"monthly","expiryTime":"2023-08-04T10:00:00Z"}]}`))
+			})
+
+			out, _, err := ValidateSubscriptionV2Google(context.Background(), g.client, g.email, g.key, "com.example.app", "token-1")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if out.HasAccess() != tt.access {
+				t.Fatalf("HasAccess %v, want %v", out.HasAccess(), tt.access)
+			}
+			want := time.Time{}
+			if tt.grace {
+				want = time.Date(2023, 8, 4, 10, 0, 0, 0, time.UTC)
+			}
+			if !out.GracePeriodExpiryTime.Equal(want) {
+				t.Fatalf("grace period expiry %v, want %v", out.GracePeriodExpiryTime, want)
+			}
+		})
+	}
+}
@@ -0,0 +1,124 @@
+package iap
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strconv"
+	"time"
+)
+
+// VoidedPurchase a canceled, refunded or charged back Google purchase.
+type VoidedPurchase struct {
+	Kind               string `json:"kind"`
+	PurchaseToken      string `json:"purchaseToken"`
+	OrderId            string `json:"orderId"`
+	PurchaseTimeMillis int64  `json:"purchaseTimeMillis,string"`
+	VoidedTimeMillis   int64  `json:"voidedTimeMillis,string"`
+	// 0 user, 1 developer, 2 Google
+	VoidedSource int `json:"voidedSource"`
+	// 0 other, 1 remorse, 2 not received, 3 defective, 4 accidental purchase, 5 fraud, 6 friendly fraud, 7 chargeback
+	VoidedReason int `json:"voidedReason"`
+	// 1 full refund, 2 quantity based partial refund
+	RefundType int `json:"refundType"`
+}
+
+type voidedPurchasesPage struct {
+	TokenPagination *struct {
+		NextPageToken string `json:"nextPageToken"`
+	} `json:"tokenPagination"`
+	VoidedPurchases []VoidedPurchase `json:"voidedPurchases"`
+}
+
+// ListVoidedPurchasesGoogle lists the purchases of packageName voided between startTime and endTime, all pages.
+// Zero times let Google apply its defaults (the last 30 days).
+func ListVoidedPurchasesGoogle(ctx context.Context, httpc *http.Client, clientEmail, privateKey, packageName string, startTime, endTime time.Time) ([]VoidedPurchase, error) {
+	return ListVoidedPurchasesGoogleWithOptions(ctx, httpc, clientEmail, privateKey, packageName, startTime, endTime, GoogleOptions{})
+}
+
+// ListVoidedPurchasesGoogleWithOptions ListVoidedPurchasesGoogle with options.
+func ListVoidedPurchasesGoogleWithOptions(ctx context.Context, httpc *http.Client, clientEmail, privateKey, packageName string, startTime, endTime time.Time, opts GoogleOptions) ([]VoidedPurchase, error) {
+	if len(packageName) < 1 {
+		return nil, errors.New("'packageName' is empty")
+	}
+
+	var out []VoidedPurchase
+	pageToken := ""
+	for {
+		query := url.Values{}
+		if !startTime.IsZero() {
+			query.Set("startTime", strconv.FormatInt(startTime.UnixNano()/int64(time.Millisecond), 10))
+		}
+		if !endTime.IsZero() {
+			query.Set("endTime", strconv.FormatInt(endTime.UnixNano()/int64(time.Millisecond), 10))
+		}
+		if len(pageToken) > 0 {
+			query.Set("token", pageToken)
+		}
+
+		var page *voidedPurchasesPage
+		err := withGoogleAccessToken(ctx, httpc, clientEmail, privateKey, opts, func(token string) (err error) {
+			query.Set("access_token", token)
+			page, err = requestVoidedPurchasesGoogle(ctx, httpc, opts.BaseUrl, packageName, query)
+			return err
+		})
+		if err != nil {
+			return nil, err
+		}
+		out = append(out, page.VoidedPurchases...)
+
+		if page.TokenPagination == nil || len(page.TokenPagination.NextPageToken) < 1 {
+			return out, nil
+		}
+		pageToken = page.TokenPagination.NextPageToken
+	}
+}
+
+func requestVoidedPurchasesGoogle(ctx context.Context, httpc *http.Client, baseUrl, packageName string, query url.Values) (*voidedPurchasesPage, error) {
+	u, err := googleAPIUrl(baseUrl, fmt.Sprintf("androidpublisher/v3/applications/%s/purchases/voidedpurchases", packageName), query.Encode())
+	if err != nil {
+		return nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		if isTimeout(ctx, err) {
+			return nil, fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", u, nil)
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	setHTTPHeaders(ctx, req)
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		if isTimeout(ctx, err) {
+			return nil, fmt.Errorf("%w: %v", ErrAPITimeout, err)
+		}
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, newGoogleHTTPError(resp)
+	}
+
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+
+	var page voidedPurchasesPage
+	if err := json.Unmarshal(buf, &page); err != nil {
+		return nil, err
+	}
+	return &page, nil
+}
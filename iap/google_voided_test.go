@@ -0,0 +1,62 @@
+package iap
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+)
+
+func TestListVoidedPurchasesGooglePages(t *testing.T) {
+	g := newTestGoogle(t)
+	var queries []string
+	g.mux.HandleFunc("/androidpublisher/v3/applications/com.example.app/purchases/voidedpurchases", func(w http.ResponseWriter, r *http.Request) {
+		query := r.URL.Query()
+		queries = append(queries, query.Get("token"))
+		if query.Get("startTime") != "1600000000000" || query.Get("endTime") != "1700000000000" {
+			t.Errorf("query %v, want the time range in milliseconds", query)
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		if query.Get("token") == "" {
+			_, _ = w.Write([]byte(`{"tokenPagination":{"nextPageToken":"page-2"},"voidedPurchases":[
+				{"kind":"androidpublisher#voidedPurchase","purchaseToken":"token-1","orderId":"GPA.1","purchaseTimeMillis":"1600000000000","voidedTimeMillis":"1600000100000","voidedSource":0,"voidedReason":1}]}`))
+			return
+		}
+		_, _ = w.Write([]byte(`{"voidedPurchases":[
+			{"kind":"androidpublisher#voidedPurchase","purchaseToken":"token-2","orderId":"GPA.2","purchaseTimeMillis":"1600000200000","voidedTimeMillis":"1600000300000","voidedSource":2,"voidedReason":7,"refundType":1}]}`))
+	})
+
+	voided, err := ListVoidedPurchasesGoogle(context.Background(), g.client, g.email, g.key, "com.example.app", time.Unix(1600000000, 0), time.Unix(1700000000, 0))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(queries) != 2 || queries[0] != "" || queries[1] != "page-2" {
+		t.Fatalf("page tokens %q, want the first page then page-2", queries)
+	}
+	if len(voided) != 2 {
+		t.Fatalf("%d voided purchases, want both pages", len(voided))
+	}
+	first, second := voided[0], voided[1]
+	if first.PurchaseToken != "token-1" || first.OrderId != "GPA.1" || first.VoidedTimeMillis != 1600000100000 || first.VoidedSource != 0 {
+		t.Fatalf("first voided purchase %+v", first)
+	}
+	if second.PurchaseToken != "token-2" || second.OrderId != "GPA.2" || second.VoidedTimeMillis != 1600000300000 || second.VoidedSource != 2 || second.VoidedReason != 7 {
+		t.Fatalf("second voided purchase %+v", second)
+	}
+}
+
+func TestListVoidedPurchasesGoogleError(t *testing.T) {
+	g := newTestGoogle(t)
+	g.mux.HandleFunc("/androidpublisher/v3/applications/com.example.app/purchases/voidedpurchases", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusForbidden)
+		_, _ = w.Write([]byte(`{"error":{"code":403,"message":"The current user has insufficient permissions"}}`))
+	})
+
+	_, err := ListVoidedPurchasesGoogle(context.Background(), g.client, g.email, g.key, "com.example.app", time.Time{}, time.Time{})
+	var httpErr *GoogleHTTPError
+	if !errors.As(err, &httpErr) || httpErr.StatusCode != http.StatusForbidden {
+		t.Fatalf("error %v, want the 403 GoogleHTTPError", err)
+	}
+}
@@ -0,0 +1,28 @@
+package iap
+
+import (
+	"context"
+	"net/http"
+)
+
+type httpHeadersKey struct{}
+
+// WithHTTPHeaders returns a ctx whose Apple and Google requests also carry h, e.g. the auth header of an
+// outbound gateway. Headers the request already sets, like Content-Type and Authorization, are kept as is.
+// The playground validate Purchase* methods pass ctx through so it applies to them too.
+func WithHTTPHeaders(ctx context.Context, h http.Header) context.Context {
+	return context.WithValue(ctx, httpHeadersKey{}, h)
+}
+
+// setHTTPHeaders adds the WithHTTPHeaders headers of ctx to req without overwriting its own.
+func setHTTPHeaders(ctx context.Context, req *http.Request) {
+	h, _ := ctx.Value(httpHeadersKey{}).(http.Header)
+	for k, values := range h {
+		if len(req.Header.Values(k)) > 0 {
+			continue
+		}
+		for _, value := range values {
+			req.Header.Add(k, value)
+		}
+	}
+}
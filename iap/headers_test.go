@@ -0,0 +1,43 @@
+package iap
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithHTTPHeaders(t *testing.T) {
+	headers := http.Header{"X-Gateway-Key": []string{"secret"}, "Content-Type": []string{"text/plain"}}
+	ctx := WithHTTPHeaders(context.Background(), headers)
+
+	var appleHeader http.Header
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		appleHeader = r.Header.Clone()
+		_, _ = w.Write([]byte(`{"status":0,"environment":"Production"}`))
+	}))
+	defer srv.Close()
+	if _, _, err := ValidateReceiptAppleWithOptions(ctx, srv.Client(), "receipt", "", AppleOptions{ProductionUrl: srv.URL}); err != nil {
+		t.Fatal(err)
+	}
+
+	g := newTestGoogle(t)
+	var googleHeader http.Header
+	g.mux.HandleFunc("/androidpublisher/v3/applications/com.example.app/purchases/products/gems_10/tokens/tokA", func(w http.ResponseWriter, r *http.Request) {
+		googleHeader = r.Header.Clone()
+		_, _ = w.Write([]byte(`{"purchaseState":0}`))
+	})
+	if _, _, _, err := ValidateReceiptGoogle(ctx, g.client, g.email, g.key, googlePurchaseJSON("gems_10", "tokA")); err != nil {
+		t.Fatal(err)
+	}
+
+	for store, h := range map[string]http.Header{"apple": appleHeader, "google": googleHeader} {
+		if got := h.Get("X-Gateway-Key"); got != "secret" {
+			t.Fatalf("%s request X-Gateway-Key %q, want the ctx header", store, got)
+		}
+	}
+	// the request own headers are kept.
+	if got := appleHeader.Get("Content-Type"); got != "application/json; charset=utf-8" {
+		t.Fatalf("apple request Content-Type %q, want its own", got)
+	}
+}
@@ -0,0 +1,315 @@
+package iap
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	HuaweiTokenUrl = "https://oauth-login.cloud.huawei.com/oauth2/v3/token"
+	// HuaweiOrderUrl the China site, apps distributed elsewhere use the site of their location, e.g.
+	// https://orders-dre.iap.cloud.huawei.eu (Germany) or https://orders-dra.iap.cloud.huawei.asia (Singapore).
+	HuaweiOrderUrl = "https://orders-drcn.iap.cloud.huawei.com.cn"
+)
+
+var (
+	ErrNon200Huawei              = errors.New("non 200 response from Huawei service")
+	ErrHuaweiInvalidReceipt      = errors.New("huawei purchase is invalid")
+	ErrHuaweiSignatureInvalid    = errors.New("huawei purchase signature is invalid")
+	ErrHuaweiInvalidCredentials  = errors.New("huawei client id or secret is invalid")
+	ErrHuaweiPurchaseDataInvalid = errors.New("huawei purchase data is malformed")
+	ErrHuaweiPublicKeyMissing    = errors.New("huawei purchase signature given without a public key")
+)
+
+const (
+	HuaweiKindConsumable    = 0
+	HuaweiKindNonConsumable = 1
+	HuaweiKindSubscription  = 2
+)
+
+// HuaweiOptions optional behaviour of the Huawei validation.
+type HuaweiOptions struct {
+	// PublicKey optional, the base64 encoded RSA IAP public key of the app from AppGallery Connect.
+	// When set the purchase data signature must verify before it is sent to Huawei, see VerifyHuaweiSignature,
+	// when empty a signature is rejected with ErrHuaweiPublicKeyMissing rather than ignored.
+	PublicKey string
+	// TokenUrl and OrderUrl optional, default HuaweiTokenUrl and HuaweiOrderUrl.
+	TokenUrl string
+	OrderUrl string
+}
+
+func (o HuaweiOptions) tokenUrl() string {
+	if len(o.TokenUrl) > 0 {
+		return o.TokenUrl
+	}
+	return HuaweiTokenUrl
+}
+
+func (o HuaweiOptions) orderUrl() string {
+	if len(o.OrderUrl) > 0 {
+		return o.OrderUrl
+	}
+	return HuaweiOrderUrl
+}
+
+// HuaweiPurchaseData InAppPurchaseData of a Huawei purchase. Dates are UNIX milliseconds.
+type HuaweiPurchaseData struct {
+	ApplicationID    int64  `json:"applicationId"`
+	AutoRenewing     bool   `json:"autoRenewing"`
+	OrderID          string `json:"orderId"`
+	Kind             int    `json:"kind"` // 0 consumable, 1 non-consumable, 2 subscription
+	PackageName      string `json:"packageName"`
+	ProductID        string `json:"productId"`
+	ProductName      string `json:"productName"`
+	PurchaseTime     int64  `json:"purchaseTime"`
+	PurchaseState    int    `json:"purchaseState"` // -1 initialized, 0 purchased, 1 canceled, 2 refunded, 3 pending
+	DeveloperPayload string `json:"developerPayload"`
+	PurchaseToken    string `json:"purchaseToken"`
+	PurchaseType     *int   `json:"purchaseType"` // 0 sandbox, absent for a formal purchase
+	Currency         string `json:"currency"`
+	Price            int64  `json:"price"` // actual price multiplied by 100, e.g. 199 for 1.99
+	Country          string `json:"country"`
+	ConsumptionState int    `json:"consumptionState"` // 0 not consumed, 1 consumed
+	Confirmed        int    `json:"confirmed"`        // 0 not confirmed, 1 confirmed
+	Quantity         int    `json:"quantity"`
+	// Subscriptions only.
+	SubscriptionID string `json:"subscriptionId"`
+	ExpirationDate int64  `json:"expirationDate"`
+	CancelTime     int64  `json:"cancelTime"`
+	CancelReason   int    `json:"cancelReason"`
+}
+
+type huaweiVerifyResponse struct {
+	ResponseCode    string `json:"responseCode"`
+	ResponseMessage string `json:"responseMessage"`
+	// PurchaseTokenData orders, InappPurchaseData subscriptions, a HuaweiPurchaseData JSON string.
+	PurchaseTokenData string `json:"purchaseTokenData"`
+	InappPurchaseData string `json:"inappPurchaseData"`
+}
+
+// ValidateReceiptHuawei validates the InAppPurchaseData and its signature returned by the HMS IAP SDK with the
+// Huawei Order service, or the Subscription service for subscriptions. clientID and clientSecret are the
+// OAuth 2.0 credentials of the app from AppGallery Connect.
+// The signature is only verified with ValidateReceiptHuaweiWithOptions and a PublicKey, pass an empty
+// signature without one.
+// return the purchase data as returned by Huawei and raw data.
+func ValidateReceiptHuawei(ctx context.Context, httpc *http.Client, clientID, clientSecret, purchaseData, signature string) (*HuaweiPurchaseData, []byte, error) {
+	return ValidateReceiptHuaweiWithOptions(ctx, httpc, clientID, clientSecret, purchaseData, signature, HuaweiOptions{})
+}
+
+// ValidateReceiptHuaweiWithOptions ValidateReceiptHuawei with options.
+func ValidateReceiptHuaweiWithOptions(ctx context.Context, httpc *http.Client, clientID, clientSecret, purchaseData, signature string, opts HuaweiOptions) (*HuaweiPurchaseData, []byte, error) {
+	if len(clientID) < 1 {
+		return nil, nil, errors.New("'clientID' is empty")
+	}
+
+	if len(clientSecret) < 1 {
+		return nil, nil, errors.New("'clientSecret' is empty")
+	}
+
+	if len(purchaseData) < 1 {
+		return nil, nil, errors.New("'purchaseData' is empty")
+	}
+
+	if len(opts.PublicKey) > 0 {
+		if err := VerifyHuaweiSignature(purchaseData, signature, opts.PublicKey); err != nil {
+			return nil, nil, err
+		}
+	} else if len(signature) > 0 {
+		return nil, nil, ErrHuaweiPublicKeyMissing
+	}
+
+	var data HuaweiPurchaseData
+	if err := json.Unmarshal([]byte(purchaseData), &data); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrHuaweiPurchaseDataInvalid, err)
+	}
+	if len(data.PurchaseToken) < 1 || len(data.ProductID) < 1 {
+		return nil, nil, fmt.Errorf("%w: purchaseToken or productId missing", ErrHuaweiPurchaseDataInvalid)
+	}
+
+	accessToken, err := huaweiAccessToken(ctx, httpc, opts.tokenUrl(), clientID, clientSecret)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	u := opts.orderUrl() + "/applications/purchases/tokens/verify"
+	payload := map[string]string{"purchaseToken": data.PurchaseToken, "productId": data.ProductID}
+	if data.Kind == HuaweiKindSubscription || len(data.SubscriptionID) > 0 {
+		u = opts.orderUrl() + "/sub/applications/v2/purchases/get"
+		payload = map[string]string{"purchaseToken": data.PurchaseToken, "subscriptionId": data.SubscriptionID}
+	}
+
+	var w bytes.Buffer
+	if err := json.NewEncoder(&w).Encode(&payload); err != nil {
+		return nil, nil, err
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", u, &w)
+	if err != nil {
+		return nil, nil, err
+	}
+	req.Header.Set("Content-Type", "application/json; charset=utf-8")
+	req.Header.Set("Authorization", "Basic "+base64.StdEncoding.EncodeToString([]byte("APPAT:"+accessToken)))
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return nil, nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, nil, fmt.Errorf("%w: %d", ErrNon200Huawei, resp.StatusCode)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var out huaweiVerifyResponse
+	if err := json.Unmarshal(buf, &out); err != nil {
+		return nil, nil, err
+	}
+	if out.ResponseCode != "0" {
+		return nil, nil, fmt.Errorf("%w: responseCode %s %s", ErrHuaweiInvalidReceipt, out.ResponseCode, out.ResponseMessage)
+	}
+
+	verified := out.PurchaseTokenData
+	if len(verified) < 1 {
+		verified = out.InappPurchaseData
+	}
+	var purchase HuaweiPurchaseData
+	if err := json.Unmarshal([]byte(verified), &purchase); err != nil {
+		return nil, nil, fmt.Errorf("%w: %v", ErrHuaweiPurchaseDataInvalid, err)
+	}
+	return &purchase, buf, nil
+}
+
+// VerifyHuaweiSignature verifies the SHA256WithRSA signature of purchaseData against the app IAP public key.
+func VerifyHuaweiSignature(purchaseData, signature, base64PublicKey string) error {
+	if len(purchaseData) < 1 {
+		return errors.New("'purchaseData' is empty")
+	}
+
+	if len(base64PublicKey) < 1 {
+		return errors.New("'base64PublicKey' is empty")
+	}
+
+	der, err := base64.StdEncoding.DecodeString(base64PublicKey)
+	if err != nil {
+		return err
+	}
+	parsed, err := x509.ParsePKIXPublicKey(der)
+	if err != nil {
+		return err
+	}
+	key, ok := parsed.(*rsa.PublicKey)
+	if !ok {
+		return errors.New("'base64PublicKey' is not an RSA key")
+	}
+
+	sig, err := base64.StdEncoding.DecodeString(signature)
+	if err != nil || len(sig) < 1 {
+		return fmt.Errorf("%w: malformed signature", ErrHuaweiSignatureInvalid)
+	}
+
+	digest := sha256.Sum256([]byte(purchaseData))
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, digest[:], sig); err != nil {
+		return fmt.Errorf("%w: %v", ErrHuaweiSignatureInvalid, err)
+	}
+	return nil
+}
+
+type huaweiToken struct {
+	accessToken string
+	expiry      time.Time
+}
+
+var (
+	huaweiTokenMu sync.Mutex
+	// huaweiTokens keyed by huaweiTokenKey, a token minted with one secret or endpoint is not reused for another.
+	huaweiTokens = map[string]huaweiToken{}
+)
+
+func huaweiTokenKey(tokenUrl, clientID, clientSecret string) string {
+	sum := sha256.Sum256([]byte(tokenUrl + "\x00" + clientID + "\x00" + clientSecret))
+	return hex.EncodeToString(sum[:])
+}
+
+// huaweiAccessToken app level access token of clientID, cached until a minute before it expires.
+func huaweiAccessToken(ctx context.Context, httpc *http.Client, tokenUrl, clientID, clientSecret string) (string, error) {
+	key := huaweiTokenKey(tokenUrl, clientID, clientSecret)
+	huaweiTokenMu.Lock()
+	cached, ok := huaweiTokens[key]
+	huaweiTokenMu.Unlock()
+	if ok && time.Now().Before(cached.expiry) {
+		return cached.accessToken, nil
+	}
+
+	form := url.Values{
+		"grant_type":    {"client_credentials"},
+		"client_id":     {clientID},
+		"client_secret": {clientSecret},
+	}
+
+	if err := ctx.Err(); err != nil {
+		return "", err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "POST", tokenUrl, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case 200:
+	case 400, 401:
+		return "", ErrHuaweiInvalidCredentials
+	default:
+		return "", fmt.Errorf("%w: %d", ErrNon200Huawei, resp.StatusCode)
+	}
+
+	var out struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int64  `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&out); err != nil {
+		return "", err
+	}
+	if len(out.AccessToken) < 1 {
+		return "", ErrHuaweiInvalidCredentials
+	}
+
+	huaweiTokenMu.Lock()
+	huaweiTokens[key] = huaweiToken{
+		accessToken: out.AccessToken,
+		expiry:      time.Now().Add(time.Duration(out.ExpiresIn)*time.Second - time.Minute),
+	}
+	huaweiTokenMu.Unlock()
+	return out.AccessToken, nil
+}
@@ -0,0 +1,141 @@
+package iap
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+)
+
+const testHuaweiPurchaseData = `{"productId":"coins","purchaseToken":"token-1","kind":0}`
+
+// huaweiPublicKey base64 encoded PKIX public key of key, as shown in AppGallery Connect.
+func huaweiPublicKey(t *testing.T, key *rsa.PrivateKey) string {
+	t.Helper()
+	der, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(der)
+}
+
+// huaweiSign SHA256WithRSA signature of purchaseData by key.
+func huaweiSign(t *testing.T, key *rsa.PrivateKey, purchaseData string) string {
+	t.Helper()
+	digest := sha256.Sum256([]byte(purchaseData))
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return base64.StdEncoding.EncodeToString(sig)
+}
+
+// newTestHuawei fake OAuth and Order service, tokens counts the token requests and verifies the verify requests.
+func newTestHuawei(t *testing.T) (srv *httptest.Server, tokens, verifies *int32) {
+	t.Helper()
+	tokens, verifies = new(int32), new(int32)
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v3/token", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(tokens, 1)
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test-token", "expires_in": 3600})
+	})
+	mux.HandleFunc("/applications/purchases/tokens/verify", func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(verifies, 1)
+		_ = json.NewEncoder(w).Encode(map[string]string{"responseCode": "0", "purchaseTokenData": testHuaweiPurchaseData})
+	})
+	srv = httptest.NewServer(mux)
+	t.Cleanup(srv.Close)
+	return srv, tokens, verifies
+}
+
+func TestVerifyHuaweiSignature(t *testing.T) {
+	key := newRSAKey(t)
+	publicKey := huaweiPublicKey(t, key)
+	signature := huaweiSign(t, key, testHuaweiPurchaseData)
+
+	if err := VerifyHuaweiSignature(testHuaweiPurchaseData, signature, publicKey); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := map[string]struct {
+		purchaseData string
+		signature    string
+	}{
+		"tampered data":       {purchaseData: `{"productId":"gems","purchaseToken":"token-1","kind":0}`, signature: signature},
+		"other key":           {purchaseData: testHuaweiPurchaseData, signature: huaweiSign(t, newRSAKey(t), testHuaweiPurchaseData)},
+		"malformed signature": {purchaseData: testHuaweiPurchaseData, signature: "not base64"},
+		"empty signature":     {purchaseData: testHuaweiPurchaseData},
+	}
+	for name, tt := range tests {
+		if err := VerifyHuaweiSignature(tt.purchaseData, tt.signature, publicKey); !errors.Is(err, ErrHuaweiSignatureInvalid) {
+			t.Errorf("%s: error %v, want ErrHuaweiSignatureInvalid", name, err)
+		}
+	}
+}
+
+func TestHuaweiSignature(t *testing.T) {
+	key := newRSAKey(t)
+	publicKey := huaweiPublicKey(t, key)
+	tests := []struct {
+		name      string
+		publicKey string
+		signature string
+		err       error
+	}{
+		{name: "valid", publicKey: publicKey, signature: huaweiSign(t, key, testHuaweiPurchaseData)},
+		{name: "invalid", publicKey: publicKey, signature: huaweiSign(t, newRSAKey(t), testHuaweiPurchaseData), err: ErrHuaweiSignatureInvalid},
+		{name: "missing", publicKey: publicKey, err: ErrHuaweiSignatureInvalid},
+		{name: "no public key", signature: huaweiSign(t, key, testHuaweiPurchaseData), err: ErrHuaweiPublicKeyMissing},
+		{name: "unsigned"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv, _, verifies := newTestHuawei(t)
+			opts := HuaweiOptions{PublicKey: tt.publicKey, TokenUrl: srv.URL + "/oauth2/v3/token", OrderUrl: srv.URL}
+
+			purchase, _, err := ValidateReceiptHuaweiWithOptions(context.Background(), srv.Client(), "client", "secret", testHuaweiPurchaseData, tt.signature, opts)
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				if n := atomic.LoadInt32(verifies); n != 0 {
+					t.Fatalf("%d verify requests for a rejected signature", n)
+				}
+				return
+			}
+			if purchase.ProductID != "coins" {
+				t.Fatalf("product %q, want coins", purchase.ProductID)
+			}
+		})
+	}
+}
+
+func TestHuaweiAccessTokenCache(t *testing.T) {
+	srv, tokens, _ := newTestHuawei(t)
+	opts := HuaweiOptions{TokenUrl: srv.URL + "/oauth2/v3/token", OrderUrl: srv.URL}
+	validate := func(clientSecret string) {
+		t.Helper()
+		if _, _, err := ValidateReceiptHuaweiWithOptions(context.Background(), srv.Client(), t.Name(), clientSecret, testHuaweiPurchaseData, "", opts); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	validate("secret")
+	validate("secret")
+	if n := atomic.LoadInt32(tokens); n != 1 {
+		t.Fatalf("%d token requests, want the cached token reused", n)
+	}
+	validate("rotated")
+	if n := atomic.LoadInt32(tokens); n != 2 {
+		t.Fatalf("%d token requests, want a token minted for the rotated secret", n)
+	}
+}
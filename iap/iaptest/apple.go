@@ -0,0 +1,115 @@
+// Package iaptest provides test helpers for code validating with the iap package.
+package iaptest
+
+import (
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/asn1"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"testing"
+	"time"
+)
+
+var (
+	oidAppleIntermediate = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 2, 1}
+	oidAppleLeaf         = asn1.ObjectIdentifier{1, 2, 840, 113635, 100, 6, 11, 1}
+)
+
+// AppleSigner signs JWS the way the App Store does, with a leaf and intermediate carrying Apple's marker
+// extensions under a test root. Trust Root with iap.AppleRootCertCache.SetCertificates and
+// iap.ContextWithAppleRootCerts to verify what it signs.
+type AppleSigner struct {
+	Root *x509.Certificate
+
+	x5c []string
+	key *ecdsa.PrivateKey
+}
+
+func NewAppleSigner(t testing.TB) *AppleSigner {
+	t.Helper()
+	newKey := func() *ecdsa.PrivateKey {
+		key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return key
+	}
+	create := func(serial int64, tmpl, parent *x509.Certificate, pub *ecdsa.PublicKey, signer *ecdsa.PrivateKey) *x509.Certificate {
+		tmpl.SerialNumber = big.NewInt(serial)
+		tmpl.NotBefore = time.Now().Add(-time.Hour)
+		tmpl.NotAfter = time.Now().Add(time.Hour)
+		if parent == nil {
+			parent = tmpl
+		}
+		der, err := x509.CreateCertificate(rand.Reader, tmpl, parent, pub, signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		cert, err := x509.ParseCertificate(der)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return cert
+	}
+	marker := func(oid asn1.ObjectIdentifier) []pkix.Extension {
+		return []pkix.Extension{{Id: oid, Value: []byte{0x05, 0x00}}}
+	}
+
+	rootKey, intermediateKey, leafKey := newKey(), newKey(), newKey()
+	ca := func(name string) *x509.Certificate {
+		return &x509.Certificate{
+			Subject:               pkix.Name{CommonName: name},
+			IsCA:                  true,
+			BasicConstraintsValid: true,
+			KeyUsage:              x509.KeyUsageCertSign,
+		}
+	}
+	root := create(1, ca("test root"), nil, &rootKey.PublicKey, rootKey)
+	intermediateTmpl := ca("test intermediate")
+	intermediateTmpl.ExtraExtensions = marker(oidAppleIntermediate)
+	intermediate := create(2, intermediateTmpl, root, &intermediateKey.PublicKey, rootKey)
+	leaf := create(3, &x509.Certificate{
+		Subject:         pkix.Name{CommonName: "test leaf"},
+		KeyUsage:        x509.KeyUsageDigitalSignature,
+		ExtraExtensions: marker(oidAppleLeaf),
+	}, intermediate, &leafKey.PublicKey, intermediateKey)
+
+	return &AppleSigner{
+		Root: root,
+		x5c: []string{
+			base64.StdEncoding.EncodeToString(leaf.Raw),
+			base64.StdEncoding.EncodeToString(intermediate.Raw),
+			base64.StdEncoding.EncodeToString(root.Raw),
+		},
+		key: leafKey,
+	}
+}
+
+// Sign the JSON encoded payload as an ES256 JWS carrying the certificate chain.
+func (s *AppleSigner) Sign(t testing.TB, payload interface{}) string {
+	t.Helper()
+	header, err := json.Marshal(map[string]interface{}{"alg": "ES256", "x5c": s.x5c})
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(payload)
+	if err != nil {
+		t.Fatal(err)
+	}
+	signing := base64.RawURLEncoding.EncodeToString(header) + "." + base64.RawURLEncoding.EncodeToString(body)
+	digest := sha256.Sum256([]byte(signing))
+	r, ss, err := ecdsa.Sign(rand.Reader, s.key, digest[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	sig := make([]byte, 64)
+	r.FillBytes(sig[:32])
+	ss.FillBytes(sig[32:])
+	return signing + "." + base64.RawURLEncoding.EncodeToString(sig)
+}
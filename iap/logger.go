@@ -0,0 +1,61 @@
+package iap
+
+import (
+	"context"
+)
+
+// Logger is a minimal structured logger. keyvals are alternating key, value pairs
+// the same as log/slog, so a slog adapter only needs to forward the calls.
+type Logger interface {
+	Debug(msg string, keyvals ...interface{})
+	Error(msg string, keyvals ...interface{})
+	// With returns a child Logger that adds keyvals to every line it writes.
+	With(keyvals ...interface{}) Logger
+}
+
+// NopLogger returns a Logger that discards everything.
+func NopLogger() Logger {
+	return nopLogger{}
+}
+
+type nopLogger struct{}
+
+func (nopLogger) Debug(msg string, keyvals ...interface{}) {}
+
+func (nopLogger) Error(msg string, keyvals ...interface{}) {}
+
+func (l nopLogger) With(keyvals ...interface{}) Logger { return l }
+
+type loggerKey struct{}
+
+// RequestIDKey is the context key of the request ID set by WithRequestID, ctx.Value(RequestIDKey{}) is a string.
+type RequestIDKey struct{}
+
+// WithRequestID returns a ctx carrying id to correlate a validation across systems: loggers given to
+// ContextWithLogger afterwards log it as request_id and the playground validate errors mention it.
+func WithRequestID(ctx context.Context, id string) context.Context {
+	return context.WithValue(ctx, RequestIDKey{}, id)
+}
+
+// RequestIDFromContext the ID set with WithRequestID, empty when there is none.
+func RequestIDFromContext(ctx context.Context) string {
+	id, _ := ctx.Value(RequestIDKey{}).(string)
+	return id
+}
+
+// ContextWithLogger returns a ctx the iap functions log to, they log nothing without one.
+// A request ID already in ctx is added to every line as request_id.
+func ContextWithLogger(ctx context.Context, l Logger) context.Context {
+	if id := RequestIDFromContext(ctx); len(id) > 0 {
+		l = l.With("request_id", id)
+	}
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// LoggerFromContext the logger set with ContextWithLogger, a Logger discarding everything when there is none.
+func LoggerFromContext(ctx context.Context) Logger {
+	if l, ok := ctx.Value(loggerKey{}).(Logger); ok && l != nil {
+		return l
+	}
+	return nopLogger{}
+}
@@ -0,0 +1,453 @@
+package iap
+
+import (
+	"bytes"
+	"context"
+	"crypto"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/pem"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/internal/flight"
+)
+
+const (
+	MicrosoftCertificateUrl = "https://go.microsoft.com/fwlink/?LinkId=246509&cid="
+)
+
+const (
+	MicrosoftProductTypeDurable    = "Durable"
+	MicrosoftProductTypeConsumable = "Consumable"
+)
+
+const (
+	xmlDSigExcC14N       = "http://www.w3.org/2001/10/xml-exc-c14n#"
+	xmlDSigRSASHA256     = "http://www.w3.org/2001/04/xmldsig-more#rsa-sha256"
+	xmlDSigSHA256        = "http://www.w3.org/2001/04/xmlenc#sha256"
+	xmlDSigEnveloped     = "http://www.w3.org/2000/09/xmldsig#enveloped-signature"
+	xmlDSigSignatureName = "Signature"
+)
+
+var (
+	ErrMicrosoftReceiptInvalid = errors.New("microsoft receipt signature is invalid")
+)
+
+// MicrosoftReceipt Windows Store receipt, dates are ISO 8601.
+type MicrosoftReceipt struct {
+	XMLName         xml.Name                  `xml:"Receipt"`
+	Version         string                    `xml:"Version,attr"`
+	CertificateID   string                    `xml:"CertificateId,attr"`
+	ReceiptDate     string                    `xml:"ReceiptDate,attr"`
+	ReceiptDeviceID string                    `xml:"ReceiptDeviceId,attr"`
+	AppReceipt      *MicrosoftAppReceipt      `xml:"AppReceipt"`
+	ProductReceipts []MicrosoftProductReceipt `xml:"ProductReceipt"`
+	Signature       xmlDSigSignature          `xml:"Signature"`
+}
+
+type MicrosoftAppReceipt struct {
+	ID           string `xml:"Id,attr"`
+	AppID        string `xml:"AppId,attr"`
+	LicenseType  string `xml:"LicenseType,attr"` // Possible values: Full, Trial
+	PurchaseDate string `xml:"PurchaseDate,attr"`
+}
+
+type MicrosoftProductReceipt struct {
+	ID                 string `xml:"Id,attr"`
+	AppID              string `xml:"AppId,attr"`
+	ProductID          string `xml:"ProductId,attr"`
+	ProductType        string `xml:"ProductType,attr"` // Possible values: Durable, Consumable
+	PurchasePrice      string `xml:"PurchasePrice,attr"`
+	PurchaseDate       string `xml:"PurchaseDate,attr"`
+	ExpirationDate     string `xml:"ExpirationDate,attr"` // Only returned for durables with a lifetime.
+	PublisherUserID    string `xml:"PublisherUserId,attr"`
+	MicrosoftProductID string `xml:"MicrosoftProductId,attr"`
+	MicrosoftAppID     string `xml:"MicrosoftAppId,attr"`
+}
+
+type xmlDSigSignature struct {
+	SignedInfo     xmlDSigSignedInfo `xml:"SignedInfo"`
+	SignatureValue string            `xml:"SignatureValue"`
+}
+
+type xmlDSigSignedInfo struct {
+	CanonicalizationMethod xmlDSigAlgorithm   `xml:"CanonicalizationMethod"`
+	SignatureMethod        xmlDSigAlgorithm   `xml:"SignatureMethod"`
+	References             []xmlDSigReference `xml:"Reference"`
+}
+
+type xmlDSigReference struct {
+	URI          string             `xml:"URI,attr"`
+	Transforms   []xmlDSigAlgorithm `xml:"Transforms>Transform"`
+	DigestMethod xmlDSigAlgorithm   `xml:"DigestMethod"`
+	DigestValue  string             `xml:"DigestValue"`
+}
+
+type xmlDSigAlgorithm struct {
+	Algorithm string `xml:"Algorithm,attr"`
+}
+
+// MicrosoftCertCache keeps the Microsoft receipt signing certificates by CertificateId for RefreshInterval.
+// CertificateId comes from the receipt, so at most MaxEntries fetched certificates are kept, the oldest are evicted.
+// When a refresh fails the previously fetched certificate keeps being used.
+type MicrosoftCertCache struct {
+	// HTTPClient default http.DefaultClient.
+	HTTPClient *http.Client
+	// RefreshInterval default 24 hours.
+	RefreshInterval time.Duration
+	// MaxEntries default 64, certificates pinned with SetCertificate don't count.
+	MaxEntries int
+
+	mu     sync.Mutex
+	certs  map[string]microsoftCert
+	flight flight.Group
+}
+
+type microsoftCert struct {
+	cert      *x509.Certificate
+	fetchedAt time.Time
+	pinned    bool
+}
+
+// DefaultMicrosoftCerts is used by ValidateReceiptMicrosoft and ValidateReceiptMicrosoftWithContext without certs.
+var DefaultMicrosoftCerts = &MicrosoftCertCache{}
+
+// SetCertificate pins the certificate (PEM or DER) for certificateID so it's never fetched, for air-gapped environments.
+func (c *MicrosoftCertCache) SetCertificate(certificateID string, cert []byte) error {
+	parsed, err := parseCertificate(cert)
+	if err != nil {
+		return err
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.certs == nil {
+		c.certs = make(map[string]microsoftCert)
+	}
+	c.certs[certificateID] = microsoftCert{cert: parsed, fetchedAt: time.Now(), pinned: true}
+	return nil
+}
+
+// Certificate returns the certificate for certificateID, fetching it from Microsoft when it's not cached or stale.
+// Concurrent calls for the same certificateID share a single fetch.
+func (c *MicrosoftCertCache) Certificate(ctx context.Context, certificateID string) (*x509.Certificate, error) {
+	if len(certificateID) < 1 {
+		return nil, errors.New("'certificateID' is empty")
+	}
+
+	c.mu.Lock()
+	cached, ok := c.certs[certificateID]
+	c.mu.Unlock()
+	if ok && (cached.pinned || time.Since(cached.fetchedAt) < c.refreshInterval()) {
+		return cached.cert, nil
+	}
+
+	v, err, _ := c.flight.Do(ctx, certificateID, func() (interface{}, error) {
+		return c.fetch(ctx, certificateID)
+	})
+	if err != nil {
+		if ok {
+			// keep serving the stale certificate.
+			return cached.cert, nil
+		}
+		return nil, err
+	}
+	cert, isCert := v.(*x509.Certificate)
+	if !isCert {
+		return nil, fmt.Errorf("no microsoft certificate %s", certificateID)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	if c.certs == nil {
+		c.certs = make(map[string]microsoftCert)
+	}
+	c.certs[certificateID] = microsoftCert{cert: cert, fetchedAt: time.Now()}
+	c.evict()
+	return cert, nil
+}
+
+func (c *MicrosoftCertCache) refreshInterval() time.Duration {
+	if c.RefreshInterval > 0 {
+		return c.RefreshInterval
+	}
+	return 24 * time.Hour
+}
+
+// evict drops the oldest fetched certificates beyond MaxEntries, c.mu must be held.
+func (c *MicrosoftCertCache) evict() {
+	max := c.MaxEntries
+	if max <= 0 {
+		max = 64
+	}
+	for {
+		fetched, oldestID := 0, ""
+		var oldest time.Time
+		for id, e := range c.certs {
+			if e.pinned {
+				continue
+			}
+			fetched++
+			if len(oldestID) < 1 || e.fetchedAt.Before(oldest) {
+				oldestID, oldest = id, e.fetchedAt
+			}
+		}
+		if fetched <= max {
+			return
+		}
+		delete(c.certs, oldestID)
+	}
+}
+
+func (c *MicrosoftCertCache) fetch(ctx context.Context, certificateID string) (*x509.Certificate, error) {
+	httpc := c.HTTPClient
+	if httpc == nil {
+		httpc = http.DefaultClient
+	}
+
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, "GET", MicrosoftCertificateUrl+url.QueryEscape(certificateID), nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := httpc.Do(req)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != 200 {
+		return nil, fmt.Errorf("non 200 response fetching microsoft certificate %s", certificateID)
+	}
+	buf, err := ioutil.ReadAll(resp.Body)
+	if err != nil {
+		return nil, err
+	}
+	return parseCertificate(buf)
+}
+
+// ValidateReceiptMicrosoft verifies the XML signature of a Windows Store receipt against Microsoft's certificate
+// and returns the receipt. The certificate is fetched once per CertificateId through DefaultMicrosoftCerts.
+func ValidateReceiptMicrosoft(receipt string) (*MicrosoftReceipt, error) {
+	return ValidateReceiptMicrosoftWithContext(context.Background(), DefaultMicrosoftCerts, receipt)
+}
+
+// ValidateReceiptMicrosoftWithContext ValidateReceiptMicrosoft fetching the certificate through certs bound by ctx,
+// certs nil uses DefaultMicrosoftCerts.
+func ValidateReceiptMicrosoftWithContext(ctx context.Context, certs *MicrosoftCertCache, receipt string) (*MicrosoftReceipt, error) {
+	if certs == nil {
+		certs = DefaultMicrosoftCerts
+	}
+
+	if len(receipt) < 1 {
+		return nil, errors.New("'receipt' is empty")
+	}
+
+	doc := []byte(receipt)
+	var out MicrosoftReceipt
+	if err := xml.Unmarshal(doc, &out); err != nil {
+		return nil, err
+	}
+
+	// encoding/xml keeps the last Signature while the canonicalization below signs the first one.
+	var signatures struct {
+		Signatures []struct{} `xml:"Signature"`
+	}
+	if err := xml.Unmarshal(doc, &signatures); err != nil {
+		return nil, err
+	}
+	if len(signatures.Signatures) != 1 {
+		return nil, fmt.Errorf("%w: expected one signature, got %d", ErrMicrosoftReceiptInvalid, len(signatures.Signatures))
+	}
+
+	signedInfo, err := canonicalizeXML(doc, []string{"Receipt", xmlDSigSignatureName, "SignedInfo"}, nil)
+	if err != nil {
+		return nil, err
+	}
+	sig, err := base64.StdEncoding.DecodeString(strings.Join(strings.Fields(out.Signature.SignatureValue), ""))
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMicrosoftReceiptInvalid, err)
+	}
+
+	cert, err := certs.Certificate(ctx, out.CertificateID)
+	if err != nil {
+		return nil, err
+	}
+	key, ok := cert.PublicKey.(*rsa.PublicKey)
+	if !ok {
+		return nil, fmt.Errorf("%w: certificate key is not RSA", ErrMicrosoftReceiptInvalid)
+	}
+	hashed := sha256.Sum256(signedInfo)
+	if err := rsa.VerifyPKCS1v15(key, crypto.SHA256, hashed[:], sig); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMicrosoftReceiptInvalid, err)
+	}
+
+	// algorithms and digest come from the SignedInfo bytes the signature was just verified over.
+	var si xmlDSigSignedInfo
+	if err := xml.Unmarshal(signedInfo, &si); err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrMicrosoftReceiptInvalid, err)
+	}
+	if si.CanonicalizationMethod.Algorithm != xmlDSigExcC14N ||
+		si.SignatureMethod.Algorithm != xmlDSigRSASHA256 ||
+		len(si.References) != 1 ||
+		si.References[0].DigestMethod.Algorithm != xmlDSigSHA256 ||
+		si.References[0].URI != "" {
+		return nil, fmt.Errorf("%w: unsupported signature algorithms", ErrMicrosoftReceiptInvalid)
+	}
+	ref := si.References[0]
+	for _, t := range ref.Transforms {
+		if t.Algorithm != xmlDSigEnveloped && t.Algorithm != xmlDSigExcC14N {
+			return nil, fmt.Errorf("%w: unsupported transform %s", ErrMicrosoftReceiptInvalid, t.Algorithm)
+		}
+	}
+
+	// the reference is the whole receipt without the enveloped signature.
+	signed, err := canonicalizeXML(doc, []string{"Receipt"}, []string{"Receipt", xmlDSigSignatureName})
+	if err != nil {
+		return nil, err
+	}
+	digest := sha256.Sum256(signed)
+	expected, err := base64.StdEncoding.DecodeString(strings.TrimSpace(ref.DigestValue))
+	if err != nil || !bytes.Equal(digest[:], expected) {
+		return nil, fmt.Errorf("%w: digest mismatch", ErrMicrosoftReceiptInvalid)
+	}
+
+	return &out, nil
+}
+
+func parseCertificate(cert []byte) (*x509.Certificate, error) {
+	if block, _ := pem.Decode(cert); block != nil {
+		cert = block.Bytes
+	}
+	return x509.ParseCertificate(cert)
+}
+
+// canonicalizeXML Exclusive XML Canonicalization (without comments) of the element at path, leaving out the
+// element at exclude. Only documents using default namespaces are supported, which is what the store receipts use.
+func canonicalizeXML(doc []byte, path, exclude []string) ([]byte, error) {
+	d := xml.NewDecoder(bytes.NewReader(doc))
+
+	var out bytes.Buffer
+	var names []string    // element path
+	var inScope []string  // default namespace in scope, per depth
+	var rendered []string // default namespace rendered on the output ancestors
+	capturing, skipping := -1, -1
+	for {
+		tok, err := d.RawToken()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return nil, err
+		}
+
+		switch t := tok.(type) {
+		case xml.StartElement:
+			if t.Name.Space != "" {
+				return nil, fmt.Errorf("%w: namespace prefixes are not supported", ErrMicrosoftReceiptInvalid)
+			}
+
+			ns := ""
+			if len(inScope) > 0 {
+				ns = inScope[len(inScope)-1]
+			}
+			var attrs []xml.Attr
+			for _, a := range t.Attr {
+				switch {
+				case a.Name.Space == "" && a.Name.Local == "xmlns":
+					ns = a.Value
+				case a.Name.Space == "":
+					attrs = append(attrs, a)
+				default:
+					return nil, fmt.Errorf("%w: namespace prefixes are not supported", ErrMicrosoftReceiptInvalid)
+				}
+			}
+
+			names = append(names, t.Name.Local)
+			inScope = append(inScope, ns)
+			depth := len(names)
+			if capturing < 0 && equalPath(names, path) {
+				capturing = depth
+			}
+			if capturing >= 0 && skipping < 0 && exclude != nil && equalPath(names, exclude) {
+				skipping = depth
+			}
+			if capturing < 0 || skipping >= 0 {
+				continue
+			}
+
+			parentNS := ""
+			if len(rendered) > 0 {
+				parentNS = rendered[len(rendered)-1]
+			}
+			out.WriteString("<" + t.Name.Local)
+			if ns != parentNS {
+				out.WriteString(` xmlns="` + escapeC14NAttr(ns) + `"`)
+			}
+			sort.Slice(attrs, func(i, j int) bool { return attrs[i].Name.Local < attrs[j].Name.Local })
+			for _, a := range attrs {
+				out.WriteString(" " + a.Name.Local + `="` + escapeC14NAttr(a.Value) + `"`)
+			}
+			out.WriteString(">")
+			rendered = append(rendered, ns)
+		case xml.EndElement:
+			depth := len(names)
+			if depth < 1 {
+				return nil, errors.New("xml is malformed")
+			}
+			if capturing >= 0 && skipping < 0 {
+				out.WriteString("</" + t.Name.Local + ">")
+				rendered = rendered[:len(rendered)-1]
+			}
+			if skipping == depth {
+				skipping = -1
+			}
+			if capturing == depth {
+				return out.Bytes(), nil
+			}
+			names = names[:depth-1]
+			inScope = inScope[:depth-1]
+		case xml.CharData:
+			if capturing >= 0 && skipping < 0 {
+				out.WriteString(escapeC14NText(string(t)))
+			}
+		}
+	}
+	return nil, fmt.Errorf("%w: %s not found", ErrMicrosoftReceiptInvalid, strings.Join(path, "/"))
+}
+
+func equalPath(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+var (
+	c14nTextEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", ">", "&gt;", "\r", "&#xD;")
+	c14nAttrEscaper = strings.NewReplacer("&", "&amp;", "<", "&lt;", `"`, "&quot;", "\t", "&#x9;", "\n", "&#xA;", "\r", "&#xD;")
+)
+
+func escapeC14NText(s string) string { return c14nTextEscaper.Replace(s) }
+
+func escapeC14NAttr(s string) string { return c14nAttrEscaper.Replace(s) }
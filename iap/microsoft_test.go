@@ -0,0 +1,169 @@
+package iap
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+const testMicrosoftCertID = "A656B9B1B3AA509EEA30222E6D5E7DBDA9822DCD"
+
+func microsoftReceiptXML(productReceipts, signature string) string {
+	return `<Receipt xmlns="http://schemas.microsoft.com/windows/2012/store/receipt" Version="1.0" CertificateId="` + testMicrosoftCertID + `" ReceiptDate="2021-01-01T00:00:00Z" ReceiptDeviceId="device">` +
+		productReceipts + signature + `</Receipt>`
+}
+
+func microsoftProductReceipt(productID string) string {
+	return `<ProductReceipt Id="tx1" AppId="app" ProductId="` + productID + `" PurchaseDate="2021-01-01T00:00:00Z" ProductType="Consumable" />`
+}
+
+func microsoftSignedInfo(digest []byte) string {
+	return `<SignedInfo><CanonicalizationMethod Algorithm="` + xmlDSigExcC14N + `" /><SignatureMethod Algorithm="` + xmlDSigRSASHA256 + `" />` +
+		`<Reference URI=""><Transforms><Transform Algorithm="` + xmlDSigEnveloped + `" /></Transforms><DigestMethod Algorithm="` + xmlDSigSHA256 + `" />` +
+		`<DigestValue>` + base64.StdEncoding.EncodeToString(digest) + `</DigestValue></Reference></SignedInfo>`
+}
+
+// microsoftSignature enveloped signature of productReceipts signed by key.
+func microsoftSignature(t *testing.T, key *rsa.PrivateKey, productReceipts string) string {
+	t.Helper()
+	signed, err := canonicalizeXML([]byte(microsoftReceiptXML(productReceipts, "")), []string{"Receipt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(signed)
+
+	envelope := `<Signature xmlns="http://www.w3.org/2000/09/xmldsig#">` + microsoftSignedInfo(digest[:]) + `<SignatureValue></SignatureValue></Signature>`
+	signedInfo, err := canonicalizeXML([]byte(microsoftReceiptXML(productReceipts, envelope)), []string{"Receipt", xmlDSigSignatureName, "SignedInfo"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashed := sha256.Sum256(signedInfo)
+	sig, err := rsa.SignPKCS1v15(rand.Reader, key, crypto.SHA256, hashed[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return strings.Replace(envelope, "<SignatureValue></SignatureValue>", "<SignatureValue>"+base64.StdEncoding.EncodeToString(sig)+"</SignatureValue>", 1)
+}
+
+func pinnedMicrosoftCerts(t *testing.T, key *rsa.PrivateKey) *MicrosoftCertCache {
+	t.Helper()
+	certs := &MicrosoftCertCache{}
+	if err := certs.SetCertificate(testMicrosoftCertID, newSelfSignedCert(t, key)); err != nil {
+		t.Fatal(err)
+	}
+	return certs
+}
+
+func TestValidateReceiptMicrosoft(t *testing.T) {
+	key := newRSAKey(t)
+	certs := pinnedMicrosoftCerts(t, key)
+	products := microsoftProductReceipt("gems_10")
+	receipt := microsoftReceiptXML(products, microsoftSignature(t, key, products))
+
+	out, err := ValidateReceiptMicrosoftWithContext(context.Background(), certs, receipt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(out.ProductReceipts) != 1 || out.ProductReceipts[0].ProductID != "gems_10" {
+		t.Fatalf("unexpected product receipts %+v", out.ProductReceipts)
+	}
+}
+
+func TestValidateReceiptMicrosoftTampered(t *testing.T) {
+	key := newRSAKey(t)
+	certs := pinnedMicrosoftCerts(t, key)
+	signature := microsoftSignature(t, key, microsoftProductReceipt("gems_10"))
+
+	// a second signature carrying the genuine SignatureValue and a digest matching the tampered receipt.
+	tampered := microsoftProductReceipt("gems_10000")
+	signed, err := canonicalizeXML([]byte(microsoftReceiptXML(tampered, "")), []string{"Receipt"}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	digest := sha256.Sum256(signed)
+	signatureValue := signature[strings.Index(signature, "<SignatureValue>"):]
+	forged := `<Signature xmlns="http://www.w3.org/2000/09/xmldsig#">` + microsoftSignedInfo(digest[:]) + signatureValue
+
+	tests := []struct {
+		name    string
+		receipt string
+	}{
+		{"product changed", microsoftReceiptXML(tampered, signature)},
+		{"second signature appended", microsoftReceiptXML(tampered, signature+forged)},
+		{"second signature prepended", microsoftReceiptXML(tampered, forged+signature)},
+		{"no signature", microsoftReceiptXML(tampered, "")},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			_, err := ValidateReceiptMicrosoftWithContext(context.Background(), certs, tt.receipt)
+			if !errors.Is(err, ErrMicrosoftReceiptInvalid) {
+				t.Fatalf("expected ErrMicrosoftReceiptInvalid, got %v", err)
+			}
+		})
+	}
+}
+
+func TestMicrosoftCertCacheFetch(t *testing.T) {
+	der := newSelfSignedCert(t, newRSAKey(t))
+	var fetches int32
+	release := make(chan struct{})
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-release
+		w.Write(der)
+	}))
+	defer srv.Close()
+
+	certs := &MicrosoftCertCache{HTTPClient: redirectClient(srv), MaxEntries: 2}
+
+	// concurrent calls for the same certificate share one fetch.
+	var wg sync.WaitGroup
+	for i := 0; i < 5; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if _, err := certs.Certificate(context.Background(), "a"); err != nil {
+				t.Error(err)
+			}
+		}()
+	}
+	time.Sleep(50 * time.Millisecond)
+	close(release)
+	wg.Wait()
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("expected 1 fetch, got %d", n)
+	}
+
+	for _, id := range []string{"b", "c"} {
+		if _, err := certs.Certificate(context.Background(), id); err != nil {
+			t.Fatal(err)
+		}
+	}
+	certs.mu.Lock()
+	_, ok := certs.certs["a"]
+	n := len(certs.certs)
+	certs.mu.Unlock()
+	if ok || n != 2 {
+		t.Fatalf("expected the oldest certificate evicted, %d cached, a cached %v", n, ok)
+	}
+
+	certs.RefreshInterval = time.Nanosecond
+	before := atomic.LoadInt32(&fetches)
+	if _, err := certs.Certificate(context.Background(), "c"); err != nil {
+		t.Fatal(err)
+	}
+	if atomic.LoadInt32(&fetches) != before+1 {
+		t.Fatal("expected a stale certificate to be fetched again")
+	}
+}
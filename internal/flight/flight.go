@@ -0,0 +1,69 @@
+// Package flight deduplicates concurrent calls, shared by the iap certificate caches and the validate result cache.
+package flight
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sync"
+)
+
+// Group runs one call per key at a time, concurrent callers of the same key wait for it and share its result.
+type Group struct {
+	mu    sync.Mutex
+	calls map[string]*call
+}
+
+type call struct {
+	done chan struct{}
+	val  interface{}
+	err  error
+	// leaderDone the leader ctx was done when fn failed, the error may only be its own.
+	leaderDone bool
+}
+
+// Do runs fn for key unless a call for key is in flight, then it waits for that call and returns its result with
+// shared set. A waiting caller gives up when its own ctx is done. The leader ctx being canceled or timing out is
+// not shared, the waiting callers run again, one of them as the new leader. A panic of fn is returned as an error.
+func (g *Group) Do(ctx context.Context, key string, fn func() (interface{}, error)) (val interface{}, err error, shared bool) {
+	for {
+		g.mu.Lock()
+		c, ok := g.calls[key]
+		if !ok {
+			c = &call{done: make(chan struct{})}
+			if g.calls == nil {
+				g.calls = make(map[string]*call)
+			}
+			g.calls[key] = c
+			g.mu.Unlock()
+
+			g.run(ctx, key, c, fn)
+			return c.val, c.err, false
+		}
+		g.mu.Unlock()
+
+		select {
+		case <-c.done:
+		case <-ctx.Done():
+			return nil, ctx.Err(), false
+		}
+		if c.leaderDone || errors.Is(c.err, context.Canceled) || errors.Is(c.err, context.DeadlineExceeded) {
+			continue
+		}
+		return c.val, c.err, true
+	}
+}
+
+func (g *Group) run(ctx context.Context, key string, c *call, fn func() (interface{}, error)) {
+	defer func() {
+		if r := recover(); r != nil {
+			c.val, c.err = nil, fmt.Errorf("flight: call panicked: %v", r)
+		}
+		c.leaderDone = c.err != nil && ctx.Err() != nil
+		g.mu.Lock()
+		delete(g.calls, key)
+		g.mu.Unlock()
+		close(c.done)
+	}()
+	c.val, c.err = fn()
+}
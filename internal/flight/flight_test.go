@@ -0,0 +1,96 @@
+package flight
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+// inFlight starts a leader call of key blocked until release is closed, it returns what fn returns then.
+func inFlight(t *testing.T, g *Group, ctx context.Context, key string, fn func() (interface{}, error)) (release chan struct{}, done chan error) {
+	t.Helper()
+	started, release, done := make(chan struct{}), make(chan struct{}), make(chan error, 1)
+	go func() {
+		_, err, _ := g.Do(ctx, key, func() (interface{}, error) {
+			close(started)
+			<-release
+			return fn()
+		})
+		done <- err
+	}()
+	<-started
+	return release, done
+}
+
+func TestDoShared(t *testing.T) {
+	var g Group
+	release, _ := inFlight(t, &g, context.Background(), "key", func() (interface{}, error) { return "leader", nil })
+
+	result := make(chan interface{}, 1)
+	go func() {
+		val, err, shared := g.Do(context.Background(), "key", func() (interface{}, error) { return "follower", nil })
+		if err != nil || !shared {
+			t.Errorf("error %v shared %v, want the shared leader result", err, shared)
+		}
+		result <- val
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if val := <-result; val != "leader" {
+		t.Fatalf("value %v, want the leader one", val)
+	}
+}
+
+func TestDoPanic(t *testing.T) {
+	var g Group
+	release, done := inFlight(t, &g, context.Background(), "key", func() (interface{}, error) { panic("boom") })
+
+	result := make(chan error, 1)
+	go func() {
+		_, err, _ := g.Do(context.Background(), "key", func() (interface{}, error) { return nil, nil })
+		result <- err
+	}()
+	time.Sleep(10 * time.Millisecond)
+	close(release)
+	if err := <-done; err == nil {
+		t.Fatal("leader panic not returned as an error")
+	}
+	if err := <-result; err == nil {
+		t.Fatal("follower got no error for the panicked call")
+	}
+}
+
+func TestDoFollowerContext(t *testing.T) {
+	var g Group
+	release, _ := inFlight(t, &g, context.Background(), "key", func() (interface{}, error) { return nil, nil })
+	defer close(release)
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	_, err, _ := g.Do(ctx, "key", func() (interface{}, error) { return nil, nil })
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error %v, want the follower deadline", err)
+	}
+}
+
+func TestDoLeaderCanceled(t *testing.T) {
+	var g Group
+	leaderCtx, cancel := context.WithCancel(context.Background())
+	release, _ := inFlight(t, &g, leaderCtx, "key", func() (interface{}, error) { return nil, errors.New("request aborted") })
+
+	result := make(chan interface{}, 1)
+	go func() {
+		val, err, shared := g.Do(context.Background(), "key", func() (interface{}, error) { return "follower", nil })
+		if err != nil || shared {
+			t.Errorf("error %v shared %v, want the follower to run as the new leader", err, shared)
+		}
+		result <- val
+	}()
+	time.Sleep(10 * time.Millisecond)
+	cancel()
+	close(release)
+	if val := <-result; val != "follower" {
+		t.Fatalf("value %v, want the follower own result", val)
+	}
+}
@@ -0,0 +1,57 @@
+package validate_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+func TestPurchaseAmazonCanceled(t *testing.T) {
+	ms := func(t time.Time) int64 { return t.UnixNano() / int64(time.Millisecond) }
+	tests := []struct {
+		name       string
+		cancelDate int64
+		err        error
+	}{
+		{name: "refunded", cancelDate: ms(time.Now().Add(-time.Hour)), err: validate.ErrPurchaseRefunded},
+		{name: "canceled at end of term", cancelDate: ms(time.Now().Add(time.Hour))},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+				_ = json.NewEncoder(w).Encode(map[string]interface{}{
+					"receiptId":    "receipt-1",
+					"productType":  "SUBSCRIPTION",
+					"productId":    "monthly",
+					"purchaseDate": ms(time.Now().Add(-24 * time.Hour)),
+					"cancelDate":   tt.cancelDate,
+				})
+			}))
+			defer srv.Close()
+			storage := memory.NewInMemoryStorage()
+			v := &validate.Validate{Storage: storage, HTTPClient: redirectClient(srv)}
+			v.Credentials.Amazon.DeveloperSecret = "secret"
+
+			resp, err := v.PurchaseAmazon(context.Background(), "user", "amzn-user", "receipt-1")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if tt.err != nil {
+				if n, _ := storage.CountUserPurchases(context.Background(), "user"); n > 0 {
+					t.Fatal("canceled purchase was stored")
+				}
+				return
+			}
+			if resp.ValidatedPurchases[0].CancellationReason != validate.CANCELLATION_REASON_UNKNOWN {
+				t.Fatalf("cancellation reason %v, want UNKNOWN", resp.ValidatedPurchases[0].CancellationReason)
+			}
+		})
+	}
+}
@@ -0,0 +1,445 @@
+package validate_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+func TestAppleStatusErrors(t *testing.T) {
+	tests := []struct {
+		status       int
+		err          error
+		invalidInput bool
+	}{
+		{status: 21000, err: validate.ErrAppleBadRequest},
+		{status: 21002, err: validate.ErrAppleMalformedReceipt, invalidInput: true},
+		{status: 21003, err: validate.ErrFailedPrecondition, invalidInput: true},
+		{status: 21004, err: validate.ErrAppleSharedSecretMismatch},
+		{status: 21005, err: validate.ErrUnavailableTryAgain},
+		{status: 21006, err: validate.ErrAppleSubscriptionExpired, invalidInput: true},
+		{status: 21008, err: validate.ErrAppleProductionReceiptInSandbox},
+		{status: 21009, err: validate.ErrUnavailableTryAgain},
+		{status: 21010, err: validate.ErrAppleAccountNotFound, invalidInput: true},
+		{status: 21150, err: validate.ErrUnavailableTryAgain},
+	}
+	for _, tt := range tests {
+		v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+		apple := newTestApple(t, v)
+		apple.production = map[string]interface{}{"status": tt.status}
+
+		_, err := v.PurchasesApple(context.Background(), "user", "receipt")
+		if !errors.Is(err, tt.err) {
+			t.Fatalf("status %d error %v, want %v", tt.status, err, tt.err)
+		}
+		var ve *validate.ValidationError
+		if !errors.As(err, &ve) || ve.ProviderStatus != tt.status {
+			t.Fatalf("status %d error %v, want a ValidationError with the status", tt.status, err)
+		}
+		if body, _ := json.Marshal(apple.production); strings.TrimSpace(string(ve.ProviderResponse)) != string(body) {
+			t.Fatalf("status %d provider response %s, want the raw body %s", tt.status, ve.ProviderResponse, body)
+		}
+		if errors.Is(err, validate.ErrFailedPrecondition) != tt.invalidInput {
+			t.Fatalf("status %d error %v, ErrFailedPrecondition %v, want %v", tt.status, err, !tt.invalidInput, tt.invalidInput)
+		}
+	}
+}
+
+func TestAppleHTTPErrors(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusServiceUnavailable)
+		_, _ = w.Write([]byte("maintenance"))
+	}))
+	defer srv.Close()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), HTTPClient: srv.Client(), AppleProductionUrl: srv.URL}
+
+	for name, purchase := range map[string]func() (*validate.ValidatePurchaseResponse, error){
+		"purchase": func() (*validate.ValidatePurchaseResponse, error) {
+			return v.PurchasesApple(context.Background(), "user", "receipt")
+		},
+		"subscription": func() (*validate.ValidatePurchaseResponse, error) {
+			return v.PurchasesSubscriptionAppleWithSecret(context.Background(), "user", "receipt", "secret")
+		},
+	} {
+		_, err := purchase()
+		var ve *validate.ValidationError
+		if !errors.As(err, &ve) || ve.Store != validate.APPLE_APP_STORE || ve.ProviderStatus != http.StatusServiceUnavailable {
+			t.Fatalf("%s: error %v, want a ValidationError with the HTTP status", name, err)
+		}
+		if string(ve.ProviderResponse) != "maintenance" || !errors.Is(err, iap.ErrNon200Apple) {
+			t.Fatalf("%s: provider response %q error %v, want the raw body and ErrNon200Apple", name, ve.ProviderResponse, err)
+		}
+	}
+}
+
+func TestPurchasesAppleSandboxFallbackResponse(t *testing.T) {
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	apple := newTestApple(t, v)
+	apple.sandbox = appleReceiptResponse("Sandbox", appleInApp("coins", "1000", time.Now()))
+
+	resp, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.UsedSandboxFallback || len(resp.ValidatedPurchases) != 1 {
+		t.Fatalf("response %+v, want the sandbox purchase", resp)
+	}
+
+	// the stored and returned response is the sandbox body, not the 21007 of production.
+	var provider struct {
+		Status      int    `json:"status"`
+		Environment string `json:"environment"`
+	}
+	if err := json.Unmarshal([]byte(resp.ValidatedPurchases[0].ProviderResponse), &provider); err != nil {
+		t.Fatal(err)
+	}
+	if provider.Status != 0 || provider.Environment != "Sandbox" {
+		t.Fatalf("provider response %s, want the sandbox body", resp.ValidatedPurchases[0].ProviderResponse)
+	}
+}
+
+func TestAppleExcludeOldTransactionsPerPath(t *testing.T) {
+	exclude, include := true, false
+	v := &validate.Validate{
+		Storage:                                 memory.NewInMemoryStorage(),
+		ApplePurchaseExcludeOldTransactions:     &include,
+		AppleSubscriptionExcludeOldTransactions: &exclude,
+	}
+	apple := newTestApple(t, v)
+	renewal := appleInApp("monthly", "2000", time.Now())
+	renewal["expires_date_ms"] = strconv.FormatInt(time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond), 10)
+
+	apple.production = appleReceiptResponse("Production", appleInApp("coins", "1000", time.Now()))
+	if _, err := v.PurchasesApple(context.Background(), "user", "receipt"); err != nil {
+		t.Fatal(err)
+	}
+
+	apple.production = appleReceiptResponse("Production", renewal)
+	if _, err := v.PurchasesSubscriptionAppleWithSecret(context.Background(), "user", "subscription receipt", "secret"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(apple.payloads) != 2 {
+		t.Fatalf("%d verifyReceipt requests, want 2", len(apple.payloads))
+	}
+	if got := apple.payloads[0]["exclude-old-transactions"]; got != false {
+		t.Fatalf("purchase exclude-old-transactions %v, want false", got)
+	}
+	if got := apple.payloads[1]["exclude-old-transactions"]; got != true {
+		t.Fatalf("subscription exclude-old-transactions %v, want true", got)
+	}
+}
+
+func TestPurchasesAppleRefunded(t *testing.T) {
+	canceled := time.Now().Add(-time.Hour).Truncate(time.Second)
+	refunded := appleInApp("coins", "1000", time.Now().Add(-2*time.Hour))
+	refunded["cancellation_date_ms"] = strconv.FormatInt(canceled.UnixNano()/int64(time.Millisecond), 10)
+	refunded["cancellation_reason"] = "1"
+	renewal := appleInApp("monthly", "2000", time.Now())
+	renewal["expires_date_ms"] = strconv.FormatInt(time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond), 10)
+	renewal["cancellation_date_ms"] = strconv.FormatInt(canceled.UnixNano()/int64(time.Millisecond), 10)
+	renewal["cancellation_reason"] = "0"
+
+	tests := []struct {
+		name     string
+		inApps   []map[string]string
+		purchase func(v *validate.Validate) (*validate.ValidatePurchaseResponse, error)
+		reason   string
+	}{
+		{
+			name:   "purchase",
+			inApps: []map[string]string{refunded, appleInApp("coins", "1001", time.Now())},
+			purchase: func(v *validate.Validate) (*validate.ValidatePurchaseResponse, error) {
+				return v.PurchasesApple(context.Background(), "user", "receipt")
+			},
+			reason: "1",
+		},
+		{
+			name:   "subscription",
+			inApps: []map[string]string{renewal},
+			purchase: func(v *validate.Validate) (*validate.ValidatePurchaseResponse, error) {
+				return v.PurchasesSubscriptionAppleWithSecret(context.Background(), "user", "receipt", "secret")
+			},
+			reason: "0",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+			apple := newTestApple(t, v)
+			apple.production = appleReceiptResponse("Production", tt.inApps...)
+
+			resp, err := tt.purchase(v)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(resp.ValidatedPurchases) != len(tt.inApps) {
+				t.Fatalf("%d validated purchases, want %d", len(resp.ValidatedPurchases), len(tt.inApps))
+			}
+			p := resp.ValidatedPurchases[0]
+			if p.CancellationReason != validate.CANCELLATION_REASON_REFUNDED || p.CancellationTime != canceled.Unix() || p.StoreCancellationReason != tt.reason {
+				t.Fatalf("refunded purchase %+v, want it flagged refunded at %d", p, canceled.Unix())
+			}
+			for _, live := range resp.ValidatedPurchases[1:] {
+				if live.CancellationReason != validate.CANCELLATION_REASON_NONE || live.CancellationTime != 0 {
+					t.Fatalf("live purchase %+v flagged canceled", live)
+				}
+			}
+		})
+	}
+}
+
+// appleRenewal in_app entry of a subscription period of originalTransactionID from purchaseTime to expiresTime.
+func appleRenewal(productID, transactionID, originalTransactionID string, purchaseTime, expiresTime time.Time) map[string]string {
+	inApp := appleInApp(productID, transactionID, purchaseTime)
+	inApp["original_transaction_id"] = originalTransactionID
+	inApp["expires_date_ms"] = strconv.FormatInt(expiresTime.UnixNano()/int64(time.Millisecond), 10)
+	return inApp
+}
+
+func TestSubscriptionPurchaseIsExpired(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		p    validate.SubscriptionPurchase
+		want bool
+	}{
+		{name: "active", p: validate.SubscriptionPurchase{ExpiresTime: now.Add(time.Hour)}, want: false},
+		{name: "expired", p: validate.SubscriptionPurchase{ExpiresTime: now.Add(-time.Hour)}, want: true},
+		{name: "expires now", p: validate.SubscriptionPurchase{ExpiresTime: now}, want: true},
+		{name: "in grace period", p: validate.SubscriptionPurchase{ExpiresTime: now.Add(-time.Hour), EffectiveExpiresTime: now.Add(time.Hour)}, want: false},
+		{name: "no expiry", p: validate.SubscriptionPurchase{}, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.p.IsExpired(now); got != tt.want {
+			t.Fatalf("%s: IsExpired %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPurchasesSubscriptionAppleSkipExpired(t *testing.T) {
+	now := time.Now()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), SkipExpiredSubscriptions: true}
+	apple := newTestApple(t, v)
+	apple.production = appleReceiptResponse("Production",
+		appleRenewal("monthly", "1000", "1000", now.AddDate(0, -2, 0), now.AddDate(0, -1, 0)),
+		appleRenewal("yearly", "2000", "2000", now.AddDate(0, -1, 0), now.AddDate(0, 11, 0)))
+
+	resp, err := v.PurchasesSubscriptionAppleWithSecret(context.Background(), "user", "receipt", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ValidatedPurchases) != 1 || resp.ValidatedPurchases[0].TransactionId != "2000" {
+		t.Fatalf("validated purchases %+v, want the active yearly only", resp.ValidatedPurchases)
+	}
+}
+
+func TestPurchasesSubscriptionAppleSkipExpiredAll(t *testing.T) {
+	now := time.Now()
+	storage := memory.NewInMemoryStorage()
+	v := &validate.Validate{Storage: storage, SkipExpiredSubscriptions: true}
+	apple := newTestApple(t, v)
+	apple.production = appleReceiptResponse("Production",
+		appleRenewal("monthly", "1000", "1000", now.AddDate(0, -2, 0), now.AddDate(0, -1, 0)))
+
+	_, err := v.PurchasesSubscriptionAppleWithSecret(context.Background(), "user", "receipt", "secret")
+	if !errors.Is(err, validate.ErrSubscriptionExpired) {
+		t.Fatalf("error %v, want ErrSubscriptionExpired", err)
+	}
+	if n, _ := storage.CountUserPurchases(context.Background(), "user"); n != 0 {
+		t.Fatalf("%d purchases stored, want none", n)
+	}
+}
+
+func TestPurchasesSubscriptionAppleLatestOnly(t *testing.T) {
+	now := time.Now()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), LatestSubscriptionOnly: true}
+	apple := newTestApple(t, v)
+	// a renewal chain of monthly, and a one period yearly.
+	apple.production = appleReceiptResponse("Production",
+		appleRenewal("monthly", "1000", "1000", now.AddDate(0, -2, 0), now.AddDate(0, -1, 0)),
+		appleRenewal("monthly", "1002", "1000", now, now.AddDate(0, 1, 0)),
+		appleRenewal("yearly", "2000", "2000", now.AddDate(0, -1, 0), now.AddDate(0, 11, 0)),
+		appleRenewal("monthly", "1001", "1000", now.AddDate(0, -1, 0), now))
+
+	resp, err := v.PurchasesSubscriptionAppleWithSecret(context.Background(), "user", "receipt", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range resp.ValidatedPurchases {
+		got = append(got, p.TransactionId)
+	}
+	if len(got) != 2 || got[0] != "1002" || got[1] != "2000" {
+		t.Fatalf("transactions %v, want the latest monthly 1002 and the yearly 2000", got)
+	}
+}
+
+func TestPurchasesSubscriptionAppleLatestReceiptInfo(t *testing.T) {
+	now := time.Now()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	apple := newTestApple(t, v)
+	// in_app lags behind, the renewal is only in latest_receipt_info.
+	response := appleReceiptResponse("Production", appleRenewal("monthly", "1000", "1000", now.AddDate(0, -1, 0), now))
+	response["latest_receipt"] = "bGF0ZXN0"
+	response["latest_receipt_info"] = []map[string]string{
+		appleRenewal("monthly", "1001", "1000", now, now.AddDate(0, 1, 0)),
+		appleRenewal("monthly", "1000", "1000", now.AddDate(0, -1, 0), now),
+	}
+	apple.production = response
+
+	resp, err := v.PurchasesSubscriptionAppleWithSecret(context.Background(), "user", "receipt", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, p := range resp.ValidatedPurchases {
+		got = append(got, p.TransactionId)
+	}
+	if len(got) != 2 || got[0] != "1001" || got[1] != "1000" {
+		t.Fatalf("transactions %v, want the latest_receipt_info ones", got)
+	}
+}
+
+func TestPurchasesSubscriptionAppleTrialPeriod(t *testing.T) {
+	now := time.Now()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	apple := newTestApple(t, v)
+	trial := appleRenewal("monthly", "1000", "1000", now.AddDate(0, -1, 0), now)
+	trial["is_trial_period"] = "true"
+	trial["is_in_intro_offer_period"] = "false"
+	paid := appleRenewal("monthly", "1001", "1000", now, now.AddDate(0, 1, 0))
+	paid["is_trial_period"] = "false"
+	paid["is_in_intro_offer_period"] = "false"
+	apple.production = appleReceiptResponse("Production", trial, paid)
+
+	resp, err := v.PurchasesSubscriptionAppleWithSecret(context.Background(), "user", "receipt", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, p := range resp.ValidatedPurchases {
+		switch p.TransactionId {
+		case "1000":
+			if !p.IsTrialPeriod || p.OfferType != iap.AppleOfferTypeFreeTrial {
+				t.Fatalf("trial purchase %+v, want the free trial offer", p)
+			}
+		case "1001":
+			if p.IsTrialPeriod || len(p.OfferType) > 0 {
+				t.Fatalf("paid purchase %+v, want no offer", p)
+			}
+		default:
+			t.Fatalf("unexpected purchase %+v", p)
+		}
+	}
+	if len(resp.ValidatedPurchases) != 2 {
+		t.Fatalf("%d purchases, want 2", len(resp.ValidatedPurchases))
+	}
+}
+
+func TestAppAccountTokenChecker(t *testing.T) {
+	tests := []struct {
+		name string
+		// owner the user the checker finds for the purchase appAccountToken.
+		owner string
+		err   error
+	}{
+		{name: "match", owner: "user"},
+		{name: "mismatch", owner: "other user", err: validate.ErrUserMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewInMemoryStorage()
+			v := &validate.Validate{
+				Storage: storage,
+				AppAccountTokenChecker: func(ctx context.Context, userID, appAccountToken string) bool {
+					return appAccountToken == "token-of-user" && userID == tt.owner
+				},
+			}
+			apple := newTestApple(t, v)
+			inApp := appleInApp("coins", "1000", time.Now())
+			inApp["app_account_token"] = "token-of-user"
+			apple.production = appleReceiptResponse("Production", inApp)
+
+			resp, err := v.PurchasesApple(context.Background(), "user", "receipt")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			n, _ := storage.CountUserPurchases(context.Background(), "user")
+			if tt.err != nil {
+				if n != 0 {
+					t.Fatalf("%d purchases stored for a mismatched user, want none", n)
+				}
+				return
+			}
+			if n != 1 || resp.ValidatedPurchases[0].AppAccountToken != "token-of-user" {
+				t.Fatalf("%d purchases stored, response %+v, want the purchase with its token", n, resp)
+			}
+		})
+	}
+}
+
+func TestPurchasesAppleSkippedTransactions(t *testing.T) {
+	now := time.Now()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	apple := newTestApple(t, v)
+	apple.production = appleReceiptResponse("Production", appleInApp("coins", "1000", now.Add(-time.Hour)))
+	if _, err := v.PurchasesApple(context.Background(), "user", "receipt"); err != nil {
+		t.Fatal(err)
+	}
+
+	// the receipt grew with a new purchase, the stored one is skipped.
+	apple.production = appleReceiptResponse("Production", appleInApp("coins", "1000", now.Add(-time.Hour)), appleInApp("gems", "1001", now))
+	resp, err := v.PurchasesApple(context.Background(), "user", "updated receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ValidatedPurchases) != 1 || resp.ValidatedPurchases[0].TransactionId != "1001" {
+		t.Fatalf("validated purchases %+v, want the new 1001 only", resp.ValidatedPurchases)
+	}
+	if len(resp.SkippedTransactions) != 1 || resp.SkippedTransactions[0] != "1000" {
+		t.Fatalf("skipped transactions %v, want the stored 1000", resp.SkippedTransactions)
+	}
+}
+
+func TestPurchasesAppleOriginalPurchaseTime(t *testing.T) {
+	installed := time.Now().AddDate(-1, 0, 0).Truncate(time.Millisecond)
+	tests := []struct {
+		name string
+		ms   string
+		want time.Time
+	}{
+		{name: "present", ms: strconv.FormatInt(installed.UnixNano()/int64(time.Millisecond), 10), want: installed},
+		{name: "absent"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+			apple := newTestApple(t, v)
+			response := appleReceiptResponse("Production", appleInApp("coins", "1000", time.Now()))
+			receipt := response["receipt"].(map[string]interface{})
+			delete(receipt, "original_purchase_date_ms")
+			if len(tt.ms) > 0 {
+				receipt["original_purchase_date_ms"] = tt.ms
+			}
+			apple.production = response
+
+			resp, err := v.PurchasesApple(context.Background(), "user", "receipt")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !resp.OriginalPurchaseTime.Equal(tt.want) {
+				t.Fatalf("original purchase time %v, want %v", resp.OriginalPurchaseTime, tt.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,45 @@
+package validate
+
+import (
+	"context"
+	"sync"
+)
+
+// ValidateBatchApple validates the Apple receipts with PurchasesApple using at most concurrency calls at a time.
+// The results are in the order of receipts, once ctx is done the receipts not started yet get ctx.Err().
+func (v *Validate) ValidateBatchApple(ctx context.Context, userID string, receipts []string, concurrency int) ([]*ValidatePurchaseResponse, []error) {
+	if concurrency < 1 {
+		concurrency = 1
+	}
+
+	responses := make([]*ValidatePurchaseResponse, len(receipts))
+	errs := make([]error, len(receipts))
+
+	sem := make(chan struct{}, concurrency)
+	var wg sync.WaitGroup
+	for i, receipt := range receipts {
+		select {
+		case <-ctx.Done():
+			errs[i] = ctx.Err()
+			continue
+		case sem <- struct{}{}:
+		}
+
+		// ctx may be done while waiting for the semaphore.
+		if err := ctx.Err(); err != nil {
+			<-sem
+			errs[i] = err
+			continue
+		}
+
+		wg.Add(1)
+		go func(i int, receipt string) {
+			defer wg.Done()
+			defer func() { <-sem }()
+			responses[i], errs[i] = v.PurchasesApple(ctx, userID, receipt)
+		}(i, receipt)
+	}
+	wg.Wait()
+
+	return responses, errs
+}
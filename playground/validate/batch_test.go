@@ -0,0 +1,65 @@
+package validate_test
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+func TestValidateBatchApple(t *testing.T) {
+	const receipts, concurrency = 50, 5
+
+	var mu sync.Mutex
+	inFlight, maxInFlight := 0, 0
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		inFlight++
+		if inFlight > maxInFlight {
+			maxInFlight = inFlight
+		}
+		mu.Unlock()
+		defer func() {
+			mu.Lock()
+			inFlight--
+			mu.Unlock()
+		}()
+		time.Sleep(time.Millisecond)
+
+		// every receipt holds the purchase whose transaction id is the receipt itself.
+		var payload struct {
+			Receipt string `json:"receipt-data"`
+		}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		_ = json.NewEncoder(w).Encode(appleReceiptResponse("Production", appleInApp("coins", payload.Receipt, time.Now())))
+	}))
+	defer srv.Close()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), HTTPClient: srv.Client(), AppleProductionUrl: srv.URL}
+
+	batch := make([]string, receipts)
+	for i := range batch {
+		batch[i] = strconv.Itoa(1000 + i)
+	}
+	responses, errs := v.ValidateBatchApple(context.Background(), "user", batch, concurrency)
+	if len(responses) != receipts || len(errs) != receipts {
+		t.Fatalf("%d responses %d errors, want %d of each", len(responses), len(errs), receipts)
+	}
+	for i, receipt := range batch {
+		if errs[i] != nil {
+			t.Fatalf("receipt %d error %v", i, errs[i])
+		}
+		if len(responses[i].ValidatedPurchases) != 1 || responses[i].ValidatedPurchases[0].TransactionId != receipt {
+			t.Fatalf("receipt %d response %+v, want the purchase of transaction %s", i, responses[i], receipt)
+		}
+	}
+	if maxInFlight > concurrency {
+		t.Fatalf("%d concurrent validations, want at most %d", maxInFlight, concurrency)
+	}
+}
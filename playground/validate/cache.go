@@ -0,0 +1,166 @@
+package validate
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+	"github.com/panuwattoa/in-app-purchase/internal/flight"
+)
+
+const (
+	DefaultResultCacheTTL        = 10 * time.Second
+	DefaultResultCacheMaxEntries = 1000
+)
+
+// ResultCache keeps the responses of successful Purchase* calls for TTL, so a receipt resubmitted by the same
+// user within it, e.g. by a client retry loop, gets the same response without a store request or Storage call.
+// Entries are keyed by a hash of the method, user and receipt and the oldest are evicted beyond MaxEntries.
+// Concurrent calls with the same key run once, the others wait and share its result, unless the ctx of that
+// call was done, then one of them validates again. A cached or shared response is returned as a copy with
+// AlreadyProcessed set and its purchases in SkippedTransactions. Errors are not cached. Safe for concurrent use, the zero value is ready to use.
+type ResultCache struct {
+	// TTL default DefaultResultCacheTTL.
+	TTL time.Duration
+	// MaxEntries default DefaultResultCacheMaxEntries.
+	MaxEntries int
+
+	mu      sync.Mutex
+	entries map[string]resultCacheEntry
+	// queue insertion order, with a single TTL also expiry order.
+	queue  []resultCacheEntry
+	flight flight.Group
+}
+
+type resultCacheEntry struct {
+	key    string
+	resp   *ValidatePurchaseResponse
+	expiry time.Time
+}
+
+func (c *ResultCache) ttl() time.Duration {
+	if c.TTL > 0 {
+		return c.TTL
+	}
+	return DefaultResultCacheTTL
+}
+
+func (c *ResultCache) maxEntries() int {
+	if c.MaxEntries > 0 {
+		return c.MaxEntries
+	}
+	return DefaultResultCacheMaxEntries
+}
+
+func (c *ResultCache) get(key string, now time.Time) *ValidatePurchaseResponse {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok || !now.Before(e.expiry) {
+		return nil
+	}
+	return e.resp
+}
+
+func (c *ResultCache) put(key string, resp *ValidatePurchaseResponse, now time.Time) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.entries == nil {
+		c.entries = make(map[string]resultCacheEntry)
+	}
+	// drop the expired entries, then the oldest until there is room.
+	for len(c.queue) > 0 {
+		oldest := c.queue[0]
+		if now.Before(oldest.expiry) && len(c.entries) < c.maxEntries() {
+			break
+		}
+		// a key put again since is queued twice, only the latest entry is in entries.
+		if e, ok := c.entries[oldest.key]; ok && e.expiry.Equal(oldest.expiry) {
+			delete(c.entries, oldest.key)
+		}
+		c.queue = c.queue[1:]
+	}
+
+	e := resultCacheEntry{key: key, resp: resp, expiry: now.Add(c.ttl())}
+	c.entries[key] = e
+	c.queue = append(c.queue, e)
+}
+
+// withResultCache returns the ResultCache response of method, userID and receipt parts or caches the one of fn.
+func (v *Validate) withResultCache(ctx context.Context, method, userID string, receipt []string, fn func() (*ValidatePurchaseResponse, error)) (*ValidatePurchaseResponse, error) {
+	if v.ResultCache == nil {
+		return fn()
+	}
+
+	log := iap.LoggerFromContext(iap.ContextWithLogger(ctx, v.logger().With("user_id", userID, "method", method)))
+	sum := sha256.Sum256([]byte(method + "\x00" + userID + "\x00" + strings.Join(receipt, "\x00")))
+	key := hex.EncodeToString(sum[:])
+	if resp := v.ResultCache.get(key, time.Now()); resp != nil {
+		log.Debug("validation result cached")
+		return alreadyProcessedResponse(resp), nil
+	}
+
+	val, err, shared := v.ResultCache.flight.Do(ctx, key, func() (interface{}, error) {
+		resp, err := fn()
+		if err != nil {
+			return nil, err
+		}
+		// a copy, the caller owns resp.
+		v.ResultCache.put(key, copyResponse(resp), time.Now())
+		return resp, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	resp, ok := val.(*ValidatePurchaseResponse)
+	if !ok || resp == nil {
+		return nil, errors.New("validation result missing")
+	}
+	if shared {
+		log.Debug("validation result shared with a concurrent call")
+		return alreadyProcessedResponse(resp), nil
+	}
+	return resp, nil
+}
+
+// alreadyProcessedResponse copy of resp for a resubmission, its purchases were processed by the first call.
+func alreadyProcessedResponse(resp *ValidatePurchaseResponse) *ValidatePurchaseResponse {
+	out := copyResponse(resp)
+	out.AlreadyProcessed = true
+	out.IsFirstPurchase = false
+	if !resp.AlreadyProcessed {
+		for _, p := range resp.ValidatedPurchases {
+			out.SkippedTransactions = append(out.SkippedTransactions, p.TransactionId)
+		}
+	}
+	return out
+}
+
+// copyResponse deep copy of resp, callers sharing a cached response can't change it for each other.
+func copyResponse(resp *ValidatePurchaseResponse) *ValidatePurchaseResponse {
+	out := *resp
+	if resp.ValidatedPurchases != nil {
+		out.ValidatedPurchases = make([]*ValidatedPurchase, 0, len(resp.ValidatedPurchases))
+		for _, p := range resp.ValidatedPurchases {
+			vp := *p
+			out.ValidatedPurchases = append(out.ValidatedPurchases, &vp)
+		}
+	}
+	if resp.RejectedPurchases != nil {
+		out.RejectedPurchases = make([]*RejectedPurchase, 0, len(resp.RejectedPurchases))
+		for _, p := range resp.RejectedPurchases {
+			rp := *p
+			out.RejectedPurchases = append(out.RejectedPurchases, &rp)
+		}
+	}
+	out.Warnings = append([]Warning(nil), resp.Warnings...)
+	out.SkippedTransactions = append([]string(nil), resp.SkippedTransactions...)
+	return &out
+}
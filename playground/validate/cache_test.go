@@ -0,0 +1,138 @@
+package validate_test
+
+import (
+	"context"
+	"errors"
+	"sync"
+	"sync/atomic"
+	"testing"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+// countingStorage counts StorePurchases calls, blocking each until release is closed when set.
+type countingStorage struct {
+	*memory.InMemoryStorage
+	calls   int32
+	release chan struct{}
+}
+
+func (s *countingStorage) StorePurchases(ctx context.Context, sp []*validate.Purchase) ([]*validate.Purchase, error) {
+	atomic.AddInt32(&s.calls, 1)
+	if s.release != nil {
+		<-s.release
+	}
+	return s.InMemoryStorage.StorePurchases(ctx, sp)
+}
+
+func newCachedValidate(storage validate.Storage) *validate.Validate {
+	return &validate.Validate{
+		Storage:     storage,
+		ResultCache: &validate.ResultCache{},
+		TestMode: map[string]*validate.ValidatedPurchase{
+			"receipt": {ProductId: "coins", TransactionId: "1000"},
+		},
+	}
+}
+
+func TestResultCacheHit(t *testing.T) {
+	storage := &countingStorage{InMemoryStorage: memory.NewInMemoryStorage()}
+	v := newCachedValidate(storage)
+
+	first, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if first.AlreadyProcessed || len(first.SkippedTransactions) > 0 {
+		t.Fatalf("first response %+v, want newly processed", first)
+	}
+
+	second, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if storage.calls != 1 {
+		t.Fatalf("StorePurchases called %d times, want 1", storage.calls)
+	}
+	if !second.AlreadyProcessed || len(second.SkippedTransactions) != 1 || second.SkippedTransactions[0] != "1000" {
+		t.Fatalf("cached response %+v, want AlreadyProcessed with transaction 1000 skipped", second)
+	}
+	if first.AlreadyProcessed || second.ValidatedPurchases[0] == first.ValidatedPurchases[0] {
+		t.Fatal("cached response shares the first response")
+	}
+
+	// not cached for another user, whose resubmission reaches Storage.
+	if _, err := v.PurchasesApple(context.Background(), "other user", "receipt"); !errors.Is(err, validate.ErrPurchaseReceiptAlreadySeen) {
+		t.Fatalf("error %v, want ErrPurchaseReceiptAlreadySeen", err)
+	}
+	if storage.calls != 2 {
+		t.Fatalf("StorePurchases called %d times for another user, want 2", storage.calls)
+	}
+}
+
+func TestResultCacheConcurrent(t *testing.T) {
+	storage := &countingStorage{InMemoryStorage: memory.NewInMemoryStorage(), release: make(chan struct{})}
+	v := newCachedValidate(storage)
+
+	const n = 8
+	var wg sync.WaitGroup
+	var processed int32
+	errs := make(chan error, n)
+	for i := 0; i < n; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			resp, err := v.PurchasesApple(context.Background(), "user", "receipt")
+			if err != nil {
+				errs <- err
+				return
+			}
+			if !resp.AlreadyProcessed {
+				atomic.AddInt32(&processed, 1)
+			}
+		}()
+	}
+	close(storage.release)
+	wg.Wait()
+	close(errs)
+	for err := range errs {
+		t.Fatal(err)
+	}
+
+	if storage.calls != 1 {
+		t.Fatalf("StorePurchases called %d times, want 1", storage.calls)
+	}
+	if processed != 1 {
+		t.Fatalf("%d responses not AlreadyProcessed, want 1", processed)
+	}
+}
+
+func TestResultCacheCopies(t *testing.T) {
+	v := newCachedValidate(memory.NewInMemoryStorage())
+
+	first, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the caller owns its response, changing it leaves the cached one as is.
+	first.ValidatedPurchases[0].ProductId = "changed"
+
+	second, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if second.ValidatedPurchases[0].ProductId != "coins" {
+		t.Fatalf("cached product %q, want coins", second.ValidatedPurchases[0].ProductId)
+	}
+	second.ValidatedPurchases[0].ProductId = "changed"
+	second.SkippedTransactions[0] = "changed"
+
+	third, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if third.ValidatedPurchases[0].ProductId != "coins" || third.SkippedTransactions[0] != "1000" {
+		t.Fatalf("cached response %+v, want it unchanged by the previous callers", third)
+	}
+}
@@ -0,0 +1,116 @@
+package validate
+
+import (
+	"context"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+)
+
+// SubscriptionStatus of a subscription receipt, returned by the Check* methods.
+type SubscriptionStatus struct {
+	ProductId     string
+	TransactionId string
+	Store         Store
+	Environment   Environment
+	ExpiresTime   time.Time
+	// EffectiveExpiresTime ExpiresTime extended by a billing grace period.
+	EffectiveExpiresTime time.Time
+	AutoRenew            bool
+	// PaymentState Google only, see SubscriptionPurchase.PaymentState.
+	PaymentState       int
+	CancellationReason CancellationReason
+	// IsActive at the time of the check, see ValidatedPurchase.IsActive.
+	IsActive bool
+}
+
+// CheckSubscriptionGoogle validates a Google subscription receipt and returns its status, nothing is stored or acknowledged.
+func (v *Validate) CheckSubscriptionGoogle(ctx context.Context, receipt string) (*SubscriptionStatus, error) {
+	return v.withCheckPipeline(ctx, GOOGLE_PLAY_STORE, func(ctx context.Context) (*SubscriptionStatus, error) {
+		return v.checkSubscriptionGoogle(ctx, receipt)
+	})
+}
+
+func (v *Validate) checkSubscriptionGoogle(ctx context.Context, receipt string) (*SubscriptionStatus, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("store", GOOGLE_PLAY_STORE))
+
+	if err := v.checkReceiptSize(receipt); err != nil {
+		return nil, err
+	}
+
+	purchases, _, _, raw, err := v.validateSubscriptionGoogle(ctx, "", receipt)
+	if err != nil {
+		return nil, err
+	}
+	return newSubscriptionStatus(latestExpiring(purchases), raw), nil
+}
+
+// CheckSubscriptionApple validates an Apple subscription receipt and returns the status of the entry expiring last,
+// nothing is stored.
+func (v *Validate) CheckSubscriptionApple(ctx context.Context, receipt string) (*SubscriptionStatus, error) {
+	return v.withCheckPipeline(ctx, APPLE_APP_STORE, func(ctx context.Context) (*SubscriptionStatus, error) {
+		return v.checkSubscriptionApple(ctx, receipt)
+	})
+}
+
+func (v *Validate) checkSubscriptionApple(ctx context.Context, receipt string) (*SubscriptionStatus, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("store", APPLE_APP_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	if err := v.checkReceiptSize(receipt); err != nil {
+		return nil, err
+	}
+
+	purchases, _, raw, err := v.validateSubscriptionApple(ctx, log, "", receipt, "")
+	if err != nil {
+		return nil, err
+	}
+
+	latest := latestExpiring(purchases)
+	if latest == nil {
+		return nil, ErrFailedPrecondition
+	}
+	return newSubscriptionStatus(latest, raw), nil
+}
+
+// withCheckPipeline runs a Check* call under the store timeout and PipelineRetry, like the Purchase* methods.
+func (v *Validate) withCheckPipeline(ctx context.Context, store Store, fn func(ctx context.Context) (*SubscriptionStatus, error)) (*SubscriptionStatus, error) {
+	var status *SubscriptionStatus
+	_, err := v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+		return v.withStoreTimeout(ctx, store, func(ctx context.Context) (*ValidatePurchaseResponse, error) {
+			var err error
+			status, err = fn(ctx)
+			return nil, err
+		})
+	})
+	if err != nil {
+		return nil, err
+	}
+	return status, nil
+}
+
+func latestExpiring(purchases []*SubscriptionPurchase) *SubscriptionPurchase {
+	var latest *SubscriptionPurchase
+	for _, p := range purchases {
+		if latest == nil || p.ExpiresTime.After(latest.ExpiresTime) {
+			latest = p
+		}
+	}
+	return latest
+}
+
+func newSubscriptionStatus(p *SubscriptionPurchase, raw []byte) *SubscriptionStatus {
+	vp := newValidatedSubscriptionPurchase(p, raw)
+	return &SubscriptionStatus{
+		ProductId:            p.productId,
+		TransactionId:        p.transactionId,
+		Store:                p.store,
+		Environment:          p.environment,
+		ExpiresTime:          p.ExpiresTime,
+		EffectiveExpiresTime: p.EffectiveExpiresTime,
+		AutoRenew:            p.AutoRenew,
+		PaymentState:         p.PaymentState,
+		CancellationReason:   p.cancellationReason,
+		IsActive:             vp.IsActive(time.Now()),
+	}
+}
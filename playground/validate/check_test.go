@@ -0,0 +1,126 @@
+package validate_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+)
+
+// untouchedStorage fails the test on any Storage call.
+type untouchedStorage struct {
+	t *testing.T
+}
+
+func (s untouchedStorage) StorePurchases(ctx context.Context, sp []*validate.Purchase) ([]*validate.Purchase, error) {
+	s.t.Error("StorePurchases called")
+	return sp, nil
+}
+
+func (s untouchedStorage) StoreSubscriptionPurchases(ctx context.Context, sp []*validate.SubscriptionPurchase) ([]*validate.SubscriptionPurchase, error) {
+	s.t.Error("StoreSubscriptionPurchases called")
+	return sp, nil
+}
+
+func (s untouchedStorage) SeenTransaction(ctx context.Context, store validate.Store, transactionID string) (bool, error) {
+	s.t.Error("SeenTransaction called")
+	return true, nil
+}
+
+func TestCheckSubscriptionGoogle(t *testing.T) {
+	g := newTestGoogle(t)
+	expires := time.Now().Add(time.Hour).Truncate(time.Millisecond)
+	g.handleJSON("/androidpublisher/v3/applications/com.example.app/purchases/subscriptions/monthly/tokens/token-1", map[string]interface{}{
+		"orderId":              "GPA.1234-5678",
+		"autoRenewing":         true,
+		"paymentState":         1,
+		"acknowledgementState": 0,
+		"expiryTimeMillis":     strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10),
+	})
+	g.mux.HandleFunc("/androidpublisher/v3/applications/com.example.app/purchases/subscriptions/monthly/tokens/token-1:acknowledge", func(w http.ResponseWriter, r *http.Request) {
+		t.Error("checked subscription acknowledged")
+	})
+	v := &validate.Validate{Storage: untouchedStorage{t}, AutoAcknowledge: true}
+	g.install(v)
+
+	// a status check can be repeated, nothing is stored.
+	for i := 0; i < 2; i++ {
+		status, err := v.CheckSubscriptionGoogle(context.Background(), googleReceipt(t, "monthly", "token-1", "GPA.1234-5678"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.ProductId != "monthly" || !status.ExpiresTime.Equal(expires) || !status.AutoRenew || status.PaymentState != 1 || !status.IsActive {
+			t.Fatalf("status %+v, want the active monthly subscription", status)
+		}
+	}
+}
+
+func TestCheckSubscriptionApple(t *testing.T) {
+	now := time.Now()
+	v := &validate.Validate{Storage: untouchedStorage{t}}
+	apple := newTestApple(t, v)
+	apple.production = appleReceiptResponse("Production",
+		appleRenewal("monthly", "1000", "1000", now.AddDate(0, -1, 0), now),
+		appleRenewal("monthly", "1001", "1000", now, now.AddDate(0, 1, 0)))
+
+	for i := 0; i < 2; i++ {
+		status, err := v.CheckSubscriptionApple(context.Background(), "receipt")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if status.TransactionId != "1001" || status.Store != validate.APPLE_APP_STORE || !status.IsActive {
+			t.Fatalf("status %+v, want the active renewal 1001", status)
+		}
+	}
+}
+
+func TestCheckSubscriptionGoogleTimeout(t *testing.T) {
+	g := newTestGoogle(t)
+	release := make(chan struct{})
+	defer close(release)
+	g.mux.HandleFunc("/androidpublisher/v3/applications/com.example.app/purchases/subscriptions/monthly/tokens/token-1", func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	v := &validate.Validate{Storage: untouchedStorage{t}, GoogleTimeout: 50 * time.Millisecond}
+	g.install(v)
+
+	_, err := v.CheckSubscriptionGoogle(context.Background(), googleReceipt(t, "monthly", "token-1", "GPA.1234-5678"))
+	if !errors.Is(err, validate.ErrGoogleTimeout) {
+		t.Fatalf("error %v, want ErrGoogleTimeout", err)
+	}
+}
+
+func TestCheckSubscriptionGooglePipelineRetry(t *testing.T) {
+	g := newTestGoogle(t)
+	var calls int32
+	expires := time.Now().Add(time.Hour)
+	g.mux.HandleFunc("/androidpublisher/v3/applications/com.example.app/purchases/subscriptions/monthly/tokens/token-1", func(w http.ResponseWriter, r *http.Request) {
+		if atomic.AddInt32(&calls, 1) == 1 {
+			w.WriteHeader(http.StatusServiceUnavailable)
+			return
+		}
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{
+			"orderId":          "GPA.1234-5678",
+			"expiryTimeMillis": strconv.FormatInt(expires.UnixNano()/int64(time.Millisecond), 10),
+		})
+	})
+	v := &validate.Validate{Storage: untouchedStorage{t}, PipelineRetry: &validate.RetryPolicy{Attempts: 2, Backoff: time.Millisecond}}
+	g.install(v)
+
+	status, err := v.CheckSubscriptionGoogle(context.Background(), googleReceipt(t, "monthly", "token-1", "GPA.1234-5678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !status.IsActive || atomic.LoadInt32(&calls) != 2 {
+		t.Fatalf("status %+v after %d calls, want the active subscription after a retry", status, calls)
+	}
+}
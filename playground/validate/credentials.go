@@ -0,0 +1,158 @@
+package validate
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io/ioutil"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+)
+
+var (
+	ErrCredentialsNotConfigured = errors.New("no credentials configured for receipt")
+	ErrBundleNotConfigured      = errors.New("no Apple shared secret configured for bundle")
+)
+
+// Credentials bundles the provider credentials used by Validate,
+// the right set is selected per receipt.
+type Credentials struct {
+	// Apple default, used when ResolveAppleSecret is not set.
+	Apple AppleCredentials
+	// ResolveAppleSecret optional, selects the shared secret by the bundle_id read from the receipt
+	// for backends serving several apps.
+	ResolveAppleSecret func(bundleID string) (string, bool)
+	// Google default, used for any package not in GooglePackages.
+	Google IAPGoogleConfig
+	// GooglePackages optional, per package name service accounts.
+	GooglePackages map[string]IAPGoogleConfig
+	Amazon         AmazonCredentials
+	Huawei         HuaweiCredentials
+}
+
+type HuaweiCredentials struct {
+	// ClientID and ClientSecret OAuth 2.0 credentials of the app from AppGallery Connect.
+	ClientID     string
+	ClientSecret string
+	// PublicKey optional, IAP public key of the app, when set purchase data signatures are verified.
+	PublicKey string
+	// OrderUrl optional, the Order service site of the app, default iap.HuaweiOrderUrl.
+	OrderUrl string
+}
+
+type AmazonCredentials struct {
+	// DeveloperSecret shared secret of the Amazon developer account.
+	DeveloperSecret string
+	// Sandbox validate with the RVS sandbox, for App Tester builds.
+	Sandbox bool
+}
+
+type AppleCredentials struct {
+	// Password app shared secret, optional for non subscription receipts.
+	Password string
+	// BundleID optional, signed transactions and server notifications of other apps are rejected.
+	// With ResolveAppleSecret the bundles it resolves are accepted too.
+	BundleID string
+	// IssuerID, KeyID and PrivateKey (PEM .p8) of the App Store Connect in-app purchase key,
+	// needed for signed StoreKit 2 transactions.
+	IssuerID   string
+	KeyID      string
+	PrivateKey string
+}
+
+// NewGoogleConfigFromJSON reads client_email, private_key and project_id of a Google service account JSON key.
+func NewGoogleConfigFromJSON(data []byte) (IAPGoogleConfig, error) {
+	var key struct {
+		Type string `json:"type"`
+		IAPGoogleConfig
+	}
+	if err := json.Unmarshal(data, &key); err != nil {
+		return IAPGoogleConfig{}, err
+	}
+
+	if len(key.Type) > 0 && key.Type != "service_account" {
+		return IAPGoogleConfig{}, errors.New("'type' is not service_account")
+	}
+	if len(key.ClientEmail) < 1 {
+		return IAPGoogleConfig{}, errors.New("'client_email' is empty")
+	}
+	if len(key.PrivateKey) < 1 {
+		return IAPGoogleConfig{}, errors.New("'private_key' is empty")
+	}
+	return key.IAPGoogleConfig, nil
+}
+
+// NewGoogleConfigFromFile NewGoogleConfigFromJSON reading the key file at path.
+func NewGoogleConfigFromFile(path string) (IAPGoogleConfig, error) {
+	data, err := ioutil.ReadFile(path)
+	if err != nil {
+		return IAPGoogleConfig{}, err
+	}
+	return NewGoogleConfigFromJSON(data)
+}
+
+// credentials Validate.Credentials with the deprecated ApplePassword and GoogleConfig filled in.
+func (v *Validate) credentials() *Credentials {
+	c := v.Credentials
+	if len(c.Apple.Password) < 1 {
+		c.Apple.Password = v.ApplePassword
+	}
+	if len(c.Google.ClientEmail) < 1 {
+		c.Google = v.GoogleConfig
+	}
+	return &c
+}
+
+// ResolveApple returns the shared secret for the app the receipt belongs to.
+func (c *Credentials) ResolveApple(receipt string) (string, error) {
+	if c.ResolveAppleSecret == nil {
+		return c.Apple.Password, nil
+	}
+
+	lr, err := iap.ParseAppleReceiptLocal(receipt)
+	if err != nil {
+		return "", fmt.Errorf("%w: %v", ErrFailedPrecondition, err)
+	}
+
+	secret, ok := c.ResolveAppleSecret(lr.BundleID)
+	if !ok {
+		return "", ErrBundleNotConfigured
+	}
+	return secret, nil
+}
+
+// appleBundleAllowed bundleID is Apple.BundleID or resolved by ResolveAppleSecret, any bundle when neither is set.
+func (c *Credentials) appleBundleAllowed(bundleID string) bool {
+	if len(c.Apple.BundleID) < 1 && c.ResolveAppleSecret == nil {
+		return true
+	}
+	if len(c.Apple.BundleID) > 0 && bundleID == c.Apple.BundleID {
+		return true
+	}
+	if c.ResolveAppleSecret != nil {
+		_, ok := c.ResolveAppleSecret(bundleID)
+		return ok
+	}
+	return false
+}
+
+// ResolveGoogle returns the service account for packageName, falling back to Google.
+func (c *Credentials) ResolveGoogle(packageName string) (IAPGoogleConfig, error) {
+	if gc, ok := c.GooglePackages[packageName]; ok {
+		return gc, nil
+	}
+
+	if len(c.Google.ClientEmail) < 1 {
+		return IAPGoogleConfig{}, ErrCredentialsNotConfigured
+	}
+	return c.Google, nil
+}
+
+// resolveGoogleReceipt selects the service account by the package name in the receipt.
+func (c *Credentials) resolveGoogleReceipt(receipt string) (IAPGoogleConfig, error) {
+	gr, err := iap.DecodeReceiptGoogle(receipt)
+	if err != nil {
+		return IAPGoogleConfig{}, err
+	}
+	return c.ResolveGoogle(gr.PackageName)
+}
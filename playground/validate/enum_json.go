@@ -0,0 +1,96 @@
+package validate
+
+import (
+	"encoding/json"
+	"fmt"
+	"strconv"
+)
+
+var storeNames = map[Store]string{
+	APPLE_APP_STORE:    "APPLE_APP_STORE",
+	GOOGLE_PLAY_STORE:  "GOOGLE_PLAY_STORE",
+	MICROSOFT_STORE:    "MICROSOFT_STORE",
+	AMAZON_APP_STORE:   "AMAZON_APP_STORE",
+	HUAWEI_APP_GALLERY: "HUAWEI_APP_GALLERY",
+}
+
+var environmentNames = map[Environment]string{
+	UNKNOWN:    "UNKNOWN",
+	SANDBOX:    "SANDBOX",
+	PRODUCTION: "PRODUCTION",
+}
+
+func (s Store) String() string {
+	if name, ok := storeNames[s]; ok {
+		return name
+	}
+	return strconv.Itoa(int(s))
+}
+
+// MarshalJSON the store name, e.g. "GOOGLE_PLAY_STORE".
+func (s Store) MarshalJSON() ([]byte, error) {
+	return json.Marshal(s.String())
+}
+
+// UnmarshalJSON accepts the store name or its number.
+func (s *Store) UnmarshalJSON(b []byte) error {
+	v, err := unmarshalEnum(b, func(name string) (int32, bool) {
+		for store, n := range storeNames {
+			if n == name {
+				return int32(store), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("store: %v", err)
+	}
+	*s = Store(v)
+	return nil
+}
+
+func (e Environment) String() string {
+	if name, ok := environmentNames[e]; ok {
+		return name
+	}
+	return strconv.Itoa(int(e))
+}
+
+// MarshalJSON the environment name, e.g. "SANDBOX".
+func (e Environment) MarshalJSON() ([]byte, error) {
+	return json.Marshal(e.String())
+}
+
+// UnmarshalJSON accepts the environment name or its number.
+func (e *Environment) UnmarshalJSON(b []byte) error {
+	v, err := unmarshalEnum(b, func(name string) (int32, bool) {
+		for env, n := range environmentNames {
+			if n == name {
+				return int32(env), true
+			}
+		}
+		return 0, false
+	})
+	if err != nil {
+		return fmt.Errorf("environment: %v", err)
+	}
+	*e = Environment(v)
+	return nil
+}
+
+func unmarshalEnum(b []byte, byName func(name string) (int32, bool)) (int32, error) {
+	var name string
+	if err := json.Unmarshal(b, &name); err == nil {
+		v, ok := byName(name)
+		if !ok {
+			return 0, fmt.Errorf("unknown value %q", name)
+		}
+		return v, nil
+	}
+
+	var v int32
+	if err := json.Unmarshal(b, &v); err != nil {
+		return 0, err
+	}
+	return v, nil
+}
@@ -0,0 +1,40 @@
+package validate_test
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+)
+
+func TestEnumJSON(t *testing.T) {
+	for _, store := range []validate.Store{validate.APPLE_APP_STORE, validate.GOOGLE_PLAY_STORE, validate.MICROSOFT_STORE, validate.AMAZON_APP_STORE, validate.HUAWEI_APP_GALLERY} {
+		for _, env := range []validate.Environment{validate.UNKNOWN, validate.SANDBOX, validate.PRODUCTION} {
+			b, err := json.Marshal(&validate.ValidatedPurchase{Store: store, Environment: env})
+			if err != nil {
+				t.Fatal(err)
+			}
+			// the zero values APPLE_APP_STORE and UNKNOWN must not be omitted.
+			if !strings.Contains(string(b), `"store":"`+store.String()+`"`) || !strings.Contains(string(b), `"environment":"`+env.String()+`"`) {
+				t.Fatalf("%s missing store %s or environment %s", b, store, env)
+			}
+
+			var vp validate.ValidatedPurchase
+			if err := json.Unmarshal(b, &vp); err != nil {
+				t.Fatal(err)
+			}
+			if vp.Store != store || vp.Environment != env {
+				t.Fatalf("round trip %v %v, want %v %v", vp.Store, vp.Environment, store, env)
+			}
+		}
+	}
+
+	var vp validate.ValidatedPurchase
+	if err := json.Unmarshal([]byte(`{"store":1,"environment":2}`), &vp); err != nil {
+		t.Fatal(err)
+	}
+	if vp.Store != validate.GOOGLE_PLAY_STORE || vp.Environment != validate.PRODUCTION {
+		t.Fatalf("numbers decoded as %v %v", vp.Store, vp.Environment)
+	}
+}
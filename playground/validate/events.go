@@ -0,0 +1,106 @@
+package validate
+
+import (
+	"context"
+	"time"
+)
+
+// Kind of domain event emitted for a validated purchase.
+type EventType int32
+
+const (
+	// New purchase, or first period of a subscription.
+	EVENT_PURCHASE_VALIDATED EventType = 1
+	// Subscription purchase from a renewal.
+	EVENT_SUBSCRIPTION_RENEWED EventType = 2
+	// Purchase refunded or canceled by the store.
+	EVENT_PURCHASE_REFUNDED EventType = 3
+	// Subscription with its effective expiry in the past.
+	EVENT_SUBSCRIPTION_EXPIRED EventType = 4
+)
+
+// PurchaseEvent store agnostic view of a validated purchase, built by ToEvents or given to EventSink.
+type PurchaseEvent struct {
+	Type EventType
+	// UserID empty when built by ToEvents, the response doesn't carry it.
+	UserID                string
+	Store                 Store
+	Environment           Environment
+	ProductID             string
+	TransactionID         string
+	OriginalTransactionID string
+	PurchaseTime          time.Time
+	// ExpiresTime effective expiry of a subscription, grace period included, zero for other products.
+	ExpiresTime time.Time
+	// Refunded the store refunded or revoked the purchase, entitlements should be removed.
+	Refunded bool
+	Purchase *ValidatedPurchase
+}
+
+// ToEvents converts the validated purchases of resp to events, in the same order.
+func ToEvents(resp *ValidatePurchaseResponse) []PurchaseEvent {
+	if resp == nil {
+		return nil
+	}
+
+	now := time.Now()
+	events := make([]PurchaseEvent, 0, len(resp.ValidatedPurchases))
+	for _, p := range resp.ValidatedPurchases {
+		events = append(events, newPurchaseEvent("", p, now))
+	}
+	return events
+}
+
+func newPurchaseEvent(userID string, p *ValidatedPurchase, now time.Time) PurchaseEvent {
+	e := PurchaseEvent{
+		Type:                  purchaseEventType(p, now),
+		UserID:                userID,
+		Store:                 p.Store,
+		Environment:           p.Environment,
+		ProductID:             p.ProductId,
+		TransactionID:         p.TransactionId,
+		OriginalTransactionID: p.OriginalTransactionId,
+		Refunded:              p.CancellationReason == CANCELLATION_REASON_REFUNDED,
+		Purchase:              p,
+	}
+	if p.PurchaseTime > 0 {
+		e.PurchaseTime = time.Unix(p.PurchaseTime, 0)
+	}
+	expires := p.EffectiveExpiresTime
+	if expires < 1 {
+		expires = p.ExpiresTime
+	}
+	if expires > 0 {
+		e.ExpiresTime = time.Unix(expires, 0)
+	}
+	return e
+}
+
+// EventSink optional, receives an event per newly stored purchase after a successful validation.
+type EventSink interface {
+	Emit(ctx context.Context, e PurchaseEvent)
+}
+
+func (v *Validate) emitEvents(ctx context.Context, userID string, purchases []*ValidatedPurchase) {
+	if v.EventSink == nil {
+		return
+	}
+
+	now := time.Now()
+	for _, p := range purchases {
+		v.EventSink.Emit(ctx, newPurchaseEvent(userID, p, now))
+	}
+}
+
+func purchaseEventType(p *ValidatedPurchase, now time.Time) EventType {
+	switch {
+	case p.CancellationReason == CANCELLATION_REASON_REFUNDED:
+		return EVENT_PURCHASE_REFUNDED
+	case p.EffectiveExpiresTime > 0 && p.EffectiveExpiresTime < now.Unix():
+		return EVENT_SUBSCRIPTION_EXPIRED
+	case p.RenewalCount > 0:
+		return EVENT_SUBSCRIPTION_RENEWED
+	default:
+		return EVENT_PURCHASE_VALIDATED
+	}
+}
@@ -0,0 +1,75 @@
+package validate_test
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+func TestToEventsAppleSubscription(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	apple := newTestApple(t, v)
+	apple.production = appleReceiptResponse("Production",
+		appleRenewal("monthly", "1000", "1000", now.AddDate(0, -2, 0), now.AddDate(0, -1, 0)),
+		appleRenewal("monthly", "1001", "1000", now.AddDate(0, -1, 0), now.AddDate(0, 1, 0)))
+
+	resp, err := v.PurchasesSubscriptionAppleWithSecret(context.Background(), "user", "receipt", "secret")
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := validate.ToEvents(resp)
+	if len(events) != len(resp.ValidatedPurchases) {
+		t.Fatalf("%d events for %d purchases", len(events), len(resp.ValidatedPurchases))
+	}
+
+	want := map[string]struct {
+		typ     validate.EventType
+		expires time.Time
+	}{
+		"1000": {typ: validate.EVENT_SUBSCRIPTION_EXPIRED, expires: now.AddDate(0, -1, 0)},
+		"1001": {typ: validate.EVENT_SUBSCRIPTION_RENEWED, expires: now.AddDate(0, 1, 0)},
+	}
+	for i, e := range events {
+		w, ok := want[e.TransactionID]
+		if !ok || e.Purchase != resp.ValidatedPurchases[i] {
+			t.Fatalf("event %+v, want the one of purchase %d", e, i)
+		}
+		delete(want, e.TransactionID)
+		if e.Type != w.typ || !e.ExpiresTime.Equal(w.expires) {
+			t.Fatalf("event %s type %d expires %v, want %d expiring %v", e.TransactionID, e.Type, e.ExpiresTime, w.typ, w.expires)
+		}
+		if e.Store != validate.APPLE_APP_STORE || e.OriginalTransactionID != "1000" || e.ProductID != "monthly" || len(e.UserID) > 0 {
+			t.Fatalf("event %+v, want the monthly Apple subscription without a user", e)
+		}
+	}
+	if len(want) > 0 {
+		t.Fatalf("no events for %v", want)
+	}
+}
+
+func TestToEventsGoogleProduct(t *testing.T) {
+	g := newTestGoogle(t)
+	g.handleJSON(googleProductPath, map[string]interface{}{"orderId": "GPA.1234-5678", "purchaseState": 0, "acknowledgementState": 1})
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	g.install(v)
+
+	resp, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	events := validate.ToEvents(resp)
+	if len(events) != 1 {
+		t.Fatalf("%d events, want 1", len(events))
+	}
+	e := events[0]
+	if e.Type != validate.EVENT_PURCHASE_VALIDATED || e.Store != validate.GOOGLE_PLAY_STORE || e.ProductID != "coins" || e.TransactionID != "GPA.1234-5678" {
+		t.Fatalf("event %+v, want the validated coins purchase", e)
+	}
+	if !e.ExpiresTime.IsZero() || e.Refunded || e.PurchaseTime.IsZero() {
+		t.Fatalf("event %+v, want a purchase time without expiry", e)
+	}
+}
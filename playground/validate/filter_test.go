@@ -0,0 +1,82 @@
+package validate_test
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+func TestPurchaseFilterVetoesEverything(t *testing.T) {
+	vetoed := errors.New("vetoed")
+	v := &validate.Validate{
+		Storage: memory.NewInMemoryStorage(),
+		PurchaseFilter: func(ctx context.Context, p *validate.Purchase) error {
+			return vetoed
+		},
+	}
+	apple := newTestApple(t, v)
+	apple.sandbox = appleReceiptResponse(iap.AppleSandboxEnv, appleInApp("coins", "1000", time.Now()))
+
+	resp, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ValidatedPurchases) > 0 || len(resp.RejectedPurchases) != 1 || !errors.Is(resp.RejectedPurchases[0].Err, vetoed) {
+		t.Fatalf("response %+v, want the purchase rejected by the filter", resp)
+	}
+	if !resp.UsedSandboxFallback || resp.OriginalPurchaseTime.IsZero() {
+		t.Fatalf("response %+v, want the receipt level fields", resp)
+	}
+}
+
+func TestMinPurchaseTime(t *testing.T) {
+	cutoff := time.Now().Add(-time.Hour).Truncate(time.Second)
+	tests := []struct {
+		name     string
+		inApps   []map[string]string
+		err      error
+		accepted int
+	}{
+		{
+			name:     "at the cutoff",
+			inApps:   []map[string]string{appleInApp("coins", "1000", cutoff)},
+			accepted: 1,
+		},
+		{
+			name:   "just before the cutoff",
+			inApps: []map[string]string{appleInApp("coins", "1000", cutoff.Add(-time.Millisecond))},
+			err:    validate.ErrPurchaseTooOld,
+		},
+		{
+			name: "some too old",
+			inApps: []map[string]string{
+				appleInApp("coins", "1000", cutoff.Add(-time.Hour)),
+				appleInApp("coins", "1001", cutoff.Add(time.Minute)),
+			},
+			accepted: 1,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := &validate.Validate{Storage: memory.NewInMemoryStorage(), MinPurchaseTime: cutoff}
+			apple := newTestApple(t, v)
+			apple.production = appleReceiptResponse(iap.AppleProductionEnv, tt.inApps...)
+
+			resp, err := v.PurchasesApple(context.Background(), "user", "receipt")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			if err != nil {
+				return
+			}
+			if len(resp.ValidatedPurchases) != tt.accepted || len(resp.RejectedPurchases) != len(tt.inApps)-tt.accepted {
+				t.Fatalf("response %+v, want %d accepted", resp, tt.accepted)
+			}
+		})
+	}
+}
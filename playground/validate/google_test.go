@@ -0,0 +1,312 @@
+package validate_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"strconv"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+const googleProductPath = "/androidpublisher/v3/applications/com.example.app/purchases/products/coins/tokens/token-1"
+
+func TestPurchaseGoogleTransactionId(t *testing.T) {
+	g := newTestGoogle(t)
+	g.handleJSON(googleProductPath, map[string]interface{}{
+		"orderId":              "GPA.1234-5678",
+		"purchaseState":        0,
+		"acknowledgementState": 1,
+	})
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	g.install(v)
+
+	// the receipt orderId is not verified, the one Google returns is used.
+	resp, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.forged"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ValidatedPurchases) != 1 {
+		t.Fatalf("%d validated purchases, want 1", len(resp.ValidatedPurchases))
+	}
+	p := resp.ValidatedPurchases[0]
+	if p.TransactionId != "GPA.1234-5678" {
+		t.Fatalf("TransactionId %q, want the Google orderId", p.TransactionId)
+	}
+	if p.PurchaseToken != "token-1" {
+		t.Fatalf("PurchaseToken %q, want token-1", p.PurchaseToken)
+	}
+}
+
+// legacyStorage has the purchases stored before the orderId was used, keyed by purchase token.
+type legacyStorage struct {
+	*memory.InMemoryStorage
+	tokens map[string]bool
+}
+
+func (s *legacyStorage) SeenTransaction(ctx context.Context, store validate.Store, transactionID string) (bool, error) {
+	return s.tokens[transactionID], nil
+}
+
+func TestPurchaseGoogleLegacyTransactionId(t *testing.T) {
+	g := newTestGoogle(t)
+	g.mux.HandleFunc(googleProductPath, func(w http.ResponseWriter, r *http.Request) {
+		t.Error("purchase stored under its token validated again")
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	v := &validate.Validate{Storage: &legacyStorage{InMemoryStorage: memory.NewInMemoryStorage(), tokens: map[string]bool{"token-1": true}}}
+	g.install(v)
+
+	_, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678"))
+	if !errors.Is(err, validate.ErrPurchaseReceiptAlreadySeen) {
+		t.Fatalf("error %v, want ErrPurchaseReceiptAlreadySeen", err)
+	}
+}
+
+// failingStorage fails StorePurchases.
+type failingStorage struct {
+	*memory.InMemoryStorage
+}
+
+func (s failingStorage) StorePurchases(ctx context.Context, sp []*validate.Purchase) ([]*validate.Purchase, error) {
+	return nil, errors.New("store failed")
+}
+
+func TestPurchaseGoogleAutoAcknowledge(t *testing.T) {
+	tests := []struct {
+		name    string
+		storage validate.Storage
+		filter  func(ctx context.Context, p *validate.Purchase) error
+		stored  bool
+	}{
+		{name: "stored", storage: memory.NewInMemoryStorage(), stored: true},
+		{name: "store failed", storage: failingStorage{memory.NewInMemoryStorage()}},
+		{name: "filtered", storage: memory.NewInMemoryStorage(), filter: func(ctx context.Context, p *validate.Purchase) error {
+			return errors.New("vetoed")
+		}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoogle(t)
+			g.handleJSON(googleProductPath, map[string]interface{}{
+				"orderId":              "GPA.1234-5678",
+				"purchaseState":        0,
+				"acknowledgementState": 0,
+			})
+			acknowledged := 0
+			g.mux.HandleFunc(googleProductPath+":acknowledge", func(w http.ResponseWriter, r *http.Request) {
+				acknowledged++
+			})
+			v := &validate.Validate{Storage: tt.storage, AutoAcknowledge: true, PurchaseFilter: tt.filter}
+			g.install(v)
+
+			resp, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678"))
+			if tt.stored {
+				if err != nil {
+					t.Fatal(err)
+				}
+				if acknowledged != 1 || resp.ValidatedPurchases[0].AcknowledgementState != 1 || len(resp.Warnings) > 0 {
+					t.Fatalf("acknowledged %d times, response %+v, want acknowledged once without warning", acknowledged, resp)
+				}
+				return
+			}
+			if acknowledged > 0 {
+				t.Fatal("purchase acknowledged without being stored")
+			}
+		})
+	}
+}
+
+func TestPurchaseSubscriptionGoogleGracePeriod(t *testing.T) {
+	tests := []struct {
+		name    string
+		orderID string
+		grace   bool
+	}{
+		{name: "renewal payment pending", orderID: "GPA.1234-5678..0", grace: true},
+		{name: "first payment pending", orderID: "GPA.1234-5678"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoogle(t)
+			g.handleJSON("/androidpublisher/v3/applications/com.example.app/purchases/subscriptions/monthly/tokens/token-1", map[string]interface{}{
+				"orderId":              tt.orderID,
+				"autoRenewing":         true,
+				"paymentState":         0,
+				"acknowledgementState": 1,
+				"expiryTimeMillis":     strconv.FormatInt(time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond), 10),
+			})
+			v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+			g.install(v)
+
+			resp, err := v.PurchaseSubscriptionGoogle(context.Background(), "user", googleReceipt(t, "monthly", "token-1", tt.orderID))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if grace := resp.ValidatedPurchases[0].GracePeriodExpiresTime > 0; grace != tt.grace {
+				t.Fatalf("in grace period %v, want %v", grace, tt.grace)
+			}
+		})
+	}
+}
+
+func TestPurchaseSubscriptionGoogleCancelReason(t *testing.T) {
+	tests := []struct {
+		name         string
+		cancelReason int
+		want         validate.CancellationReason
+	}{
+		{name: "user canceled", cancelReason: 0, want: validate.CANCELLATION_REASON_USER_CANCELED},
+		{name: "billing error", cancelReason: 1, want: validate.CANCELLATION_REASON_BILLING_ERROR},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoogle(t)
+			g.handleJSON("/androidpublisher/v3/applications/com.example.app/purchases/subscriptions/monthly/tokens/token-1", map[string]interface{}{
+				"orderId":              "GPA.1234-5678",
+				"cancelReason":         tt.cancelReason,
+				"acknowledgementState": 1,
+				"expiryTimeMillis":     strconv.FormatInt(time.Now().Add(time.Hour).UnixNano()/int64(time.Millisecond), 10),
+			})
+			v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+			g.install(v)
+
+			resp, err := v.PurchaseSubscriptionGoogle(context.Background(), "user", googleReceipt(t, "monthly", "token-1", "GPA.1234-5678"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			p := resp.ValidatedPurchases[0]
+			if p.CancellationReason != tt.want || p.CancelReason != tt.cancelReason {
+				t.Fatalf("cancellation reason %v (cancel reason %d), want %v", p.CancellationReason, p.CancelReason, tt.want)
+			}
+		})
+	}
+}
+
+func TestPurchaseGoogleEnvironment(t *testing.T) {
+	tests := []struct {
+		name         string
+		purchaseType interface{}
+		env          validate.Environment
+	}{
+		{name: "standard", env: validate.PRODUCTION},
+		{name: "license tester", purchaseType: 0, env: validate.SANDBOX},
+		{name: "promo", purchaseType: 1, env: validate.PRODUCTION},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			body := map[string]interface{}{"orderId": "GPA.1234-5678", "purchaseState": 0, "acknowledgementState": 1}
+			if tt.purchaseType != nil {
+				body["purchaseType"] = tt.purchaseType
+			}
+			g := newTestGoogle(t)
+			g.handleJSON(googleProductPath, body)
+			v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+			g.install(v)
+
+			resp, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678"))
+			if err != nil {
+				t.Fatal(err)
+			}
+			if env := resp.ValidatedPurchases[0].Environment; env != tt.env {
+				t.Fatalf("environment %v, want %v", env, tt.env)
+			}
+		})
+	}
+}
+
+func TestPurchaseGoogleResubmitted(t *testing.T) {
+	g := newTestGoogle(t)
+	var validations int32
+	g.mux.HandleFunc(googleProductPath, func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&validations, 1)
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"orderId":"GPA.1234-5678","purchaseState":0,"acknowledgementState":1}`))
+	})
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	g.install(v)
+
+	receipt := googleReceipt(t, "coins", "token-1", "GPA.1234-5678")
+	if _, err := v.PurchaseGoogle(context.Background(), "user", receipt); err != nil {
+		t.Fatal(err)
+	}
+
+	// stored under the orderId, the resubmission must not reach Google.
+	var requests int32
+	transport := v.HTTPClient.Transport
+	v.HTTPClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		return transport.RoundTrip(r)
+	})}
+	_, err := v.PurchaseGoogle(context.Background(), "user", receipt)
+	if !errors.Is(err, validate.ErrPurchaseReceiptAlreadySeen) {
+		t.Fatalf("error %v, want ErrPurchaseReceiptAlreadySeen", err)
+	}
+	if requests > 0 || validations != 1 {
+		t.Fatalf("%d requests for the resubmitted receipt, want none", requests)
+	}
+}
+
+func TestPurchaseGoogleState(t *testing.T) {
+	tests := []struct {
+		name  string
+		state int
+		err   error
+	}{
+		{name: "purchased", state: 0},
+		{name: "canceled", state: 1, err: validate.ErrPurchaseRefunded},
+		{name: "pending", state: 2, err: validate.ErrPurchasePending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			g := newTestGoogle(t)
+			g.handleJSON(googleProductPath, map[string]interface{}{
+				"orderId":              "GPA.1234-5678",
+				"purchaseState":        tt.state,
+				"acknowledgementState": 1,
+			})
+			storage := memory.NewInMemoryStorage()
+			v := &validate.Validate{Storage: storage}
+			g.install(v)
+
+			_, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678"))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			var ve *validate.ValidationError
+			if tt.err != nil && (!errors.As(err, &ve) || ve.ProviderStatus != tt.state) {
+				t.Fatalf("error %v, want a ValidationError with the purchase state", err)
+			}
+			n, _ := storage.CountUserPurchases(context.Background(), "user")
+			if tt.err != nil && n > 0 {
+				t.Fatal("rejected purchase was stored")
+			}
+		})
+	}
+}
+
+func TestPurchaseGoogleResponseFields(t *testing.T) {
+	g := newTestGoogle(t)
+	g.handleJSON(googleProductPath, map[string]interface{}{
+		"orderId":              "GPA.1234-5678",
+		"purchaseState":        0,
+		"acknowledgementState": 1,
+		"consumptionState":     1,
+		"regionCode":           "TH",
+	})
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	g.install(v)
+
+	resp, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := resp.ValidatedPurchases[0]
+	if p.RegionCode != "TH" || p.AcknowledgementState != 1 || p.ConsumptionState != 1 {
+		t.Fatalf("purchase %+v, want the region, acknowledgement and consumption state of the response", p)
+	}
+}
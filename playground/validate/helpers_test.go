@@ -0,0 +1,177 @@
+package validate_test
+
+import (
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/json"
+	"encoding/pem"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+	"github.com/panuwattoa/in-app-purchase/iap/iaptest"
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+)
+
+type roundTripFunc func(r *http.Request) (*http.Response, error)
+
+func (f roundTripFunc) RoundTrip(r *http.Request) (*http.Response, error) { return f(r) }
+
+// redirectClient sends every request to srv whatever its URL, for the store endpoints that are constants.
+func redirectClient(srv *httptest.Server) *http.Client {
+	u, _ := url.Parse(srv.URL)
+	transport := srv.Client().Transport
+	return &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		r = r.Clone(r.Context())
+		r.URL.Scheme = u.Scheme
+		r.URL.Host = u.Host
+		r.Host = u.Host
+		return transport.RoundTrip(r)
+	})}
+}
+
+// testGoogle fake Google OAuth token endpoint and Android Publisher API, requests of the Validate it is
+// installed on go to mux.
+type testGoogle struct {
+	mux    *http.ServeMux
+	srv    *httptest.Server
+	config validate.IAPGoogleConfig
+}
+
+func newTestGoogle(t *testing.T) *testGoogle {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := &testGoogle{
+		mux: http.NewServeMux(),
+		config: validate.IAPGoogleConfig{
+			ClientEmail: "test@example.iam.gserviceaccount.com",
+			// A fresh key per test, access tokens are cached by service account.
+			PrivateKey: string(pem.EncodeToMemory(&pem.Block{Type: "RSA PRIVATE KEY", Bytes: x509.MarshalPKCS1PrivateKey(key)})),
+		},
+	}
+	g.mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write([]byte(`{"access_token":"test-token","token_type":"Bearer","expires_in":3600}`))
+	})
+	g.srv = httptest.NewTLSServer(g.mux)
+	t.Cleanup(g.srv.Close)
+	return g
+}
+
+// install makes v use the fake for Google requests with the fake service account.
+func (g *testGoogle) install(v *validate.Validate) {
+	v.HTTPClient = redirectClient(g.srv)
+	v.Credentials.Google = g.config
+}
+
+// handleJSON serves body for GET path.
+func (g *testGoogle) handleJSON(path string, body interface{}) {
+	g.mux.HandleFunc(path, func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Content-Type", "application/json")
+		_ = json.NewEncoder(w).Encode(body)
+	})
+}
+
+// googleReceipt client receipt as returned by the Play Billing Library, wrapped without a signature.
+func googleReceipt(t *testing.T, productID, purchaseToken, orderID string) string {
+	t.Helper()
+	purchase, err := json.Marshal(map[string]interface{}{
+		"orderId":       orderID,
+		"packageName":   "com.example.app",
+		"productId":     productID,
+		"purchaseTime":  time.Now().UnixNano() / int64(time.Millisecond),
+		"purchaseToken": purchaseToken,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	receipt, err := json.Marshal(map[string]string{"json": string(purchase)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(receipt)
+}
+
+// testApple fake verifyReceipt endpoints, production answers 21007 unless production is set.
+type testApple struct {
+	mux        *http.ServeMux
+	production interface{}
+	sandbox    interface{}
+	// payloads request bodies received by both endpoints.
+	mu       sync.Mutex
+	payloads []map[string]interface{}
+}
+
+func newTestApple(t *testing.T, v *validate.Validate) *testApple {
+	t.Helper()
+	a := &testApple{mux: http.NewServeMux()}
+	record := func(r *http.Request) {
+		var payload map[string]interface{}
+		_ = json.NewDecoder(r.Body).Decode(&payload)
+		a.mu.Lock()
+		a.payloads = append(a.payloads, payload)
+		a.mu.Unlock()
+	}
+	a.mux.HandleFunc("/production", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		body := a.production
+		if body == nil {
+			body = map[string]interface{}{"status": 21007}
+		}
+		_ = json.NewEncoder(w).Encode(body)
+	})
+	a.mux.HandleFunc("/sandbox", func(w http.ResponseWriter, r *http.Request) {
+		record(r)
+		_ = json.NewEncoder(w).Encode(a.sandbox)
+	})
+	srv := httptest.NewServer(a.mux)
+	t.Cleanup(srv.Close)
+	v.HTTPClient = srv.Client()
+	v.AppleProductionUrl = srv.URL + "/production"
+	v.AppleSandboxUrl = srv.URL + "/sandbox"
+	return a
+}
+
+// appleReceiptResponse verifyReceipt response of a receipt with inApps, first installed a year ago.
+func appleReceiptResponse(environment string, inApps ...map[string]string) map[string]interface{} {
+	return map[string]interface{}{
+		"status":      0,
+		"environment": environment,
+		"receipt": map[string]interface{}{
+			"original_purchase_date_ms": strconv.FormatInt(time.Now().AddDate(-1, 0, 0).UnixNano()/int64(time.Millisecond), 10),
+			"in_app":                    inApps,
+		},
+	}
+}
+
+// appleInApp in_app entry of a product purchased at purchaseTime.
+func appleInApp(productID, transactionID string, purchaseTime time.Time) map[string]string {
+	ms := strconv.FormatInt(purchaseTime.UnixNano()/int64(time.Millisecond), 10)
+	return map[string]string{
+		"product_id":                productID,
+		"transaction_id":            transactionID,
+		"original_transaction_id":   transactionID,
+		"purchase_date_ms":          ms,
+		"original_purchase_date_ms": ms,
+		"quantity":                  "1",
+	}
+}
+
+// appleRootCerts root cache trusting the signer, for Validate.AppleRootCerts.
+func appleRootCerts(t *testing.T, s *iaptest.AppleSigner) *iap.AppleRootCertCache {
+	t.Helper()
+	roots := &iap.AppleRootCertCache{}
+	if err := roots.SetCertificates(s.Root.Raw); err != nil {
+		t.Fatal(err)
+	}
+	return roots
+}
@@ -0,0 +1,89 @@
+package validate_test
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+// newTestHuawei fake OAuth and Order service answering verify with purchaseState.
+func newTestHuawei(t *testing.T, v *validate.Validate, purchaseState int, verify http.HandlerFunc) {
+	t.Helper()
+	mux := http.NewServeMux()
+	mux.HandleFunc("/oauth2/v3/token", func(w http.ResponseWriter, r *http.Request) {
+		_ = json.NewEncoder(w).Encode(map[string]interface{}{"access_token": "test-token", "expires_in": 3600})
+	})
+	mux.HandleFunc("/applications/purchases/tokens/verify", func(w http.ResponseWriter, r *http.Request) {
+		if verify != nil {
+			verify(w, r)
+			return
+		}
+		data, _ := json.Marshal(map[string]interface{}{
+			"productId":     "coins",
+			"purchaseToken": "token-1",
+			"purchaseTime":  time.Now().UnixNano() / int64(time.Millisecond),
+			"purchaseState": purchaseState,
+		})
+		_ = json.NewEncoder(w).Encode(map[string]string{"responseCode": "0", "purchaseTokenData": string(data)})
+	})
+	srv := httptest.NewTLSServer(mux)
+	t.Cleanup(srv.Close)
+	v.HTTPClient = redirectClient(srv)
+	v.Credentials.Huawei.ClientID = "client"
+	v.Credentials.Huawei.ClientSecret = "secret"
+}
+
+const huaweiPurchaseData = `{"productId":"coins","purchaseToken":"token-1","kind":0}`
+
+func TestPurchaseHuaweiState(t *testing.T) {
+	tests := []struct {
+		name  string
+		state int
+		err   error
+	}{
+		{name: "purchased", state: 0},
+		{name: "canceled", state: 1, err: validate.ErrPurchaseRefunded},
+		{name: "refunded", state: 2, err: validate.ErrPurchaseRefunded},
+		{name: "pending", state: 3, err: validate.ErrPurchasePending},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewInMemoryStorage()
+			v := &validate.Validate{Storage: storage}
+			newTestHuawei(t, v, tt.state, nil)
+
+			_, err := v.PurchaseHuawei(context.Background(), "user", huaweiPurchaseData, "")
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			n, _ := storage.CountUserPurchases(context.Background(), "user")
+			if tt.err != nil && n > 0 {
+				t.Fatal("rejected purchase was stored")
+			}
+		})
+	}
+}
+
+func TestHuaweiTimeout(t *testing.T) {
+	release := make(chan struct{})
+	defer close(release)
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), HuaweiTimeout: 50 * time.Millisecond}
+	newTestHuawei(t, v, 0, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+
+	_, err := v.PurchaseHuawei(context.Background(), "user", huaweiPurchaseData, "")
+	if !errors.Is(err, validate.ErrHuaweiTimeout) {
+		t.Fatalf("error %v, want ErrHuaweiTimeout", err)
+	}
+}
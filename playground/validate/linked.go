@@ -0,0 +1,106 @@
+package validate
+
+import (
+	"context"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+)
+
+// maxLinkedPurchaseTokens bounds how far FollowLinkedTokens walks a linkedPurchaseToken chain.
+const maxLinkedPurchaseTokens = 10
+
+// linkedSubscriptionsGoogle walks the linkedPurchaseToken chain starting at token with purchases.subscriptionsv2,
+// the linked tokens may be of other products so the v1 endpoint, which needs the product, can't be used.
+// seen holds the tokens already validated, a chain looping back stops there.
+func (v *Validate) linkedSubscriptionsGoogle(ctx context.Context, userID string, gc IAPGoogleConfig, packageName, token string, seen map[string]bool) ([]*SubscriptionPurchase, error) {
+	var purchases []*SubscriptionPurchase
+	for len(token) > 0 && !seen[token] && len(purchases) < maxLinkedPurchaseTokens {
+		seen[token] = true
+
+		g, raw, err := iap.ValidateSubscriptionV2GoogleWithOptions(ctx, v.httpClient(), gc.ClientEmail, gc.PrivateKey, packageName, token, v.googleOptions(gc))
+		if err != nil {
+			return nil, err
+		}
+
+		p, err := newLinkedSubscriptionPurchaseGoogle(userID, token, g, raw)
+		if err != nil {
+			return nil, err
+		}
+		purchases = append(purchases, p)
+		token = g.LinkedPurchaseToken
+	}
+	return purchases, nil
+}
+
+func newLinkedSubscriptionPurchaseGoogle(userID, token string, g *iap.SubscriptionPurchaseV2Google, raw []byte) (*SubscriptionPurchase, error) {
+	env := PRODUCTION
+	if g.TestPurchase != nil {
+		env = SANDBOX
+	}
+
+	var startTime time.Time
+	if len(g.StartTime) > 0 {
+		t, err := time.Parse(time.RFC3339Nano, g.StartTime)
+		if err != nil {
+			return nil, err
+		}
+		startTime = t
+	}
+
+	productId := ""
+	autoRenew := false
+	var expiresTime time.Time
+	for _, item := range g.LineItems {
+		expiry, err := time.Parse(time.RFC3339Nano, item.ExpiryTime)
+		if err != nil {
+			return nil, err
+		}
+		if expiry.After(expiresTime) {
+			expiresTime = expiry
+			productId = item.ProductId
+			autoRenew = item.AutoRenewingPlan != nil && item.AutoRenewingPlan.AutoRenewEnabled
+		}
+	}
+
+	return &SubscriptionPurchase{
+		Purchase: Purchase{
+			userID:        userID,
+			store:         GOOGLE_PLAY_STORE,
+			productId:     productId,
+			transactionId: token,
+			rawResponse:   string(raw),
+			purchaseTime:  startTime,
+			environment:   env,
+
+			purchaseToken:      token,
+			cancellationReason: googleCanceledStateReason(g.CanceledStateContext),
+			regionCode:         g.RegionCode,
+			productType:        PRODUCT_TYPE_SUBSCRIPTION,
+		},
+		AutoRenew:              autoRenew,
+		OriginalPurchaseTime:   startTime,
+		ExpiresTime:            expiresTime,
+		EffectiveExpiresTime:   expiresTime,
+		GracePeriodExpiresTime: g.GracePeriodExpiryTime,
+		RenewalCount:           googleRenewalCount(g.LatestOrderId),
+	}, nil
+}
+
+// googleCanceledStateReason maps the subscriptionsv2 canceledStateContext.
+func googleCanceledStateReason(c *iap.CanceledStateContextGoogle) CancellationReason {
+	switch {
+	case c == nil:
+		return CANCELLATION_REASON_NONE
+	case c.UserInitiatedCancellation != nil:
+		return CANCELLATION_REASON_USER_CANCELED
+	case c.SystemInitiatedCancellation != nil:
+		return CANCELLATION_REASON_BILLING_ERROR
+	case c.ReplacementCancellation != nil:
+		return CANCELLATION_REASON_REPLACED
+	case c.DeveloperInitiatedCancellation != nil:
+		return CANCELLATION_REASON_DEVELOPER_CANCELED
+	default:
+		return CANCELLATION_REASON_UNKNOWN
+	}
+}
@@ -0,0 +1,64 @@
+package validate_test
+
+import (
+	"context"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+func TestFollowLinkedTokens(t *testing.T) {
+	now := time.Now().Truncate(time.Second)
+	g := newTestGoogle(t)
+	// token-3 upgraded from token-2, itself resubscribed from token-1.
+	g.handleJSON("/androidpublisher/v3/applications/com.example.app/purchases/subscriptions/yearly/tokens/token-3", map[string]interface{}{
+		"orderId":              "GPA.3333",
+		"autoRenewing":         true,
+		"acknowledgementState": 1,
+		"linkedPurchaseToken":  "token-2",
+		"expiryTimeMillis":     strconv.FormatInt(now.AddDate(1, 0, 0).UnixNano()/int64(time.Millisecond), 10),
+	})
+	g.handleJSON("/androidpublisher/v3/applications/com.example.app/purchases/subscriptionsv2/tokens/token-2", map[string]interface{}{
+		"startTime":           now.AddDate(0, -2, 0).Format(time.RFC3339),
+		"subscriptionState":   "SUBSCRIPTION_STATE_EXPIRED",
+		"latestOrderId":       "GPA.2222..1",
+		"linkedPurchaseToken": "token-1",
+		"lineItems":           []map[string]interface{}{{"productId": "monthly", "expiryTime": now.Format(time.RFC3339)}},
+	})
+	g.handleJSON("/androidpublisher/v3/applications/com.example.app/purchases/subscriptionsv2/tokens/token-1", map[string]interface{}{
+		"startTime":         now.AddDate(0, -4, 0).Format(time.RFC3339),
+		"subscriptionState": "SUBSCRIPTION_STATE_EXPIRED",
+		"latestOrderId":     "GPA.1111",
+		"lineItems":         []map[string]interface{}{{"productId": "monthly", "expiryTime": now.AddDate(0, -3, 0).Format(time.RFC3339)}},
+	})
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), FollowLinkedTokens: true}
+	g.install(v)
+
+	resp, err := v.PurchaseSubscriptionGoogle(context.Background(), "user", googleReceipt(t, "yearly", "token-3", "GPA.3333"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []struct {
+		token, product string
+		expires        time.Time
+	}{
+		{token: "token-3", product: "yearly", expires: now.AddDate(1, 0, 0)},
+		{token: "token-2", product: "monthly", expires: now},
+		{token: "token-1", product: "monthly", expires: now.AddDate(0, -3, 0)},
+	}
+	if len(resp.ValidatedPurchases) != len(want) {
+		t.Fatalf("%d purchases, want the chain of %d", len(resp.ValidatedPurchases), len(want))
+	}
+	for i, w := range want {
+		p := resp.ValidatedPurchases[i]
+		if p.TransactionId != w.token || p.ProductId != w.product || p.ExpiresTime != w.expires.Unix() {
+			t.Fatalf("purchase %d %+v, want %s of %s expiring %v", i, p, w.token, w.product, w.expires)
+		}
+	}
+	if renewals := resp.ValidatedPurchases[1].RenewalCount; renewals != 2 {
+		t.Fatalf("linked token-2 renewal count %d, want 2", renewals)
+	}
+}
@@ -0,0 +1,84 @@
+package validate
+
+import (
+	"context"
+	"encoding/base64"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultPurchasesListLimit used when ListPurchases is given no limit.
+	DefaultPurchasesListLimit = 100
+	// MaxPurchasesListLimit bigger limits are capped.
+	MaxPurchasesListLimit = 100
+)
+
+// PurchasesCursor position in a user's purchases list. It's encoded as the URL safe base64 of
+// "<create time unix nanoseconds>:<transaction id>".
+type PurchasesCursor struct {
+	CreateTime    time.Time
+	TransactionId string
+}
+
+func (c PurchasesCursor) Encode() string {
+	return base64.RawURLEncoding.EncodeToString([]byte(strconv.FormatInt(c.CreateTime.UnixNano(), 10) + ":" + c.TransactionId))
+}
+
+// ParsePurchasesCursor returns ErrPurchasesListInvalidCursor for a cursor not made by PurchasesCursor.Encode.
+func ParsePurchasesCursor(cursor string) (PurchasesCursor, error) {
+	raw, err := base64.RawURLEncoding.DecodeString(cursor)
+	if err != nil {
+		return PurchasesCursor{}, ErrPurchasesListInvalidCursor
+	}
+
+	parts := strings.SplitN(string(raw), ":", 2)
+	if len(parts) != 2 || len(parts[1]) < 1 {
+		return PurchasesCursor{}, ErrPurchasesListInvalidCursor
+	}
+	nanos, err := strconv.ParseInt(parts[0], 10, 64)
+	if err != nil {
+		return PurchasesCursor{}, ErrPurchasesListInvalidCursor
+	}
+	return PurchasesCursor{CreateTime: time.Unix(0, nanos), TransactionId: parts[1]}, nil
+}
+
+type PurchasesList struct {
+	ValidatedPurchases []*ValidatedPurchase `json:"validated_purchases,omitempty"`
+	// Cursor of the next page, empty on the last page.
+	Cursor string `json:"cursor,omitempty"`
+}
+
+// ListPurchases returns a page of the user's stored purchases, cursor is empty for the first page.
+// It fails with ErrPurchasesListUnsupported when Storage doesn't implement PurchaseLister.
+func (v *Validate) ListPurchases(ctx context.Context, userID string, limit int, cursor string) (*PurchasesList, error) {
+	lister, ok := v.Storage.(PurchaseLister)
+	if !ok {
+		return nil, ErrPurchasesListUnsupported
+	}
+
+	if len(cursor) > 0 {
+		if _, err := ParsePurchasesCursor(cursor); err != nil {
+			return nil, err
+		}
+	}
+
+	if limit <= 0 {
+		limit = DefaultPurchasesListLimit
+	}
+	if limit > MaxPurchasesListLimit {
+		limit = MaxPurchasesListLimit
+	}
+
+	purchases, next, err := lister.ListPurchases(ctx, userID, limit, cursor)
+	if err != nil {
+		return nil, err
+	}
+
+	validatedPurchases := make([]*ValidatedPurchase, 0, len(purchases))
+	for _, p := range purchases {
+		validatedPurchases = append(validatedPurchases, newValidatedPurchase(p, []byte(p.rawResponse)))
+	}
+	return &PurchasesList{ValidatedPurchases: validatedPurchases, Cursor: next}, nil
+}
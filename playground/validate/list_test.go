@@ -0,0 +1,70 @@
+package validate_test
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"testing"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+func TestListPurchasesPages(t *testing.T) {
+	storage := memory.NewInMemoryStorage()
+	var purchases []*validate.Purchase
+	for i := 0; i < 5; i++ {
+		purchases = append(purchases, validate.NewPurchase("user", "receipt", &validate.ValidatedPurchase{
+			Store:         validate.APPLE_APP_STORE,
+			ProductId:     "coins",
+			TransactionId: strconv.Itoa(1000 + i),
+		}))
+	}
+	if _, err := storage.StorePurchases(context.Background(), purchases); err != nil {
+		t.Fatal(err)
+	}
+	v := &validate.Validate{Storage: storage}
+
+	var got []string
+	var sizes []int
+	cursor := ""
+	for page := 0; page < 4; page++ {
+		list, err := v.ListPurchases(context.Background(), "user", 2, cursor)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sizes = append(sizes, len(list.ValidatedPurchases))
+		for _, p := range list.ValidatedPurchases {
+			got = append(got, p.TransactionId)
+		}
+		if cursor = list.Cursor; len(cursor) < 1 {
+			break
+		}
+	}
+
+	if len(sizes) != 3 || sizes[0] != 2 || sizes[1] != 2 || sizes[2] != 1 {
+		t.Fatalf("page sizes %v, want [2 2 1]", sizes)
+	}
+	for i, id := range got {
+		if want := strconv.Itoa(1000 + i); id != want {
+			t.Fatalf("purchases %v, want 1000 to 1004 in order", got)
+		}
+	}
+}
+
+func TestListPurchasesInvalidCursor(t *testing.T) {
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	for _, cursor := range []string{"garbage!", "bm90LWEtY3Vyc29y"} {
+		if _, err := v.ListPurchases(context.Background(), "user", 2, cursor); !errors.Is(err, validate.ErrPurchasesListInvalidCursor) {
+			t.Fatalf("cursor %q error %v, want ErrPurchasesListInvalidCursor", cursor, err)
+		}
+	}
+}
+
+func TestListPurchasesUnsupported(t *testing.T) {
+	// only the Storage methods, not PurchaseLister.
+	v := &validate.Validate{Storage: struct{ validate.Storage }{memory.NewInMemoryStorage()}}
+	if _, err := v.ListPurchases(context.Background(), "user", 2, ""); !errors.Is(err, validate.ErrPurchasesListUnsupported) {
+		t.Fatalf("error %v, want ErrPurchasesListUnsupported", err)
+	}
+}
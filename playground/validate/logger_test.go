@@ -0,0 +1,180 @@
+package validate_test
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+type logLine struct {
+	level, msg string
+	keyvals    []interface{}
+}
+
+// captureLogger records every line of it and of its children.
+type captureLogger struct {
+	mu      *sync.Mutex
+	lines   *[]logLine
+	keyvals []interface{}
+}
+
+func newCaptureLogger() captureLogger {
+	return captureLogger{mu: &sync.Mutex{}, lines: &[]logLine{}}
+}
+
+func (l captureLogger) Debug(msg string, keyvals ...interface{}) { l.write("debug", msg, keyvals) }
+
+func (l captureLogger) Error(msg string, keyvals ...interface{}) { l.write("error", msg, keyvals) }
+
+func (l captureLogger) With(keyvals ...interface{}) iap.Logger {
+	l.keyvals = append(append([]interface{}(nil), l.keyvals...), keyvals...)
+	return l
+}
+
+func (l captureLogger) write(level, msg string, keyvals []interface{}) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	*l.lines = append(*l.lines, logLine{level: level, msg: msg, keyvals: append(append([]interface{}(nil), l.keyvals...), keyvals...)})
+}
+
+// value of key in the line keyvals.
+func (l logLine) value(key string) interface{} {
+	for i := 0; i+1 < len(l.keyvals); i += 2 {
+		if l.keyvals[i] == key {
+			return l.keyvals[i+1]
+		}
+	}
+	return nil
+}
+
+func TestLoggerDefaultSilent(t *testing.T) {
+	g := newTestGoogle(t)
+	g.handleJSON(googleProductPath, map[string]interface{}{"orderId": "GPA.1234-5678", "purchaseState": 0, "acknowledgementState": 1})
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	g.install(v)
+
+	var buf bytes.Buffer
+	log.SetOutput(&buf)
+	defer log.SetOutput(os.Stderr)
+	if _, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678")); err != nil {
+		t.Fatal(err)
+	}
+	if buf.Len() > 0 {
+		t.Fatalf("logged %q without a Logger", buf.String())
+	}
+}
+
+func TestLoggerCaptures(t *testing.T) {
+	g := newTestGoogle(t)
+	g.handleJSON(googleProductPath, map[string]interface{}{"orderId": "GPA.1234-5678", "purchaseState": 0, "acknowledgementState": 1})
+	logger := newCaptureLogger()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), Logger: logger}
+	g.install(v)
+
+	if _, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678")); err != nil {
+		t.Fatal(err)
+	}
+
+	var response *logLine
+	for i, line := range *logger.lines {
+		if line.msg == "google response" {
+			response = &(*logger.lines)[i]
+		}
+	}
+	if response == nil {
+		t.Fatalf("lines %+v, want the google response", *logger.lines)
+	}
+	if response.level != "debug" || response.value("status_code") != 200 || response.value("user_id") != "user" || response.value("store") != validate.GOOGLE_PLAY_STORE {
+		t.Fatalf("line %+v, want the debug status code with the user and store", *response)
+	}
+}
+
+func TestRequestID(t *testing.T) {
+	g := newTestGoogle(t)
+	g.handleJSON(googleProductPath, map[string]interface{}{"orderId": "GPA.1234-5678", "purchaseState": 0, "acknowledgementState": 1})
+	logger := newCaptureLogger()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), Logger: logger}
+	g.install(v)
+	ctx := iap.WithRequestID(context.Background(), "req-1")
+
+	if _, err := v.PurchaseGoogle(ctx, "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678")); err != nil {
+		t.Fatal(err)
+	}
+	if len(*logger.lines) < 1 {
+		t.Fatal("nothing logged")
+	}
+	for _, line := range *logger.lines {
+		if line.value("request_id") != "req-1" {
+			t.Fatalf("line %+v, want the request_id", line)
+		}
+	}
+
+	// no subscription is served, the call fails.
+	_, err := v.PurchaseSubscriptionGoogle(ctx, "user", googleReceipt(t, "monthly", "token-2", "GPA.2345-6789"))
+	if err == nil || !strings.Contains(err.Error(), "req-1") {
+		t.Fatalf("error %v, want the request ID in it", err)
+	}
+}
+
+func TestLoggerPerPurchase(t *testing.T) {
+	logger := newCaptureLogger()
+	v := &validate.Validate{
+		Storage: memory.NewInMemoryStorage(),
+		Logger:  logger,
+		PurchaseFilter: func(ctx context.Context, p *validate.Purchase) error {
+			if p.ProductID() == "gems" {
+				return errors.New("vetoed")
+			}
+			return nil
+		},
+	}
+	apple := newTestApple(t, v)
+	apple.production = appleReceiptResponse("Production", appleInApp("coins", "1000", time.Now()), appleInApp("gems", "1001", time.Now()))
+	if _, err := v.PurchasesApple(context.Background(), "user", "receipt"); err != nil {
+		t.Fatal(err)
+	}
+
+	g := newTestGoogle(t)
+	g.handleJSON(googleProductPath, map[string]interface{}{"orderId": "GPA.1234-5678", "purchaseState": 0, "acknowledgementState": 0})
+	g.mux.HandleFunc(googleProductPath+":acknowledge", func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusInternalServerError)
+	})
+	v.AutoAcknowledge = true
+	g.install(v)
+	if _, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678")); err != nil {
+		t.Fatal(err)
+	}
+
+	want := map[string]struct {
+		transactionID string
+		environment   validate.Environment
+	}{
+		"purchase rejected":                   {transactionID: "1001", environment: validate.PRODUCTION},
+		"purchase validated":                  {transactionID: "1000", environment: validate.PRODUCTION},
+		"error acknowledging google purchase": {transactionID: "GPA.1234-5678", environment: validate.PRODUCTION},
+	}
+	for _, line := range *logger.lines {
+		w, ok := want[line.msg]
+		if !ok {
+			continue
+		}
+		if line.value("transaction_id") != w.transactionID || line.value("environment") != w.environment || line.value("user_id") != "user" {
+			t.Fatalf("line %+v, want the user and the transaction %s in %v", line, w.transactionID, w.environment)
+		}
+		delete(want, line.msg)
+	}
+	if len(want) > 0 {
+		t.Fatalf("lines %v not logged", want)
+	}
+}
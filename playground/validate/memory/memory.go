@@ -0,0 +1,224 @@
+// Package memory provides an in-memory validate.Storage, for tests and local development.
+package memory
+
+import (
+	"context"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+)
+
+var (
+	_ validate.Storage                  = (*InMemoryStorage)(nil)
+	_ validate.PurchaseGetter           = (*InMemoryStorage)(nil)
+	_ validate.PurchaseCounter          = (*InMemoryStorage)(nil)
+	_ validate.PurchaseLister           = (*InMemoryStorage)(nil)
+	_ validate.TransactionChecker       = (*InMemoryStorage)(nil)
+	_ validate.SubscriptionStateUpdater = (*InMemoryStorage)(nil)
+)
+
+// InMemoryStorage dedupes purchases by validate.IdempotencyKey, StorePurchases and StoreSubscriptionPurchases
+// return only the newly seen ones. It's safe for concurrent use, it keeps copies of the purchases it stores and
+// returns copies of them, UpdateSubscriptionState changes the stored ones.
+type InMemoryStorage struct {
+	mu            sync.Mutex
+	purchases     map[string]*validate.Purchase
+	subscriptions map[string]*validate.SubscriptionPurchase
+	byUser        map[string][]*validate.Purchase
+	states        map[string]validate.SubscriptionState
+	now           func() time.Time
+}
+
+func NewInMemoryStorage() *InMemoryStorage {
+	return &InMemoryStorage{
+		purchases:     make(map[string]*validate.Purchase),
+		subscriptions: make(map[string]*validate.SubscriptionPurchase),
+		byUser:        make(map[string][]*validate.Purchase),
+		states:        make(map[string]validate.SubscriptionState),
+		now:           time.Now,
+	}
+}
+
+func (s *InMemoryStorage) StorePurchases(ctx context.Context, sp []*validate.Purchase) ([]*validate.Purchase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := make([]*validate.Purchase, 0, len(sp))
+	for _, p := range sp {
+		k := p.IdempotencyKey()
+		if _, ok := s.purchases[k]; ok {
+			continue
+		}
+		s.stamp(p)
+		c := *p
+		s.purchases[k] = &c
+		s.byUser[p.UserID()] = append(s.byUser[p.UserID()], &c)
+		stored = append(stored, p)
+	}
+	return stored, nil
+}
+
+func (s *InMemoryStorage) StoreSubscriptionPurchases(ctx context.Context, sp []*validate.SubscriptionPurchase) ([]*validate.SubscriptionPurchase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	stored := make([]*validate.SubscriptionPurchase, 0, len(sp))
+	for _, p := range sp {
+		k := p.IdempotencyKey()
+		if _, ok := s.purchases[k]; ok {
+			continue
+		}
+		s.stamp(&p.Purchase)
+		c := *p
+		s.purchases[k] = &c.Purchase
+		s.subscriptions[k] = &c
+		s.byUser[p.UserID()] = append(s.byUser[p.UserID()], &c.Purchase)
+		stored = append(stored, p)
+	}
+	return stored, nil
+}
+
+func (s *InMemoryStorage) stamp(p *validate.Purchase) {
+	now := s.now()
+	p.SetCreateTime(now)
+	p.SetUpdateTime(now)
+}
+
+// ListPurchases implements validate.PurchaseLister, limit is clamped like validate.Validate.ListPurchases, DefaultPurchasesListLimit when <= 0.
+func (s *InMemoryStorage) ListPurchases(ctx context.Context, userID string, limit int, cursor string) ([]*validate.Purchase, string, error) {
+	if limit <= 0 {
+		limit = validate.DefaultPurchasesListLimit
+	}
+	if limit > validate.MaxPurchasesListLimit {
+		limit = validate.MaxPurchasesListLimit
+	}
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	purchases := make([]*validate.Purchase, 0, len(s.byUser[userID]))
+	for _, p := range s.byUser[userID] {
+		c := *p
+		purchases = append(purchases, &c)
+	}
+	sort.Slice(purchases, func(i, j int) bool {
+		return less(purchases[i], purchases[j].CreateTime(), purchases[j].TransactionID())
+	})
+
+	start := 0
+	if len(cursor) > 0 {
+		c, err := validate.ParsePurchasesCursor(cursor)
+		if err != nil {
+			return nil, "", err
+		}
+		start = sort.Search(len(purchases), func(i int) bool {
+			return !less(purchases[i], c.CreateTime, c.TransactionId) &&
+				!(purchases[i].CreateTime().Equal(c.CreateTime) && purchases[i].TransactionID() == c.TransactionId)
+		})
+	}
+
+	end := start + limit
+	if end >= len(purchases) {
+		return purchases[start:], "", nil
+	}
+	last := purchases[end-1]
+	next := validate.PurchasesCursor{CreateTime: last.CreateTime(), TransactionId: last.TransactionID()}
+	return purchases[start:end], next.Encode(), nil
+}
+
+// less orders by create time then transaction ID.
+func less(p *validate.Purchase, createTime time.Time, transactionId string) bool {
+	if !p.CreateTime().Equal(createTime) {
+		return p.CreateTime().Before(createTime)
+	}
+	return p.TransactionID() < transactionId
+}
+
+// GetPurchases implements validate.PurchaseGetter.
+func (s *InMemoryStorage) GetPurchases(ctx context.Context, sp []*validate.Purchase) ([]*validate.Purchase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*validate.Purchase, 0, len(sp))
+	for _, p := range sp {
+		if stored, ok := s.purchases[p.IdempotencyKey()]; ok {
+			c := *stored
+			out = append(out, &c)
+		}
+	}
+	return out, nil
+}
+
+func (s *InMemoryStorage) GetSubscriptionPurchases(ctx context.Context, sp []*validate.SubscriptionPurchase) ([]*validate.SubscriptionPurchase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	out := make([]*validate.SubscriptionPurchase, 0, len(sp))
+	for _, p := range sp {
+		if stored, ok := s.subscriptions[p.IdempotencyKey()]; ok {
+			c := *stored
+			out = append(out, &c)
+		}
+	}
+	return out, nil
+}
+
+// CountUserPurchases implements validate.PurchaseCounter.
+func (s *InMemoryStorage) CountUserPurchases(ctx context.Context, userID string) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	return len(s.byUser[userID]), nil
+}
+
+// SeenTransaction implements validate.TransactionChecker.
+func (s *InMemoryStorage) SeenTransaction(ctx context.Context, store validate.Store, transactionID string) (bool, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	_, ok := s.purchases[validate.IdempotencyKey(store, transactionID)]
+	return ok, nil
+}
+
+// UpdateSubscriptionState records the state for the update's transaction and for every stored subscription
+// period it matches, by original transaction ID for Apple. Subscriptions not stored yet are skipped.
+func (s *InMemoryStorage) UpdateSubscriptionState(ctx context.Context, update *validate.SubscriptionStateUpdate) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.states[validate.IdempotencyKey(update.Store, update.TransactionId)] = update.State
+	for k, p := range s.subscriptions {
+		if p.Store() != update.Store {
+			continue
+		}
+		if p.TransactionID() != update.TransactionId &&
+			(len(update.OriginalTransactionId) < 1 || p.OriginalTransactionID() != update.OriginalTransactionId) {
+			continue
+		}
+
+		s.states[k] = update.State
+		switch update.State {
+		case validate.SUBSCRIPTION_STATE_ACTIVE:
+			p.AutoRenew = true
+		case validate.SUBSCRIPTION_STATE_CANCELED, validate.SUBSCRIPTION_STATE_EXPIRED, validate.SUBSCRIPTION_STATE_REFUNDED:
+			p.AutoRenew = false
+		}
+		if !update.ExpiresTime.IsZero() && update.ExpiresTime.After(p.ExpiresTime) {
+			p.ExpiresTime = update.ExpiresTime
+			p.EffectiveExpiresTime = update.ExpiresTime
+		}
+		p.SetUpdateTime(s.now())
+	}
+	return nil
+}
+
+// SubscriptionState the last state UpdateSubscriptionState recorded for the store and transaction ID.
+func (s *InMemoryStorage) SubscriptionState(store validate.Store, transactionID string) (validate.SubscriptionState, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	state, ok := s.states[validate.IdempotencyKey(store, transactionID)]
+	return state, ok
+}
@@ -0,0 +1,137 @@
+package memory_test
+
+import (
+	"context"
+	"strconv"
+	"sync"
+	"testing"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+func purchase(transactionID string) *validate.Purchase {
+	return validate.NewPurchase("user", "receipt", &validate.ValidatedPurchase{
+		Store:         validate.APPLE_APP_STORE,
+		ProductId:     "coins",
+		TransactionId: transactionID,
+	})
+}
+
+func TestStorePurchasesDedupe(t *testing.T) {
+	s := memory.NewInMemoryStorage()
+	ctx := context.Background()
+
+	stored, err := s.StorePurchases(ctx, []*validate.Purchase{purchase("1000")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 1 || stored[0].TransactionID() != "1000" {
+		t.Fatalf("stored %v, want the purchase", stored)
+	}
+
+	stored, err = s.StorePurchases(ctx, []*validate.Purchase{purchase("1000")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(stored) != 0 {
+		t.Fatalf("stored %d purchases for a seen transaction, want none", len(stored))
+	}
+
+	// the same transaction ID of another store is another purchase.
+	google := validate.NewPurchase("user", "receipt", &validate.ValidatedPurchase{Store: validate.GOOGLE_PLAY_STORE, TransactionId: "1000"})
+	if stored, err = s.StorePurchases(ctx, []*validate.Purchase{google}); err != nil || len(stored) != 1 {
+		t.Fatalf("stored %d purchases error %v, want the Google purchase", len(stored), err)
+	}
+}
+
+func TestStorePurchasesConcurrent(t *testing.T) {
+	s := memory.NewInMemoryStorage()
+	var wg sync.WaitGroup
+	var mu sync.Mutex
+	stored := 0
+	for i := 0; i < 20; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			out, err := s.StorePurchases(context.Background(), []*validate.Purchase{purchase("1000")})
+			if err != nil {
+				t.Error(err)
+			}
+			mu.Lock()
+			stored += len(out)
+			mu.Unlock()
+		}()
+	}
+	wg.Wait()
+	if stored != 1 {
+		t.Fatalf("transaction stored %d times, want once", stored)
+	}
+}
+
+func TestListPurchasesLimit(t *testing.T) {
+	s := memory.NewInMemoryStorage()
+	purchases := make([]*validate.Purchase, 0, validate.DefaultPurchasesListLimit+1)
+	for i := 0; i <= validate.DefaultPurchasesListLimit; i++ {
+		purchases = append(purchases, purchase(strconv.Itoa(i)))
+	}
+	if _, err := s.StorePurchases(context.Background(), purchases); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, limit := range []int{0, -1} {
+		page, cursor, err := s.ListPurchases(context.Background(), "user", limit, "")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(page) != validate.DefaultPurchasesListLimit || len(cursor) < 1 {
+			t.Fatalf("limit %d: %d purchases cursor %q, want a default page and a next cursor", limit, len(page), cursor)
+		}
+	}
+}
+
+func TestGetSubscriptionPurchasesCopies(t *testing.T) {
+	s := memory.NewInMemoryStorage()
+	ctx := context.Background()
+	sub := &validate.SubscriptionPurchase{Purchase: *purchase("1000"), AutoRenew: true}
+	if _, err := s.StoreSubscriptionPurchases(ctx, []*validate.SubscriptionPurchase{sub}); err != nil {
+		t.Fatal(err)
+	}
+
+	// readers don't race with the state updates, they get copies.
+	var wg sync.WaitGroup
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for i := 0; i < 10; i++ {
+			update := &validate.SubscriptionStateUpdate{Store: validate.APPLE_APP_STORE, TransactionId: "1000", State: validate.SUBSCRIPTION_STATE_CANCELED}
+			if err := s.UpdateSubscriptionState(ctx, update); err != nil {
+				t.Error(err)
+			}
+		}
+	}()
+	for i := 0; i < 10; i++ {
+		got, err := s.GetSubscriptionPurchases(ctx, []*validate.SubscriptionPurchase{sub})
+		if err != nil {
+			t.Fatal(err)
+		}
+		_ = got[0].AutoRenew
+		_ = got[0].UpdateTime()
+	}
+	wg.Wait()
+
+	got, err := s.GetSubscriptionPurchases(ctx, []*validate.SubscriptionPurchase{sub})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got[0] == sub || got[0].AutoRenew {
+		t.Fatalf("subscription %+v, want a copy with the canceled state", got[0])
+	}
+	got[0].AutoRenew = true
+	if again, _ := s.GetSubscriptionPurchases(ctx, []*validate.SubscriptionPurchase{sub}); again[0].AutoRenew {
+		t.Fatal("change of a returned subscription reached the stored one")
+	}
+	if !sub.AutoRenew {
+		t.Fatal("state update reached the subscription given to StoreSubscriptionPurchases")
+	}
+}
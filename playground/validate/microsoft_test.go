@@ -0,0 +1,51 @@
+package validate_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+// microsoftReceipt receipt signed with the certificate "cert", fetched before the signature is checked.
+const microsoftReceipt = `<Receipt CertificateId="cert"><Signature xmlns="http://www.w3.org/2000/09/xmldsig#">` +
+	`<SignedInfo></SignedInfo><SignatureValue>AAAA</SignatureValue></Signature></Receipt>`
+
+func TestPurchaseMicrosoftCertificateFetch(t *testing.T) {
+	var fetches int32
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		atomic.AddInt32(&fetches, 1)
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), HTTPClient: redirectClient(srv)}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	_, err := v.PurchaseMicrosoft(ctx, "user", microsoftReceipt)
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("error %v, want the caller deadline", err)
+	}
+	if n := atomic.LoadInt32(&fetches); n != 1 {
+		t.Fatalf("%d certificate fetches through HTTPClient, want 1", n)
+	}
+}
+
+func TestMicrosoftTimeout(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-r.Context().Done()
+	}))
+	defer srv.Close()
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), HTTPClient: redirectClient(srv), MicrosoftTimeout: 50 * time.Millisecond}
+
+	_, err := v.PurchaseMicrosoft(context.Background(), "user", microsoftReceipt)
+	if !errors.Is(err, validate.ErrMicrosoftTimeout) {
+		t.Fatalf("error %v, want ErrMicrosoftTimeout", err)
+	}
+}
@@ -0,0 +1,290 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"strconv"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+)
+
+// State of a subscription as last reported by a store notification.
+//
+// A subscription starts ACTIVE. A user turning off auto renew moves it to CANCELED, access continues until
+// it expires and it goes back to ACTIVE if auto renew is turned on again or it is restarted. A failed renewal
+// moves it to IN_GRACE_PERIOD when the app has a billing grace period, access continues, otherwise to ON_HOLD,
+// access stops; the store keeps retrying and a successful retry moves it back to ACTIVE. Once the retries
+// or the paid period run out it is EXPIRED, a later resubscribe is ACTIVE again. Google subscriptions can also
+// be PAUSED by the user until the pause ends. REFUNDED (the store refunded or revoked it) is final.
+type SubscriptionState int32
+
+const (
+	SUBSCRIPTION_STATE_UNKNOWN SubscriptionState = 0
+	// Paid or trial period in progress and renewing, after a purchase, renewal, recovery or restart.
+	SUBSCRIPTION_STATE_ACTIVE SubscriptionState = 1
+	// Auto renew turned off, access continues until ExpiresTime.
+	SUBSCRIPTION_STATE_CANCELED SubscriptionState = 2
+	// Renewal failed, the store retries billing and access continues within the grace period.
+	SUBSCRIPTION_STATE_IN_GRACE_PERIOD SubscriptionState = 3
+	// Renewal failed, the store retries billing and access is suspended.
+	SUBSCRIPTION_STATE_ON_HOLD SubscriptionState = 4
+	// Google only, paused by the user, access is suspended.
+	SUBSCRIPTION_STATE_PAUSED SubscriptionState = 5
+	// Access ended.
+	SUBSCRIPTION_STATE_EXPIRED SubscriptionState = 6
+	// Refunded or revoked by the store, access ended.
+	SUBSCRIPTION_STATE_REFUNDED SubscriptionState = 7
+)
+
+// SubscriptionStateUpdate state change of a stored subscription reported by a store notification.
+type SubscriptionStateUpdate struct {
+	Store Store
+	// TransactionId Apple transaction of the notification, for Google the purchase token.
+	TransactionId string
+	// OriginalTransactionId Apple only, shared by every period of the subscription so it matches all of them.
+	OriginalTransactionId string
+	ProductId             string
+	State                 SubscriptionState
+	// ExpiresTime new expiry, Apple only, zero when the notification doesn't carry one.
+	ExpiresTime time.Time
+	// NotificationType store notification that caused the update, e.g. DID_RENEW/AUTO_RENEW_DISABLED or 3 for Google.
+	NotificationType string
+	// UpdateTime when the store signed or sent the notification.
+	UpdateTime time.Time
+}
+
+// HandleAppleNotification verifies an App Store Server Notification V2 signedPayload and passes the
+// resulting state change to Storage.UpdateSubscriptionState, Storage must implement SubscriptionStateUpdater.
+// Notifications of another app than AppleCredentials.BundleID fail with ErrAppleBundleMismatch, sandbox
+// notifications with AppleProductionOnly (production ones with AppleSandboxOnly) with ErrAppleEnvironmentMismatch.
+// Notifications that don't change the state of a subscription (e.g. TEST, CONSUMPTION_REQUEST, PRICE_INCREASE)
+// are acknowledged without an update.
+func (v *Validate) HandleAppleNotification(ctx context.Context, signedPayload string) error {
+	if len(signedPayload) < 1 {
+		return errors.New("'signedPayload' is empty")
+	}
+
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("store", APPLE_APP_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	n, err := iap.ParseAppleServerNotificationV2(v.appleRootCerts(ctx), signedPayload)
+	if err != nil {
+		log.Debug("apple notification invalid", "error", err)
+		return ErrFailedPrecondition
+	}
+
+	if err := v.checkAppleNotification(ctx, n); err != nil {
+		log.Debug("apple notification rejected", "bundle_id", n.Data.BundleID, "environment", n.Data.Environment, "error", err)
+		return err
+	}
+
+	state := appleNotificationState(n.NotificationType, n.Subtype)
+	if state == SUBSCRIPTION_STATE_UNKNOWN || n.Transaction == nil {
+		log.Debug("apple notification ignored", "notification_type", n.NotificationType, "subtype", n.Subtype)
+		return nil
+	}
+
+	notificationType := n.NotificationType
+	if len(n.Subtype) > 0 {
+		notificationType += "/" + n.Subtype
+	}
+	update := &SubscriptionStateUpdate{
+		Store:                 APPLE_APP_STORE,
+		TransactionId:         n.Transaction.TransactionID,
+		OriginalTransactionId: n.Transaction.OriginalTransactionID,
+		ProductId:             n.Transaction.ProductID,
+		State:                 state,
+		NotificationType:      notificationType,
+		UpdateTime:            parseMillisecondUnixTimestamp(int(n.SignedDate)),
+	}
+	if n.Transaction.ExpiresDate > 0 {
+		update.ExpiresTime = parseMillisecondUnixTimestamp(int(n.Transaction.ExpiresDate))
+	}
+	return v.updateSubscriptionState(ctx, log, update)
+}
+
+// checkAppleNotification the notification and its signed transaction must belong to the configured app and
+// to an environment this Validate serves.
+func (v *Validate) checkAppleNotification(ctx context.Context, n *iap.AppleNotificationV2) error {
+	if !v.credentials().appleBundleAllowed(n.Data.BundleID) {
+		return ErrAppleBundleMismatch
+	}
+	if n.Transaction != nil && n.Transaction.BundleID != n.Data.BundleID {
+		return ErrAppleBundleMismatch
+	}
+	if n.Transaction != nil && len(n.Transaction.Environment) > 0 && n.Transaction.Environment != n.Data.Environment {
+		return ErrAppleEnvironmentMismatch
+	}
+
+	opts := v.appleOptions(ctx)
+	switch n.Data.Environment {
+	case iap.AppleSandboxEnv:
+		if opts.ProductionOnly {
+			return ErrAppleEnvironmentMismatch
+		}
+	case iap.AppleProductionEnv:
+		if opts.SandboxOnly {
+			return ErrAppleEnvironmentMismatch
+		}
+	default:
+		return ErrAppleEnvironmentMismatch
+	}
+	return nil
+}
+
+// HandleGoogleNotification decodes a Real-time Developer Notification Pub/Sub push body and passes the
+// subscription state to Storage.UpdateSubscriptionState, keyed by purchase token, Storage must implement
+// SubscriptionStateUpdater. The push body isn't signed so only its purchase token is used, the state, product
+// and expiry are reloaded with iap.ValidateSubscriptionV2Google using the service account of its package name.
+// Voided subscription purchases Google reports as expired are REFUNDED, test, one-time product and price
+// change notifications are acknowledged without an update.
+func (v *Validate) HandleGoogleNotification(ctx context.Context, pubsubData []byte) error {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("store", GOOGLE_PLAY_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	n, err := iap.ParseGoogleRTDN(pubsubData)
+	if err != nil {
+		log.Debug("google notification invalid", "error", err)
+		return ErrFailedPrecondition
+	}
+
+	update := &SubscriptionStateUpdate{
+		Store:      GOOGLE_PLAY_STORE,
+		UpdateTime: parseMillisecondUnixTimestamp(int(n.EventTimeMillis)),
+	}
+	voided := false
+	switch {
+	case n.SubscriptionNotification != nil:
+		sn := n.SubscriptionNotification
+		if googleNotificationState(sn.NotificationType) == SUBSCRIPTION_STATE_UNKNOWN {
+			break
+		}
+		update.TransactionId = sn.PurchaseToken
+		update.NotificationType = strconv.Itoa(sn.NotificationType)
+	case n.VoidedPurchaseNotification != nil && n.VoidedPurchaseNotification.ProductType == 1:
+		update.TransactionId = n.VoidedPurchaseNotification.PurchaseToken
+		update.NotificationType = "VOIDED"
+		voided = true
+	}
+	if len(update.TransactionId) < 1 {
+		log.Debug("google notification ignored")
+		return nil
+	}
+
+	if _, ok := v.Storage.(SubscriptionStateUpdater); !ok {
+		return ErrSubscriptionStateUnsupported
+	}
+
+	gc, err := v.credentials().ResolveGoogle(n.PackageName)
+	if err != nil {
+		return err
+	}
+
+	g, _, err := iap.ValidateSubscriptionV2GoogleWithOptions(ctx, v.httpClient(), gc.ClientEmail, gc.PrivateKey, n.PackageName, update.TransactionId, v.googleOptions(gc))
+	if err != nil {
+		log.Error("error reloading google subscription", "error", err)
+		return googleValidationError(err)
+	}
+
+	update.State = googleSubscriptionV2State(g.SubscriptionState)
+	if voided && update.State == SUBSCRIPTION_STATE_EXPIRED {
+		update.State = SUBSCRIPTION_STATE_REFUNDED
+	}
+	if update.State == SUBSCRIPTION_STATE_UNKNOWN {
+		log.Debug("google notification ignored", "subscription_state", g.SubscriptionState)
+		return nil
+	}
+	for _, item := range g.LineItems {
+		if len(update.ProductId) < 1 {
+			update.ProductId = item.ProductId
+		}
+		expiry, err := time.Parse(time.RFC3339Nano, item.ExpiryTime)
+		if err == nil && expiry.After(update.ExpiresTime) {
+			update.ExpiresTime = expiry
+		}
+	}
+	return v.updateSubscriptionState(ctx, log, update)
+}
+
+func (v *Validate) updateSubscriptionState(ctx context.Context, log iap.Logger, update *SubscriptionStateUpdate) error {
+	log = log.With("transaction_id", update.TransactionId, "notification_type", update.NotificationType)
+	updater, ok := v.Storage.(SubscriptionStateUpdater)
+	if !ok {
+		return ErrSubscriptionStateUnsupported
+	}
+	if v.DryRun {
+		log.Debug("dry run, subscription state not updated", "state", update.State)
+		return nil
+	}
+
+	if err := updater.UpdateSubscriptionState(ctx, update); err != nil {
+		log.Error("error updating subscription state", "error", err)
+		return err
+	}
+	log.Debug("subscription state updated", "state", update.State)
+	return nil
+}
+
+func appleNotificationState(notificationType, subtype string) SubscriptionState {
+	switch notificationType {
+	case "SUBSCRIBED", "DID_RENEW", "OFFER_REDEEMED", "RENEWAL_EXTENDED":
+		return SUBSCRIPTION_STATE_ACTIVE
+	case "DID_CHANGE_RENEWAL_STATUS":
+		if subtype == "AUTO_RENEW_DISABLED" {
+			return SUBSCRIPTION_STATE_CANCELED
+		}
+		return SUBSCRIPTION_STATE_ACTIVE
+	case "DID_FAIL_TO_RENEW":
+		if subtype == "GRACE_PERIOD" {
+			return SUBSCRIPTION_STATE_IN_GRACE_PERIOD
+		}
+		return SUBSCRIPTION_STATE_ON_HOLD
+	case "EXPIRED", "GRACE_PERIOD_EXPIRED":
+		return SUBSCRIPTION_STATE_EXPIRED
+	case "REFUND", "REVOKE":
+		return SUBSCRIPTION_STATE_REFUNDED
+	default:
+		return SUBSCRIPTION_STATE_UNKNOWN
+	}
+}
+
+func googleNotificationState(notificationType int) SubscriptionState {
+	switch notificationType {
+	case 1, 2, 4, 7, 9: // RECOVERED, RENEWED, PURCHASED, RESTARTED, DEFERRED
+		return SUBSCRIPTION_STATE_ACTIVE
+	case 3:
+		return SUBSCRIPTION_STATE_CANCELED
+	case 5:
+		return SUBSCRIPTION_STATE_ON_HOLD
+	case 6:
+		return SUBSCRIPTION_STATE_IN_GRACE_PERIOD
+	case 10:
+		return SUBSCRIPTION_STATE_PAUSED
+	case 12:
+		return SUBSCRIPTION_STATE_REFUNDED
+	case 13:
+		return SUBSCRIPTION_STATE_EXPIRED
+	default: // 8 PRICE_CHANGE_CONFIRMED, 11 PAUSE_SCHEDULE_CHANGED
+		return SUBSCRIPTION_STATE_UNKNOWN
+	}
+}
+
+func googleSubscriptionV2State(state string) SubscriptionState {
+	switch state {
+	case iap.GoogleSubscriptionStateActive:
+		return SUBSCRIPTION_STATE_ACTIVE
+	case iap.GoogleSubscriptionStateCanceled:
+		return SUBSCRIPTION_STATE_CANCELED
+	case iap.GoogleSubscriptionStateInGracePeriod:
+		return SUBSCRIPTION_STATE_IN_GRACE_PERIOD
+	case iap.GoogleSubscriptionStateOnHold:
+		return SUBSCRIPTION_STATE_ON_HOLD
+	case iap.GoogleSubscriptionStatePaused:
+		return SUBSCRIPTION_STATE_PAUSED
+	case iap.GoogleSubscriptionStateExpired:
+		return SUBSCRIPTION_STATE_EXPIRED
+	default: // PENDING, PENDING_PURCHASE_CANCELED
+		return SUBSCRIPTION_STATE_UNKNOWN
+	}
+}
@@ -0,0 +1,153 @@
+package validate_test
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+	"github.com/panuwattoa/in-app-purchase/iap/iaptest"
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+func googleRTDN(t *testing.T, notification map[string]interface{}) []byte {
+	t.Helper()
+	notification["version"] = "1.0"
+	notification["packageName"] = "com.example.app"
+	notification["eventTimeMillis"] = "1700000000000"
+	data, err := json.Marshal(notification)
+	if err != nil {
+		t.Fatal(err)
+	}
+	body, err := json.Marshal(map[string]interface{}{
+		"message": map[string]string{"data": base64.StdEncoding.EncodeToString(data)},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return body
+}
+
+func TestHandleGoogleNotificationReloadsState(t *testing.T) {
+	g := newTestGoogle(t)
+	g.handleJSON("/androidpublisher/v3/applications/com.example.app/purchases/subscriptionsv2/tokens/token-1", map[string]interface{}{
+		"subscriptionState": iap.GoogleSubscriptionStateExpired,
+		"lineItems": []map[string]string{
+			{"productId": "monthly", "expiryTime": "2024-01-02T03:04:05Z"},
+		},
+	})
+	storage := memory.NewInMemoryStorage()
+	v := &validate.Validate{Storage: storage}
+	g.install(v)
+
+	// The push body isn't signed, the RENEWED in it must not be what gets stored.
+	body := googleRTDN(t, map[string]interface{}{
+		"subscriptionNotification": map[string]interface{}{
+			"notificationType": 2,
+			"purchaseToken":    "token-1",
+			"subscriptionId":   "forged",
+		},
+	})
+	if err := v.HandleGoogleNotification(context.Background(), body); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := storage.SubscriptionState(validate.GOOGLE_PLAY_STORE, "token-1"); state != validate.SUBSCRIPTION_STATE_EXPIRED {
+		t.Fatalf("state %v, want EXPIRED", state)
+	}
+}
+
+func TestHandleGoogleNotificationVoided(t *testing.T) {
+	g := newTestGoogle(t)
+	g.handleJSON("/androidpublisher/v3/applications/com.example.app/purchases/subscriptionsv2/tokens/token-1", map[string]interface{}{
+		"subscriptionState": iap.GoogleSubscriptionStateExpired,
+	})
+	storage := memory.NewInMemoryStorage()
+	v := &validate.Validate{Storage: storage}
+	g.install(v)
+
+	body := googleRTDN(t, map[string]interface{}{
+		"voidedPurchaseNotification": map[string]interface{}{"purchaseToken": "token-1", "productType": 1},
+	})
+	if err := v.HandleGoogleNotification(context.Background(), body); err != nil {
+		t.Fatal(err)
+	}
+	if state, _ := storage.SubscriptionState(validate.GOOGLE_PLAY_STORE, "token-1"); state != validate.SUBSCRIPTION_STATE_REFUNDED {
+		t.Fatalf("state %v, want REFUNDED", state)
+	}
+}
+
+// storageOnly hides the optional interfaces of the wrapped Storage.
+type storageOnly struct {
+	validate.Storage
+}
+
+func TestHandleGoogleNotificationUnsupported(t *testing.T) {
+	v := &validate.Validate{Storage: storageOnly{memory.NewInMemoryStorage()}}
+	body := googleRTDN(t, map[string]interface{}{
+		"subscriptionNotification": map[string]interface{}{"notificationType": 2, "purchaseToken": "token-1"},
+	})
+	if err := v.HandleGoogleNotification(context.Background(), body); !errors.Is(err, validate.ErrSubscriptionStateUnsupported) {
+		t.Fatalf("error %v, want ErrSubscriptionStateUnsupported", err)
+	}
+}
+
+func TestHandleAppleNotification(t *testing.T) {
+	signer := iaptest.NewAppleSigner(t)
+	notification := func(bundleID, environment string) string {
+		transaction := signer.Sign(t, map[string]interface{}{
+			"transactionId":         "1000",
+			"originalTransactionId": "1000",
+			"bundleId":              bundleID,
+			"productId":             "monthly",
+			"expiresDate":           time.Now().Add(time.Hour).UnixNano() / int64(time.Millisecond),
+			"environment":           environment,
+		})
+		return signer.Sign(t, map[string]interface{}{
+			"notificationType": "EXPIRED",
+			"signedDate":       time.Now().UnixNano() / int64(time.Millisecond),
+			"data": map[string]interface{}{
+				"bundleId":              bundleID,
+				"environment":           environment,
+				"signedTransactionInfo": transaction,
+			},
+		})
+	}
+
+	tests := []struct {
+		name           string
+		productionOnly bool
+		bundleID       string
+		environment    string
+		err            error
+	}{
+		{name: "configured app", bundleID: "com.example.app", environment: iap.AppleProductionEnv},
+		{name: "other app", bundleID: "com.other.app", environment: iap.AppleProductionEnv, err: validate.ErrAppleBundleMismatch},
+		{name: "sandbox with production only", productionOnly: true, bundleID: "com.example.app", environment: iap.AppleSandboxEnv, err: validate.ErrAppleEnvironmentMismatch},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			storage := memory.NewInMemoryStorage()
+			v := &validate.Validate{Storage: storage, AppleProductionOnly: tt.productionOnly, AppleRootCerts: appleRootCerts(t, signer)}
+			v.Credentials.Apple.BundleID = "com.example.app"
+
+			err := v.HandleAppleNotification(context.Background(), notification(tt.bundleID, tt.environment))
+			if !errors.Is(err, tt.err) {
+				t.Fatalf("error %v, want %v", err, tt.err)
+			}
+			state, ok := storage.SubscriptionState(validate.APPLE_APP_STORE, "1000")
+			if tt.err != nil {
+				if ok {
+					t.Fatal("rejected notification updated the state")
+				}
+				return
+			}
+			if state != validate.SUBSCRIPTION_STATE_EXPIRED {
+				t.Fatalf("state %v, want EXPIRED", state)
+			}
+		})
+	}
+}
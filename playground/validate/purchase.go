@@ -0,0 +1,165 @@
+package validate
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"strconv"
+	"time"
+)
+
+// NewPurchase builds the Purchase of vp for Storage implementations returning stored purchases, e.g. from
+// GetPurchases or ListPurchases. vp holds what the accessors below return, rawRequest what RawRequest returned
+// when it was stored, RawRequestHash hashes it.
+func NewPurchase(userID, rawRequest string, vp *ValidatedPurchase) *Purchase {
+	return &Purchase{
+		userID:        userID,
+		store:         vp.Store,
+		productId:     vp.ProductId,
+		transactionId: vp.TransactionId,
+		rawRequest:    rawRequest,
+		rawResponse:   vp.ProviderResponse,
+		purchaseTime:  unixTime(vp.PurchaseTime),
+		createTime:    unixTime(vp.CreateTime),
+		updateTime:    unixTime(vp.UpdateTime),
+		environment:   vp.Environment,
+
+		originalTransactionId:       vp.OriginalTransactionId,
+		cancellationReason:          vp.CancellationReason,
+		cancellationTime:            unixTime(vp.CancellationTime),
+		storeCancellationReason:     vp.StoreCancellationReason,
+		storefront:                  vp.Storefront,
+		storefrontId:                vp.StorefrontId,
+		unacknowledged:              vp.Store == GOOGLE_PLAY_STORE && vp.AcknowledgementState == 0,
+		familyShared:                vp.FamilyShared,
+		obfuscatedExternalProfileId: vp.ObfuscatedExternalProfileId,
+		consumed:                    vp.AlreadyConsumed,
+		regionCode:                  vp.RegionCode,
+		acknowledgementState:        vp.AcknowledgementState,
+		consumptionState:            vp.ConsumptionState,
+		productType:                 vp.ProductType,
+		quantity:                    vp.Quantity,
+		appAccountToken:             vp.AppAccountToken,
+		purchaseToken:               vp.PurchaseToken,
+		priceMicros:                 vp.PriceMicros,
+		currency:                    vp.Currency,
+	}
+}
+
+// NewSubscriptionPurchase like NewPurchase, with the subscription fields of vp. EffectiveExpiresTime defaults to
+// ExpiresTime and ProductType to PRODUCT_TYPE_SUBSCRIPTION.
+func NewSubscriptionPurchase(userID, rawRequest string, vp *ValidatedPurchase) *SubscriptionPurchase {
+	sp := &SubscriptionPurchase{
+		Purchase:               *NewPurchase(userID, rawRequest, vp),
+		AutoRenew:              vp.AutoRenew,
+		AutoRenewProductId:     vp.AutoRenewProductId,
+		OriginalPurchaseTime:   unixTime(vp.OriginalPurchaseTime),
+		ExpiresTime:            unixTime(vp.ExpiresTime),
+		EffectiveExpiresTime:   unixTime(vp.ExpiresTime),
+		GracePeriodExpiresTime: unixTime(vp.GracePeriodExpiresTime),
+		PaymentState:           vp.PaymentState,
+		CancelReason:           vp.CancelReason,
+		UserCancellationTime:   unixTime(vp.UserCancellationTime),
+		IsTrialPeriod:          vp.IsTrialPeriod,
+		OfferType:              vp.OfferType,
+		RenewalCount:           vp.RenewalCount,
+	}
+	if vp.EffectiveExpiresTime > 0 {
+		sp.EffectiveExpiresTime = unixTime(vp.EffectiveExpiresTime)
+	}
+	if sp.productType == PRODUCT_TYPE_UNKNOWN {
+		sp.productType = PRODUCT_TYPE_SUBSCRIPTION
+	}
+	return sp
+}
+
+// Accessors for Storage implementations persisting a Purchase.
+
+func (p *Purchase) UserID() string { return p.userID }
+
+func (p *Purchase) Store() Store { return p.store }
+
+func (p *Purchase) ProductID() string { return p.productId }
+
+func (p *Purchase) TransactionID() string { return p.transactionId }
+
+func (p *Purchase) OriginalTransactionID() string { return p.originalTransactionId }
+
+// IdempotencyKey the unique key of a stored purchase, see IdempotencyKey.
+func (p *Purchase) IdempotencyKey() string { return IdempotencyKey(p.store, p.transactionId) }
+
+// RawRequest the receipt as sent by the client, its RawRequestHash with Validate.HashRawRequest.
+func (p *Purchase) RawRequest() string { return p.rawRequest }
+
+// RawRequestHash hex SHA-256 of the receipt as sent by the client.
+func (p *Purchase) RawRequestHash() string {
+	if p.rawRequestHashed {
+		return p.rawRequest
+	}
+	sum := sha256.Sum256([]byte(p.rawRequest))
+	return hex.EncodeToString(sum[:])
+}
+
+func (p *Purchase) hashRawRequest() {
+	p.rawRequest = p.RawRequestHash()
+	p.rawRequestHashed = true
+}
+
+// RawResponse the provider validation response.
+func (p *Purchase) RawResponse() string { return p.rawResponse }
+
+func (p *Purchase) PurchaseTime() time.Time { return p.purchaseTime }
+
+func (p *Purchase) CreateTime() time.Time { return p.createTime }
+
+func (p *Purchase) UpdateTime() time.Time { return p.updateTime }
+
+// SetCreateTime lets Storage stamp when the purchase was first stored, before it's returned from StorePurchases.
+func (p *Purchase) SetCreateTime(t time.Time) { p.createTime = t }
+
+// SetUpdateTime lets Storage stamp when the purchase was last updated.
+func (p *Purchase) SetUpdateTime(t time.Time) { p.updateTime = t }
+
+func (p *Purchase) Environment() Environment { return p.environment }
+
+func (p *Purchase) CancellationReason() CancellationReason { return p.cancellationReason }
+
+func (p *Purchase) CancellationTime() time.Time { return p.cancellationTime }
+
+func (p *Purchase) StoreCancellationReason() string { return p.storeCancellationReason }
+
+func (p *Purchase) Storefront() string { return p.storefront }
+
+func (p *Purchase) StorefrontID() string { return p.storefrontId }
+
+func (p *Purchase) Unacknowledged() bool { return p.unacknowledged }
+
+func (p *Purchase) FamilyShared() bool { return p.familyShared }
+
+func (p *Purchase) ObfuscatedExternalProfileID() string { return p.obfuscatedExternalProfileId }
+
+func (p *Purchase) Consumed() bool { return p.consumed }
+
+func (p *Purchase) RegionCode() string { return p.regionCode }
+
+func (p *Purchase) AcknowledgementState() int { return p.acknowledgementState }
+
+func (p *Purchase) ConsumptionState() int { return p.consumptionState }
+
+func (p *Purchase) ProductType() ProductType { return p.productType }
+
+func (p *Purchase) PriceMicros() int64 { return p.priceMicros }
+
+func (p *Purchase) Currency() string { return p.currency }
+
+func (p *Purchase) Quantity() int { return p.quantity }
+
+func (p *Purchase) AppAccountToken() string { return p.appAccountToken }
+
+// PurchaseToken Google purchase token, TransactionID is the orderId for products.
+func (p *Purchase) PurchaseToken() string { return p.purchaseToken }
+
+// IdempotencyKey canonical "<store>:<transactionId>" key, a transaction ID is only unique within its store.
+// A purchase resubmitted (e.g. a retried validation) has the same key even if its other fields differ.
+func IdempotencyKey(store Store, transactionID string) string {
+	return strconv.Itoa(int(store)) + ":" + transactionID
+}
@@ -0,0 +1,176 @@
+package validate_test
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"reflect"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+)
+
+// row what a database backed Storage keeps of a purchase, read through the Purchase accessors only.
+type row struct {
+	userID     string
+	rawRequest string
+	vp         validate.ValidatedPurchase
+}
+
+func newRow(p *validate.Purchase) row {
+	unix := func(t time.Time) int64 {
+		if t.IsZero() {
+			return 0
+		}
+		return t.Unix()
+	}
+	return row{
+		userID:     p.UserID(),
+		rawRequest: p.RawRequest(),
+		vp: validate.ValidatedPurchase{
+			ProductId:                   p.ProductID(),
+			TransactionId:               p.TransactionID(),
+			OriginalTransactionId:       p.OriginalTransactionID(),
+			Store:                       p.Store(),
+			PurchaseTime:                unix(p.PurchaseTime()),
+			CreateTime:                  unix(p.CreateTime()),
+			UpdateTime:                  unix(p.UpdateTime()),
+			ProviderResponse:            p.RawResponse(),
+			Environment:                 p.Environment(),
+			Storefront:                  p.Storefront(),
+			StorefrontId:                p.StorefrontID(),
+			CancellationReason:          p.CancellationReason(),
+			CancellationTime:            unix(p.CancellationTime()),
+			StoreCancellationReason:     p.StoreCancellationReason(),
+			ObfuscatedExternalProfileId: p.ObfuscatedExternalProfileID(),
+			AlreadyConsumed:             p.Consumed(),
+			RegionCode:                  p.RegionCode(),
+			AcknowledgementState:        p.AcknowledgementState(),
+			ConsumptionState:            p.ConsumptionState(),
+			ProductType:                 p.ProductType(),
+			PriceMicros:                 p.PriceMicros(),
+			Currency:                    p.Currency(),
+			Quantity:                    p.Quantity(),
+			PurchaseToken:               p.PurchaseToken(),
+			AppAccountToken:             p.AppAccountToken(),
+			FamilyShared:                p.FamilyShared(),
+		},
+	}
+}
+
+// rowStorage Storage and PurchaseGetter keeping rows, returned purchases are rebuilt with NewPurchase.
+type rowStorage struct {
+	mu   sync.Mutex
+	rows map[string]row
+}
+
+func (s *rowStorage) StorePurchases(ctx context.Context, sp []*validate.Purchase) ([]*validate.Purchase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var stored []*validate.Purchase
+	for _, p := range sp {
+		if _, ok := s.rows[p.IdempotencyKey()]; ok {
+			continue
+		}
+		p.SetCreateTime(time.Unix(1700000000, 0))
+		p.SetUpdateTime(time.Unix(1700000000, 0))
+		r := newRow(p)
+		s.rows[p.IdempotencyKey()] = r
+		stored = append(stored, validate.NewPurchase(r.userID, r.rawRequest, &r.vp))
+	}
+	return stored, nil
+}
+
+func (s *rowStorage) GetPurchases(ctx context.Context, sp []*validate.Purchase) ([]*validate.Purchase, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	var out []*validate.Purchase
+	for _, p := range sp {
+		if r, ok := s.rows[p.IdempotencyKey()]; ok {
+			out = append(out, validate.NewPurchase(r.userID, r.rawRequest, &r.vp))
+		}
+	}
+	return out, nil
+}
+
+func (s *rowStorage) StoreSubscriptionPurchases(ctx context.Context, sp []*validate.SubscriptionPurchase) ([]*validate.SubscriptionPurchase, error) {
+	return sp, nil
+}
+
+func (s *rowStorage) GetSubscriptionPurchases(ctx context.Context, sp []*validate.SubscriptionPurchase) ([]*validate.SubscriptionPurchase, error) {
+	return nil, nil
+}
+
+func TestNewPurchase(t *testing.T) {
+	canned := &validate.ValidatedPurchase{
+		ProductId:                   "coins",
+		TransactionId:               "GPA.1234",
+		PurchaseTime:                1690000000,
+		ProviderResponse:            `{"orderId":"GPA.1234"}`,
+		Environment:                 validate.PRODUCTION,
+		CancellationReason:          validate.CANCELLATION_REASON_USER_CANCELED,
+		CancellationTime:            1690000100,
+		StoreCancellationReason:     "1",
+		ObfuscatedExternalProfileId: "profile",
+		RegionCode:                  "TH",
+		ConsumptionState:            1,
+		AlreadyConsumed:             true,
+		ProductType:                 validate.PRODUCT_TYPE_CONSUMABLE,
+		PriceMicros:                 29000000,
+		Currency:                    "THB",
+		Quantity:                    2,
+		PurchaseToken:               "token-1",
+	}
+	storage := &rowStorage{rows: map[string]row{}}
+	v := &validate.Validate{Storage: storage, Idempotent: true, TestMode: map[string]*validate.ValidatedPurchase{"receipt": canned}}
+
+	first, err := v.PurchaseGoogle(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := v.PurchaseGoogle(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !second.AlreadyProcessed {
+		t.Fatal("resubmission not AlreadyProcessed")
+	}
+
+	want := *canned
+	want.Store = validate.GOOGLE_PLAY_STORE
+	want.CreateTime = 1700000000
+	want.UpdateTime = 1700000000
+	for _, resp := range []*validate.ValidatePurchaseResponse{first, second} {
+		if got := *resp.ValidatedPurchases[0]; !reflect.DeepEqual(got, want) {
+			t.Fatalf("purchase read back\n%+v\nwant\n%+v", got, want)
+		}
+	}
+	if got := storage.rows[validate.IdempotencyKey(validate.GOOGLE_PLAY_STORE, "GPA.1234")]; got.userID != "user" || got.rawRequest != "receipt" {
+		t.Fatalf("row %+v, want the user and receipt", got)
+	}
+}
+
+func TestHashRawRequest(t *testing.T) {
+	storage := &rowStorage{rows: map[string]row{}}
+	v := &validate.Validate{
+		Storage:        storage,
+		HashRawRequest: true,
+		TestMode:       map[string]*validate.ValidatedPurchase{"receipt": {ProductId: "coins", TransactionId: "1000"}},
+	}
+
+	if _, err := v.PurchasesApple(context.Background(), "user", "receipt"); err != nil {
+		t.Fatal(err)
+	}
+	got := storage.rows[validate.IdempotencyKey(validate.APPLE_APP_STORE, "1000")].rawRequest
+	if sum := sha256.Sum256([]byte("receipt")); got != hex.EncodeToString(sum[:]) {
+		t.Fatalf("stored raw request %q, want the hex SHA-256 of the receipt", got)
+	}
+	if b, err := hex.DecodeString(got); err != nil || len(got) != 64 || len(b) != sha256.Size {
+		t.Fatalf("stored raw request %q, want 64 hex characters", got)
+	}
+
+}
@@ -0,0 +1,86 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+)
+
+// RetryPolicy for Validate.PipelineRetry.
+type RetryPolicy struct {
+	// Attempts including the first call, 1 or less means no retry.
+	Attempts int
+	// Backoff before the first retry, doubled after every attempt.
+	Backoff time.Duration
+	// Retryable optional, default IsTransientError.
+	Retryable func(err error) bool
+}
+
+// IsTransientError errors worth retrying: store temporarily unavailable, timeouts and 5xx responses.
+func IsTransientError(err error) bool {
+	if errors.Is(err, ErrUnavailableTryAgain) ||
+		errors.Is(err, ErrAppleTimeout) ||
+		errors.Is(err, ErrGoogleTimeout) ||
+		errors.Is(err, ErrHuaweiTimeout) ||
+		errors.Is(err, iap.ErrAPITimeout) ||
+		errors.Is(err, iap.ErrTokenMintTimeout) ||
+		errors.Is(err, iap.ErrGoogleAuthUnavailable) {
+		return true
+	}
+
+	var appleErr *iap.AppleHTTPError
+	if errors.As(err, &appleErr) {
+		return appleErr.StatusCode >= 500
+	}
+	var googleErr *iap.GoogleHTTPError
+	return errors.As(err, &googleErr) && googleErr.StatusCode >= 500
+}
+
+// withPipelineRetry runs fn under PipelineRetry, the final error mentions the iap.WithRequestID ID of ctx.
+func (v *Validate) withPipelineRetry(ctx context.Context, fn func() (*ValidatePurchaseResponse, error)) (*ValidatePurchaseResponse, error) {
+	resp, err := v.retryPipeline(ctx, fn)
+	if err != nil {
+		if id := iap.RequestIDFromContext(ctx); len(id) > 0 {
+			return nil, fmt.Errorf("request_id %s: %w", id, err)
+		}
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (v *Validate) retryPipeline(ctx context.Context, fn func() (*ValidatePurchaseResponse, error)) (*ValidatePurchaseResponse, error) {
+	// a canceled call, e.g. during shutdown, doesn't reach the store or Storage.
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+
+	policy := v.PipelineRetry
+	if policy == nil || policy.Attempts <= 1 {
+		return fn()
+	}
+
+	retryable := policy.Retryable
+	if retryable == nil {
+		retryable = IsTransientError
+	}
+
+	log := iap.LoggerFromContext(iap.ContextWithLogger(ctx, v.logger()))
+	backoff := policy.Backoff
+	for attempt := 1; ; attempt++ {
+		resp, err := fn()
+		if err == nil || attempt >= policy.Attempts || !retryable(err) {
+			return resp, err
+		}
+
+		log.Debug("retrying validation", "attempt", attempt, "error", err)
+		select {
+		case <-ctx.Done():
+			return nil, err
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+}
@@ -0,0 +1,113 @@
+package validate
+
+import (
+	"time"
+)
+
+// expiresAt effective expiry of a subscription, zero time for other purchases.
+func (p *ValidatedPurchase) expiresAt() time.Time {
+	switch {
+	case p.EffectiveExpiresTime > 0:
+		return time.Unix(p.EffectiveExpiresTime, 0)
+	case p.ExpiresTime > 0:
+		return time.Unix(p.ExpiresTime, 0)
+	default:
+		return time.Time{}
+	}
+}
+
+// DaysRemaining whole days from now until the effective expiry (grace period included), rounded down
+// so a subscription expiring later today is 0 and one expired an hour ago is -1. 0 for non subscriptions.
+func (p *ValidatedPurchase) DaysRemaining(now time.Time) int {
+	expires := p.expiresAt()
+	if expires.IsZero() {
+		return 0
+	}
+
+	d := expires.Sub(now)
+	days := int(d / (24 * time.Hour))
+	if d < 0 && d%(24*time.Hour) != 0 {
+		days--
+	}
+	return days
+}
+
+// IsActive whether the user should have access to the subscription at now:
+//   - refunded or revoked (CANCELLATION_REASON_REFUNDED) is never active,
+//   - otherwise it's active until the effective expiry, which covers the paid period and a billing grace period,
+//   - a user cancellation only stops the renewal, access continues until the expiry,
+//   - billing retry past the grace period has no access.
+//
+// Purchases without an expiry are not subscriptions and are never active.
+func (p *ValidatedPurchase) IsActive(now time.Time) bool {
+	if p.CancellationReason == CANCELLATION_REASON_REFUNDED {
+		return false
+	}
+
+	expires := p.expiresAt()
+	return !expires.IsZero() && now.Before(expires)
+}
+
+// ActiveSubscription the active (see IsActive) validated purchase with the furthest effective expiry at at,
+// false when no subscription of the response is active.
+func (r *ValidatePurchaseResponse) ActiveSubscription(at time.Time) (*ValidatedPurchase, bool) {
+	var active *ValidatedPurchase
+	for _, p := range r.ActiveSubscriptions(at) {
+		if active == nil || p.expiresAt().After(active.expiresAt()) {
+			active = p
+		}
+	}
+	return active, active != nil
+}
+
+// ActiveSubscriptions ActiveSubscription per product, products without an active subscription are left out.
+func (r *ValidatePurchaseResponse) ActiveSubscriptions(at time.Time) map[string]*ValidatedPurchase {
+	active := make(map[string]*ValidatedPurchase)
+	for _, p := range r.ValidatedPurchases {
+		if !p.IsActive(at) {
+			continue
+		}
+		if a, ok := active[p.ProductId]; !ok || p.expiresAt().After(a.expiresAt()) {
+			active[p.ProductId] = p
+		}
+	}
+	return active
+}
+
+// IsExpired whether the subscription period, extended by a billing grace period, ended at or before at.
+// Entries without an expiry never expire.
+func (p *SubscriptionPurchase) IsExpired(at time.Time) bool {
+	expires := p.EffectiveExpiresTime
+	if expires.IsZero() {
+		expires = p.ExpiresTime
+	}
+	return !expires.IsZero() && !at.Before(expires)
+}
+
+// IsInGracePeriod whether at is within the billing grace period, past the expiry the renewal failed but the user
+// keeps access while the store retries it.
+func (p *SubscriptionPurchase) IsInGracePeriod(at time.Time) bool {
+	if p.GracePeriodExpiresTime.IsZero() || !at.Before(p.GracePeriodExpiresTime) {
+		return false
+	}
+	// Google already moved the expiry to the end of the grace period, Apple keeps the end of the paid period.
+	return !at.Before(p.ExpiresTime) || !p.ExpiresTime.Before(p.GracePeriodExpiresTime)
+}
+
+// latestPerProduct keeps the latest purchased entry of each product, in receipt order.
+func latestPerProduct(purchases []*SubscriptionPurchase) []*SubscriptionPurchase {
+	latest := make(map[string]*SubscriptionPurchase, len(purchases))
+	for _, p := range purchases {
+		if l, ok := latest[p.productId]; !ok || p.purchaseTime.After(l.purchaseTime) {
+			latest[p.productId] = p
+		}
+	}
+
+	out := make([]*SubscriptionPurchase, 0, len(latest))
+	for _, p := range purchases {
+		if latest[p.productId] == p {
+			out = append(out, p)
+		}
+	}
+	return out
+}
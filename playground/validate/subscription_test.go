@@ -0,0 +1,57 @@
+package validate_test
+
+import (
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+)
+
+func TestActiveSubscriptions(t *testing.T) {
+	now := time.Now()
+	unix := func(d time.Duration) int64 { return now.Add(d).Unix() }
+	resp := &validate.ValidatePurchaseResponse{ValidatedPurchases: []*validate.ValidatedPurchase{
+		{ProductId: "monthly", TransactionId: "1000", ExpiresTime: unix(-24 * time.Hour)},
+		{ProductId: "monthly", TransactionId: "1001", ExpiresTime: unix(24 * time.Hour)},
+		{ProductId: "monthly", TransactionId: "1002", ExpiresTime: unix(-time.Hour), EffectiveExpiresTime: unix(48 * time.Hour)},
+		{ProductId: "yearly", TransactionId: "2000", ExpiresTime: unix(300 * 24 * time.Hour), CancellationReason: validate.CANCELLATION_REASON_REFUNDED},
+		{ProductId: "weekly", TransactionId: "3000", ExpiresTime: unix(-time.Hour)},
+		{ProductId: "coins", TransactionId: "4000"},
+	}}
+
+	active := resp.ActiveSubscriptions(now)
+	if len(active) != 1 || active["monthly"] == nil || active["monthly"].TransactionId != "1002" {
+		t.Fatalf("active subscriptions %+v, want the monthly 1002 in its grace period only", active)
+	}
+	if p, ok := resp.ActiveSubscription(now); !ok || p.TransactionId != "1002" {
+		t.Fatalf("active subscription %+v %v, want 1002", p, ok)
+	}
+
+	// two days later every entry expired.
+	if active := resp.ActiveSubscriptions(now.Add(72 * time.Hour)); len(active) > 0 {
+		t.Fatalf("active subscriptions %+v, want none", active)
+	}
+	if p, ok := resp.ActiveSubscription(now.Add(72 * time.Hour)); ok {
+		t.Fatalf("active subscription %+v, want none", p)
+	}
+}
+
+func TestSubscriptionPurchaseIsInGracePeriod(t *testing.T) {
+	now := time.Now()
+	tests := []struct {
+		name string
+		p    validate.SubscriptionPurchase
+		want bool
+	}{
+		{name: "in grace period", p: validate.SubscriptionPurchase{ExpiresTime: now.Add(-time.Hour), GracePeriodExpiresTime: now.Add(time.Hour)}, want: true},
+		{name: "grace period over", p: validate.SubscriptionPurchase{ExpiresTime: now.Add(-2 * time.Hour), GracePeriodExpiresTime: now.Add(-time.Hour)}, want: false},
+		{name: "not expired yet", p: validate.SubscriptionPurchase{ExpiresTime: now.Add(time.Hour), GracePeriodExpiresTime: now.Add(2 * time.Hour)}, want: false},
+		{name: "google grace period", p: validate.SubscriptionPurchase{ExpiresTime: now.Add(time.Hour), GracePeriodExpiresTime: now.Add(time.Hour)}, want: true},
+		{name: "no grace period", p: validate.SubscriptionPurchase{ExpiresTime: now.Add(-time.Hour)}, want: false},
+	}
+	for _, tt := range tests {
+		if got := tt.p.IsInGracePeriod(now); got != tt.want {
+			t.Fatalf("%s: IsInGracePeriod %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package validate
+
+import (
+	"context"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+)
+
+// testModePurchase the TestMode canned response for receipt as validated by store, ErrFailedPrecondition
+// for a receipt without one.
+func (v *Validate) testModePurchase(log iap.Logger, store Store, receipt string) (*ValidatedPurchase, error) {
+	if v.ProductionService {
+		log.Error("test mode refused on a production service")
+		return nil, ErrTestModeProduction
+	}
+
+	vp, ok := v.TestMode[receipt]
+	if !ok || vp == nil {
+		log.Debug("test mode receipt without canned response")
+		return nil, ErrFailedPrecondition
+	}
+
+	canned := *vp
+	canned.Store = store
+	if canned.Environment == UNKNOWN {
+		canned.Environment = SANDBOX
+	}
+	// stamped by Storage.
+	canned.CreateTime, canned.UpdateTime = 0, 0
+	return &canned, nil
+}
+
+func (v *Validate) testModePurchases(ctx context.Context, log iap.Logger, userID string, store Store, receipt string) (*ValidatePurchaseResponse, error) {
+	vp, err := v.testModePurchase(log, store, receipt)
+	if err != nil {
+		return nil, err
+	}
+	return v.storePurchases(ctx, log, userID, []*Purchase{NewPurchase(userID, receipt, vp)}, []byte(vp.ProviderResponse))
+}
+
+func (v *Validate) testModeSubscriptionPurchases(ctx context.Context, log iap.Logger, userID string, store Store, receipt string) (*ValidatePurchaseResponse, error) {
+	vp, err := v.testModePurchase(log, store, receipt)
+	if err != nil {
+		return nil, err
+	}
+
+	sp := NewSubscriptionPurchase(userID, receipt, vp)
+	sp.productType = PRODUCT_TYPE_SUBSCRIPTION
+	return v.storeSubscriptionPurchases(ctx, log, userID, []*SubscriptionPurchase{sp}, []byte(vp.ProviderResponse))
+}
+
+// unixTime zero for 0, the ValidatedPurchase unset value.
+func unixTime(sec int64) time.Time {
+	if sec <= 0 {
+		return time.Time{}
+	}
+	return time.Unix(sec, 0)
+}
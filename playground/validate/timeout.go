@@ -0,0 +1,85 @@
+package validate
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+)
+
+var (
+	ErrAppleTimeout     = errors.New("Apple validation timed out")
+	ErrGoogleTimeout    = errors.New("Google validation timed out")
+	ErrHuaweiTimeout    = errors.New("Huawei validation timed out")
+	ErrMicrosoftTimeout = errors.New("Microsoft validation timed out")
+)
+
+// withStoreTimeout runs fn under the store timeout, an error caused by it is wrapped with the store timeout error.
+// The timeout bounds the provider requests only, Storage calls get the caller ctx back with storageContext.
+func (v *Validate) withStoreTimeout(ctx context.Context, store Store, fn func(ctx context.Context) (*ValidatePurchaseResponse, error)) (*ValidatePurchaseResponse, error) {
+	var timeout time.Duration
+	var timeoutErr error
+	switch store {
+	case APPLE_APP_STORE:
+		timeout, timeoutErr = v.AppleTimeout, ErrAppleTimeout
+	case GOOGLE_PLAY_STORE:
+		timeout, timeoutErr = v.GoogleTimeout, ErrGoogleTimeout
+	case HUAWEI_APP_GALLERY:
+		timeout, timeoutErr = v.HuaweiTimeout, ErrHuaweiTimeout
+	case MICROSOFT_STORE:
+		timeout, timeoutErr = v.MicrosoftTimeout, ErrMicrosoftTimeout
+	}
+	if timeout <= 0 {
+		return fn(ctx)
+	}
+
+	storeCtx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+	storeCtx = context.WithValue(storeCtx, callerContextKey{}, ctx)
+
+	resp, err := fn(storeCtx)
+	if err != nil && ctx.Err() == nil && errors.Is(storeCtx.Err(), context.DeadlineExceeded) {
+		return nil, &storeTimeoutError{timeout: timeoutErr, err: err}
+	}
+	return resp, err
+}
+
+// storeTimeoutError the store timeout error and the error fn failed with, errors.Is matches both
+// and errors.As finds the cause, e.g. a ValidationError.
+type storeTimeoutError struct {
+	timeout error
+	err     error
+}
+
+func (e *storeTimeoutError) Error() string {
+	return fmt.Sprintf("%v: %v", e.timeout, e.err)
+}
+
+func (e *storeTimeoutError) Is(target error) bool {
+	return e.timeout == target
+}
+
+func (e *storeTimeoutError) Unwrap() error {
+	return e.err
+}
+
+type callerContextKey struct{}
+
+// callerContext has the values of the ctx it was derived from and the deadline and cancellation of the
+// caller ctx withStoreTimeout got.
+type callerContext struct {
+	context.Context
+	values context.Context
+}
+
+func (c callerContext) Value(key interface{}) interface{} { return c.values.Value(key) }
+
+// storageContext ctx without the store timeout of withStoreTimeout, so a slow provider doesn't leave a
+// Storage write with no time left or cancel it half way.
+func storageContext(ctx context.Context) context.Context {
+	caller, ok := ctx.Value(callerContextKey{}).(context.Context)
+	if !ok {
+		return ctx
+	}
+	return callerContext{Context: caller, values: ctx}
+}
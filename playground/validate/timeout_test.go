@@ -0,0 +1,66 @@
+package validate_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+// slowStorage StorePurchases takes delay and fails when its ctx is done by then.
+type slowStorage struct {
+	*memory.InMemoryStorage
+	delay time.Duration
+}
+
+func (s slowStorage) StorePurchases(ctx context.Context, sp []*validate.Purchase) ([]*validate.Purchase, error) {
+	time.Sleep(s.delay)
+	if err := ctx.Err(); err != nil {
+		return nil, err
+	}
+	return s.InMemoryStorage.StorePurchases(ctx, sp)
+}
+
+func TestGoogleTimeout(t *testing.T) {
+	g := newTestGoogle(t)
+	release := make(chan struct{})
+	defer close(release)
+	g.mux.HandleFunc(googleProductPath, func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-release:
+		case <-r.Context().Done():
+		}
+	})
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), GoogleTimeout: 50 * time.Millisecond}
+	g.install(v)
+
+	_, err := v.PurchaseGoogle(context.Background(), "user", googleReceipt(t, "coins", "token-1", "GPA.1234-5678"))
+	if !errors.Is(err, validate.ErrGoogleTimeout) {
+		t.Fatalf("error %v, want ErrGoogleTimeout", err)
+	}
+	// the cause is kept.
+	if !errors.Is(err, iap.ErrAPITimeout) {
+		t.Fatalf("error %v, want the API timeout cause", err)
+	}
+}
+
+func TestStoreTimeoutExcludesStorage(t *testing.T) {
+	v := &validate.Validate{
+		Storage:      slowStorage{InMemoryStorage: memory.NewInMemoryStorage(), delay: 100 * time.Millisecond},
+		AppleTimeout: 50 * time.Millisecond,
+		TestMode:     map[string]*validate.ValidatedPurchase{"receipt": {ProductId: "coins", TransactionId: "1000"}},
+	}
+
+	resp, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(resp.ValidatedPurchases) != 1 {
+		t.Fatalf("%d validated purchases, want 1", len(resp.ValidatedPurchases))
+	}
+}
@@ -3,8 +3,11 @@ package validate
 import (
 	"context"
 	"errors"
+	"fmt"
 	"net/http"
 	"strconv"
+	"strings"
+	"sync"
 	"time"
 
 	"github.com/panuwattoa/in-app-purchase/iap"
@@ -18,6 +21,12 @@ const (
 	APPLE_APP_STORE Store = 0
 	// Google Play Store
 	GOOGLE_PLAY_STORE Store = 1
+	// Microsoft Store
+	MICROSOFT_STORE Store = 2
+	// Amazon Appstore
+	AMAZON_APP_STORE Store = 3
+	// Huawei AppGallery
+	HUAWEI_APP_GALLERY Store = 4
 )
 
 // Environment where the purchase took place
@@ -32,25 +41,127 @@ const (
 	PRODUCTION Environment = 2
 )
 
+// Why a purchase was canceled, normalized across stores.
+type CancellationReason int32
+
+const (
+	// Not canceled.
+	CANCELLATION_REASON_NONE CancellationReason = 0
+	// Canceled but the store did not say why.
+	CANCELLATION_REASON_UNKNOWN CancellationReason = 1
+	// User canceled the subscription.
+	CANCELLATION_REASON_USER_CANCELED CancellationReason = 2
+	// Canceled by the store because of a billing problem.
+	CANCELLATION_REASON_BILLING_ERROR CancellationReason = 3
+	// Subscription was replaced with a new subscription.
+	CANCELLATION_REASON_REPLACED CancellationReason = 4
+	// Canceled by the developer.
+	CANCELLATION_REASON_DEVELOPER_CANCELED CancellationReason = 5
+	// Refunded by the store.
+	CANCELLATION_REASON_REFUNDED CancellationReason = 6
+)
+
+// What kind of product was purchased, grant logic usually differs per type.
+type ProductType int32
+
+const (
+	// Not classified, e.g. purchases stored before the type was recorded.
+	PRODUCT_TYPE_UNKNOWN ProductType = 0
+	// Can be bought repeatedly, e.g. coins.
+	PRODUCT_TYPE_CONSUMABLE ProductType = 1
+	// Bought once and kept forever, e.g. remove ads.
+	PRODUCT_TYPE_NON_CONSUMABLE ProductType = 2
+	// Auto renewing or time limited access.
+	PRODUCT_TYPE_SUBSCRIPTION ProductType = 3
+)
+
 var (
 	ErrPurchasesListInvalidCursor = errors.New("purchases list cursor invalid")
 	ErrUnavailableTryAgain        = errors.New("Apple IAP verification is currently unavailable")
 	ErrFailedPrecondition         = errors.New("Invalid Receipt")
 	ErrPurchaseReceiptAlreadySeen = errors.New("Purchase Receipt Already Seen")
+	ErrReceiptTooLarge            = errors.New("Receipt Too Large")
+	ErrPurchaseTooOld             = errors.New("Purchase Too Old")
+	ErrPurchaseRefunded           = errors.New("Purchase Refunded")
+	ErrPurchasePending            = errors.New("Purchase Pending")
+	ErrUserMismatch               = errors.New("Purchase User Mismatch")
+	// ErrSubscriptionExpired SkipExpiredSubscriptions skipped every subscription entry of the receipt.
+	ErrSubscriptionExpired = errors.New("Subscription Expired")
+	// ErrSubscriptionStateUnsupported notifications are handled only when Storage implements SubscriptionStateUpdater.
+	ErrSubscriptionStateUnsupported = errors.New("Subscription State Updates Unsupported")
+	// ErrPurchasesListUnsupported ListPurchases needs a Storage implementing PurchaseLister.
+	ErrPurchasesListUnsupported = errors.New("Purchases List Unsupported")
+	// ErrTestModeProduction TestMode is refused on a Validate with ProductionService set.
+	ErrTestModeProduction = errors.New("Test Mode In Production Service")
 )
 
+// Apple verifyReceipt statuses, those caused by the receipt rather than the request or configuration
+// wrap ErrFailedPrecondition.
+var (
+	// 21000, the request to Apple was malformed.
+	ErrAppleBadRequest = errors.New("Apple Bad Request")
+	// 21002, the receipt data is not a receipt.
+	ErrAppleMalformedReceipt = fmt.Errorf("%w: Apple Malformed Receipt", ErrFailedPrecondition)
+	// 21004, the configured shared secret is wrong for the app.
+	ErrAppleSharedSecretMismatch = errors.New("Apple Shared Secret Mismatch")
+	// 21006, the receipt is valid but its subscription expired.
+	ErrAppleSubscriptionExpired = fmt.Errorf("%w: Apple Subscription Expired", ErrFailedPrecondition)
+	// 21008, a production receipt was sent to the sandbox, e.g. with AppleSandboxOnly.
+	ErrAppleProductionReceiptInSandbox = errors.New("Apple Production Receipt In Sandbox")
+	// 21010, the user account was not found or deleted.
+	ErrAppleAccountNotFound = fmt.Errorf("%w: Apple Account Not Found", ErrFailedPrecondition)
+	// The signed transaction or notification belongs to an app other than AppleCredentials.BundleID.
+	ErrAppleBundleMismatch = fmt.Errorf("%w: Apple Bundle Mismatch", ErrFailedPrecondition)
+	// The notification comes from an Apple environment this Validate doesn't serve.
+	ErrAppleEnvironmentMismatch = fmt.Errorf("%w: Apple Environment Mismatch", ErrFailedPrecondition)
+)
+
+// DefaultMaxReceiptBytes is used when Validate.MaxReceiptBytes is not set,
+// large enough for an Apple receipt with a long subscription history.
+const DefaultMaxReceiptBytes = 1 << 20
+
 type ValidatePurchaseResponse struct {
 	// Newly seen validated purchases.
 	ValidatedPurchases []*ValidatedPurchase `json:"validated_purchases,omitempty"`
+	// Subscription receipt validated without the Apple shared secret, auto renew and expiry are not reliable.
+	SubscriptionInfoUnavailable bool `json:"subscription_info_unavailable,omitempty"`
+	// The newly seen purchases are the first the user ever made, only set when Storage implements PurchaseCounter.
+	IsFirstPurchase bool `json:"is_first_purchase,omitempty"`
+	// Apple production rejected the receipt as sandbox (21007) and it was validated with the sandbox.
+	UsedSandboxFallback bool `json:"used_sandbox_fallback,omitempty"`
+	// OriginalPurchaseTime Apple only, when the app was first purchased or downloaded by the user according to the
+	// receipt, zero when Apple doesn't report it. Not in the JSON, a zero time.Time can't be omitted.
+	OriginalPurchaseTime time.Time `json:"-"`
+	// Purchases vetoed by Validate.MinPurchaseTime or Validate.PurchaseFilter, these were not stored.
+	RejectedPurchases []*RejectedPurchase `json:"rejected_purchases,omitempty"`
+	// Non fatal advisories about the validated purchases.
+	Warnings []Warning `json:"warnings,omitempty"`
+	// Every purchase was already seen, ValidatedPurchases are the previously stored ones. Validate.Idempotent
+	// or a Validate.ResultCache hit only.
+	AlreadyProcessed bool `json:"already_processed,omitempty"`
+	// Transaction IDs of the validated purchases Storage had already stored, not in ValidatedPurchases
+	// unless AlreadyProcessed.
+	SkippedTransactions []string `json:"skipped_transactions,omitempty"`
+}
+
+type RejectedPurchase struct {
+	TransactionId string `json:"transaction_id,omitempty"`
+	// Err ErrPurchaseTooOld or returned by PurchaseFilter.
+	Err    error  `json:"-"`
+	Reason string `json:"reason,omitempty"`
 }
 
 type ValidatedPurchase struct {
 	// Purchase Product ID.
 	ProductId string `json:"product_id,omitempty"`
-	// Purchase Transaction ID.
+	// Purchase Transaction ID. Google orderId for products, purchase token for subscriptions and test purchases
+	// without an orderId. Google products stored by earlier versions are keyed by the purchase token, a Storage
+	// holding those must implement TransactionChecker so their resubmissions are still detected, or rekey them.
 	TransactionId string `json:"transaction_id,omitempty"`
+	// Apple transaction ID of the first purchase, shared by the renewals and restores of it.
+	OriginalTransactionId string `json:"original_transaction_id,omitempty"`
 	// Store identifier
-	Store Store `json:"store,omitempty"`
+	Store Store `json:"store"`
 	// UNIX Timestamp when the purchase was done.
 	PurchaseTime int64 `json:"purchase_time,omitempty"`
 	// UNIX Timestamp when the receipt validation was stored in DB.
@@ -60,7 +171,63 @@ type ValidatedPurchase struct {
 	// Raw provider validation response.
 	ProviderResponse string `json:"provider_response,omitempty"`
 	// Whether the purchase was done in production or sandbox environment.
-	Environment Environment `json:"environment,omitempty"`
+	Environment Environment `json:"environment"`
+	// UNIX Timestamp of the very first purchase of the subscription, PurchaseTime is the current period start.
+	OriginalPurchaseTime int64 `json:"original_purchase_time,omitempty"`
+	// UNIX Timestamp when the subscription period ends, subscriptions only.
+	ExpiresTime int64 `json:"expires_time,omitempty"`
+	// UNIX Timestamp until the user should keep access, ExpiresTime extended by a billing grace period.
+	EffectiveExpiresTime int64 `json:"effective_expires_time,omitempty"`
+	// UNIX Timestamp when the billing grace period ends, only set while the store retries a failed renewal.
+	GracePeriodExpiresTime int64 `json:"grace_period_expires_time,omitempty"`
+	// Subscription renews at the end of the period.
+	AutoRenew bool `json:"auto_renew,omitempty"`
+	// How many times the subscription renewed, see SubscriptionPurchase.RenewalCount.
+	RenewalCount int `json:"renewal_count,omitempty"`
+	// Product the subscription renews into at ExpiresTime, Apple only.
+	AutoRenewProductId string `json:"auto_renew_product_id,omitempty"`
+	// Current subscription period is a free trial, Apple only.
+	IsTrialPeriod bool `json:"is_trial_period,omitempty"`
+	// Offer of the current subscription period, see SubscriptionPurchase.OfferType.
+	OfferType string `json:"offer_type,omitempty"`
+	// App Store storefront country code (e.g. USA) and identifier, empty for receipts validated with verifyReceipt.
+	Storefront   string `json:"storefront,omitempty"`
+	StorefrontId string `json:"storefront_id,omitempty"`
+	// Set for canceled or refunded purchases.
+	CancellationReason CancellationReason `json:"cancellation_reason"`
+	// UNIX Timestamp when the store canceled or refunded the purchase, entitlements should be revoked.
+	CancellationTime int64 `json:"cancellation_time,omitempty"`
+	// Store cancel code of a subscription, see SubscriptionPurchase.CancelReason.
+	CancelReason int `json:"cancel_reason,omitempty"`
+	// UNIX Timestamp when the user canceled the subscription renewal, Google only.
+	UserCancellationTime int64 `json:"user_cancellation_time,omitempty"`
+	// Google subscription paymentState, see SubscriptionPurchase.PaymentState.
+	PaymentState int `json:"payment_state,omitempty"`
+	// Store specific reason as returned by the store, e.g. Apple cancellation_reason 0 other, 1 app issue.
+	StoreCancellationReason string `json:"store_cancellation_reason,omitempty"`
+	// Google obfuscated profile ID set by the app at purchase time, empty when not provided.
+	ObfuscatedExternalProfileId string `json:"obfuscated_external_profile_id,omitempty"`
+	// Google consumable already consumed, it must not be granted again.
+	AlreadyConsumed bool `json:"already_consumed,omitempty"`
+	// Google ISO 3166-1 alpha-2 billing region of the user.
+	RegionCode string `json:"region_code,omitempty"`
+	// Google acknowledgementState, 0 yet to be acknowledged, 1 acknowledged.
+	AcknowledgementState int `json:"acknowledgement_state,omitempty"`
+	// Google consumptionState of products, 0 yet to be consumed, 1 consumed.
+	ConsumptionState int `json:"consumption_state,omitempty"`
+	// Consumable, non consumable or subscription.
+	ProductType ProductType `json:"product_type"`
+	// Google listed price in micros (e.g. 29000000 for 29.00) and ISO 4217 currency, as reported by the client.
+	PriceMicros int64  `json:"price_micros,omitempty"`
+	Currency    string `json:"currency,omitempty"`
+	// Units bought in the transaction, grant the product this many times. Apple, Amazon and Huawei only.
+	Quantity int `json:"quantity,omitempty"`
+	// Google purchase token, needed to acknowledge, consume or look up the purchase with Google.
+	PurchaseToken string `json:"purchase_token,omitempty"`
+	// UUID the app attached to the purchase with StoreKit 2 to link it to its user, Apple only.
+	AppAccountToken string `json:"app_account_token,omitempty"`
+	// Apple entitlement shared by a family member, not bought by the user.
+	FamilyShared bool `json:"family_shared,omitempty"`
 }
 
 type Purchase struct {
@@ -69,58 +236,376 @@ type Purchase struct {
 	productId     string
 	transactionId string
 	rawRequest    string
-	rawResponse   string
-	purchaseTime  time.Time
-	createTime    time.Time // Set by storePurchases
-	updateTime    time.Time // Set by storePurchases
-	environment   Environment
+	// rawRequest is already the RawRequestHash, Validate.HashRawRequest.
+	rawRequestHashed bool
+	rawResponse      string
+	purchaseTime     time.Time
+	createTime       time.Time // Set by Storage with SetCreateTime
+	updateTime       time.Time // Set by Storage with SetUpdateTime
+	environment      Environment
+	// Apple only, the same for every renewal or restore of a purchase.
+	originalTransactionId string
+	// CANCELLATION_REASON_NONE unless the store reports the purchase canceled or refunded.
+	cancellationReason      CancellationReason
+	cancellationTime        time.Time
+	storeCancellationReason string
+	// Apple signed transactions only.
+	storefront   string
+	storefrontId string
+	// Google purchase with acknowledgementState 0.
+	unacknowledged bool
+	// Apple in_app_ownership_type FAMILY_SHARED.
+	familyShared bool
+	// Google only, empty when the purchase was not tagged with a profile.
+	obfuscatedExternalProfileId string
+	// Google purchase with consumptionState 1.
+	consumed bool
+	// Google only.
+	regionCode           string
+	acknowledgementState int
+	consumptionState     int
+	productType          ProductType
+	// Units bought, Apple, Amazon and Huawei only, zero when the store doesn't report it.
+	quantity int
+	// Apple only, empty when the app didn't set one.
+	appAccountToken string
+	// Google only, differs from transactionId for products with an orderId.
+	purchaseToken string
+	// Google only, from the skuDetails the client sent with the receipt, not validated by Google.
+	priceMicros int64
+	currency    string
 }
 
 type SubscriptionPurchase struct {
 	Purchase
-	AutoRenew   bool
-	ExpiresTime time.Time
+	AutoRenew bool
+	// AutoRenewProductId product of the next period, Apple only, differs from productId after a scheduled downgrade.
+	AutoRenewProductId string
+	// OriginalPurchaseTime first ever start of the subscription, purchaseTime is the current period start.
+	OriginalPurchaseTime time.Time
+	ExpiresTime          time.Time
+	// EffectiveExpiresTime is when access should end, ExpiresTime extended by a billing grace period.
+	EffectiveExpiresTime time.Time
+	// GracePeriodExpiresTime end of the billing grace period, zero unless the store is retrying a failed renewal
+	// within one, see IsInGracePeriod.
+	GracePeriodExpiresTime time.Time
+	// PaymentState Google only, 0 pending, 1 received, 2 free trial, 3 pending deferred upgrade/downgrade.
+	PaymentState int
+	// CancelReason store code behind CancellationReason(), only meaningful when that is not CANCELLATION_REASON_NONE.
+	// Google cancelReason 0 user, 1 system (billing), 2 replaced, 3 developer.
+	// Apple expiration_intent 1 user, 2 billing error, 3 price increase declined, 4 product unavailable, 5 unknown.
+	CancelReason int
+	// UserCancellationTime when the user canceled the renewal, Google only, zero when not user canceled.
+	UserCancellationTime time.Time
+	// IsTrialPeriod and OfferType Apple only, the current period is a free trial, OfferType is one of the
+	// iap.AppleOfferType consts, empty for a full price period.
+	IsTrialPeriod bool
+	OfferType     string
+	// RenewalCount how many times the subscription renewed. Apple counts the transactions of the subscription
+	// in the receipt so it's only accurate when the full history is returned, Google reads it from the orderId suffix.
+	RenewalCount int
 }
 
 type Validate struct {
-	Storage Storage
-	// ApplePassword optional
+	Storage     Storage
+	Credentials Credentials
+	// ApplePassword optional, used when Credentials.Apple.Password is empty.
+	//
+	// Deprecated: use Credentials.Apple.Password.
 	ApplePassword string
-	GoogleConfig  IAPGoogleConfig
+	// GoogleConfig optional, used when Credentials.Google has no ClientEmail.
+	//
+	// Deprecated: use Credentials.Google.
+	GoogleConfig IAPGoogleConfig
+	// HTTPClient optional, used for the store APIs, default a client with a 5 seconds timeout.
+	HTTPClient *http.Client
+	// AppleTimeout, GoogleTimeout, HuaweiTimeout and MicrosoftTimeout optional, bound each Apple, Google, Huawei
+	// or Microsoft Purchase* and Check* call independently of the HTTPClient timeout, hitting them returns
+	// ErrAppleTimeout, ErrGoogleTimeout, ErrHuaweiTimeout or ErrMicrosoftTimeout.
+	AppleTimeout     time.Duration
+	GoogleTimeout    time.Duration
+	HuaweiTimeout    time.Duration
+	MicrosoftTimeout time.Duration
+	// Logger optional, each Purchase* method derives a child logger carrying user_id and store, the
+	// iap.WithRequestID request_id of ctx, and per purchase transaction_id and environment. The iap calls log to it too.
+	Logger iap.Logger
+	// MaxReceiptBytes bigger receipts are rejected with ErrReceiptTooLarge before decoding or sending them,
+	// default DefaultMaxReceiptBytes.
+	MaxReceiptBytes int
+	// EventSink optional, default none.
+	EventSink EventSink
+	// PurchaseFilter optional, runs on each provider validated purchase before it is stored.
+	// A purchase it returns an error for is not stored and is reported in RejectedPurchases.
+	PurchaseFilter func(ctx context.Context, p *Purchase) error
+	// MinPurchaseTime optional, purchases made before it are not stored and are reported in RejectedPurchases
+	// with ErrPurchaseTooOld, the call fails with ErrPurchaseTooOld when all of them are. Apple receipts are
+	// checked per in_app entry.
+	MinPurchaseTime time.Time
+	// NonConsumableProductIds optional, Apple and Google receipts don't tell consumables from non consumables,
+	// products in it are classified PRODUCT_TYPE_NON_CONSUMABLE, other non subscription products PRODUCT_TYPE_CONSUMABLE.
+	NonConsumableProductIds map[string]bool
+	// ProductionService warn about sandbox purchases with WARNING_SANDBOX_PURCHASE.
+	ProductionService bool
+	// ExpiryWarningWindow default DefaultExpiryWarningWindow.
+	ExpiryWarningWindow time.Duration
+	// PipelineRetry optional, retries a whole Purchase* call, provider validation and storage, or Check* call on
+	// transient errors.
+	// Storage must be idempotent, a retried call stores the same purchases again.
+	PipelineRetry *RetryPolicy
+	// AppleRetry optional, retries verifyReceipt while Apple answers is-retryable, or 503 with RetryUnavailable,
+	// before giving up with ErrUnavailableTryAgain.
+	AppleRetry iap.AppleRetryPolicy
+	// ApplePurchaseExcludeOldTransactions and AppleSubscriptionExcludeOldTransactions optional, exclude-old-transactions
+	// of the purchase and of the subscription validation, see iap.AppleOptions.ExcludeOldTransactions.
+	ApplePurchaseExcludeOldTransactions     *bool
+	AppleSubscriptionExcludeOldTransactions *bool
+	// AppleProductionUrl and AppleSandboxUrl optional, verifyReceipt URLs overrides, see iap.AppleOptions.
+	AppleProductionUrl string
+	AppleSandboxUrl    string
+	// AppleProductionOnly and AppleSandboxOnly optional, see iap.AppleOptions.
+	AppleProductionOnly bool
+	AppleSandboxOnly    bool
+	// GoogleAPIUrl optional, Android Publisher API base URL override, see iap.GoogleOptions.BaseUrl.
+	GoogleAPIUrl string
+	// AutoAcknowledge acknowledge unacknowledged Google purchases once Storage stored them,
+	// Google refunds purchases not acknowledged within 3 days.
+	AutoAcknowledge bool
+	// SkipExpiredSubscriptions Apple subscription entries expired at validation time are not stored or returned,
+	// the call fails with ErrSubscriptionExpired when all of them are.
+	SkipExpiredSubscriptions bool
+	// LatestSubscriptionOnly only the latest Apple subscription entry of each product is stored and returned,
+	// not every renewal in the receipt.
+	LatestSubscriptionOnly bool
+	// Idempotent return the previously stored purchases with AlreadyProcessed instead of
+	// ErrPurchaseReceiptAlreadySeen when a receipt is resubmitted, Storage must implement PurchaseGetter.
+	Idempotent bool
+	// FollowLinkedTokens optional, Google subscriptions also return the purchases of the linkedPurchaseToken chain
+	// left behind by upgrades, downgrades and resubscribes, newest first.
+	FollowLinkedTokens bool
+	// DryRun validate with the store and return the purchases without touching Storage, for testing against
+	// the sandbox. Nothing is stored, acknowledged or emitted and CreateTime/UpdateTime are zero.
+	DryRun bool
+	// TestMode optional, canned purchases by receipt for local development without store credentials.
+	// PurchasesApple, PurchaseGoogle and their subscription variants store and return the canned purchase of the
+	// receipt without any store request, a receipt not in it fails with ErrFailedPrecondition. The Amazon, Huawei
+	// and Microsoft paths and the signed Apple transactions are always validated with the store.
+	// Refused with ErrTestModeProduction when ProductionService is set.
+	TestMode map[string]*ValidatedPurchase
+	// HashRawRequest Storage gets the hex SHA-256 of the receipt as RawRequest instead of the receipt itself,
+	// which can be tens of KB and is sensitive, see Purchase.RawRequestHash.
+	HashRawRequest bool
+	// AppAccountTokenChecker optional, called for Apple purchases carrying an appAccountToken with the userID of
+	// the Purchase* call, returning false fails the call with ErrUserMismatch and nothing is stored.
+	AppAccountTokenChecker func(ctx context.Context, userID, appAccountToken string) bool
+	// ResultCache optional, Purchase* calls return the response of a receipt the user submitted within its TTL,
+	// or that a concurrent call is validating, with AlreadyProcessed set instead of validating it again.
+	ResultCache *ResultCache
+	// MicrosoftCerts optional, caches the Microsoft receipt signing certificates, default a cache per HTTPClient
+	// fetching with it.
+	MicrosoftCerts *iap.MicrosoftCertCache
+	// AppleRootCerts optional, verifies the signed Apple transactions and notifications, default iap.DefaultAppleRootCerts.
+	AppleRootCerts *iap.AppleRootCertCache
 }
 
 type IAPGoogleConfig struct {
 	ClientEmail string `json:"client_email" usage:"Google Service Account client email."`
 	PrivateKey  string `json:"private_key" usage:"Google Service Account private key."`
+	ProjectID   string `json:"project_id" usage:"Google Cloud project of the Service Account."`
+	// PublicKey optional, when set receipts must carry a valid signature by this base64 app license key.
+	PublicKey string `json:"public_key" usage:"Google Play app license key, base64 encoded."`
+}
+
+func (v *Validate) googleOptions(gc IAPGoogleConfig) iap.GoogleOptions {
+	return iap.GoogleOptions{PublicKey: gc.PublicKey, BaseUrl: v.GoogleAPIUrl}
 }
 
+// Storage persists validated purchases. Purchases are unique by IdempotencyKey (store and transaction ID):
+// StorePurchases and StoreSubscriptionPurchases must store a key at most once and return only the purchases
+// they newly stored, a resubmitted purchase is left as is and not returned.
 type Storage interface {
 	StorePurchases(ctx context.Context, sp []*Purchase) ([]*Purchase, error)
 	StoreSubscriptionPurchases(ctx context.Context, sp []*SubscriptionPurchase) ([]*SubscriptionPurchase, error)
 }
 
+// PurchaseGetter optional, needed by Validate.Idempotent.
+type PurchaseGetter interface {
+	// GetPurchases returns the stored purchases matching the store and transaction ID of sp.
+	GetPurchases(ctx context.Context, sp []*Purchase) ([]*Purchase, error)
+	GetSubscriptionPurchases(ctx context.Context, sp []*SubscriptionPurchase) ([]*SubscriptionPurchase, error)
+}
+
+// TransactionChecker optional, when Storage implements it PurchasesApple and PurchaseGoogle skip the store
+// validation of receipts whose transactions are all already stored and return ErrPurchaseReceiptAlreadySeen.
+// PurchaseGoogle checks the receipt orderId and the purchase token, the transaction ID of test purchases and
+// of the purchases stored before the orderId was used.
+// Not consulted with Validate.Idempotent.
+type TransactionChecker interface {
+	SeenTransaction(ctx context.Context, store Store, transactionID string) (bool, error)
+}
+
+// SubscriptionStateUpdater optional, needed by HandleAppleNotification and HandleGoogleNotification.
+type SubscriptionStateUpdater interface {
+	// UpdateSubscriptionState applies a state change to the stored subscription purchases matching update,
+	// see SubscriptionState for the transitions. An update for a subscription that isn't stored yet should
+	// not be an error, notifications can arrive first.
+	UpdateSubscriptionState(ctx context.Context, update *SubscriptionStateUpdate) error
+}
+
+// PurchaseLister optional, needed by Validate.ListPurchases.
+type PurchaseLister interface {
+	// ListPurchases returns up to limit purchases of the user ordered by create time then transaction ID,
+	// starting after cursor (empty for the first page), and the cursor of the next page, empty on the last page.
+	// Cursors are built with PurchasesCursor.Encode.
+	ListPurchases(ctx context.Context, userID string, limit int, cursor string) ([]*Purchase, string, error)
+}
+
+// PurchaseCounter optional, when Storage implements it the response reports IsFirstPurchase.
+type PurchaseCounter interface {
+	// CountUserPurchases returns how many purchases are stored for the user across all stores.
+	CountUserPurchases(ctx context.Context, userID string) (int, error)
+}
+
 func NewValidate(sg Storage, applePassword string, gc IAPGoogleConfig) *Validate {
 	return &Validate{
-		Storage:       sg,
-		ApplePassword: applePassword,
-		GoogleConfig:  gc,
+		Storage: sg,
+		Credentials: Credentials{
+			Apple:  AppleCredentials{Password: applePassword},
+			Google: gc,
+		},
+	}
+}
+
+func NewValidateWithCredentials(sg Storage, c Credentials) *Validate {
+	return &Validate{
+		Storage:     sg,
+		Credentials: c,
 	}
 }
 
 var httpc = &http.Client{Timeout: 5 * time.Second}
 
+// microsoftCerts default Validate.MicrosoftCerts by HTTP client, so the certificates outlive the Purchase* calls.
+var microsoftCerts sync.Map
+
+func (v *Validate) httpClient() *http.Client {
+	if v.HTTPClient == nil {
+		return httpc
+	}
+	return v.HTTPClient
+}
+
+func (v *Validate) microsoftCerts() *iap.MicrosoftCertCache {
+	if v.MicrosoftCerts != nil {
+		return v.MicrosoftCerts
+	}
+	httpc := v.httpClient()
+	certs, _ := microsoftCerts.LoadOrStore(httpc, &iap.MicrosoftCertCache{HTTPClient: httpc})
+	return certs.(*iap.MicrosoftCertCache)
+}
+
+// appleRootCerts ctx verifying the Apple JWS against AppleRootCerts when set.
+func (v *Validate) appleRootCerts(ctx context.Context) context.Context {
+	if v.AppleRootCerts == nil {
+		return ctx
+	}
+	return iap.ContextWithAppleRootCerts(ctx, v.AppleRootCerts)
+}
+
+func (v *Validate) appleOptions(ctx context.Context) iap.AppleOptions {
+	opts := iap.AppleOptions{
+		Retry:          v.AppleRetry,
+		ProductionUrl:  v.AppleProductionUrl,
+		SandboxUrl:     v.AppleSandboxUrl,
+		ProductionOnly: v.AppleProductionOnly,
+		SandboxOnly:    v.AppleSandboxOnly,
+	}
+	switch env, _ := ctx.Value(appleEnvironmentKey{}).(Environment); env {
+	case SANDBOX:
+		opts.ProductionOnly, opts.SandboxOnly = false, true
+	case PRODUCTION:
+		opts.ProductionOnly, opts.SandboxOnly = true, false
+	}
+	return opts
+}
+
+type appleEnvironmentKey struct{}
+
+// WithAppleEnvironment returns a ctx making the Apple Purchases* calls validate with env only, overriding
+// AppleProductionOnly and AppleSandboxOnly for that call. SANDBOX skips the production round trip, e.g. for a
+// receipt from a known QA build, UNKNOWN keeps the production first with sandbox fallback default.
+func WithAppleEnvironment(ctx context.Context, env Environment) context.Context {
+	return context.WithValue(ctx, appleEnvironmentKey{}, env)
+}
+
+func (v *Validate) checkReceiptSize(receipt string) error {
+	max := v.MaxReceiptBytes
+	if max <= 0 {
+		max = DefaultMaxReceiptBytes
+	}
+
+	if len(receipt) > max {
+		return ErrReceiptTooLarge
+	}
+	return nil
+}
+
+func (v *Validate) logger() iap.Logger {
+	if v.Logger == nil {
+		return iap.NopLogger()
+	}
+	return v.Logger
+}
+
+// purchaseLogger log carrying the transaction_id and environment of a purchase, for every line about it.
+func purchaseLogger(log iap.Logger, transactionID string, environment Environment) iap.Logger {
+	return log.With("transaction_id", transactionID, "environment", environment)
+}
+
 func (v *Validate) PurchasesApple(ctx context.Context, userID, receipt string) (*ValidatePurchaseResponse, error) {
-	validation, raw, err := iap.ValidateReceiptApple(ctx, httpc, receipt, "")
-	if err != nil {
+	return v.withResultCache(ctx, "PurchasesApple", userID, []string{receipt}, func() (*ValidatePurchaseResponse, error) {
+		return v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+			return v.withStoreTimeout(ctx, APPLE_APP_STORE, func(ctx context.Context) (*ValidatePurchaseResponse, error) {
+				return v.purchasesApple(ctx, userID, receipt)
+			})
+		})
+	})
+}
+
+func (v *Validate) purchasesApple(ctx context.Context, userID, receipt string) (*ValidatePurchaseResponse, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("user_id", userID, "store", APPLE_APP_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	if err := v.checkReceiptSize(receipt); err != nil {
 		return nil, err
 	}
 
+	if v.TestMode != nil {
+		return v.testModePurchases(ctx, log, userID, APPLE_APP_STORE, receipt)
+	}
+
+	// a receipt that can't be read locally is left to Apple to reject.
+	if lr, err := iap.ParseAppleReceiptLocal(receipt); err == nil {
+		seen, err := v.allTransactionsSeen(ctx, APPLE_APP_STORE, lr.InAppTransactionIDs)
+		if err != nil {
+			return nil, err
+		}
+		if seen {
+			log.Debug("purchase receipt already seen")
+			return nil, ErrPurchaseReceiptAlreadySeen
+		}
+	}
+
+	opts := v.appleOptions(ctx)
+	opts.ExcludeOldTransactions = v.ApplePurchaseExcludeOldTransactions
+	validation, raw, err := iap.ValidateReceiptAppleWithOptions(ctx, v.httpClient(), receipt, "", opts)
+	if err != nil {
+		return nil, appleValidationError(err)
+	}
+
 	if validation.Status != iap.AppleReceiptIsValid {
 		// TODO: log to DB
-		if validation.IsRetryable == true {
-			return nil, ErrUnavailableTryAgain
-		}
-		return nil, ErrFailedPrecondition
+		log.Debug("apple receipt invalid", "status", validation.Status, "is_retryable", validation.IsRetryable)
+		return nil, &ValidationError{Store: APPLE_APP_STORE, ProviderStatus: validation.Status, ProviderResponse: raw, Err: appleStatusError(validation)}
 	}
 
 	env := PRODUCTION
@@ -134,6 +619,10 @@ func (v *Validate) PurchasesApple(ctx context.Context, userID, receipt string) (
 		if err != nil {
 			return nil, err
 		}
+		cancellationTime, err := appleCancellationTime(purchase)
+		if err != nil {
+			return nil, err
+		}
 
 		storagePurchases = append(storagePurchases, &Purchase{
 			userID:        userID,
@@ -144,87 +633,177 @@ func (v *Validate) PurchasesApple(ctx context.Context, userID, receipt string) (
 			rawRequest:    receipt,
 			purchaseTime:  parseMillisecondUnixTimestamp(pt),
 			environment:   env,
+
+			originalTransactionId:   purchase.OriginalTransactionID,
+			cancellationReason:      appleCancellationReason(purchase),
+			cancellationTime:        cancellationTime,
+			storeCancellationReason: purchase.CancellationReason,
+			familyShared:            purchase.InAppOwnershipType == iap.AppleOwnershipFamilyShared,
+			productType:             v.appleProductType(purchase),
+			quantity:                purchase.Quantity,
+			appAccountToken:         purchase.AppAccountToken,
 		})
 	}
 
-	purchases, err := v.Storage.StorePurchases(ctx, storagePurchases)
+	resp, err := v.storePurchases(ctx, log, userID, storagePurchases, raw)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(purchases) < 1 {
-		return nil, ErrPurchaseReceiptAlreadySeen
-	}
+	resp.UsedSandboxFallback = validation.UsedSandboxFallback
+	resp.OriginalPurchaseTime = appleReceiptOriginalPurchaseTime(validation.Receipt)
+	return resp, nil
+}
 
-	validatedPurchases := make([]*ValidatedPurchase, 0, len(purchases))
-	for _, p := range purchases {
-		validatedPurchases = append(validatedPurchases, &ValidatedPurchase{
-			ProductId:        p.productId,
-			TransactionId:    p.transactionId,
-			Store:            p.store,
-			PurchaseTime:     p.purchaseTime.Unix(),
-			CreateTime:       p.createTime.Unix(),
-			UpdateTime:       p.updateTime.Unix(),
-			ProviderResponse: string(raw),
-			Environment:      p.environment,
+func (v *Validate) PurchaseGoogle(ctx context.Context, userID string, receipt string) (*ValidatePurchaseResponse, error) {
+	return v.withResultCache(ctx, "PurchaseGoogle", userID, []string{receipt}, func() (*ValidatePurchaseResponse, error) {
+		return v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+			return v.withStoreTimeout(ctx, GOOGLE_PLAY_STORE, func(ctx context.Context) (*ValidatePurchaseResponse, error) {
+				return v.purchaseGoogle(ctx, userID, receipt)
+			})
 		})
+	})
+}
+
+func (v *Validate) purchaseGoogle(ctx context.Context, userID string, receipt string) (*ValidatePurchaseResponse, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("user_id", userID, "store", GOOGLE_PLAY_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	if err := v.checkReceiptSize(receipt); err != nil {
+		return nil, err
 	}
 
-	return &ValidatePurchaseResponse{
-		ValidatedPurchases: validatedPurchases,
-	}, nil
-}
+	if v.TestMode != nil {
+		return v.testModePurchases(ctx, log, userID, GOOGLE_PLAY_STORE, receipt)
+	}
 
-func (v *Validate) PurchaseGoogle(ctx context.Context, userID string, receipt string) (*ValidatePurchaseResponse, error) {
-	_, gReceipt, raw, err := iap.ValidateReceiptGoogle(ctx, httpc, v.GoogleConfig.ClientEmail, v.GoogleConfig.PrivateKey, receipt)
+	gc, err := v.credentials().resolveGoogleReceipt(receipt)
 	if err != nil {
 		return nil, err
 	}
-	purchases, err := v.Storage.StorePurchases(ctx, []*Purchase{
+
+	// The purchase is stored under the orderId Google returns, the receipt one is unverified but a forged one
+	// can only turn this request into ErrPurchaseReceiptAlreadySeen. Purchases stored by earlier versions and
+	// test purchases without an orderId are keyed by the purchase token.
+	if gr, err := iap.DecodeReceiptGoogle(receipt); err == nil {
+		ids := []string{gr.PurchaseToken}
+		if len(gr.OrderID) > 0 {
+			ids = append([]string{gr.OrderID}, ids...)
+		}
+		for _, id := range ids {
+			seen, err := v.allTransactionsSeen(ctx, GOOGLE_PLAY_STORE, []string{id})
+			if err != nil {
+				return nil, err
+			}
+			if seen {
+				log.Debug("purchase receipt already seen")
+				return nil, ErrPurchaseReceiptAlreadySeen
+			}
+		}
+	}
+
+	g, gReceipt, raw, err := iap.ValidateReceiptGoogleWithOptions(ctx, v.httpClient(), gc.ClientEmail, gc.PrivateKey, receipt, v.googleOptions(gc))
+	if err != nil {
+		return nil, googleValidationError(err)
+	}
+
+	switch g.PurchaseState {
+	case 1:
+		purchaseLogger(log, googleTransactionId(g.OrderId, gReceipt.PurchaseToken), googleEnvironment(g.IsTestPurchase)).Debug("google purchase canceled", "purchase_state", g.PurchaseState)
+		return nil, &ValidationError{Store: GOOGLE_PLAY_STORE, ProviderStatus: g.PurchaseState, ProviderResponse: raw, Err: ErrPurchaseRefunded}
+	case 2:
+		// must not be granted until the payment completes.
+		purchaseLogger(log, googleTransactionId(g.OrderId, gReceipt.PurchaseToken), googleEnvironment(g.IsTestPurchase)).Debug("google purchase pending", "purchase_state", g.PurchaseState)
+		return nil, &ValidationError{Store: GOOGLE_PLAY_STORE, ProviderStatus: g.PurchaseState, ProviderResponse: raw, Err: ErrPurchasePending}
+	}
+
+	unacknowledged := g.AcknowledgementState == 0
+	storagePurchases := []*Purchase{
 		{
 			userID:        userID,
 			store:         GOOGLE_PLAY_STORE,
 			productId:     gReceipt.ProductID,
-			transactionId: gReceipt.PurchaseToken,
+			transactionId: googleTransactionId(g.OrderId, gReceipt.PurchaseToken),
 			rawRequest:    receipt,
 			rawResponse:   string(raw),
 			purchaseTime:  parseMillisecondUnixTimestamp(int(gReceipt.PurchaseTime)),
-			environment:   UNKNOWN,
+			environment:   googleEnvironment(g.IsTestPurchase),
+
+			purchaseToken:               gReceipt.PurchaseToken,
+			unacknowledged:              unacknowledged,
+			obfuscatedExternalProfileId: g.ObfuscatedExternalProfileId,
+			consumed:                    g.AlreadyConsumed,
+			regionCode:                  g.RegionCode,
+			acknowledgementState:        acknowledgementState(unacknowledged),
+			consumptionState:            g.ConsumptionState,
+			productType:                 v.productType(gReceipt.ProductID),
+			priceMicros:                 googlePriceMicros(gReceipt),
+			currency:                    googleCurrency(gReceipt),
 		},
-	})
+	}
+
+	resp, err := v.storePurchases(ctx, log, userID, storagePurchases, raw)
 	if err != nil {
 		return nil, err
 	}
+	v.autoAcknowledgeGoogle(log, resp, gReceipt.PurchaseToken, func() error {
+		return iap.AcknowledgeProductGoogleWithOptions(ctx, v.httpClient(), gc.ClientEmail, gc.PrivateKey, gReceipt.PackageName, gReceipt.ProductID, gReceipt.PurchaseToken, v.googleOptions(gc))
+	})
+	return resp, nil
+}
 
-	if len(purchases) < 1 {
-		return nil, ErrPurchaseReceiptAlreadySeen
+func (v *Validate) PurchaseSubscriptionGoogle(ctx context.Context, userID string, receipt string) (*ValidatePurchaseResponse, error) {
+	return v.withResultCache(ctx, "PurchaseSubscriptionGoogle", userID, []string{receipt}, func() (*ValidatePurchaseResponse, error) {
+		return v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+			return v.withStoreTimeout(ctx, GOOGLE_PLAY_STORE, func(ctx context.Context) (*ValidatePurchaseResponse, error) {
+				return v.purchaseSubscriptionGoogle(ctx, userID, receipt)
+			})
+		})
+	})
+}
+
+func (v *Validate) purchaseSubscriptionGoogle(ctx context.Context, userID string, receipt string) (*ValidatePurchaseResponse, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("user_id", userID, "store", GOOGLE_PLAY_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	if err := v.checkReceiptSize(receipt); err != nil {
+		return nil, err
 	}
 
-	validatedPurchases := make([]*ValidatedPurchase, 0, len(purchases))
-	for _, p := range purchases {
-		validatedPurchases = append(validatedPurchases, &ValidatedPurchase{
-			ProductId:        p.productId,
-			TransactionId:    p.transactionId,
-			Store:            p.store,
-			PurchaseTime:     p.purchaseTime.Unix(),
-			CreateTime:       p.createTime.Unix(),
-			UpdateTime:       p.updateTime.Unix(),
-			ProviderResponse: string(raw),
-			Environment:      p.environment,
-		})
+	if v.TestMode != nil {
+		return v.testModeSubscriptionPurchases(ctx, log, userID, GOOGLE_PLAY_STORE, receipt)
 	}
 
-	return &ValidatePurchaseResponse{
-		ValidatedPurchases: validatedPurchases,
-	}, nil
-}
+	storagePurchases, gc, gReceipt, raw, err := v.validateSubscriptionGoogle(ctx, userID, receipt)
+	if err != nil {
+		return nil, err
+	}
 
-func (v *Validate) PurchaseSubscriptionGoogle(ctx context.Context, userID string, receipt string) (*ValidatePurchaseResponse, error) {
-	g, gReceipt, raw, err := iap.ValidateSubscriptionReceiptGoogle(ctx, httpc, v.GoogleConfig.ClientEmail, v.GoogleConfig.PrivateKey, receipt)
+	resp, err := v.storeSubscriptionPurchases(ctx, log, userID, storagePurchases, raw)
 	if err != nil {
 		return nil, err
 	}
-	purchases, err := v.Storage.StoreSubscriptionPurchases(ctx, []*SubscriptionPurchase{
+	v.autoAcknowledgeGoogle(log, resp, gReceipt.PurchaseToken, func() error {
+		return iap.AcknowledgeSubscriptionGoogleWithOptions(ctx, v.httpClient(), gc.ClientEmail, gc.PrivateKey, gReceipt.PackageName, gReceipt.ProductID, gReceipt.PurchaseToken, v.googleOptions(gc))
+	})
+	return resp, nil
+}
+
+// validateSubscriptionGoogle validates the receipt with Google, it also returns the credentials and the receipt it used.
+func (v *Validate) validateSubscriptionGoogle(ctx context.Context, userID, receipt string) ([]*SubscriptionPurchase, IAPGoogleConfig, *iap.ReceiptGoogle, []byte, error) {
+	gc, err := v.credentials().resolveGoogleReceipt(receipt)
+	if err != nil {
+		return nil, IAPGoogleConfig{}, nil, nil, err
+	}
+
+	g, gReceipt, raw, err := iap.ValidateSubscriptionReceiptGoogleWithOptions(ctx, v.httpClient(), gc.ClientEmail, gc.PrivateKey, receipt, v.googleOptions(gc))
+	if err != nil {
+		return nil, IAPGoogleConfig{}, nil, nil, googleValidationError(err)
+	}
+
+	unacknowledged := g.AcknowledgementState == 0
+
+	storagePurchases := []*SubscriptionPurchase{
 		{
 			Purchase: Purchase{
 				userID:        userID,
@@ -234,51 +813,140 @@ func (v *Validate) PurchaseSubscriptionGoogle(ctx context.Context, userID string
 				rawRequest:    receipt,
 				rawResponse:   string(raw),
 				purchaseTime:  parseMillisecondUnixTimestamp(int(gReceipt.PurchaseTime)),
-				environment:   UNKNOWN,
+				environment:   googleEnvironment(g.IsTestPurchase),
+
+				purchaseToken:               gReceipt.PurchaseToken,
+				cancellationReason:          googleCancellationReason(g.IsCanceled, g.CancelReason),
+				unacknowledged:              unacknowledged,
+				obfuscatedExternalProfileId: g.ObfuscatedExternalProfileId,
+				regionCode:                  g.CountryCode,
+				acknowledgementState:        acknowledgementState(unacknowledged),
+				productType:                 PRODUCT_TYPE_SUBSCRIPTION,
+				priceMicros:                 googlePriceMicros(gReceipt),
+				currency:                    googleCurrency(gReceipt),
 			},
-			AutoRenew:   g.AutoRenewing,
-			ExpiresTime: parseMillisecondUnixTimestamp(int(g.ExpirySubscriptionTimeMillis)),
+			AutoRenew:            g.AutoRenewing,
+			OriginalPurchaseTime: parseMillisecondUnixTimestamp(int(g.StartSubscriptionTimeMillis)),
+			ExpiresTime:          parseMillisecondUnixTimestamp(int(g.ExpirySubscriptionTimeMillis)),
+			// Google moves expiryTimeMillis to the end of the grace period while it retries billing,
+			// and leaves it in the past on account hold, so it already is the effective expiry.
+			EffectiveExpiresTime:   parseMillisecondUnixTimestamp(int(g.ExpirySubscriptionTimeMillis)),
+			GracePeriodExpiresTime: googleGracePeriodExpiresTime(g),
+			RenewalCount:           googleRenewalCount(g.OrderId),
+			PaymentState:           g.PaymentState,
+			CancelReason:           g.CancelReason,
+			UserCancellationTime:   googleUserCancellationTime(g),
 		},
+	}
+
+	if v.FollowLinkedTokens {
+		seen := map[string]bool{gReceipt.PurchaseToken: true}
+		linked, err := v.linkedSubscriptionsGoogle(ctx, userID, gc, gReceipt.PackageName, g.LinkedPurchaseToken, seen)
+		if err != nil {
+			return nil, IAPGoogleConfig{}, nil, nil, err
+		}
+		storagePurchases = append(storagePurchases, linked...)
+	}
+
+	return storagePurchases, gc, gReceipt, raw, nil
+}
+
+func (v *Validate) PurchasesSubscriptionApple(ctx context.Context, userID, receipt string) (*ValidatePurchaseResponse, error) {
+	return v.withResultCache(ctx, "PurchasesSubscriptionApple", userID, []string{receipt}, func() (*ValidatePurchaseResponse, error) {
+		return v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+			return v.withStoreTimeout(ctx, APPLE_APP_STORE, func(ctx context.Context) (*ValidatePurchaseResponse, error) {
+				return v.purchasesSubscriptionApple(ctx, userID, receipt, "")
+			})
+		})
+	})
+}
+
+// PurchasesSubscriptionAppleWithSecret PurchasesSubscriptionApple validating with sharedSecret instead of
+// the secret resolved from Credentials, for backends serving several apps.
+func (v *Validate) PurchasesSubscriptionAppleWithSecret(ctx context.Context, userID, receipt, sharedSecret string) (*ValidatePurchaseResponse, error) {
+	if len(sharedSecret) < 1 {
+		return nil, errors.New("'sharedSecret' is empty")
+	}
+
+	return v.withResultCache(ctx, "PurchasesSubscriptionAppleWithSecret", userID, []string{receipt, sharedSecret}, func() (*ValidatePurchaseResponse, error) {
+		return v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+			return v.withStoreTimeout(ctx, APPLE_APP_STORE, func(ctx context.Context) (*ValidatePurchaseResponse, error) {
+				return v.purchasesSubscriptionApple(ctx, userID, receipt, sharedSecret)
+			})
+		})
 	})
+}
+
+func (v *Validate) purchasesSubscriptionApple(ctx context.Context, userID, receipt, sharedSecret string) (*ValidatePurchaseResponse, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("user_id", userID, "store", APPLE_APP_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	if err := v.checkReceiptSize(receipt); err != nil {
+		return nil, err
+	}
+
+	if v.TestMode != nil {
+		return v.testModeSubscriptionPurchases(ctx, log, userID, APPLE_APP_STORE, receipt)
+	}
+
+	storagePurchases, validation, raw, err := v.validateSubscriptionApple(ctx, log, userID, receipt, sharedSecret)
 	if err != nil {
 		return nil, err
 	}
 
-	if len(purchases) < 1 {
-		return nil, ErrPurchaseReceiptAlreadySeen
+	if v.LatestSubscriptionOnly {
+		storagePurchases = latestPerProduct(storagePurchases)
+	}
+	if v.SkipExpiredSubscriptions {
+		now := time.Now()
+		active := make([]*SubscriptionPurchase, 0, len(storagePurchases))
+		for _, p := range storagePurchases {
+			if p.IsExpired(now) {
+				purchaseLogger(log, p.transactionId, p.environment).Debug("skipping expired subscription")
+				continue
+			}
+			active = append(active, p)
+		}
+		if len(storagePurchases) > 0 && len(active) < 1 {
+			return nil, &ValidationError{Store: APPLE_APP_STORE, ProviderResponse: raw, Err: ErrSubscriptionExpired}
+		}
+		storagePurchases = active
 	}
 
-	validatedPurchases := make([]*ValidatedPurchase, 0, len(purchases))
-	for _, p := range purchases {
-		validatedPurchases = append(validatedPurchases, &ValidatedPurchase{
-			ProductId:        p.productId,
-			TransactionId:    p.transactionId,
-			Store:            p.store,
-			PurchaseTime:     p.purchaseTime.Unix(),
-			CreateTime:       p.createTime.Unix(),
-			UpdateTime:       p.updateTime.Unix(),
-			ProviderResponse: string(raw),
-			Environment:      p.environment,
-		})
+	resp, err := v.storeSubscriptionPurchases(ctx, log, userID, storagePurchases, raw)
+	if err != nil {
+		return nil, err
 	}
 
-	return &ValidatePurchaseResponse{
-		ValidatedPurchases: validatedPurchases,
-	}, nil
+	resp.SubscriptionInfoUnavailable = validation.SubscriptionInfoUnavailable
+	resp.UsedSandboxFallback = validation.UsedSandboxFallback
+	resp.OriginalPurchaseTime = appleReceiptOriginalPurchaseTime(validation.Receipt)
+	return resp, nil
 }
 
-func (v *Validate) PurchasesSubscriptionApple(ctx context.Context, userID, receipt string) (*ValidatePurchaseResponse, error) {
-	validation, raw, err := iap.ValidateReceiptApple(ctx, httpc, receipt, v.ApplePassword)
+// validateSubscriptionApple validates the receipt with Apple using sharedSecret, or the secret resolved from
+// Credentials when empty, and builds a SubscriptionPurchase per in_app entry.
+func (v *Validate) validateSubscriptionApple(ctx context.Context, log iap.Logger, userID, receipt, sharedSecret string) ([]*SubscriptionPurchase, *iap.ValidateReceiptAppleResponse, []byte, error) {
+	password := sharedSecret
+	if len(password) < 1 {
+		var err error
+		password, err = v.credentials().ResolveApple(receipt)
+		if err != nil {
+			return nil, nil, nil, err
+		}
+	}
+
+	opts := v.appleOptions(ctx)
+	opts.ExcludeOldTransactions = v.AppleSubscriptionExcludeOldTransactions
+	validation, raw, err := iap.ValidateSubscriptionReceiptAppleWithOptions(ctx, v.httpClient(), receipt, password, opts)
 	if err != nil {
-		return nil, err
+		return nil, nil, nil, appleValidationError(err)
 	}
 
 	if validation.Status != iap.AppleReceiptIsValid {
 		// TODO: log to DB
-		if validation.IsRetryable == true {
-			return nil, ErrUnavailableTryAgain
-		}
-		return nil, ErrFailedPrecondition
+		log.Debug("apple receipt invalid", "status", validation.Status, "is_retryable", validation.IsRetryable)
+		return nil, nil, nil, &ValidationError{Store: APPLE_APP_STORE, ProviderStatus: validation.Status, ProviderResponse: raw, Err: appleStatusError(validation)}
 	}
 
 	env := PRODUCTION
@@ -286,20 +954,71 @@ func (v *Validate) PurchasesSubscriptionApple(ctx context.Context, userID, recei
 		env = SANDBOX
 	}
 
-	storagePurchases := make([]*SubscriptionPurchase, 0, len(validation.Receipt.InApp))
-	for _, purchase := range validation.Receipt.InApp {
+	// latest_receipt_info is authoritative for subscriptions, in_app may lag behind.
+	transactions := validation.SubscriptionTransactions()
+	transactionsPerSubscription := make(map[string]int, len(transactions))
+	for _, purchase := range transactions {
+		transactionsPerSubscription[purchase.OriginalTransactionID]++
+	}
+
+	storagePurchases := make([]*SubscriptionPurchase, 0, len(transactions))
+	for _, purchase := range transactions {
 		pt, err := strconv.Atoi(purchase.PurchaseDateMs)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
-
-		exp, err := strconv.Atoi(purchase.ExpiresDateMs)
+		cancellationTime, err := appleCancellationTime(purchase)
 		if err != nil {
-			return nil, err
+			return nil, nil, nil, err
 		}
+
+		// consumable entries in the same receipt have no expiry
+		exp := 0
+		if len(purchase.ExpiresDateMs) > 0 {
+			exp, err = strconv.Atoi(purchase.ExpiresDateMs)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+		}
+		originalPurchaseTime := time.Time{}
+		if len(purchase.OriginalPurchaseDateMs) > 0 {
+			opt, err := strconv.Atoi(purchase.OriginalPurchaseDateMs)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			originalPurchaseTime = parseMillisecondUnixTimestamp(opt)
+		}
+
+		renewalInfo := validation.RenewalInfo(purchase)
 		isAutoRenew := false
-		if len(purchase.PendingRenewalInfo) > 0 {
-			isAutoRenew = purchase.PendingRenewalInfo[0].AutoRenewStatus == "1"
+		autoRenewProductId := ""
+		cancellationReason := appleCancellationReason(purchase)
+		cancelReason := 0
+		if renewalInfo != nil {
+			isAutoRenew = renewalInfo.AutoRenewStatus == "1"
+			autoRenewProductId = renewalInfo.AutoRenewProductID
+			if cancellationReason == CANCELLATION_REASON_NONE && len(renewalInfo.ExpirationIntent) > 0 {
+				cancelReason, err = strconv.Atoi(renewalInfo.ExpirationIntent)
+				if err != nil {
+					return nil, nil, nil, err
+				}
+				cancellationReason = appleExpirationReason(cancelReason)
+			}
+		}
+
+		expiresTime := time.Time{}
+		effectiveExpiresTime := time.Time{}
+		gracePeriodExpiresTime := time.Time{}
+		if exp > 0 {
+			expiresTime = parseMillisecondUnixTimestamp(exp)
+			gracePeriodExpiresTime, err = appleGracePeriodExpiresTime(renewalInfo)
+			if err != nil {
+				return nil, nil, nil, err
+			}
+			effectiveExpiresTime = expiresTime
+			if gracePeriodExpiresTime.After(expiresTime) {
+				effectiveExpiresTime = gracePeriodExpiresTime
+			}
 		}
 		storagePurchases = append(storagePurchases, &SubscriptionPurchase{
 			Purchase: Purchase{
@@ -311,38 +1030,962 @@ func (v *Validate) PurchasesSubscriptionApple(ctx context.Context, userID, recei
 				rawRequest:    receipt,
 				purchaseTime:  parseMillisecondUnixTimestamp(pt),
 				environment:   env,
+
+				originalTransactionId:   purchase.OriginalTransactionID,
+				cancellationReason:      cancellationReason,
+				cancellationTime:        cancellationTime,
+				storeCancellationReason: purchase.CancellationReason,
+				familyShared:            purchase.InAppOwnershipType == iap.AppleOwnershipFamilyShared,
+				productType:             v.appleProductType(purchase),
+				quantity:                purchase.Quantity,
+				appAccountToken:         purchase.AppAccountToken,
 			},
-			AutoRenew:   isAutoRenew,
-			ExpiresTime: parseMillisecondUnixTimestamp(exp),
+			AutoRenew:              isAutoRenew,
+			AutoRenewProductId:     autoRenewProductId,
+			IsTrialPeriod:          purchase.IsTrialPeriod,
+			OfferType:              purchase.OfferType,
+			OriginalPurchaseTime:   originalPurchaseTime,
+			ExpiresTime:            expiresTime,
+			EffectiveExpiresTime:   effectiveExpiresTime,
+			GracePeriodExpiresTime: gracePeriodExpiresTime,
+			CancelReason:           cancelReason,
+			RenewalCount:           transactionsPerSubscription[purchase.OriginalTransactionID] - 1,
 		})
 	}
 
-	purchases, err := v.Storage.StoreSubscriptionPurchases(ctx, storagePurchases)
+	return storagePurchases, validation, raw, nil
+}
+
+func (v *Validate) PurchaseMicrosoft(ctx context.Context, userID, receipt string) (*ValidatePurchaseResponse, error) {
+	return v.withResultCache(ctx, "PurchaseMicrosoft", userID, []string{receipt}, func() (*ValidatePurchaseResponse, error) {
+		return v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+			return v.withStoreTimeout(ctx, MICROSOFT_STORE, func(ctx context.Context) (*ValidatePurchaseResponse, error) {
+				return v.purchaseMicrosoft(ctx, userID, receipt)
+			})
+		})
+	})
+}
+
+func (v *Validate) purchaseMicrosoft(ctx context.Context, userID, receipt string) (*ValidatePurchaseResponse, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("user_id", userID, "store", MICROSOFT_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	if err := v.checkReceiptSize(receipt); err != nil {
+		return nil, err
+	}
+
+	validation, err := iap.ValidateReceiptMicrosoftWithContext(ctx, v.microsoftCerts(), receipt)
 	if err != nil {
+		if errors.Is(err, iap.ErrMicrosoftReceiptInvalid) {
+			log.Debug("microsoft receipt invalid", "error", err)
+			return nil, ErrFailedPrecondition
+		}
 		return nil, err
 	}
 
-	if len(purchases) < 1 {
-		return nil, ErrPurchaseReceiptAlreadySeen
+	storagePurchases := make([]*Purchase, 0, len(validation.ProductReceipts))
+	for _, purchase := range validation.ProductReceipts {
+		pt, err := time.Parse(time.RFC3339, purchase.PurchaseDate)
+		if err != nil {
+			return nil, err
+		}
+
+		storagePurchases = append(storagePurchases, &Purchase{
+			userID:        userID,
+			store:         MICROSOFT_STORE,
+			productId:     purchase.ProductID,
+			transactionId: purchase.ID,
+			rawResponse:   receipt,
+			rawRequest:    receipt,
+			purchaseTime:  pt,
+			environment:   UNKNOWN,
+
+			productType: microsoftProductType(purchase.ProductType),
+		})
 	}
 
-	validatedPurchases := make([]*ValidatedPurchase, 0, len(purchases))
-	for _, p := range purchases {
-		validatedPurchases = append(validatedPurchases, &ValidatedPurchase{
-			ProductId:        p.productId,
-			TransactionId:    p.transactionId,
-			Store:            p.store,
-			PurchaseTime:     p.purchaseTime.Unix(),
-			CreateTime:       p.createTime.Unix(),
-			UpdateTime:       p.updateTime.Unix(),
-			ProviderResponse: string(raw),
-			Environment:      p.environment,
+	// the signed receipt itself is the provider response.
+	return v.storePurchases(ctx, log, userID, storagePurchases, []byte(receipt))
+}
+
+// PurchaseAmazon validates an Amazon Appstore receipt id, amazonUserID is the Amazon user id from the Appstore SDK.
+func (v *Validate) PurchaseAmazon(ctx context.Context, userID, amazonUserID, receiptID string) (*ValidatePurchaseResponse, error) {
+	return v.withResultCache(ctx, "PurchaseAmazon", userID, []string{amazonUserID, receiptID}, func() (*ValidatePurchaseResponse, error) {
+		return v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+			return v.purchaseAmazon(ctx, userID, amazonUserID, receiptID)
 		})
+	})
+}
+
+func (v *Validate) purchaseAmazon(ctx context.Context, userID, amazonUserID, receiptID string) (*ValidatePurchaseResponse, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("user_id", userID, "store", AMAZON_APP_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	if err := v.checkReceiptSize(receiptID); err != nil {
+		return nil, err
 	}
 
-	return &ValidatePurchaseResponse{
-		ValidatedPurchases: validatedPurchases,
-	}, nil
+	validate := iap.ValidateReceiptAmazon
+	if v.credentials().Amazon.Sandbox {
+		validate = iap.ValidateReceiptAmazonSandbox
+	}
+	a, raw, err := validate(ctx, v.httpClient(), v.credentials().Amazon.DeveloperSecret, amazonUserID, receiptID)
+	if err != nil {
+		if errors.Is(err, iap.ErrAmazonInvalidReceipt) || errors.Is(err, iap.ErrAmazonReceiptNoLongerValid) {
+			log.Debug("amazon receipt invalid", "error", err)
+			status := 400
+			if errors.Is(err, iap.ErrAmazonReceiptNoLongerValid) {
+				status = 410
+			}
+			return nil, &ValidationError{Store: AMAZON_APP_STORE, ProviderStatus: status, Err: ErrFailedPrecondition}
+		}
+		return nil, err
+	}
+
+	env := PRODUCTION
+	if a.TestTransaction || v.credentials().Amazon.Sandbox {
+		env = SANDBOX
+	}
+
+	cancellationReason := CANCELLATION_REASON_NONE
+	cancellationTime := time.Time{}
+	if a.CancelDate != nil {
+		cancellationReason = CANCELLATION_REASON_UNKNOWN
+		cancellationTime = parseMillisecondUnixTimestamp(int(*a.CancelDate))
+		// a subscription canceled at the end of its term keeps access until then.
+		if !time.Now().Before(cancellationTime) {
+			purchaseLogger(log, a.ReceiptID, env).Debug("amazon purchase canceled", "cancel_date", *a.CancelDate)
+			return nil, &ValidationError{Store: AMAZON_APP_STORE, ProviderStatus: 200, ProviderResponse: raw, Err: ErrPurchaseRefunded}
+		}
+	}
+
+	storagePurchases := []*Purchase{
+		{
+			userID:        userID,
+			store:         AMAZON_APP_STORE,
+			productId:     a.ProductID,
+			transactionId: a.ReceiptID,
+			rawRequest:    receiptID,
+			rawResponse:   string(raw),
+			purchaseTime:  parseMillisecondUnixTimestamp(int(a.PurchaseDate)),
+			environment:   env,
+
+			cancellationReason: cancellationReason,
+			cancellationTime:   cancellationTime,
+			productType:        amazonProductType(a.ProductType),
+			quantity:           a.Quantity,
+		},
+	}
+
+	return v.storePurchases(ctx, log, userID, storagePurchases, raw)
+}
+
+// PurchaseHuawei validates the InAppPurchaseData and its signature returned by the HMS IAP SDK.
+// The signature is verified with the Huawei PublicKey credential, without one it must be empty.
+func (v *Validate) PurchaseHuawei(ctx context.Context, userID, purchaseData, signature string) (*ValidatePurchaseResponse, error) {
+	return v.withResultCache(ctx, "PurchaseHuawei", userID, []string{purchaseData, signature}, func() (*ValidatePurchaseResponse, error) {
+		return v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+			return v.withStoreTimeout(ctx, HUAWEI_APP_GALLERY, func(ctx context.Context) (*ValidatePurchaseResponse, error) {
+				return v.purchaseHuawei(ctx, userID, purchaseData, signature)
+			})
+		})
+	})
+}
+
+func (v *Validate) purchaseHuawei(ctx context.Context, userID, purchaseData, signature string) (*ValidatePurchaseResponse, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("user_id", userID, "store", HUAWEI_APP_GALLERY))
+	log := iap.LoggerFromContext(ctx)
+
+	if err := v.checkReceiptSize(purchaseData); err != nil {
+		return nil, err
+	}
+
+	huawei := v.credentials().Huawei
+	opts := iap.HuaweiOptions{PublicKey: huawei.PublicKey, OrderUrl: huawei.OrderUrl}
+	h, raw, err := iap.ValidateReceiptHuaweiWithOptions(ctx, v.httpClient(), huawei.ClientID, huawei.ClientSecret, purchaseData, signature, opts)
+	if err != nil {
+		if errors.Is(err, iap.ErrHuaweiInvalidReceipt) || errors.Is(err, iap.ErrHuaweiSignatureInvalid) || errors.Is(err, iap.ErrHuaweiPurchaseDataInvalid) {
+			log.Debug("huawei purchase invalid", "error", err)
+			return nil, &ValidationError{Store: HUAWEI_APP_GALLERY, ProviderResponse: raw, Err: ErrFailedPrecondition}
+		}
+		return nil, err
+	}
+
+	env := PRODUCTION
+	if h.PurchaseType != nil && *h.PurchaseType == 0 {
+		env = SANDBOX
+	}
+
+	switch h.PurchaseState {
+	case -1, 3:
+		// initialized or pending, must not be granted until the payment completes.
+		purchaseLogger(log, h.PurchaseToken, env).Debug("huawei purchase pending", "purchase_state", h.PurchaseState)
+		return nil, &ValidationError{Store: HUAWEI_APP_GALLERY, ProviderStatus: h.PurchaseState, ProviderResponse: raw, Err: ErrPurchasePending}
+	case 1, 2:
+		// canceled or refunded.
+		purchaseLogger(log, h.PurchaseToken, env).Debug("huawei purchase refunded", "purchase_state", h.PurchaseState)
+		return nil, &ValidationError{Store: HUAWEI_APP_GALLERY, ProviderStatus: h.PurchaseState, ProviderResponse: raw, Err: ErrPurchaseRefunded}
+	}
+
+	storagePurchases := []*Purchase{
+		{
+			userID:        userID,
+			store:         HUAWEI_APP_GALLERY,
+			productId:     h.ProductID,
+			transactionId: h.PurchaseToken,
+			rawRequest:    purchaseData,
+			rawResponse:   string(raw),
+			purchaseTime:  parseMillisecondUnixTimestamp(int(h.PurchaseTime)),
+			environment:   env,
+
+			consumed:    h.ConsumptionState == 1,
+			regionCode:  h.Country,
+			productType: huaweiProductType(h.Kind),
+			quantity:    h.Quantity,
+		},
+	}
+
+	return v.storePurchases(ctx, log, userID, storagePurchases, raw)
+}
+
+// PurchaseAppleTransaction validates a StoreKit 2 signed transaction with the App Store Server API.
+func (v *Validate) PurchaseAppleTransaction(ctx context.Context, userID, signedTransaction string) (*ValidatePurchaseResponse, error) {
+	return v.withResultCache(ctx, "PurchaseAppleTransaction", userID, []string{signedTransaction}, func() (*ValidatePurchaseResponse, error) {
+		return v.withPipelineRetry(ctx, func() (*ValidatePurchaseResponse, error) {
+			return v.withStoreTimeout(ctx, APPLE_APP_STORE, func(ctx context.Context) (*ValidatePurchaseResponse, error) {
+				return v.purchaseAppleTransaction(ctx, userID, signedTransaction)
+			})
+		})
+	})
+}
+
+func (v *Validate) purchaseAppleTransaction(ctx context.Context, userID, signedTransaction string) (*ValidatePurchaseResponse, error) {
+	ctx = iap.ContextWithLogger(ctx, v.logger().With("user_id", userID, "store", APPLE_APP_STORE))
+	log := iap.LoggerFromContext(ctx)
+
+	if err := v.checkReceiptSize(signedTransaction); err != nil {
+		return nil, err
+	}
+
+	apple := v.credentials().Apple
+	transaction, raw, err := iap.ValidateTransactionApple(v.appleRootCerts(ctx), v.httpClient(), signedTransaction, apple.IssuerID, apple.KeyID, apple.PrivateKey)
+	if err != nil {
+		if errors.Is(err, iap.ErrAppleJWSInvalid) {
+			log.Debug("apple transaction invalid", "error", err)
+			return nil, ErrFailedPrecondition
+		}
+		if errors.Is(err, iap.ErrAppleBundleMismatch) {
+			log.Debug("apple transaction bundle mismatch", "error", err)
+			return nil, ErrAppleBundleMismatch
+		}
+		return nil, err
+	}
+
+	if !v.credentials().appleBundleAllowed(transaction.BundleID) {
+		log.Debug("apple transaction of another app", "bundle_id", transaction.BundleID)
+		return nil, &ValidationError{Store: APPLE_APP_STORE, ProviderResponse: raw, Err: ErrAppleBundleMismatch}
+	}
+
+	env := PRODUCTION
+	if transaction.Environment == iap.AppleSandboxEnv {
+		env = SANDBOX
+	}
+
+	cancellationReason := CANCELLATION_REASON_NONE
+	cancellationTime := time.Time{}
+	storeCancellationReason := ""
+	if transaction.RevocationDate > 0 {
+		cancellationReason = CANCELLATION_REASON_REFUNDED
+		cancellationTime = parseMillisecondUnixTimestamp(int(transaction.RevocationDate))
+		if transaction.RevocationReason != nil {
+			storeCancellationReason = strconv.Itoa(*transaction.RevocationReason)
+		}
+	}
+
+	storagePurchases := []*Purchase{
+		{
+			userID:        userID,
+			store:         APPLE_APP_STORE,
+			productId:     transaction.ProductID,
+			transactionId: transaction.TransactionID,
+			rawResponse:   string(raw),
+			rawRequest:    signedTransaction,
+			purchaseTime:  parseMillisecondUnixTimestamp(int(transaction.PurchaseDate)),
+			environment:   env,
+
+			originalTransactionId:   transaction.OriginalTransactionID,
+			cancellationReason:      cancellationReason,
+			cancellationTime:        cancellationTime,
+			storeCancellationReason: storeCancellationReason,
+			storefront:              transaction.Storefront,
+			storefrontId:            transaction.StorefrontID,
+			familyShared:            transaction.InAppOwnershipType == iap.AppleOwnershipFamilyShared,
+			productType:             appleTransactionProductType(transaction.Type),
+			quantity:                transaction.Quantity,
+			appAccountToken:         transaction.AppAccountToken,
+		},
+	}
+
+	return v.storePurchases(ctx, log, userID, storagePurchases, raw)
+}
+
+// storePurchases filters and stores the provider validated purchases and builds the response.
+func (v *Validate) storePurchases(ctx context.Context, log iap.Logger, userID string, storagePurchases []*Purchase, raw []byte) (*ValidatePurchaseResponse, error) {
+	ctx = storageContext(ctx)
+	for _, p := range storagePurchases {
+		if err := v.checkAppAccountToken(ctx, log, userID, p, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	unique := uniquePurchases(storagePurchases)
+	storagePurchases, rejected := v.filterPurchases(ctx, log, unique)
+	if len(storagePurchases) < 1 && len(rejected) > 0 {
+		// everything was rejected, nothing to store.
+		return allRejectedResponse(log, unique[0].store, rejected, raw)
+	}
+
+	if v.DryRun {
+		validatedPurchases := make([]*ValidatedPurchase, 0, len(storagePurchases))
+		for _, p := range storagePurchases {
+			validatedPurchases = append(validatedPurchases, newValidatedPurchase(p, raw))
+		}
+		return &ValidatePurchaseResponse{ValidatedPurchases: validatedPurchases, RejectedPurchases: rejected}, nil
+	}
+
+	if v.HashRawRequest {
+		for _, p := range storagePurchases {
+			p.hashRawRequest()
+		}
+	}
+
+	purchases, err := v.Storage.StorePurchases(ctx, storagePurchases)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(purchases) < 1 {
+		log.Debug("purchase receipt already seen")
+		getter, ok := v.Storage.(PurchaseGetter)
+		if !v.Idempotent || !ok {
+			return nil, ErrPurchaseReceiptAlreadySeen
+		}
+
+		stored, err := getter.GetPurchases(ctx, storagePurchases)
+		if err != nil {
+			return nil, err
+		}
+		if len(stored) < 1 {
+			return nil, ErrPurchaseReceiptAlreadySeen
+		}
+
+		validatedPurchases := make([]*ValidatedPurchase, 0, len(stored))
+		for _, p := range stored {
+			validatedPurchases = append(validatedPurchases, newValidatedPurchase(p, []byte(p.rawResponse)))
+		}
+		return &ValidatePurchaseResponse{
+			ValidatedPurchases:  validatedPurchases,
+			AlreadyProcessed:    true,
+			SkippedTransactions: skippedTransactions(storagePurchases, nil),
+		}, nil
+	}
+
+	validatedPurchases := make([]*ValidatedPurchase, 0, len(purchases))
+	var warnings []Warning
+	for _, p := range purchases {
+		purchaseLogger(log, p.transactionId, p.environment).Debug("purchase validated")
+		vp := newValidatedPurchase(p, raw)
+		warnings = append(warnings, v.purchaseWarnings(p, vp)...)
+		validatedPurchases = append(validatedPurchases, vp)
+	}
+
+	isFirstPurchase := v.isFirstPurchase(ctx, log, userID, len(purchases))
+
+	v.emitEvents(ctx, userID, validatedPurchases)
+
+	return &ValidatePurchaseResponse{
+		ValidatedPurchases:  validatedPurchases,
+		IsFirstPurchase:     isFirstPurchase,
+		RejectedPurchases:   rejected,
+		Warnings:            warnings,
+		SkippedTransactions: skippedTransactions(storagePurchases, purchases),
+	}, nil
+}
+
+func (v *Validate) storeSubscriptionPurchases(ctx context.Context, log iap.Logger, userID string, storagePurchases []*SubscriptionPurchase, raw []byte) (*ValidatePurchaseResponse, error) {
+	ctx = storageContext(ctx)
+	for _, p := range storagePurchases {
+		if err := v.checkAppAccountToken(ctx, log, userID, &p.Purchase, raw); err != nil {
+			return nil, err
+		}
+	}
+
+	unique := uniqueSubscriptionPurchases(storagePurchases)
+	storagePurchases, rejected := v.filterSubscriptionPurchases(ctx, log, unique)
+	if len(storagePurchases) < 1 && len(rejected) > 0 {
+		// everything was rejected, nothing to store.
+		return allRejectedResponse(log, unique[0].store, rejected, raw)
+	}
+
+	if v.DryRun {
+		validatedPurchases := make([]*ValidatedPurchase, 0, len(storagePurchases))
+		for _, p := range storagePurchases {
+			validatedPurchases = append(validatedPurchases, newValidatedSubscriptionPurchase(p, raw))
+		}
+		return &ValidatePurchaseResponse{ValidatedPurchases: validatedPurchases, RejectedPurchases: rejected}, nil
+	}
+
+	if v.HashRawRequest {
+		for _, p := range storagePurchases {
+			p.hashRawRequest()
+		}
+	}
+
+	purchases, err := v.Storage.StoreSubscriptionPurchases(ctx, storagePurchases)
+	if err != nil {
+		return nil, err
+	}
+
+	if len(purchases) < 1 {
+		log.Debug("purchase receipt already seen")
+		getter, ok := v.Storage.(PurchaseGetter)
+		if !v.Idempotent || !ok {
+			return nil, ErrPurchaseReceiptAlreadySeen
+		}
+
+		stored, err := getter.GetSubscriptionPurchases(ctx, storagePurchases)
+		if err != nil {
+			return nil, err
+		}
+		if len(stored) < 1 {
+			return nil, ErrPurchaseReceiptAlreadySeen
+		}
+
+		validatedPurchases := make([]*ValidatedPurchase, 0, len(stored))
+		for _, p := range stored {
+			validatedPurchases = append(validatedPurchases, newValidatedSubscriptionPurchase(p, []byte(p.rawResponse)))
+		}
+		return &ValidatePurchaseResponse{
+			ValidatedPurchases:  validatedPurchases,
+			AlreadyProcessed:    true,
+			SkippedTransactions: skippedSubscriptionTransactions(storagePurchases, nil),
+		}, nil
+	}
+
+	validatedPurchases := make([]*ValidatedPurchase, 0, len(purchases))
+	var warnings []Warning
+	for _, p := range purchases {
+		purchaseLogger(log, p.transactionId, p.environment).Debug("purchase validated")
+		vp := newValidatedSubscriptionPurchase(p, raw)
+		warnings = append(warnings, v.purchaseWarnings(&p.Purchase, vp)...)
+		validatedPurchases = append(validatedPurchases, vp)
+	}
+
+	isFirstPurchase := v.isFirstPurchase(ctx, log, userID, len(purchases))
+
+	v.emitEvents(ctx, userID, validatedPurchases)
+
+	return &ValidatePurchaseResponse{
+		ValidatedPurchases:  validatedPurchases,
+		IsFirstPurchase:     isFirstPurchase,
+		RejectedPurchases:   rejected,
+		Warnings:            warnings,
+		SkippedTransactions: skippedSubscriptionTransactions(storagePurchases, purchases),
+	}, nil
+}
+
+// skippedTransactions transaction IDs of the purchases Storage didn't return as newly stored.
+func skippedTransactions(purchases, stored []*Purchase) []string {
+	newly := make(map[string]bool, len(stored))
+	for _, p := range stored {
+		newly[p.IdempotencyKey()] = true
+	}
+
+	var skipped []string
+	for _, p := range purchases {
+		if !newly[p.IdempotencyKey()] {
+			skipped = append(skipped, p.transactionId)
+		}
+	}
+	return skipped
+}
+
+func skippedSubscriptionTransactions(purchases, stored []*SubscriptionPurchase) []string {
+	toPurchases := func(sp []*SubscriptionPurchase) []*Purchase {
+		out := make([]*Purchase, 0, len(sp))
+		for _, p := range sp {
+			out = append(out, &p.Purchase)
+		}
+		return out
+	}
+	return skippedTransactions(toPurchases(purchases), toPurchases(stored))
+}
+
+// uniquePurchases drops repeated IdempotencyKey, keeping the first, receipts can list a transaction twice.
+func uniquePurchases(purchases []*Purchase) []*Purchase {
+	seen := make(map[string]bool, len(purchases))
+	out := make([]*Purchase, 0, len(purchases))
+	for _, p := range purchases {
+		if k := p.IdempotencyKey(); !seen[k] {
+			seen[k] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+func uniqueSubscriptionPurchases(purchases []*SubscriptionPurchase) []*SubscriptionPurchase {
+	seen := make(map[string]bool, len(purchases))
+	out := make([]*SubscriptionPurchase, 0, len(purchases))
+	for _, p := range purchases {
+		if k := p.IdempotencyKey(); !seen[k] {
+			seen[k] = true
+			out = append(out, p)
+		}
+	}
+	return out
+}
+
+// allRejectedResponse when every purchase was rejected, ErrPurchaseTooOld if MinPurchaseTime rejected them all,
+// otherwise a response with only the RejectedPurchases, the caller still sets its receipt level fields.
+func allRejectedResponse(log iap.Logger, store Store, rejected []*RejectedPurchase, raw []byte) (*ValidatePurchaseResponse, error) {
+	for _, r := range rejected {
+		if !errors.Is(r.Err, ErrPurchaseTooOld) {
+			log.Debug("every purchase rejected", "rejected", len(rejected))
+			return &ValidatePurchaseResponse{RejectedPurchases: rejected}, nil
+		}
+	}
+	log.Debug("every purchase too old", "rejected", len(rejected))
+	return nil, &ValidationError{Store: store, ProviderResponse: raw, Err: ErrPurchaseTooOld}
+}
+
+func (v *Validate) filterPurchases(ctx context.Context, log iap.Logger, purchases []*Purchase) ([]*Purchase, []*RejectedPurchase) {
+	if v.PurchaseFilter == nil && v.MinPurchaseTime.IsZero() {
+		return purchases, nil
+	}
+
+	accepted := make([]*Purchase, 0, len(purchases))
+	var rejected []*RejectedPurchase
+	for _, p := range purchases {
+		if err := v.checkPurchase(ctx, p); err != nil {
+			purchaseLogger(log, p.transactionId, p.environment).Debug("purchase rejected", "error", err)
+			rejected = append(rejected, &RejectedPurchase{TransactionId: p.transactionId, Err: err, Reason: err.Error()})
+			continue
+		}
+		accepted = append(accepted, p)
+	}
+	return accepted, rejected
+}
+
+func (v *Validate) filterSubscriptionPurchases(ctx context.Context, log iap.Logger, purchases []*SubscriptionPurchase) ([]*SubscriptionPurchase, []*RejectedPurchase) {
+	if v.PurchaseFilter == nil && v.MinPurchaseTime.IsZero() {
+		return purchases, nil
+	}
+
+	accepted := make([]*SubscriptionPurchase, 0, len(purchases))
+	var rejected []*RejectedPurchase
+	for _, p := range purchases {
+		if err := v.checkPurchase(ctx, &p.Purchase); err != nil {
+			purchaseLogger(log, p.transactionId, p.environment).Debug("purchase rejected", "error", err)
+			rejected = append(rejected, &RejectedPurchase{TransactionId: p.transactionId, Err: err, Reason: err.Error()})
+			continue
+		}
+		accepted = append(accepted, p)
+	}
+	return accepted, rejected
+}
+
+func (v *Validate) checkPurchase(ctx context.Context, p *Purchase) error {
+	if !v.MinPurchaseTime.IsZero() && p.purchaseTime.Before(v.MinPurchaseTime) {
+		return ErrPurchaseTooOld
+	}
+	if v.PurchaseFilter != nil {
+		return v.PurchaseFilter(ctx, p)
+	}
+	return nil
+}
+
+// checkAppAccountToken ErrUserMismatch when Validate.AppAccountTokenChecker rejects the appAccountToken of p.
+func (v *Validate) checkAppAccountToken(ctx context.Context, log iap.Logger, userID string, p *Purchase, raw []byte) error {
+	if v.AppAccountTokenChecker == nil || len(p.appAccountToken) < 1 {
+		return nil
+	}
+	if v.AppAccountTokenChecker(ctx, userID, p.appAccountToken) {
+		return nil
+	}
+	purchaseLogger(log, p.transactionId, p.environment).Debug("app account token does not match user", "app_account_token", p.appAccountToken)
+	return &ValidationError{Store: p.store, ProviderResponse: raw, Err: ErrUserMismatch}
+}
+
+// allTransactionsSeen false when there are no IDs or Storage doesn't implement TransactionChecker.
+func (v *Validate) allTransactionsSeen(ctx context.Context, store Store, transactionIDs []string) (bool, error) {
+	ctx = storageContext(ctx)
+	checker, ok := v.Storage.(TransactionChecker)
+	if !ok || v.Idempotent || v.DryRun || len(transactionIDs) < 1 {
+		return false, nil
+	}
+
+	for _, id := range transactionIDs {
+		seen, err := checker.SeenTransaction(ctx, store, id)
+		if err != nil || !seen {
+			return false, err
+		}
+	}
+	return true, nil
+}
+
+// isFirstPurchase is called after storing, the user is new when everything stored is what was just stored.
+// The purchases are stored by then so a failing count is logged and reported as not first instead of failing the call.
+func (v *Validate) isFirstPurchase(ctx context.Context, log iap.Logger, userID string, stored int) bool {
+	counter, ok := v.Storage.(PurchaseCounter)
+	if !ok {
+		return false
+	}
+
+	count, err := counter.CountUserPurchases(ctx, userID)
+	if err != nil {
+		log.Error("error counting user purchases", "error", err)
+		return false
+	}
+	return count <= stored
+}
+
+func newValidatedPurchase(p *Purchase, raw []byte) *ValidatedPurchase {
+	vp := &ValidatedPurchase{
+		ProductId:                   p.productId,
+		TransactionId:               p.transactionId,
+		OriginalTransactionId:       p.originalTransactionId,
+		Store:                       p.store,
+		PurchaseTime:                p.purchaseTime.Unix(),
+		ProviderResponse:            string(raw),
+		Environment:                 p.environment,
+		Storefront:                  p.storefront,
+		StorefrontId:                p.storefrontId,
+		CancellationReason:          p.cancellationReason,
+		StoreCancellationReason:     p.storeCancellationReason,
+		ObfuscatedExternalProfileId: p.obfuscatedExternalProfileId,
+		AlreadyConsumed:             p.consumed,
+		RegionCode:                  p.regionCode,
+		AcknowledgementState:        p.acknowledgementState,
+		ConsumptionState:            p.consumptionState,
+		ProductType:                 p.productType,
+		PriceMicros:                 p.priceMicros,
+		Currency:                    p.currency,
+		Quantity:                    p.quantity,
+		AppAccountToken:             p.appAccountToken,
+		PurchaseToken:               p.purchaseToken,
+		FamilyShared:                p.familyShared,
+	}
+	if !p.createTime.IsZero() {
+		vp.CreateTime = p.createTime.Unix()
+	}
+	if !p.updateTime.IsZero() {
+		vp.UpdateTime = p.updateTime.Unix()
+	}
+	if !p.cancellationTime.IsZero() {
+		vp.CancellationTime = p.cancellationTime.Unix()
+	}
+	return vp
+}
+
+func newValidatedSubscriptionPurchase(p *SubscriptionPurchase, raw []byte) *ValidatedPurchase {
+	vp := newValidatedPurchase(&p.Purchase, raw)
+	if !p.OriginalPurchaseTime.IsZero() {
+		vp.OriginalPurchaseTime = p.OriginalPurchaseTime.Unix()
+	}
+	if !p.ExpiresTime.IsZero() {
+		vp.ExpiresTime = p.ExpiresTime.Unix()
+	}
+	if !p.EffectiveExpiresTime.IsZero() {
+		vp.EffectiveExpiresTime = p.EffectiveExpiresTime.Unix()
+	}
+	if !p.GracePeriodExpiresTime.IsZero() {
+		vp.GracePeriodExpiresTime = p.GracePeriodExpiresTime.Unix()
+	}
+	vp.AutoRenew = p.AutoRenew
+	vp.AutoRenewProductId = p.AutoRenewProductId
+	vp.IsTrialPeriod = p.IsTrialPeriod
+	vp.CancelReason = p.CancelReason
+	vp.PaymentState = p.PaymentState
+	if !p.UserCancellationTime.IsZero() {
+		vp.UserCancellationTime = p.UserCancellationTime.Unix()
+	}
+	vp.OfferType = p.OfferType
+	vp.RenewalCount = p.RenewalCount
+	return vp
+}
+
+// googleRenewalCount recurring orders get the initial order id with a "..N" suffix, N counting renewals from 0.
+// e.g. GPA.1234-5678-9012-34567 first period, GPA.1234-5678-9012-34567..0 first renewal.
+func googleRenewalCount(orderID string) int {
+	i := strings.LastIndex(orderID, "..")
+	if i < 0 {
+		return 0
+	}
+
+	n, err := strconv.Atoi(orderID[i+2:])
+	if err != nil {
+		return 0
+	}
+	return n + 1
+}
+
+// appleGracePeriodExpiresTime while Apple retries billing a subscription with billing grace period enabled
+// the user keeps access until grace_period_expires_date, zero time when not in a grace period.
+func appleGracePeriodExpiresTime(info *iap.PendingRenewalInfo) (time.Time, error) {
+	if info == nil || info.IsInBillingRetryPeriod != "1" || len(info.GracePeriodExpiresDateMs) < 1 {
+		return time.Time{}, nil
+	}
+
+	grace, err := strconv.Atoi(info.GracePeriodExpiresDateMs)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseMillisecondUnixTimestamp(grace), nil
+}
+
+// googleGracePeriodExpiresTime a renewing subscription with a pending renewal payment is in its grace period,
+// Google already moved expiryTimeMillis to the grace period end. On account hold the expiry is in the past.
+// The payment of a first period is pending too before it was ever paid, that is not a grace period.
+func googleGracePeriodExpiresTime(g *iap.ReceiptSubscriptionGoogleResponse) time.Time {
+	if g.IsCanceled || !g.AutoRenewing || g.PaymentState != 0 || googleRenewalCount(g.OrderId) < 1 {
+		return time.Time{}
+	}
+	return parseMillisecondUnixTimestamp(int(g.ExpirySubscriptionTimeMillis))
+}
+
+// appleStatusError maps a non zero verifyReceipt status, ErrFailedPrecondition when the receipt itself is invalid.
+func appleStatusError(validation *iap.ValidateReceiptAppleResponse) error {
+	status := validation.Status
+	switch {
+	case validation.IsRetryable,
+		status == iap.AppleStatusServerUnavailable,
+		status == iap.AppleStatusInternalDataAccess,
+		status >= iap.AppleStatusInternalErrorRangeMin && status <= iap.AppleStatusInternalErrorRangeMax:
+		return ErrUnavailableTryAgain
+	case status == iap.AppleStatusBadRequest:
+		return ErrAppleBadRequest
+	case status == iap.AppleStatusMalformedReceipt:
+		return ErrAppleMalformedReceipt
+	case status == iap.AppleStatusSharedSecretMismatch:
+		return ErrAppleSharedSecretMismatch
+	case status == iap.AppleStatusSubscriptionExpired:
+		return ErrAppleSubscriptionExpired
+	case status == iap.AppleStatusProductionInSandbox:
+		return ErrAppleProductionReceiptInSandbox
+	case status == iap.AppleStatusAccountNotFound:
+		return ErrAppleAccountNotFound
+	default:
+		// 21003 and anything undocumented.
+		return ErrFailedPrecondition
+	}
+}
+
+// appleCancellationReason Apple only sets cancellation_date on transactions refunded by Apple support,
+// cancellation_reason (1 issue in app, 0 other) doesn't change that it was a refund.
+func appleCancellationReason(purchase *iap.InApp) CancellationReason {
+	if len(purchase.CancellationDateMs) < 1 {
+		return CANCELLATION_REASON_NONE
+	}
+	return CANCELLATION_REASON_REFUNDED
+}
+
+// productType of a non subscription product, see NonConsumableProductIds.
+func (v *Validate) productType(productId string) ProductType {
+	if v.NonConsumableProductIds[productId] {
+		return PRODUCT_TYPE_NON_CONSUMABLE
+	}
+	return PRODUCT_TYPE_CONSUMABLE
+}
+
+// appleProductType only subscription entries have an expires_date.
+func (v *Validate) appleProductType(purchase *iap.InApp) ProductType {
+	if len(purchase.ExpiresDateMs) > 0 {
+		return PRODUCT_TYPE_SUBSCRIPTION
+	}
+	return v.productType(purchase.ProductID)
+}
+
+// appleTransactionProductType maps the signed transaction type.
+func appleTransactionProductType(transactionType string) ProductType {
+	switch transactionType {
+	case iap.AppleTransactionTypeConsumable:
+		return PRODUCT_TYPE_CONSUMABLE
+	case iap.AppleTransactionTypeNonConsumable:
+		return PRODUCT_TYPE_NON_CONSUMABLE
+	case iap.AppleTransactionTypeAutoRenewable, iap.AppleTransactionTypeNonRenewing:
+		return PRODUCT_TYPE_SUBSCRIPTION
+	default:
+		return PRODUCT_TYPE_UNKNOWN
+	}
+}
+
+func microsoftProductType(productType string) ProductType {
+	switch productType {
+	case iap.MicrosoftProductTypeConsumable:
+		return PRODUCT_TYPE_CONSUMABLE
+	case iap.MicrosoftProductTypeDurable:
+		return PRODUCT_TYPE_NON_CONSUMABLE
+	default:
+		return PRODUCT_TYPE_UNKNOWN
+	}
+}
+
+func amazonProductType(productType string) ProductType {
+	switch productType {
+	case iap.AmazonProductTypeConsumable:
+		return PRODUCT_TYPE_CONSUMABLE
+	case iap.AmazonProductTypeEntitled:
+		return PRODUCT_TYPE_NON_CONSUMABLE
+	case iap.AmazonProductTypeSubscription:
+		return PRODUCT_TYPE_SUBSCRIPTION
+	default:
+		return PRODUCT_TYPE_UNKNOWN
+	}
+}
+
+func huaweiProductType(kind int) ProductType {
+	switch kind {
+	case iap.HuaweiKindConsumable:
+		return PRODUCT_TYPE_CONSUMABLE
+	case iap.HuaweiKindNonConsumable:
+		return PRODUCT_TYPE_NON_CONSUMABLE
+	case iap.HuaweiKindSubscription:
+		return PRODUCT_TYPE_SUBSCRIPTION
+	default:
+		return PRODUCT_TYPE_UNKNOWN
+	}
+}
+
+// appleExpirationReason maps the pending_renewal_info expiration_intent of an expired subscription.
+func appleExpirationReason(expirationIntent int) CancellationReason {
+	switch expirationIntent {
+	case 1:
+		return CANCELLATION_REASON_USER_CANCELED
+	case 2:
+		return CANCELLATION_REASON_BILLING_ERROR
+	default:
+		return CANCELLATION_REASON_UNKNOWN
+	}
+}
+
+func googlePriceMicros(gr *iap.ReceiptGoogle) int64 {
+	if gr.SkuDetails == nil {
+		return 0
+	}
+	return gr.SkuDetails.PriceAmountMicros
+}
+
+func googleCurrency(gr *iap.ReceiptGoogle) string {
+	if gr.SkuDetails == nil {
+		return ""
+	}
+	return gr.SkuDetails.PriceCurrencyCode
+}
+
+// googleUserCancellationTime userCancellationTimeMillis, only present when the user canceled.
+func googleUserCancellationTime(g *iap.ReceiptSubscriptionGoogleResponse) time.Time {
+	if !g.IsCanceled || g.CancelReason != 0 || g.UserCancellationTimeMillis < 1 {
+		return time.Time{}
+	}
+	return parseMillisecondUnixTimestamp(int(g.UserCancellationTimeMillis))
+}
+
+func acknowledgementState(unacknowledged bool) int {
+	if unacknowledged {
+		return 0
+	}
+	return 1
+}
+
+// autoAcknowledgeGoogle with AutoAcknowledge acknowledges the purchase of purchaseToken once resp has it newly
+// stored, never a purchase that was filtered, rejected or not stored. A failing acknowledgement is logged and
+// leaves the purchase unacknowledged in resp, it is stored already and Google allows 3 days to acknowledge it.
+func (v *Validate) autoAcknowledgeGoogle(log iap.Logger, resp *ValidatePurchaseResponse, purchaseToken string, acknowledge func() error) {
+	if !v.AutoAcknowledge || v.DryRun || resp.AlreadyProcessed {
+		return
+	}
+
+	for _, vp := range resp.ValidatedPurchases {
+		if vp.PurchaseToken != purchaseToken || vp.AcknowledgementState != 0 {
+			continue
+		}
+		if err := acknowledge(); err != nil {
+			purchaseLogger(log, vp.TransactionId, vp.Environment).Error("error acknowledging google purchase", "error", err)
+			return
+		}
+		vp.AcknowledgementState = 1
+		warnings := resp.Warnings[:0]
+		for _, w := range resp.Warnings {
+			if w.Code != WARNING_UNACKNOWLEDGED || w.TransactionId != vp.TransactionId {
+				warnings = append(warnings, w)
+			}
+		}
+		resp.Warnings = warnings
+		return
+	}
+}
+
+func appleCancellationTime(purchase *iap.InApp) (time.Time, error) {
+	if len(purchase.CancellationDateMs) < 1 {
+		return time.Time{}, nil
+	}
+
+	ct, err := strconv.Atoi(purchase.CancellationDateMs)
+	if err != nil {
+		return time.Time{}, err
+	}
+	return parseMillisecondUnixTimestamp(ct), nil
+}
+
+// googleTransactionId the orderId of a Google product purchase, like an Apple transaction ID it identifies the
+// order. Test purchases have no orderId, the purchase token identifies them.
+func googleTransactionId(orderId, purchaseToken string) string {
+	if len(orderId) > 0 {
+		return orderId
+	}
+	return purchaseToken
+}
+
+// googleEnvironment license tester purchases (purchaseType 0) are SANDBOX, standard and promo purchases PRODUCTION.
+func googleEnvironment(testPurchase bool) Environment {
+	if testPurchase {
+		return SANDBOX
+	}
+	return PRODUCTION
+}
+
+// googleCancellationReason maps the subscription cancelReason.
+func googleCancellationReason(canceled bool, cancelReason int) CancellationReason {
+	if !canceled {
+		return CANCELLATION_REASON_NONE
+	}
+
+	switch cancelReason {
+	case 0:
+		return CANCELLATION_REASON_USER_CANCELED
+	case 1:
+		return CANCELLATION_REASON_BILLING_ERROR
+	case 2:
+		return CANCELLATION_REASON_REPLACED
+	case 3:
+		return CANCELLATION_REASON_DEVELOPER_CANCELED
+	default:
+		return CANCELLATION_REASON_UNKNOWN
+	}
+}
+
+// appleReceiptOriginalPurchaseTime receipt level original_purchase_date_ms, zero when missing or malformed.
+func appleReceiptOriginalPurchaseTime(receipt *iap.ResponseReceipt) time.Time {
+	if receipt == nil || len(receipt.OriginalPurchaseDateMs) < 1 {
+		return time.Time{}
+	}
+
+	ms, err := strconv.Atoi(receipt.OriginalPurchaseDateMs)
+	if err != nil || ms <= 0 {
+		return time.Time{}
+	}
+	return parseMillisecondUnixTimestamp(ms)
 }
 
 func parseMillisecondUnixTimestamp(t int) time.Time {
@@ -0,0 +1,124 @@
+package validate_test
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/panuwattoa/in-app-purchase/playground/validate"
+	"github.com/panuwattoa/in-app-purchase/playground/validate/memory"
+)
+
+// failingCounter fails CountUserPurchases.
+type failingCounter struct {
+	*memory.InMemoryStorage
+}
+
+func (s failingCounter) CountUserPurchases(ctx context.Context, userID string) (int, error) {
+	return 0, errors.New("count failed")
+}
+
+func TestIsFirstPurchase(t *testing.T) {
+	testMode := map[string]*validate.ValidatedPurchase{
+		"first":  {ProductId: "coins", TransactionId: "1000"},
+		"second": {ProductId: "coins", TransactionId: "1001"},
+	}
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage(), TestMode: testMode}
+
+	resp, err := v.PurchasesApple(context.Background(), "user", "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !resp.IsFirstPurchase {
+		t.Fatal("first purchase of the user not IsFirstPurchase")
+	}
+	resp, err = v.PurchasesApple(context.Background(), "user", "second")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.IsFirstPurchase {
+		t.Fatal("second purchase of the user IsFirstPurchase")
+	}
+
+	// the purchase is stored by the time the count fails, the call must not fail.
+	storage := failingCounter{memory.NewInMemoryStorage()}
+	v = &validate.Validate{Storage: storage, TestMode: testMode}
+	resp, err = v.PurchasesApple(context.Background(), "user", "first")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resp.IsFirstPurchase || len(resp.ValidatedPurchases) != 1 {
+		t.Fatalf("response %+v, want the stored purchase without IsFirstPurchase", resp)
+	}
+}
+
+func TestTestModeProductionService(t *testing.T) {
+	storage := memory.NewInMemoryStorage()
+	v := &validate.Validate{
+		Storage:           storage,
+		ProductionService: true,
+		TestMode:          map[string]*validate.ValidatedPurchase{"receipt": {ProductId: "coins", TransactionId: "1000"}},
+	}
+
+	if _, err := v.PurchasesApple(context.Background(), "user", "receipt"); !errors.Is(err, validate.ErrTestModeProduction) {
+		t.Fatalf("error %v, want ErrTestModeProduction", err)
+	}
+	if _, err := v.PurchaseSubscriptionGoogle(context.Background(), "user", "receipt"); !errors.Is(err, validate.ErrTestModeProduction) {
+		t.Fatalf("error %v, want ErrTestModeProduction", err)
+	}
+	if n, _ := storage.CountUserPurchases(context.Background(), "user"); n > 0 {
+		t.Fatal("canned purchase stored on a production service")
+	}
+}
+
+func TestHTTPClient(t *testing.T) {
+	v := &validate.Validate{Storage: memory.NewInMemoryStorage()}
+	apple := newTestApple(t, v)
+	apple.production = appleReceiptResponse("Production", appleInApp("coins", "1000", time.Now()))
+
+	// nil falls back to the default client.
+	v.HTTPClient = nil
+	if _, err := v.PurchasesApple(context.Background(), "user", "receipt"); err != nil {
+		t.Fatal(err)
+	}
+
+	var requests int32
+	transport := http.DefaultTransport
+	v.HTTPClient = &http.Client{Transport: roundTripFunc(func(r *http.Request) (*http.Response, error) {
+		atomic.AddInt32(&requests, 1)
+		return transport.RoundTrip(r)
+	})}
+	apple.production = appleReceiptResponse("Production", appleInApp("coins", "1001", time.Now()))
+	if _, err := v.PurchasesApple(context.Background(), "user", "other receipt"); err != nil {
+		t.Fatal(err)
+	}
+	if requests != 1 {
+		t.Fatalf("%d requests through the provided client, want 1", requests)
+	}
+}
+
+func TestDryRun(t *testing.T) {
+	v := &validate.Validate{Storage: untouchedStorage{t}, DryRun: true}
+	apple := newTestApple(t, v)
+	apple.production = appleReceiptResponse("Production", appleInApp("coins", "1000", time.Now()))
+
+	// nothing is stored, the same receipt validates the same way again.
+	first, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	second, err := v.PurchasesApple(context.Background(), "user", "receipt")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(first.ValidatedPurchases) != 1 || first.ValidatedPurchases[0].TransactionId != "1000" {
+		t.Fatalf("response %+v, want the receipt purchase", first)
+	}
+	if !reflect.DeepEqual(first, second) {
+		t.Fatalf("second response %+v, want %+v", second, first)
+	}
+}
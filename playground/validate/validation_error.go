@@ -0,0 +1,45 @@
+package validate
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/panuwattoa/in-app-purchase/iap"
+)
+
+// ValidationError a store rejected the purchase, Err is the sentinel (e.g. ErrFailedPrecondition)
+// so errors.Is keeps working, ProviderStatus and ProviderResponse are what the store answered.
+type ValidationError struct {
+	Store Store
+	// ProviderStatus Apple verifyReceipt status, Google purchaseState or HTTP status, Amazon HTTP status, Huawei purchaseState.
+	ProviderStatus int
+	// ProviderResponse raw store response body, may be truncated for HTTP errors.
+	ProviderResponse []byte
+	Err              error
+}
+
+func (e *ValidationError) Error() string {
+	return fmt.Sprintf("%v: %s status %d", e.Err, e.Store, e.ProviderStatus)
+}
+
+func (e *ValidationError) Unwrap() error {
+	return e.Err
+}
+
+// appleValidationError wraps an Apple non 200 response in a ValidationError, other errors are returned as is.
+func appleValidationError(err error) error {
+	var httpErr *iap.AppleHTTPError
+	if !errors.As(err, &httpErr) {
+		return err
+	}
+	return &ValidationError{Store: APPLE_APP_STORE, ProviderStatus: httpErr.StatusCode, ProviderResponse: httpErr.Body, Err: err}
+}
+
+// googleValidationError wraps a Google non 200 response in a ValidationError, other errors are returned as is.
+func googleValidationError(err error) error {
+	var httpErr *iap.GoogleHTTPError
+	if !errors.As(err, &httpErr) {
+		return err
+	}
+	return &ValidationError{Store: GOOGLE_PLAY_STORE, ProviderStatus: httpErr.StatusCode, ProviderResponse: httpErr.Body, Err: err}
+}
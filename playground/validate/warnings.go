@@ -0,0 +1,57 @@
+package validate
+
+import (
+	"time"
+)
+
+// Non fatal condition found while validating a purchase.
+type WarningCode int32
+
+const (
+	// Sandbox purchase validated by a Validate with ProductionService set.
+	WARNING_SANDBOX_PURCHASE WarningCode = 1
+	// Google purchase not acknowledged yet, Google refunds it after 3 days.
+	WARNING_UNACKNOWLEDGED WarningCode = 2
+	// Subscription that won't renew ends within Validate.ExpiryWarningWindow.
+	WARNING_SUBSCRIPTION_EXPIRING WarningCode = 3
+	// Apple entitlement shared by a family member, not bought by the user.
+	WARNING_FAMILY_SHARED WarningCode = 4
+)
+
+// DefaultExpiryWarningWindow is used when Validate.ExpiryWarningWindow is not set.
+const DefaultExpiryWarningWindow = 24 * time.Hour
+
+type Warning struct {
+	Code WarningCode `json:"code"`
+	// Purchase the warning is about.
+	TransactionId string `json:"transaction_id,omitempty"`
+	Message       string `json:"message,omitempty"`
+}
+
+func (v *Validate) purchaseWarnings(p *Purchase, vp *ValidatedPurchase) []Warning {
+	var warnings []Warning
+	if v.ProductionService && p.environment == SANDBOX {
+		warnings = append(warnings, Warning{Code: WARNING_SANDBOX_PURCHASE, TransactionId: p.transactionId, Message: "sandbox purchase on a production service"})
+	}
+
+	if p.unacknowledged {
+		warnings = append(warnings, Warning{Code: WARNING_UNACKNOWLEDGED, TransactionId: p.transactionId, Message: "purchase is not acknowledged"})
+	}
+
+	if p.familyShared {
+		warnings = append(warnings, Warning{Code: WARNING_FAMILY_SHARED, TransactionId: p.transactionId, Message: "purchase is family shared"})
+	}
+
+	window := v.ExpiryWarningWindow
+	if window <= 0 {
+		window = DefaultExpiryWarningWindow
+	}
+	if vp.EffectiveExpiresTime > 0 && !vp.AutoRenew {
+		expires := time.Unix(vp.EffectiveExpiresTime, 0)
+		if now := time.Now(); expires.After(now) && expires.Before(now.Add(window)) {
+			warnings = append(warnings, Warning{Code: WARNING_SUBSCRIPTION_EXPIRING, TransactionId: p.transactionId, Message: "subscription expires soon and won't renew"})
+		}
+	}
+
+	return warnings
+}